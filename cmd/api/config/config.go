@@ -3,19 +3,25 @@ package config
 import "time"
 
 type Config struct {
-	CacheExpirationTime  time.Duration
-	GracePeriod          time.Duration
-	SessionCheckInterval time.Duration
-	CacheCleanupDelay    time.Duration
-	SessionMemoryTimeout time.Duration
+	CacheExpirationTime       time.Duration
+	GracePeriod               time.Duration
+	SessionCheckInterval      time.Duration
+	CacheCleanupDelay         time.Duration
+	SessionMemoryTimeout      time.Duration
+	ArxivRequestInterval      time.Duration
+	ArxivHarvestInterval      time.Duration
+	PaperVersionCheckInterval time.Duration
 }
 
 func NewConfig() *Config {
 	return &Config{
-		CacheExpirationTime:  20 * time.Minute,
-		GracePeriod:          5 * time.Minute,
-		SessionCheckInterval: 30 * time.Second,
-		CacheCleanupDelay:    1 * time.Minute,
-		SessionMemoryTimeout: 10 * time.Minute,
+		CacheExpirationTime:       20 * time.Minute,
+		GracePeriod:               5 * time.Minute,
+		SessionCheckInterval:      30 * time.Second,
+		CacheCleanupDelay:         1 * time.Minute,
+		SessionMemoryTimeout:      10 * time.Minute,
+		ArxivRequestInterval:      3 * time.Second, // arXiv asks for at most one request every 3 seconds
+		ArxivHarvestInterval:      24 * time.Hour,
+		PaperVersionCheckInterval: 12 * time.Hour,
 	}
 }
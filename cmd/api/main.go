@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -22,7 +23,6 @@ import (
 	"nexus_scholar_go_backend/internal/utils/broker"
 	"nexus_scholar_go_backend/internal/wsocket"
 
-	"cloud.google.com/go/storage"
 	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -34,6 +34,9 @@ import (
 	"google.golang.org/api/option"
 )
 
+// Bucket used by the filesystem and S3 backends when none is configured
+const defaultBucketName = "nexus-scholar"
+
 func main() {
 	// Initialize zerolog
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
@@ -59,11 +62,6 @@ func main() {
 
 	ctx := context.Background()
 
-	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
-	if projectID == "" {
-		log.Fatal().Msg("GOOGLE_CLOUD_PROJECT environment variable is not set")
-	}
-
 	if err := database.InitDB(); err != nil {
 		log.Fatal().Err(err).Msg("Failed to initialize database")
 	}
@@ -82,47 +80,89 @@ func main() {
 	// Initial parameters for services
 	cfg := config.NewConfig()
 	arxivBaseURL := "https://arxiv.org/pdf/"
+	arxivSourceURL := "https://arxiv.org/e-print/"
 
-	gcsBucketName := os.Getenv("GCS_BUCKET_NAME")
-	if gcsBucketName == "" {
-		log.Fatal().Msg("GCS_BUCKET_NAME environment variable is not set")
+	storageCfg := storageConfigFromEnv()
+	bucketName := os.Getenv("STORAGE_BUCKET_NAME")
+	if bucketName == "" {
+		bucketName = os.Getenv("GCS_BUCKET_NAME")
+	}
+	if bucketName == "" {
+		// GCS bucket names are global, so there is no sensible default there
+		if storageCfg.Backend == services.StorageBackendGCS {
+			log.Fatal().Msg("GCS_BUCKET_NAME environment variable is not set")
+		}
+		bucketName = defaultBucketName
 	}
 
 	// Initialize Internal services
+	arxivMetadataService := services.NewArxivMetadataService(database.DB, cfg.ArxivRequestInterval, log)
+	if harvestSets := os.Getenv("ARXIV_HARVEST_SETS"); harvestSets != "" {
+		go arxivMetadataService.StartPeriodicHarvest(ctx, strings.Split(harvestSets, ","), cfg.ArxivHarvestInterval)
+	}
+
+	notificationService := services.NewNotificationService(database.DB, messageBroker, log)
+
 	chatServiceDB := services.NewChatServiceDB(database.DB)
 	cacheServiceDB := services.NewCacheServiceDB(database.DB)
-	contentAggregationService := services.NewContentAggregationService(arxivBaseURL, log)
+	// The math extraction mode recognizes equations in PDFs without a LaTeX source only when
+	// a recognizer is configured
+	var equationRecognizer services.EquationRecognizer
+	if recognizerURL := os.Getenv("EQUATION_RECOGNIZER_URL"); recognizerURL != "" {
+		equationRecognizer = services.NewHTTPEquationRecognizer(recognizerURL, os.Getenv("EQUATION_RECOGNIZER_TOKEN"))
+	}
+	contentAggregationService := services.NewContentAggregationService(arxivBaseURL, arxivSourceURL, equationRecognizer, log)
+	paperVersionService := services.NewPaperVersionService(database.DB, arxivMetadataService, contentAggregationService, notificationService, log)
+	go paperVersionService.StartPeriodicCheck(ctx, cfg.PaperVersionCheckInterval)
+	workspaceService := services.NewWorkspaceService(database.DB, chatServiceDB, log)
 	cacheManagementService := services.NewCacheManagementService(
 		genaiClient,
 		contentAggregationService,
 		cfg.CacheExpirationTime,
 		cacheServiceDB,
 		chatServiceDB,
+		workspaceService,
 		log,
 	)
 
 	userService := services.NewUserService(database.DB, cacheManagementService, log)
+	instructionPresetService := services.NewInstructionPresetService(database.DB, log)
+	chatSummaryService := services.NewChatSummaryService(database.DB, chatServiceDB, genaiClient, cacheManagementService, workspaceService, log)
 
 	chatSessionService := services.NewChatSessionService(
 		genaiClient,
 		chatServiceDB,
 		cacheServiceDB,
 		cacheManagementService,
+		chatSummaryService,
 		cfg,
 		log,
 	)
 
-	// Initialize GCS service
-	gcsService, err := services.NewGCSService(ctx, log)
+	// Initialize object storage
+	cloudStorage, err := services.NewCloudStorageManager(ctx, storageCfg, log)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to create GCS service")
+		log.Fatal().Err(err).Msgf("Failed to create %s storage backend", storageCfg.Backend)
 	}
 
 	// Check and create bucket if it doesn't exist
-	if err := checkAndCreateBucket(ctx, gcsBucketName, gcsService.Client); err != nil {
-		log.Fatal().Err(err).Msg("Failed to check/create GCS bucket")
+	if err := cloudStorage.EnsureBucket(ctx, bucketName); err != nil {
+		log.Fatal().Err(err).Msg("Failed to check/create storage bucket")
 	}
 
+	documentService := services.NewDocumentService(database.DB, contentAggregationService, contentAggregationService, contentAggregationService, cloudStorage, bucketName, log)
+	projectService := services.NewProjectService(database.DB, chatServiceDB, log)
+	shareLinkService := services.NewShareLinkService(database.DB, chatServiceDB, shareLinkSecretFromEnv(), log)
+	chatExportService := services.NewChatExportService(database.DB, workspaceService, chatServiceDB, log)
+	chatSearchService := services.NewChatSearchService(database.DB, log)
+	chatHistoryService := services.NewChatHistoryService(database.DB, workspaceService, log)
+	extractionService := services.NewExtractionService(database.DB, workspaceService, chatServiceDB, cacheManagementService, cacheManagementService, cacheServiceDB, chatSessionService, messageBroker, log)
+	reportService := services.NewReportService(database.DB, workspaceService, chatServiceDB, cacheManagementService, cacheManagementService, cacheServiceDB, chatSessionService, messageBroker, log)
+	go reportService.ResumeInterrupted(ctx)
+	suggestionService := services.NewSuggestionService(database.DB, chatServiceDB, cacheManagementService, cacheManagementService, cacheServiceDB, log)
+	comparisonService := services.NewComparisonService(database.DB, workspaceService, chatServiceDB, cacheManagementService, cacheManagementService, cacheServiceDB, chatSessionService, log)
+	glossaryService := services.NewGlossaryService(database.DB, workspaceService, chatServiceDB, cacheManagementService, cacheManagementService, cacheServiceDB, messageBroker, log)
+
 	researchChatService := services.NewResearchChatService(
 		contentAggregationService,
 		cacheManagementService,
@@ -130,8 +170,12 @@ func main() {
 		chatServiceDB,
 		cacheServiceDB,
 		cfg.CacheExpirationTime,
-		gcsService,
-		gcsBucketName,
+		cloudStorage,
+		bucketName,
+		documentService,
+		chatSummaryService,
+		instructionPresetService,
+		glossaryService,
 		log,
 	)
 
@@ -167,7 +211,7 @@ func main() {
 	}
 
 	// Create WebSocket handler
-	wsHandler := wsocket.NewHandler(researchChatService, upgrader, cfg.SessionCheckInterval, cfg.SessionMemoryTimeout, log)
+	wsHandler := wsocket.NewHandler(researchChatService, workspaceService, suggestionService, upgrader, cfg.SessionCheckInterval, cfg.SessionMemoryTimeout, log)
 
 	r.Use(loggingMiddleware(log))
 	r.Use(setSessionID())
@@ -181,7 +225,22 @@ func main() {
 	})
 	r.Use(logResponseStatus())
 
-	api.SetupRoutes(r, researchChatService, chatServiceDB, stripeService, cacheManagementService, userService, messageBroker, log)
+	api.SetupRoutes(r, researchChatService, chatServiceDB, stripeService, cacheManagementService, userService, arxivMetadataService, documentService, projectService, workspaceService, messageBroker, log)
+	api.SetupNotificationRoutes(r, notificationService, userService)
+	api.SetupDocumentRoutes(r, documentService, userService)
+	api.SetupProjectRoutes(r, projectService, researchChatService, userService)
+	api.SetupWorkspaceRoutes(r, workspaceService, userService)
+	api.SetupShareRoutes(r, shareLinkService, userService)
+	api.SetupExportRoutes(r, chatExportService, userService)
+	api.SetupBranchRoutes(r, researchChatService, workspaceService, userService)
+	api.SetupPresetRoutes(r, instructionPresetService, userService)
+	api.SetupChatSearchRoutes(r, chatSearchService, userService)
+	api.SetupChatHistoryRoutes(r, chatHistoryService, chatSummaryService, userService)
+	api.SetupExtractionRoutes(r, extractionService, userService)
+	api.SetupReportRoutes(r, reportService, userService)
+	api.SetupComparisonRoutes(r, comparisonService, userService)
+	api.SetupGlossaryRoutes(r, glossaryService, userService)
+	api.SetupSettingsRoutes(r, userService)
 	auth.SetupRoutes(r, userService)
 
 	// Add WebSocket route
@@ -209,21 +268,42 @@ func main() {
 	}
 }
 
-func checkAndCreateBucket(ctx context.Context, bucketName string, client *storage.Client) error {
-	log := zerolog.Ctx(ctx)
-	bucket := client.Bucket(bucketName)
-	_, err := bucket.Attrs(ctx)
-	if err == storage.ErrBucketNotExist {
-		log.Info().Msgf("Bucket %s does not exist. Creating...", bucketName)
-		if err := bucket.Create(ctx, os.Getenv("GOOGLE_CLOUD_PROJECT"), nil); err != nil {
-			return fmt.Errorf("failed to create bucket: %w", err)
-		}
-		log.Info().Msgf("Bucket %s created successfully", bucketName)
-	} else if err != nil {
-		return fmt.Errorf("failed to get bucket attributes: %w", err)
+// storageConfigFromEnv selects the object storage backend via STORAGE_BACKEND (gcs, fs or s3)
+func storageConfigFromEnv() services.StorageConfig {
+	cfg := services.StorageConfig{
+		Backend:           os.Getenv("STORAGE_BACKEND"),
+		GCSProjectID:      os.Getenv("GOOGLE_CLOUD_PROJECT"),
+		FileSystemRoot:    os.Getenv("STORAGE_FS_ROOT"),
+		S3Endpoint:        os.Getenv("S3_ENDPOINT"),
+		S3AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+		S3SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		S3Region:          os.Getenv("S3_REGION"),
+		S3UseSSL:          os.Getenv("S3_USE_SSL") != "false",
+	}
+	if cfg.Backend == "" {
+		cfg.Backend = services.StorageBackendGCS
 	}
+	if cfg.FileSystemRoot == "" {
+		cfg.FileSystemRoot = "./data/storage"
+	}
+	return cfg
+}
 
-	return nil
+// shareLinkSecretFromEnv returns the key share link tokens are signed with. Without
+// SHARE_LINK_SECRET a random key is used outside production, so links stop working on restart.
+func shareLinkSecretFromEnv() []byte {
+	if secret := os.Getenv("SHARE_LINK_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	if os.Getenv("GO_ENV") == "production" {
+		log.Fatal().Msg("SHARE_LINK_SECRET environment variable is not set")
+	}
+	log.Warn().Msg("SHARE_LINK_SECRET is not set, share links will not survive a restart")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatal().Err(err).Msg("Failed to generate share link secret")
+	}
+	return secret
 }
 
 func customRecoveryMiddleware() gin.HandlerFunc {
@@ -0,0 +1,53 @@
+// Command arxivimport loads a JSON-lines arXiv metadata snapshot (the format of
+// the public Kaggle/GCS bulk dump) into the local metadata mirror.
+//
+//	go run ./cmd/arxivimport -file arxiv-metadata-oai-snapshot.json
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"time"
+
+	"nexus_scholar_go_backend/cmd/api/config"
+	"nexus_scholar_go_backend/internal/database"
+	"nexus_scholar_go_backend/internal/services"
+
+	"github.com/joho/godotenv"
+	"github.com/rs/zerolog"
+)
+
+func main() {
+	log := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout}).With().Timestamp().Logger()
+
+	dumpPath := flag.String("file", "", "path to the JSON-lines arXiv metadata snapshot")
+	flag.Parse()
+	if *dumpPath == "" {
+		log.Fatal().Msg("-file is required")
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Warn().Err(err).Msg("No .env file found")
+	}
+
+	if err := database.InitDB(); err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize database")
+	}
+
+	file, err := os.Open(*dumpPath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to open metadata dump")
+	}
+	defer file.Close()
+
+	cfg := config.NewConfig()
+	arxivMetadataService := services.NewArxivMetadataService(database.DB, cfg.ArxivRequestInterval, log)
+
+	start := time.Now()
+	imported, err := arxivMetadataService.ImportMetadataDump(context.Background(), file)
+	if err != nil {
+		log.Fatal().Err(err).Int("imported", imported).Msg("Metadata dump import failed")
+	}
+	log.Info().Int("imported", imported).Dur("took", time.Since(start)).Msg("Metadata dump imported")
+}
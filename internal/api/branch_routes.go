@@ -0,0 +1,215 @@
+package api
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/services"
+	"nexus_scholar_go_backend/internal/utils/auth"
+	"nexus_scholar_go_backend/internal/utils/errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/generative-ai-go/genai"
+	"gorm.io/gorm"
+)
+
+func SetupBranchRoutes(r *gin.Engine, researchChatService *services.ResearchChatService, workspaceService *services.WorkspaceService, userService *services.UserService) {
+	api := r.Group("/api/chat", auth.AuthMiddleware(userService))
+	{
+		api.GET("/:session_id/branches", getBranchesHandler(researchChatService, workspaceService))
+		api.POST("/:session_id/branches/:branch_id/activate", activateBranchHandler(researchChatService, workspaceService))
+		api.POST("/:session_id/regenerate", regenerateAnswerHandler(researchChatService, workspaceService))
+		api.POST("/:session_id/messages/:message_id/edit", editMessageHandler(researchChatService, workspaceService))
+	}
+}
+
+func branchJSON(branch *models.ChatBranch, activeBranchID *uint) gin.H {
+	return gin.H{
+		"id":             branch.ID,
+		"forked_from_id": branch.ForkedFromID,
+		"reason":         branch.Reason,
+		"created_at":     branch.CreatedAt,
+		"active":         activeBranchID != nil && *activeBranchID == branch.ID,
+	}
+}
+
+func getBranchesHandler(researchChatService *services.ResearchChatService, workspaceService *services.WorkspaceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chat, ok := authorizeChatSession(c, workspaceService)
+		if !ok {
+			return
+		}
+
+		branches, err := researchChatService.GetBranches(chat.SessionID)
+		if err != nil {
+			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to get branches: %v", err)))
+			return
+		}
+
+		result := make([]gin.H, len(branches))
+		for i := range branches {
+			result[i] = branchJSON(&branches[i], chat.ActiveBranchID)
+		}
+		c.JSON(http.StatusOK, gin.H{"branches": result})
+	}
+}
+
+func activateBranchHandler(researchChatService *services.ResearchChatService, workspaceService *services.WorkspaceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chat, ok := authorizeChatSession(c, workspaceService)
+		if !ok {
+			return
+		}
+		branchID, err := strconv.ParseUint(c.Param("branch_id"), 10, 64)
+		if err != nil {
+			errors.HandleError(c, errors.New400Error("Invalid branch ID"))
+			return
+		}
+
+		// Branches of a terminated session can still be browsed; there is no turn to wait for
+		release, err := researchChatService.AcquireTurn(chat.SessionID)
+		switch {
+		case stderrors.Is(err, services.ErrSessionNotFound):
+			release = func() {}
+		case stderrors.Is(err, services.ErrTurnInProgress):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to reserve session: %v", err)))
+			return
+		}
+		defer release()
+
+		if err := researchChatService.SwitchBranch(chat.SessionID, uint(branchID)); err != nil {
+			handleBranchError(c, err, "failed to switch branch")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"active_branch_id": branchID})
+	}
+}
+
+func regenerateAnswerHandler(researchChatService *services.ResearchChatService, workspaceService *services.WorkspaceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chat, ok := authorizeChatSession(c, workspaceService)
+		if !ok {
+			return
+		}
+
+		release, ok := acquireTurn(c, researchChatService, chat.SessionID)
+		if !ok {
+			return
+		}
+		defer release()
+
+		responseIterator, branch, err := researchChatService.RegenerateAnswer(c.Request.Context(), chat.SessionID)
+		if err != nil {
+			handleBranchError(c, err, "failed to regenerate answer")
+			return
+		}
+		streamBranchAnswer(c, researchChatService, chat.SessionID, branch, responseIterator)
+	}
+}
+
+func editMessageHandler(researchChatService *services.ResearchChatService, workspaceService *services.WorkspaceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chat, ok := authorizeChatSession(c, workspaceService)
+		if !ok {
+			return
+		}
+		messageID, err := strconv.ParseUint(c.Param("message_id"), 10, 64)
+		if err != nil {
+			errors.HandleError(c, errors.New400Error("Invalid message ID"))
+			return
+		}
+		var request struct {
+			Message string `json:"message" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+
+		release, ok := acquireTurn(c, researchChatService, chat.SessionID)
+		if !ok {
+			return
+		}
+		defer release()
+
+		responseIterator, branch, err := researchChatService.EditMessage(c.Request.Context(), chat.SessionID, uint(messageID), request.Message)
+		if err != nil {
+			handleBranchError(c, err, "failed to edit message")
+			return
+		}
+		user, _ := currentUser(c)
+		if _, err := researchChatService.SaveMessageToDB(c.Request.Context(), chat.SessionID, "user", request.Message, &user.ID); err != nil {
+			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to save edited message: %v", err)))
+			return
+		}
+		streamBranchAnswer(c, researchChatService, chat.SessionID, branch, responseIterator)
+	}
+}
+
+// streamBranchAnswer announces the new branch before streaming the answer on it
+func streamBranchAnswer(c *gin.Context, researchChatService *services.ResearchChatService, sessionID string, branch *models.ChatBranch, responseIterator *genai.GenerateContentResponseIterator) {
+	c.SSEvent("branch", branchJSON(branch, &branch.ID))
+	streamAnswer(c, researchChatService, sessionID, responseIterator)
+}
+
+// authorizeChatSession loads the session of the request if the current user may access it,
+// and otherwise writes the error response
+func authorizeChatSession(c *gin.Context, workspaceService *services.WorkspaceService) (*models.Chat, bool) {
+	return authorizeSessionID(c, workspaceService, c.Param("session_id"))
+}
+
+// authorizeSessionID is authorizeChatSession for routes that take the session ID in the body
+func authorizeSessionID(c *gin.Context, workspaceService *services.WorkspaceService, sessionID string) (*models.Chat, bool) {
+	user, err := currentUser(c)
+	if err != nil {
+		errors.HandleError(c, err)
+		return nil, false
+	}
+	chat, err := workspaceService.AuthorizeSession(c.Request.Context(), user.ID, sessionID)
+	if stderrors.Is(err, gorm.ErrRecordNotFound) {
+		errors.HandleError(c, errors.New404Error("Session not found"))
+		return nil, false
+	}
+	if err != nil {
+		errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to authorize session: %v", err)))
+		return nil, false
+	}
+	return chat, true
+}
+
+// acquireTurn reserves the session for one prompt, answering 409 while another is being answered
+func acquireTurn(c *gin.Context, researchChatService *services.ResearchChatService, sessionID string) (func(), bool) {
+	release, err := researchChatService.AcquireTurn(sessionID)
+	if stderrors.Is(err, services.ErrTurnInProgress) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if stderrors.Is(err, services.ErrSessionNotFound) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Session is no longer active"})
+		return nil, false
+	}
+	if err != nil {
+		errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to reserve session: %v", err)))
+		return nil, false
+	}
+	return release, true
+}
+
+func handleBranchError(c *gin.Context, err error, action string) {
+	switch {
+	case stderrors.Is(err, services.ErrInvalidBranchOperation):
+		errors.HandleError(c, errors.New400Error(err.Error()))
+	case stderrors.Is(err, services.ErrSessionNotFound):
+		c.JSON(http.StatusConflict, gin.H{"error": "Session is no longer active"})
+	case stderrors.Is(err, gorm.ErrRecordNotFound):
+		errors.HandleError(c, errors.New404Error("Not found"))
+	default:
+		errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("%s: %v", action, err)))
+	}
+}
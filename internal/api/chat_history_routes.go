@@ -0,0 +1,161 @@
+package api
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"nexus_scholar_go_backend/internal/services"
+	"nexus_scholar_go_backend/internal/utils/auth"
+	"nexus_scholar_go_backend/internal/utils/errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func SetupChatHistoryRoutes(r *gin.Engine, chatHistoryService *services.ChatHistoryService, chatSummaryService *services.ChatSummaryService, userService *services.UserService) {
+	api := r.Group("/api/chat", auth.AuthMiddleware(userService))
+	{
+		api.GET("/history", getChatHistoryHandler(chatHistoryService))
+		api.PATCH("/:session_id", updateChatDetailsHandler(chatSummaryService))
+		api.GET("/:session_id/messages", getChatMessagesHandler(chatHistoryService))
+	}
+}
+
+func getChatHistoryHandler(chatHistoryService *services.ChatHistoryService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		filter := services.ChatHistoryFilter{
+			PriceTier: c.Query("price_tier"),
+			Cursor:    c.Query("cursor"),
+		}
+		if filter.PriceTier != "" && filter.PriceTier != "base" && filter.PriceTier != "pro" {
+			errors.HandleError(c, errors.New400Error("price_tier must be base or pro"))
+			return
+		}
+		if projectID := c.Query("project_id"); projectID != "" {
+			id, err := strconv.ParseUint(projectID, 10, 64)
+			if err != nil {
+				errors.HandleError(c, errors.New400Error("Invalid project_id"))
+				return
+			}
+			filterProjectID := uint(id)
+			filter.ProjectID = &filterProjectID
+		}
+		if filter.From, err = parseDateQuery(c, "from", false); err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+		if filter.To, err = parseDateQuery(c, "to", true); err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+		if filter.Limit, err = pageLimit(c, 20); err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		page, err := chatHistoryService.ListChats(c.Request.Context(), user.ID, filter)
+		if err != nil {
+			handleChatHistoryError(c, err, "failed to retrieve chat history")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"chats":       page.Chats,
+			"next_cursor": page.NextCursor,
+		})
+	}
+}
+
+func getChatMessagesHandler(chatHistoryService *services.ChatHistoryService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+		limit, err := pageLimit(c, 50)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		page, err := chatHistoryService.ListMessages(c.Request.Context(), user.ID, c.Param("session_id"), c.Query("cursor"), limit)
+		if err != nil {
+			handleChatHistoryError(c, err, "failed to retrieve chat messages")
+			return
+		}
+
+		messages := make([]gin.H, len(page.Messages))
+		for i := range page.Messages {
+			messages[i] = messageJSON(&page.Messages[i])
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"messages":    messages,
+			"next_cursor": page.NextCursor,
+		})
+	}
+}
+
+func updateChatDetailsHandler(chatSummaryService *services.ChatSummaryService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		var request struct {
+			Title   *string `json:"title"`
+			Summary *string `json:"summary"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+
+		chat, err := chatSummaryService.UpdateChatDetails(c.Request.Context(), user.ID, c.Param("session_id"), services.ChatDetailsInput{
+			Title:   request.Title,
+			Summary: request.Summary,
+		})
+		if err != nil {
+			handleChatHistoryError(c, err, "failed to update chat")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"session_id": chat.SessionID,
+			"title":      chat.Title,
+			"summary":    chat.Summary,
+		})
+	}
+}
+
+// pageLimit reads the limit query parameter of a cursor-paginated listing
+func pageLimit(c *gin.Context, defaultLimit int) (int, error) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultLimit)))
+	if err != nil || limit < 1 || limit > 100 {
+		return 0, errors.New400Error("limit must be between 1 and 100")
+	}
+	return limit, nil
+}
+
+func handleChatHistoryError(c *gin.Context, err error, action string) {
+	switch {
+	case stderrors.Is(err, services.ErrInvalidCursor):
+		errors.HandleError(c, errors.New400Error("Invalid cursor"))
+	case stderrors.Is(err, services.ErrInvalidChatDetails):
+		errors.HandleError(c, errors.New400Error(err.Error()))
+	case stderrors.Is(err, gorm.ErrRecordNotFound):
+		errors.HandleError(c, errors.New404Error("Session not found"))
+	default:
+		errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("%s: %v", action, err)))
+	}
+}
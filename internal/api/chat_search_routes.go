@@ -0,0 +1,103 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"nexus_scholar_go_backend/internal/services"
+	"nexus_scholar_go_backend/internal/utils/auth"
+	"nexus_scholar_go_backend/internal/utils/errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+func SetupChatSearchRoutes(r *gin.Engine, chatSearchService *services.ChatSearchService, userService *services.UserService) {
+	r.GET("/api/chat/search", auth.AuthMiddleware(userService), searchChatsHandler(chatSearchService))
+}
+
+func searchChatsHandler(chatSearchService *services.ChatSearchService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		params := services.ChatSearchParams{
+			Query:     strings.TrimSpace(c.Query("q")),
+			PriceTier: c.Query("price_tier"),
+			ArxivID:   strings.TrimSpace(c.Query("arxiv_id")),
+		}
+		if params.Query == "" {
+			errors.HandleError(c, errors.New400Error("Query parameter q is required"))
+			return
+		}
+		if params.PriceTier != "" && params.PriceTier != "base" && params.PriceTier != "pro" {
+			errors.HandleError(c, errors.New400Error("price_tier must be base or pro"))
+			return
+		}
+		if documentID := c.Query("document_id"); documentID != "" {
+			id, err := strconv.ParseUint(documentID, 10, 64)
+			if err != nil {
+				errors.HandleError(c, errors.New400Error("Invalid document_id"))
+				return
+			}
+			params.DocumentID = uint(id)
+		}
+		if params.From, err = parseDateQuery(c, "from", false); err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+		if params.To, err = parseDateQuery(c, "to", true); err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		params.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || params.Limit < 1 || params.Limit > 100 {
+			errors.HandleError(c, errors.New400Error("limit must be between 1 and 100"))
+			return
+		}
+		params.Offset, err = strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if err != nil || params.Offset < 0 {
+			errors.HandleError(c, errors.New400Error("offset must be a non-negative integer"))
+			return
+		}
+
+		results, total, err := chatSearchService.Search(c.Request.Context(), user.ID, params)
+		if err != nil {
+			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to search chats: %v", err)))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"results": results,
+			"total":   total,
+			"limit":   params.Limit,
+			"offset":  params.Offset,
+		})
+	}
+}
+
+// parseDateQuery reads an RFC 3339 timestamp or a YYYY-MM-DD date from the query string.
+// For an end of range, a plain date includes that whole day.
+func parseDateQuery(c *gin.Context, name string, endOfRange bool) (time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, errors.New400Error(name + " must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+	}
+	if endOfRange {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/services"
+	"nexus_scholar_go_backend/internal/utils/auth"
+	"nexus_scholar_go_backend/internal/utils/errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func SetupComparisonRoutes(r *gin.Engine, comparisonService *services.ComparisonService, userService *services.UserService) {
+	api := r.Group("/api/chat", auth.AuthMiddleware(userService))
+	{
+		api.GET("/:session_id/comparisons", listComparisonsHandler(comparisonService))
+		api.POST("/:session_id/comparisons", createComparisonHandler(comparisonService))
+		api.GET("/:session_id/comparisons/:comparison_id", getComparisonHandler(comparisonService))
+		api.GET("/:session_id/comparisons/:comparison_id/export", exportComparisonHandler(comparisonService))
+		api.DELETE("/:session_id/comparisons/:comparison_id", deleteComparisonHandler(comparisonService))
+	}
+}
+
+func createComparisonHandler(comparisonService *services.ComparisonService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+		var request struct {
+			Documents []int  `json:"documents" binding:"required"`
+			Focus     string `json:"focus"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+
+		comparison, matrix, err := comparisonService.Compare(c.Request.Context(), user.ID, c.Param("session_id"), services.ComparisonInput{
+			Documents: request.Documents,
+			Focus:     request.Focus,
+		})
+		if err != nil {
+			handleComparisonError(c, err, "failed to compare documents")
+			return
+		}
+		c.JSON(http.StatusCreated, comparisonJSON(comparison, matrix))
+	}
+}
+
+func listComparisonsHandler(comparisonService *services.ComparisonService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		comparisons, matrices, err := comparisonService.ListComparisons(c.Request.Context(), user.ID, c.Param("session_id"))
+		if err != nil {
+			handleComparisonError(c, err, "failed to list comparisons")
+			return
+		}
+		result := make([]gin.H, len(comparisons))
+		for i := range comparisons {
+			result[i] = comparisonJSON(&comparisons[i], matrices[i])
+		}
+		c.JSON(http.StatusOK, gin.H{"comparisons": result})
+	}
+}
+
+func getComparisonHandler(comparisonService *services.ComparisonService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, comparisonID, ok := comparisonRequest(c)
+		if !ok {
+			return
+		}
+
+		comparison, matrix, err := comparisonService.GetComparison(c.Request.Context(), user.ID, c.Param("session_id"), comparisonID)
+		if err != nil {
+			handleComparisonError(c, err, "failed to get comparison")
+			return
+		}
+		c.JSON(http.StatusOK, comparisonJSON(comparison, matrix))
+	}
+}
+
+func exportComparisonHandler(comparisonService *services.ComparisonService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, comparisonID, ok := comparisonRequest(c)
+		if !ok {
+			return
+		}
+
+		export, err := comparisonService.ExportComparison(c.Request.Context(), user.ID, c.Param("session_id"), comparisonID, c.Query("format"))
+		if err != nil {
+			handleComparisonError(c, err, "failed to export comparison")
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.FileName))
+		c.Data(http.StatusOK, export.ContentType, export.Content)
+	}
+}
+
+func deleteComparisonHandler(comparisonService *services.ComparisonService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, comparisonID, ok := comparisonRequest(c)
+		if !ok {
+			return
+		}
+
+		if err := comparisonService.DeleteComparison(c.Request.Context(), user.ID, c.Param("session_id"), comparisonID); err != nil {
+			handleComparisonError(c, err, "failed to delete comparison")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Comparison deleted"})
+	}
+}
+
+// comparisonRequest resolves the current user and the comparison ID of the route
+func comparisonRequest(c *gin.Context) (*models.User, uint, bool) {
+	user, err := currentUser(c)
+	if err != nil {
+		errors.HandleError(c, err)
+		return nil, 0, false
+	}
+	comparisonID, err := strconv.ParseUint(c.Param("comparison_id"), 10, 64)
+	if err != nil {
+		errors.HandleError(c, errors.New400Error("Invalid comparison id"))
+		return nil, 0, false
+	}
+	return user, uint(comparisonID), true
+}
+
+func handleComparisonError(c *gin.Context, err error, action string) {
+	switch {
+	case stderrors.Is(err, services.ErrInvalidComparison), stderrors.Is(err, services.ErrInvalidExportFormat):
+		errors.HandleError(c, errors.New400Error(err.Error()))
+	case stderrors.Is(err, services.ErrInsufficientCredit):
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+	case stderrors.Is(err, services.ErrCorpusUnavailable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case stderrors.Is(err, gorm.ErrRecordNotFound):
+		errors.HandleError(c, errors.New404Error("Session or comparison not found"))
+	default:
+		errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("%s: %v", action, err)))
+	}
+}
+
+func comparisonJSON(comparison *models.Comparison, matrix *services.ComparisonMatrix) gin.H {
+	return gin.H{
+		"id":               comparison.ID,
+		"focus":            comparison.Focus,
+		"documents":        matrix.Documents,
+		"rows":             matrix.Rows,
+		"token_count_used": comparison.TokenCountUsed,
+		"token_hours_used": comparison.TokenHoursUsed,
+		"created_at":       comparison.CreatedAt,
+	}
+}
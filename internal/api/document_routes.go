@@ -0,0 +1,241 @@
+package api
+
+import (
+	stderrors "errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/services"
+	"nexus_scholar_go_backend/internal/utils/auth"
+	"nexus_scholar_go_backend/internal/utils/errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const maxDocumentUploadSize = 50 << 20
+
+func SetupDocumentRoutes(r *gin.Engine, documentService *services.DocumentService, userService *services.UserService) {
+	api := r.Group("/api/documents", auth.AuthMiddleware(userService))
+	{
+		api.GET("", listDocumentsHandler(documentService))
+		api.POST("", uploadDocumentHandler(documentService))
+		api.GET("/:id", getDocumentHandler(documentService))
+		api.GET("/:id/file", downloadDocumentHandler(documentService))
+		api.GET("/:id/text", getDocumentTextHandler(documentService))
+		api.PUT("/:id/tags", setDocumentTagsHandler(documentService))
+		api.DELETE("/:id", deleteDocumentHandler(documentService))
+	}
+}
+
+func listDocumentsHandler(documentService *services.DocumentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		documents, err := documentService.ListDocuments(c.Request.Context(), user.ID, c.Query("tag"))
+		if err != nil {
+			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to list documents: %v", err)))
+			return
+		}
+
+		result := make([]gin.H, len(documents))
+		for i := range documents {
+			result[i] = documentJSON(&documents[i])
+		}
+		c.JSON(http.StatusOK, gin.H{"documents": result})
+	}
+}
+
+func uploadDocumentHandler(documentService *services.DocumentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			errors.HandleError(c, errors.New400Error("A PDF must be uploaded in the file field"))
+			return
+		}
+
+		document, err := saveUploadedDocument(c, documentService, user, fileHeader)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"document": documentJSON(document)})
+	}
+}
+
+func getDocumentHandler(documentService *services.DocumentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, documentID, ok := documentRequest(c)
+		if !ok {
+			return
+		}
+
+		document, err := documentService.GetDocument(c.Request.Context(), user.ID, documentID)
+		if err != nil {
+			handleDocumentError(c, err, "failed to get document")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"document": documentJSON(document)})
+	}
+}
+
+func downloadDocumentHandler(documentService *services.DocumentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, documentID, ok := documentRequest(c)
+		if !ok {
+			return
+		}
+
+		document, reader, err := documentService.OpenDocumentFile(c.Request.Context(), user.ID, documentID)
+		if err != nil {
+			handleDocumentError(c, err, "failed to open document")
+			return
+		}
+		defer reader.Close()
+
+		c.DataFromReader(http.StatusOK, document.SizeBytes, document.ContentType, reader, map[string]string{
+			"Content-Disposition": fmt.Sprintf("attachment; filename=%q", document.FileName),
+		})
+	}
+}
+
+func getDocumentTextHandler(documentService *services.DocumentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, documentID, ok := documentRequest(c)
+		if !ok {
+			return
+		}
+
+		text, err := documentService.GetDocumentText(c.Request.Context(), user.ID, documentID)
+		if err != nil {
+			handleDocumentError(c, err, "failed to get document text")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"content": text})
+	}
+}
+
+func setDocumentTagsHandler(documentService *services.DocumentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, documentID, ok := documentRequest(c)
+		if !ok {
+			return
+		}
+
+		var request struct {
+			Tags []string `json:"tags"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+
+		document, err := documentService.SetTags(c.Request.Context(), user.ID, documentID, request.Tags)
+		if stderrors.Is(err, services.ErrInvalidDocumentTag) {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+		if err != nil {
+			handleDocumentError(c, err, "failed to set document tags")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"document": documentJSON(document)})
+	}
+}
+
+func deleteDocumentHandler(documentService *services.DocumentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, documentID, ok := documentRequest(c)
+		if !ok {
+			return
+		}
+
+		if err := documentService.DeleteDocument(c.Request.Context(), user.ID, documentID); err != nil {
+			handleDocumentError(c, err, "failed to delete document")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Document deleted"})
+	}
+}
+
+// saveUploadedDocument validates an uploaded PDF and adds it to the user's library
+func saveUploadedDocument(c *gin.Context, documentService *services.DocumentService, user *models.User, fileHeader *multipart.FileHeader) (*models.Document, error) {
+	if !strings.EqualFold(filepath.Ext(fileHeader.Filename), ".pdf") {
+		return nil, errors.New400Error(fmt.Sprintf("%s is not a PDF", fileHeader.Filename))
+	}
+	if fileHeader.Size > maxDocumentUploadSize {
+		return nil, errors.New400Error(fmt.Sprintf("%s is larger than %d MB", fileHeader.Filename, maxDocumentUploadSize>>20))
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, errors.LogAndReturn500(fmt.Errorf("failed to open uploaded file %s: %v", fileHeader.Filename, err))
+	}
+	defer file.Close()
+
+	document, err := documentService.UploadDocument(c.Request.Context(), user.ID, fileHeader.Filename, file)
+	if err != nil {
+		return nil, errors.LogAndReturn500(fmt.Errorf("failed to add %s to library: %v", fileHeader.Filename, err))
+	}
+	return document, nil
+}
+
+// documentRequest resolves the current user and the :id parameter, writing the error response if either fails
+func documentRequest(c *gin.Context) (*models.User, uint, bool) {
+	user, err := currentUser(c)
+	if err != nil {
+		errors.HandleError(c, err)
+		return nil, 0, false
+	}
+	documentID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		errors.HandleError(c, errors.New400Error("Invalid document id"))
+		return nil, 0, false
+	}
+	return user, uint(documentID), true
+}
+
+func handleDocumentError(c *gin.Context, err error, action string) {
+	if stderrors.Is(err, gorm.ErrRecordNotFound) {
+		errors.HandleError(c, errors.New404Error("Document not found"))
+		return
+	}
+	errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("%s: %v", action, err)))
+}
+
+func documentJSON(d *models.Document) gin.H {
+	tags := make([]string, len(d.Tags))
+	for i, tag := range d.Tags {
+		tags[i] = tag.Name
+	}
+	return gin.H{
+		"id":          d.ID,
+		"title":       d.Title,
+		"file_name":   d.FileName,
+		"size_bytes":  d.SizeBytes,
+		"text_length": d.TextLength,
+		"tags":        tags,
+		"created_at":  d.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+
+	"nexus_scholar_go_backend/internal/services"
+	"nexus_scholar_go_backend/internal/utils/auth"
+	"nexus_scholar_go_backend/internal/utils/errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func SetupExportRoutes(r *gin.Engine, chatExportService *services.ChatExportService, userService *services.UserService) {
+	api := r.Group("/api/chat", auth.AuthMiddleware(userService))
+	{
+		api.GET("/:session_id/export", exportChatHandler(chatExportService))
+	}
+}
+
+func exportChatHandler(chatExportService *services.ChatExportService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		export, err := chatExportService.ExportChat(c.Request.Context(), user.ID, c.Param("session_id"), c.DefaultQuery("format", services.ExportFormatMarkdown))
+		if err != nil {
+			switch {
+			case stderrors.Is(err, services.ErrInvalidExportFormat):
+				errors.HandleError(c, errors.New400Error(err.Error()))
+			case stderrors.Is(err, gorm.ErrRecordNotFound):
+				errors.HandleError(c, errors.New404Error("Session not found"))
+			default:
+				errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to export chat: %v", err)))
+			}
+			return
+		}
+
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.FileName))
+		c.Data(http.StatusOK, export.ContentType, export.Content)
+	}
+}
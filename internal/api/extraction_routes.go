@@ -0,0 +1,210 @@
+package api
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/services"
+	"nexus_scholar_go_backend/internal/utils/auth"
+	"nexus_scholar_go_backend/internal/utils/errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func SetupExtractionRoutes(r *gin.Engine, extractionService *services.ExtractionService, userService *services.UserService) {
+	api := r.Group("/api/chat", auth.AuthMiddleware(userService))
+	{
+		api.GET("/:session_id/extractions", listExtractionTablesHandler(extractionService))
+		api.POST("/:session_id/extractions", createExtractionTableHandler(extractionService))
+		api.GET("/:session_id/extractions/:table_id", getExtractionTableHandler(extractionService))
+		api.GET("/:session_id/extractions/:table_id/export", exportExtractionTableHandler(extractionService))
+		api.DELETE("/:session_id/extractions/:table_id", deleteExtractionTableHandler(extractionService))
+	}
+}
+
+func createExtractionTableHandler(extractionService *services.ExtractionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+		var request struct {
+			Name    string `json:"name" binding:"required"`
+			Columns []struct {
+				Name        string `json:"name"`
+				Description string `json:"description"`
+				Type        string `json:"type"`
+			} `json:"columns" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+
+		input := services.ExtractionTableInput{Name: request.Name}
+		for _, column := range request.Columns {
+			input.Columns = append(input.Columns, services.ExtractionColumnInput{
+				Name:        column.Name,
+				Description: column.Description,
+				Type:        column.Type,
+			})
+		}
+		table, err := extractionService.CreateTable(c.Request.Context(), user.ID, c.Param("session_id"), input)
+		if err != nil {
+			handleExtractionError(c, err, "failed to create extraction table")
+			return
+		}
+		c.JSON(http.StatusAccepted, extractionTableJSON(table))
+	}
+}
+
+func listExtractionTablesHandler(extractionService *services.ExtractionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		tables, err := extractionService.ListTables(c.Request.Context(), user.ID, c.Param("session_id"))
+		if err != nil {
+			handleExtractionError(c, err, "failed to list extraction tables")
+			return
+		}
+		result := make([]gin.H, len(tables))
+		for i := range tables {
+			result[i] = extractionTableJSON(&tables[i])
+		}
+		c.JSON(http.StatusOK, gin.H{"tables": result})
+	}
+}
+
+func getExtractionTableHandler(extractionService *services.ExtractionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, tableID, ok := extractionRequest(c)
+		if !ok {
+			return
+		}
+
+		table, rows, err := extractionService.GetTable(c.Request.Context(), user.ID, c.Param("session_id"), tableID)
+		if err != nil {
+			handleExtractionError(c, err, "failed to get extraction table")
+			return
+		}
+
+		rowsJSON := make([]gin.H, len(rows))
+		for i, row := range rows {
+			cells := make([]gin.H, 0, len(row.Cells))
+			for _, column := range table.Columns {
+				if cell, ok := row.Cells[column.ID]; ok {
+					cells = append(cells, gin.H{
+						"column_id": column.ID,
+						"value":     cell.Value,
+						"quote":     cell.Quote,
+						"found":     cell.Found,
+					})
+				}
+			}
+			rowsJSON[i] = gin.H{
+				"document": row.Document.Position,
+				"title":    row.Document.Title,
+				"cells":    cells,
+			}
+		}
+		result := extractionTableJSON(table)
+		result["rows"] = rowsJSON
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func exportExtractionTableHandler(extractionService *services.ExtractionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, tableID, ok := extractionRequest(c)
+		if !ok {
+			return
+		}
+
+		export, err := extractionService.ExportTable(c.Request.Context(), user.ID, c.Param("session_id"), tableID, c.DefaultQuery("format", services.ExportFormatCSV))
+		if err != nil {
+			handleExtractionError(c, err, "failed to export extraction table")
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.FileName))
+		c.Data(http.StatusOK, export.ContentType, export.Content)
+	}
+}
+
+func deleteExtractionTableHandler(extractionService *services.ExtractionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, tableID, ok := extractionRequest(c)
+		if !ok {
+			return
+		}
+
+		if err := extractionService.DeleteTable(c.Request.Context(), user.ID, c.Param("session_id"), tableID); err != nil {
+			handleExtractionError(c, err, "failed to delete extraction table")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Extraction table deleted"})
+	}
+}
+
+// extractionRequest resolves the current user and the table ID of the route
+func extractionRequest(c *gin.Context) (*models.User, uint, bool) {
+	user, err := currentUser(c)
+	if err != nil {
+		errors.HandleError(c, err)
+		return nil, 0, false
+	}
+	tableID, err := strconv.ParseUint(c.Param("table_id"), 10, 64)
+	if err != nil {
+		errors.HandleError(c, errors.New400Error("Invalid table id"))
+		return nil, 0, false
+	}
+	return user, uint(tableID), true
+}
+
+func handleExtractionError(c *gin.Context, err error, action string) {
+	switch {
+	case stderrors.Is(err, services.ErrInvalidExtraction), stderrors.Is(err, services.ErrInvalidExportFormat):
+		errors.HandleError(c, errors.New400Error(err.Error()))
+	case stderrors.Is(err, services.ErrInsufficientCredit):
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+	case stderrors.Is(err, services.ErrCorpusUnavailable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case stderrors.Is(err, gorm.ErrRecordNotFound):
+		errors.HandleError(c, errors.New404Error("Session or extraction table not found"))
+	default:
+		errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("%s: %v", action, err)))
+	}
+}
+
+func extractionTableJSON(t *models.ExtractionTable) gin.H {
+	columns := make([]gin.H, len(t.Columns))
+	for i, column := range t.Columns {
+		columns[i] = gin.H{
+			"id":          column.ID,
+			"name":        column.Name,
+			"description": column.Description,
+			"type":        column.Type,
+		}
+	}
+	return gin.H{
+		"id":               t.ID,
+		"name":             t.Name,
+		"status":           t.Status,
+		"error":            t.Error,
+		"total":            t.Total,
+		"completed":        t.Completed,
+		"failed":           t.Failed,
+		"token_count_used": t.TokenCountUsed,
+		"token_hours_used": t.TokenHoursUsed,
+		"columns":          columns,
+		"created_at":       t.CreatedAt,
+	}
+}
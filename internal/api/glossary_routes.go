@@ -0,0 +1,92 @@
+package api
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/services"
+	"nexus_scholar_go_backend/internal/utils/auth"
+	"nexus_scholar_go_backend/internal/utils/errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func SetupGlossaryRoutes(r *gin.Engine, glossaryService *services.GlossaryService, userService *services.UserService) {
+	api := r.Group("/api/sessions", auth.AuthMiddleware(userService))
+	{
+		api.GET("/:id/glossary", getGlossaryHandler(glossaryService))
+		api.POST("/:id/glossary", rebuildGlossaryHandler(glossaryService))
+	}
+}
+
+func getGlossaryHandler(glossaryService *services.GlossaryService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		glossary, err := glossaryService.GetGlossary(c.Request.Context(), user.ID, c.Param("id"), c.Query("kind"))
+		if err != nil {
+			handleGlossaryError(c, err, "failed to get glossary")
+			return
+		}
+		c.JSON(http.StatusOK, glossaryJSON(glossary))
+	}
+}
+
+func rebuildGlossaryHandler(glossaryService *services.GlossaryService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		if err := glossaryService.RebuildGlossary(c.Request.Context(), user.ID, c.Param("id")); err != nil {
+			handleGlossaryError(c, err, "failed to rebuild glossary")
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"message": "Glossary is being built"})
+	}
+}
+
+func handleGlossaryError(c *gin.Context, err error, action string) {
+	switch {
+	case stderrors.Is(err, services.ErrInvalidGlossary), stderrors.Is(err, services.ErrCorpusUnavailable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case stderrors.Is(err, gorm.ErrRecordNotFound):
+		errors.HandleError(c, errors.New404Error("Session or glossary not found"))
+	default:
+		errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("%s: %v", action, err)))
+	}
+}
+
+func glossaryJSON(glossary *models.Glossary) gin.H {
+	terms := make([]gin.H, len(glossary.Terms))
+	for i, term := range glossary.Terms {
+		documents := term.Documents
+		if documents == nil {
+			documents = []int{}
+		}
+		terms[i] = gin.H{
+			"id":         term.ID,
+			"term":       term.Term,
+			"kind":       term.Kind,
+			"definition": term.Definition,
+			"documents":  documents,
+		}
+	}
+	return gin.H{
+		"status":           glossary.Status,
+		"error":            glossary.Error,
+		"terms":            terms,
+		"token_count_used": glossary.TokenCountUsed,
+		"token_hours_used": glossary.TokenHoursUsed,
+		"updated_at":       glossary.UpdatedAt,
+	}
+}
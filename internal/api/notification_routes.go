@@ -0,0 +1,112 @@
+package api
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/services"
+	"nexus_scholar_go_backend/internal/utils/auth"
+	"nexus_scholar_go_backend/internal/utils/errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func SetupNotificationRoutes(r *gin.Engine, notificationService *services.NotificationService, userService *services.UserService) {
+	api := r.Group("/api/notifications", auth.AuthMiddleware(userService))
+	{
+		api.GET("", listNotificationsHandler(notificationService))
+		api.POST("/read-all", markAllNotificationsReadHandler(notificationService))
+		api.POST("/:id/read", markNotificationReadHandler(notificationService))
+	}
+}
+
+// currentUser returns the authenticated user set by auth.AuthMiddleware
+func currentUser(c *gin.Context) (*models.User, error) {
+	user, exists := c.Get("user")
+	if !exists {
+		return nil, errors.New401Error()
+	}
+	userModel, ok := user.(*models.User)
+	if !ok {
+		return nil, errors.LogAndReturn500(fmt.Errorf("failed to cast user to *models.User"))
+	}
+	return userModel, nil
+}
+
+func listNotificationsHandler(notificationService *services.NotificationService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if err != nil || limit < 1 || limit > 200 {
+			errors.HandleError(c, errors.New400Error("limit must be between 1 and 200"))
+			return
+		}
+		unreadOnly := c.Query("unread") == "true"
+
+		notifications, err := notificationService.ListNotifications(c.Request.Context(), user.ID, unreadOnly, limit)
+		if err != nil {
+			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to list notifications: %v", err)))
+			return
+		}
+
+		result := make([]services.NotificationJSON, len(notifications))
+		for i, n := range notifications {
+			result[i] = services.NewNotificationJSON(&n)
+		}
+		c.JSON(http.StatusOK, gin.H{"notifications": result})
+	}
+}
+
+func markNotificationReadHandler(notificationService *services.NotificationService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		notificationID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			errors.HandleError(c, errors.New400Error("Invalid notification id"))
+			return
+		}
+
+		err = notificationService.MarkAsRead(c.Request.Context(), user.ID, uint(notificationID))
+		if stderrors.Is(err, gorm.ErrRecordNotFound) {
+			errors.HandleError(c, errors.New404Error("Notification not found"))
+			return
+		}
+		if err != nil {
+			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to mark notification as read: %v", err)))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+	}
+}
+
+func markAllNotificationsReadHandler(notificationService *services.NotificationService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		if err := notificationService.MarkAllAsRead(c.Request.Context(), user.ID); err != nil {
+			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to mark notifications as read: %v", err)))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "All notifications marked as read"})
+	}
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/services"
+	"nexus_scholar_go_backend/internal/utils/auth"
+	"nexus_scholar_go_backend/internal/utils/errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// presetRequestBody is the JSON body of preset create and update requests
+type presetRequestBody struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	Instruction string `json:"instruction" binding:"required"`
+}
+
+func (b presetRequestBody) input() services.PresetInput {
+	return services.PresetInput{
+		Name:        b.Name,
+		Description: b.Description,
+		Instruction: b.Instruction,
+	}
+}
+
+func SetupPresetRoutes(r *gin.Engine, presetService *services.InstructionPresetService, userService *services.UserService) {
+	api := r.Group("/api/presets", auth.AuthMiddleware(userService))
+	{
+		api.GET("", listPresetsHandler(presetService))
+		api.POST("", createPresetHandler(presetService))
+		api.PUT("/:id", updatePresetHandler(presetService))
+		api.DELETE("/:id", deletePresetHandler(presetService))
+	}
+}
+
+func listPresetsHandler(presetService *services.InstructionPresetService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		presets, err := presetService.ListPresets(c.Request.Context(), user.ID)
+		if err != nil {
+			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to list presets: %v", err)))
+			return
+		}
+
+		custom := make([]gin.H, len(presets))
+		for i := range presets {
+			custom[i] = presetJSON(&presets[i])
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"builtin": presetService.BuiltinPresets(),
+			"custom":  custom,
+		})
+	}
+}
+
+func createPresetHandler(presetService *services.InstructionPresetService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+		var body presetRequestBody
+		if err := c.ShouldBindJSON(&body); err != nil {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+
+		preset, err := presetService.CreatePreset(c.Request.Context(), user.ID, body.input())
+		if err != nil {
+			handlePresetError(c, err, "failed to create preset")
+			return
+		}
+		c.JSON(http.StatusCreated, presetJSON(preset))
+	}
+}
+
+func updatePresetHandler(presetService *services.InstructionPresetService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, presetID, ok := presetRequest(c)
+		if !ok {
+			return
+		}
+		var body presetRequestBody
+		if err := c.ShouldBindJSON(&body); err != nil {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+
+		preset, err := presetService.UpdatePreset(c.Request.Context(), user.ID, presetID, body.input())
+		if err != nil {
+			handlePresetError(c, err, "failed to update preset")
+			return
+		}
+		c.JSON(http.StatusOK, presetJSON(preset))
+	}
+}
+
+func deletePresetHandler(presetService *services.InstructionPresetService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, presetID, ok := presetRequest(c)
+		if !ok {
+			return
+		}
+
+		if err := presetService.DeletePreset(c.Request.Context(), user.ID, presetID); err != nil {
+			handlePresetError(c, err, "failed to delete preset")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Preset deleted"})
+	}
+}
+
+// presetRequest resolves the current user and the preset ID of the route
+func presetRequest(c *gin.Context) (*models.User, uint, bool) {
+	user, err := currentUser(c)
+	if err != nil {
+		errors.HandleError(c, err)
+		return nil, 0, false
+	}
+	presetID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		errors.HandleError(c, errors.New400Error("Invalid preset id"))
+		return nil, 0, false
+	}
+	return user, uint(presetID), true
+}
+
+func handlePresetError(c *gin.Context, err error, action string) {
+	switch {
+	case stderrors.Is(err, services.ErrInvalidInstruction):
+		errors.HandleError(c, errors.New400Error(err.Error()))
+	case stderrors.Is(err, gorm.ErrRecordNotFound):
+		errors.HandleError(c, errors.New404Error("Preset not found"))
+	default:
+		errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("%s: %v", action, err)))
+	}
+}
+
+// handleInstructionError answers a request whose answer style could not be resolved
+func handleInstructionError(c *gin.Context, err error) {
+	handlePresetError(c, err, "failed to resolve answer style")
+}
+
+func presetJSON(p *models.InstructionPreset) gin.H {
+	return gin.H{
+		"id":          p.ID,
+		"name":        p.Name,
+		"description": p.Description,
+		"instruction": p.Instruction,
+		"created_at":  p.CreatedAt,
+		"updated_at":  p.UpdatedAt,
+	}
+}
@@ -0,0 +1,331 @@
+package api
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/services"
+	"nexus_scholar_go_backend/internal/utils/auth"
+	"nexus_scholar_go_backend/internal/utils/errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// projectRequestBody is the JSON body of project create and update requests
+type projectRequestBody struct {
+	Name             string   `json:"name" binding:"required"`
+	Description      string   `json:"description"`
+	DefaultPriceTier string   `json:"default_price_tier"`
+	ArxivIDs         []string `json:"arxiv_ids"`
+	DocumentIDs      []uint   `json:"document_ids"`
+}
+
+func (b projectRequestBody) input() services.ProjectInput {
+	return services.ProjectInput{
+		Name:             b.Name,
+		Description:      b.Description,
+		DefaultPriceTier: b.DefaultPriceTier,
+		ArxivIDs:         b.ArxivIDs,
+		DocumentIDs:      b.DocumentIDs,
+	}
+}
+
+func SetupProjectRoutes(r *gin.Engine, projectService *services.ProjectService, researchChatService *services.ResearchChatService, userService *services.UserService) {
+	api := r.Group("/api/projects", auth.AuthMiddleware(userService))
+	{
+		api.GET("", listProjectsHandler(projectService))
+		api.POST("", createProjectHandler(projectService))
+		api.GET("/:id", getProjectHandler(projectService))
+		api.PUT("/:id", updateProjectHandler(projectService))
+		api.DELETE("/:id", deleteProjectHandler(projectService))
+		api.GET("/:id/history", getProjectHistoryHandler(projectService))
+		api.GET("/:id/usage", getProjectUsageHandler(projectService))
+		api.POST("/:id/sessions", startProjectSessionHandler(projectService, researchChatService))
+		api.PUT("/:id/sessions/:session_id", addProjectSessionHandler(projectService))
+		api.DELETE("/:id/sessions/:session_id", removeProjectSessionHandler(projectService))
+	}
+}
+
+func listProjectsHandler(projectService *services.ProjectService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		projects, err := projectService.ListProjects(c.Request.Context(), user.ID)
+		if err != nil {
+			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to list projects: %v", err)))
+			return
+		}
+
+		result := make([]gin.H, len(projects))
+		for i := range projects {
+			result[i] = projectJSON(&projects[i])
+		}
+		c.JSON(http.StatusOK, gin.H{"projects": result})
+	}
+}
+
+func createProjectHandler(projectService *services.ProjectService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		var request projectRequestBody
+		if err := c.ShouldBindJSON(&request); err != nil {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+
+		project, err := projectService.CreateProject(c.Request.Context(), user.ID, request.input())
+		if err != nil {
+			handleProjectError(c, err, "failed to create project")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"project": projectJSON(project)})
+	}
+}
+
+func getProjectHandler(projectService *services.ProjectService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, projectID, ok := projectRequest(c)
+		if !ok {
+			return
+		}
+
+		project, err := projectService.GetProject(c.Request.Context(), user.ID, projectID)
+		if err != nil {
+			handleProjectError(c, err, "failed to get project")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"project": projectJSON(project)})
+	}
+}
+
+func updateProjectHandler(projectService *services.ProjectService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, projectID, ok := projectRequest(c)
+		if !ok {
+			return
+		}
+
+		var request projectRequestBody
+		if err := c.ShouldBindJSON(&request); err != nil {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+
+		project, err := projectService.UpdateProject(c.Request.Context(), user.ID, projectID, request.input())
+		if err != nil {
+			handleProjectError(c, err, "failed to update project")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"project": projectJSON(project)})
+	}
+}
+
+func deleteProjectHandler(projectService *services.ProjectService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, projectID, ok := projectRequest(c)
+		if !ok {
+			return
+		}
+
+		if err := projectService.DeleteProject(c.Request.Context(), user.ID, projectID); err != nil {
+			handleProjectError(c, err, "failed to delete project")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Project deleted"})
+	}
+}
+
+func getProjectHistoryHandler(projectService *services.ProjectService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, projectID, ok := projectRequest(c)
+		if !ok {
+			return
+		}
+
+		chats, err := projectService.GetProjectChatHistory(c.Request.Context(), user.ID, projectID)
+		if err != nil {
+			handleProjectError(c, err, "failed to retrieve project chat history")
+			return
+		}
+
+		chatHistory := make([]gin.H, len(chats))
+		for i := range chats {
+			chatHistory[i] = chatHistoryJSON(&chats[i])
+		}
+		c.JSON(http.StatusOK, gin.H{"chat_history": chatHistory})
+	}
+}
+
+func getProjectUsageHandler(projectService *services.ProjectService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, projectID, ok := projectRequest(c)
+		if !ok {
+			return
+		}
+
+		usage, err := projectService.GetProjectUsage(c.Request.Context(), user.ID, projectID)
+		if err != nil {
+			handleProjectError(c, err, "failed to get project usage")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"usage": usage})
+	}
+}
+
+func startProjectSessionHandler(projectService *services.ProjectService, researchChatService *services.ResearchChatService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, projectID, ok := projectRequest(c)
+		if !ok {
+			return
+		}
+
+		var request struct {
+			PriceTier         string `json:"price_tier"`
+			Preset            string `json:"preset"`
+			PresetID          uint   `json:"preset_id"`
+			SystemInstruction string `json:"system_instruction"`
+			IncludeFigures    bool   `json:"include_figures"`
+			ExtractionMode    string `json:"extraction_mode"`
+			models.GenerationParams
+		}
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&request); err != nil {
+				errors.HandleError(c, errors.New400Error(err.Error()))
+				return
+			}
+		}
+		if request.PriceTier != "" && request.PriceTier != "base" && request.PriceTier != "pro" {
+			errors.HandleError(c, errors.New400Error("Invalid price_tier. Must be 'base' or 'pro'."))
+			return
+		}
+
+		sessionRequest, err := projectService.SessionRequest(c.Request.Context(), user.ID, projectID, request.PriceTier)
+		if err != nil {
+			handleProjectError(c, err, "failed to prepare project session")
+			return
+		}
+
+		sessionRequest.Generation = request.GenerationParams
+		sessionRequest.IncludeFigures = request.IncludeFigures
+		sessionRequest.ExtractionMode = request.ExtractionMode
+		sessionRequest.Instruction = services.InstructionSelection{
+			Preset:      request.Preset,
+			PresetID:    request.PresetID,
+			Instruction: request.SystemInstruction,
+		}
+		session, err := researchChatService.StartResearchSession(c, sessionRequest)
+		if err != nil {
+			handleProjectError(c, err, "failed to start research session")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"cached_content_name": session.CacheName,
+			"session_id":          session.SessionID,
+			"project_id":          projectID,
+			"price_tier":          sessionRequest.PriceTier,
+		})
+	}
+}
+
+func addProjectSessionHandler(projectService *services.ProjectService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, projectID, ok := projectRequest(c)
+		if !ok {
+			return
+		}
+
+		if err := projectService.AddSession(c.Request.Context(), user.ID, projectID, c.Param("session_id")); err != nil {
+			handleProjectError(c, err, "failed to add session to project")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Session added to project"})
+	}
+}
+
+func removeProjectSessionHandler(projectService *services.ProjectService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, projectID, ok := projectRequest(c)
+		if !ok {
+			return
+		}
+
+		if err := projectService.RemoveSession(c.Request.Context(), user.ID, projectID, c.Param("session_id")); err != nil {
+			handleProjectError(c, err, "failed to remove session from project")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Session removed from project"})
+	}
+}
+
+// projectRequest resolves the current user and the :id parameter, writing the error response if either fails
+func projectRequest(c *gin.Context) (*models.User, uint, bool) {
+	user, err := currentUser(c)
+	if err != nil {
+		errors.HandleError(c, err)
+		return nil, 0, false
+	}
+	projectID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		errors.HandleError(c, errors.New400Error("Invalid project id"))
+		return nil, 0, false
+	}
+	return user, uint(projectID), true
+}
+
+func handleProjectError(c *gin.Context, err error, action string) {
+	switch {
+	case stderrors.Is(err, services.ErrInvalidProject), stderrors.Is(err, services.ErrInvalidInstruction), stderrors.Is(err, services.ErrInvalidGenerationParams),
+		stderrors.Is(err, services.ErrFiguresRequireProTier), stderrors.Is(err, services.ErrInvalidExtractionMode):
+		errors.HandleError(c, errors.New400Error(err.Error()))
+	case stderrors.Is(err, services.ErrInsufficientCredit):
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+	case stderrors.Is(err, gorm.ErrRecordNotFound):
+		errors.HandleError(c, errors.New404Error("Project, session or document not found"))
+	default:
+		errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("%s: %v", action, err)))
+	}
+}
+
+func projectJSON(p *models.Project) gin.H {
+	var arxivIDs []string
+	var documentIDs []uint
+	for _, doc := range p.Documents {
+		if doc.ArxivID != "" {
+			arxivIDs = append(arxivIDs, doc.ArxivID)
+		} else {
+			documentIDs = append(documentIDs, doc.DocumentID)
+		}
+	}
+	return gin.H{
+		"id":                 p.ID,
+		"name":               p.Name,
+		"description":        p.Description,
+		"default_price_tier": p.DefaultPriceTier,
+		"arxiv_ids":          arxivIDs,
+		"document_ids":       documentIDs,
+		"created_at":         p.CreatedAt.Format(time.RFC3339),
+		"updated_at":         p.UpdatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/services"
+	"nexus_scholar_go_backend/internal/utils/auth"
+	"nexus_scholar_go_backend/internal/utils/errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func SetupReportRoutes(r *gin.Engine, reportService *services.ReportService, userService *services.UserService) {
+	api := r.Group("/api/chat", auth.AuthMiddleware(userService))
+	{
+		api.GET("/:session_id/reports", listReportsHandler(reportService))
+		api.POST("/:session_id/reports", createReportHandler(reportService))
+		api.GET("/:session_id/reports/:report_id", getReportHandler(reportService))
+		api.POST("/:session_id/reports/:report_id/resume", resumeReportHandler(reportService))
+		api.GET("/:session_id/reports/:report_id/download", downloadReportHandler(reportService))
+		api.DELETE("/:session_id/reports/:report_id", deleteReportHandler(reportService))
+	}
+}
+
+func createReportHandler(reportService *services.ReportService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+		var request struct {
+			Topic string `json:"topic"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+
+		report, err := reportService.CreateReport(c.Request.Context(), user.ID, c.Param("session_id"), request.Topic)
+		if err != nil {
+			handleReportError(c, err, "failed to create report")
+			return
+		}
+		c.JSON(http.StatusAccepted, reportJSON(report, false))
+	}
+}
+
+func listReportsHandler(reportService *services.ReportService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		reports, err := reportService.ListReports(c.Request.Context(), user.ID, c.Param("session_id"))
+		if err != nil {
+			handleReportError(c, err, "failed to list reports")
+			return
+		}
+		result := make([]gin.H, len(reports))
+		for i := range reports {
+			result[i] = reportJSON(&reports[i], false)
+		}
+		c.JSON(http.StatusOK, gin.H{"reports": result})
+	}
+}
+
+func getReportHandler(reportService *services.ReportService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, reportID, ok := reportRequest(c)
+		if !ok {
+			return
+		}
+
+		report, err := reportService.GetReport(c.Request.Context(), user.ID, c.Param("session_id"), reportID)
+		if err != nil {
+			handleReportError(c, err, "failed to get report")
+			return
+		}
+		c.JSON(http.StatusOK, reportJSON(report, true))
+	}
+}
+
+func resumeReportHandler(reportService *services.ReportService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, reportID, ok := reportRequest(c)
+		if !ok {
+			return
+		}
+
+		report, err := reportService.ResumeReport(c.Request.Context(), user.ID, c.Param("session_id"), reportID)
+		if err != nil {
+			handleReportError(c, err, "failed to resume report")
+			return
+		}
+		c.JSON(http.StatusAccepted, reportJSON(report, false))
+	}
+}
+
+func downloadReportHandler(reportService *services.ReportService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, reportID, ok := reportRequest(c)
+		if !ok {
+			return
+		}
+
+		export, err := reportService.DownloadReport(c.Request.Context(), user.ID, c.Param("session_id"), reportID, c.Query("format"))
+		if err != nil {
+			handleReportError(c, err, "failed to download report")
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.FileName))
+		c.Data(http.StatusOK, export.ContentType, export.Content)
+	}
+}
+
+func deleteReportHandler(reportService *services.ReportService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, reportID, ok := reportRequest(c)
+		if !ok {
+			return
+		}
+
+		if err := reportService.DeleteReport(c.Request.Context(), user.ID, c.Param("session_id"), reportID); err != nil {
+			handleReportError(c, err, "failed to delete report")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Report deleted"})
+	}
+}
+
+// reportRequest resolves the current user and the report ID of the route
+func reportRequest(c *gin.Context) (*models.User, uint, bool) {
+	user, err := currentUser(c)
+	if err != nil {
+		errors.HandleError(c, err)
+		return nil, 0, false
+	}
+	reportID, err := strconv.ParseUint(c.Param("report_id"), 10, 64)
+	if err != nil {
+		errors.HandleError(c, errors.New400Error("Invalid report id"))
+		return nil, 0, false
+	}
+	return user, uint(reportID), true
+}
+
+func handleReportError(c *gin.Context, err error, action string) {
+	switch {
+	case stderrors.Is(err, services.ErrInvalidReport), stderrors.Is(err, services.ErrInvalidExportFormat):
+		errors.HandleError(c, errors.New400Error(err.Error()))
+	case stderrors.Is(err, services.ErrInsufficientCredit):
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+	case stderrors.Is(err, services.ErrCorpusUnavailable), stderrors.Is(err, services.ErrReportNotReady):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case stderrors.Is(err, gorm.ErrRecordNotFound):
+		errors.HandleError(c, errors.New404Error("Session or report not found"))
+	default:
+		errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("%s: %v", action, err)))
+	}
+}
+
+// reportJSON describes a report and its outline, with the text of the sections when
+// withContent is set
+func reportJSON(r *models.Report, withContent bool) gin.H {
+	sections := make([]gin.H, len(r.Sections))
+	written := 0
+	for i, section := range r.Sections {
+		sections[i] = gin.H{
+			"id":        section.ID,
+			"title":     section.Title,
+			"brief":     section.Brief,
+			"generated": section.Generated,
+		}
+		if withContent {
+			sections[i]["content"] = section.Content
+		}
+		if section.Generated {
+			written++
+		}
+	}
+	return gin.H{
+		"id":               r.ID,
+		"topic":            r.Topic,
+		"title":            r.Title,
+		"status":           r.Status,
+		"error":            r.Error,
+		"completed":        written,
+		"total":            len(r.Sections),
+		"token_count_used": r.TokenCountUsed,
+		"token_hours_used": r.TokenHoursUsed,
+		"sections":         sections,
+		"created_at":       r.CreatedAt,
+	}
+}
@@ -3,12 +3,13 @@ package api
 import (
 	"context"
 	"encoding/json"
+	stderrors "errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"nexus_scholar_go_backend/internal/models"
@@ -24,28 +25,32 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/stripe/stripe-go/v79"
 	"google.golang.org/api/iterator"
+	"gorm.io/gorm"
 )
 
-func SetupRoutes(r *gin.Engine, researchChatService *services.ResearchChatService, chatService services.ChatServiceDB, stripeService *services.StripeService, cacheManagementService *services.CacheManagementService, userService *services.UserService, messageBroker *broker.Broker, log zerolog.Logger) {
+func SetupRoutes(r *gin.Engine, researchChatService *services.ResearchChatService, chatService services.ChatServiceDB, stripeService *services.StripeService, cacheManagementService *services.CacheManagementService, userService *services.UserService, arxivMetadataService *services.ArxivMetadataService, documentService *services.DocumentService, projectService *services.ProjectService, workspaceService *services.WorkspaceService, messageBroker *broker.Broker, log zerolog.Logger) {
 	api := r.Group("/api")
 	{
-		api.GET("/papers/:arxiv_id", auth.AuthMiddleware(userService), getPaper(log))
-		api.GET("/papers/:arxiv_id/title", auth.AuthMiddleware(userService), getPaperTitle(log))
+		api.GET("/papers/search", auth.AuthMiddleware(userService), searchPapersHandler(arxivMetadataService))
+		api.GET("/papers/:arxiv_id", auth.AuthMiddleware(userService), getPaper(arxivMetadataService, log))
+		api.GET("/papers/:arxiv_id/title", auth.AuthMiddleware(userService), getPaperTitle(arxivMetadataService, log))
+		api.GET("/papers/:arxiv_id/versions", auth.AuthMiddleware(userService), getPaperVersionsHandler())
 		api.GET("/private", auth.AuthMiddleware(userService), privateRoute)
-		api.POST("/create-research-session", auth.AuthMiddleware(userService), createResearchSessionHandler(researchChatService))
+		api.POST("/create-research-session", auth.AuthMiddleware(userService), createResearchSessionHandler(researchChatService, documentService, projectService, workspaceService))
+		api.POST("/estimate-research-session", auth.AuthMiddleware(userService), estimateResearchSessionHandler(researchChatService, workspaceService))
+		api.GET("/generation-limits", auth.AuthMiddleware(userService), getGenerationLimitsHandler)
 		api.GET("/raw-cache", auth.AuthMiddleware(userService), getRawCacheHandler(researchChatService))
-		api.POST("/chat/message", auth.AuthMiddleware(userService), sendChatMessageHandler(researchChatService))
+		api.POST("/chat/message", auth.AuthMiddleware(userService), sendChatMessageHandler(researchChatService, workspaceService))
 		api.POST("/chat/terminate", auth.AuthMiddleware(userService), terminateChatSessionHandler(researchChatService))
-		api.GET("/chat/history", auth.AuthMiddleware(userService), getChatHistoryHandler(researchChatService))
-		api.POST("/purchase-cache-volume", auth.AuthMiddleware(userService), purchaseCacheVolume(stripeService))
+		api.POST("/purchase-cache-volume", auth.AuthMiddleware(userService), purchaseCacheVolume(stripeService, workspaceService))
 		api.GET("/cache-usage", auth.AuthMiddleware(userService), getCacheUsageHandler(cacheManagementService, chatService, log))
-		api.POST("/stripe/webhook", stripeWebhookHandler(stripeService, cacheManagementService, messageBroker))
-		api.POST("/stripe/webhook_clitest", stripeWebhookHandler_clitest(stripeService, cacheManagementService, messageBroker))
-		// api.GET("/test-bib-parsing/:arxiv_id", testBibParsingHandler(log))
+		api.POST("/stripe/webhook", stripeWebhookHandler(stripeService, cacheManagementService, workspaceService, messageBroker))
+		api.POST("/stripe/webhook_clitest", stripeWebhookHandler_clitest(stripeService, cacheManagementService, workspaceService, messageBroker))
+		// api.GET("/test-bib-parsing/:arxiv_id", testBibParsingHandler(arxivMetadataService, log))
 	}
 }
 
-func getPaper(log zerolog.Logger) gin.HandlerFunc {
+func getPaper(arxivMetadataService *services.ArxivMetadataService, log zerolog.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		arxivID := c.Param("arxiv_id")
 		if arxivID == "" {
@@ -53,7 +58,7 @@ func getPaper(log zerolog.Logger) gin.HandlerFunc {
 			return
 		}
 
-		paperLoader := services.NewPaperLoader(log)
+		paperLoader := services.NewPaperLoader(log, arxivMetadataService)
 		result, err := paperLoader.ProcessPaper(arxivID)
 		if err != nil {
 			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to process paper: %v", err)))
@@ -64,7 +69,7 @@ func getPaper(log zerolog.Logger) gin.HandlerFunc {
 	}
 }
 
-func getPaperTitle(log zerolog.Logger) gin.HandlerFunc {
+func getPaperTitle(arxivMetadataService *services.ArxivMetadataService, log zerolog.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		arxivID := c.Param("arxiv_id")
 		if arxivID == "" {
@@ -73,7 +78,7 @@ func getPaperTitle(log zerolog.Logger) gin.HandlerFunc {
 		}
 		parentArxivID := c.Query("parent_arxiv_id")
 
-		paperLoader := services.NewPaperLoader(log)
+		paperLoader := services.NewPaperLoader(log, arxivMetadataService)
 		metadata, err := paperLoader.GetPaperMetadata(arxivID)
 		if err != nil {
 			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to get paper metadata: %v", err)))
@@ -114,6 +119,70 @@ func getPaperTitle(log zerolog.Logger) gin.HandlerFunc {
 	}
 }
 
+func getPaperVersionsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		arxivID := c.Param("arxiv_id")
+		if arxivID == "" {
+			errors.HandleError(c, errors.New400Error("ArXiv ID is required"))
+			return
+		}
+
+		versions, err := services.GetPaperVersions(arxivID)
+		if err != nil {
+			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to get paper versions: %v", err)))
+			return
+		}
+
+		result := make([]gin.H, len(versions))
+		for i, v := range versions {
+			result[i] = gin.H{
+				"version":      v.Version,
+				"title":        v.Title,
+				"authors":      v.Authors,
+				"abstract":     v.Abstract,
+				"last_updated": v.LastUpdated,
+				"diff_summary": v.DiffSummary,
+				"recorded_at":  v.CreatedAt.Format(time.RFC3339),
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"arxiv_id": arxivID, "versions": result})
+	}
+}
+
+func searchPapersHandler(arxivMetadataService *services.ArxivMetadataService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := strings.TrimSpace(c.Query("q"))
+		if query == "" {
+			errors.HandleError(c, errors.New400Error("Query parameter q is required"))
+			return
+		}
+
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || limit < 1 || limit > 100 {
+			errors.HandleError(c, errors.New400Error("limit must be between 1 and 100"))
+			return
+		}
+		offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if err != nil || offset < 0 {
+			errors.HandleError(c, errors.New400Error("offset must be a non-negative integer"))
+			return
+		}
+
+		results, total, err := arxivMetadataService.Search(c.Request.Context(), query, limit, offset)
+		if err != nil {
+			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to search papers: %v", err)))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"results": results,
+			"total":   total,
+			"limit":   limit,
+			"offset":  offset,
+		})
+	}
+}
+
 func privateRoute(c *gin.Context) {
 	user, _ := c.Get("user")
 	c.JSON(http.StatusOK, gin.H{
@@ -122,13 +191,19 @@ func privateRoute(c *gin.Context) {
 	})
 }
 
-func createResearchSessionHandler(researchChatService *services.ResearchChatService) gin.HandlerFunc {
+func createResearchSessionHandler(researchChatService *services.ResearchChatService, documentService *services.DocumentService, projectService *services.ProjectService, workspaceService *services.WorkspaceService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Log request details
 		log.Info().Msgf("Request Method: %s", c.Request.Method)
 		log.Info().Msgf("Request Headers: %v", c.Request.Header)
 		log.Info().Msgf("Request Content-Type: %s", c.ContentType())
 
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
 		priceTier := c.PostForm("price_tier")
 		if priceTier != "base" && priceTier != "pro" {
 			errors.HandleError(c, errors.New400Error("Invalid price_tier. Must be 'base' or 'pro'."))
@@ -136,100 +211,342 @@ func createResearchSessionHandler(researchChatService *services.ResearchChatServ
 		}
 
 		// Get arXiv IDs
-		arxivIDsJSON := c.PostForm("arxiv_ids")
 		var arxivIDs []string
-		if err := json.Unmarshal([]byte(arxivIDsJSON), &arxivIDs); err != nil {
-			errors.HandleError(c, errors.New400Error("Invalid arXiv IDs format"))
+		if arxivIDsJSON := c.PostForm("arxiv_ids"); arxivIDsJSON != "" {
+			if err := json.Unmarshal([]byte(arxivIDsJSON), &arxivIDs); err != nil {
+				errors.HandleError(c, errors.New400Error("Invalid arXiv IDs format"))
+				return
+			}
+		}
+
+		// Get documents already in the user's library
+		var documentIDs []uint
+		if documentIDsJSON := c.PostForm("document_ids"); documentIDsJSON != "" {
+			if err := json.Unmarshal([]byte(documentIDsJSON), &documentIDs); err != nil {
+				errors.HandleError(c, errors.New400Error("Invalid document IDs format"))
+				return
+			}
+		}
+
+		// Optionally file the session under one of the user's projects
+		var projectID *uint
+		if projectIDValue := c.PostForm("project_id"); projectIDValue != "" {
+			id, err := strconv.ParseUint(projectIDValue, 10, 64)
+			if err != nil {
+				errors.HandleError(c, errors.New400Error("Invalid project_id"))
+				return
+			}
+			project, err := projectService.GetProject(c.Request.Context(), user.ID, uint(id))
+			if err != nil {
+				handleProjectError(c, err, "failed to get project")
+				return
+			}
+			projectID = &project.ID
+		}
+
+		// Optionally share the session with a workspace and bill it to the workspace pool
+		var workspaceID *uint
+		if workspaceIDValue := c.PostForm("workspace_id"); workspaceIDValue != "" {
+			id, err := strconv.ParseUint(workspaceIDValue, 10, 64)
+			if err != nil {
+				errors.HandleError(c, errors.New400Error("Invalid workspace_id"))
+				return
+			}
+			member, err := workspaceService.GetMember(c.Request.Context(), uint(id), user.ID)
+			if err != nil {
+				handleWorkspaceError(c, err, "failed to get workspace")
+				return
+			}
+			workspaceID = &member.WorkspaceID
+		}
+
+		// Optionally set the answer style of the session: a built-in preset, one of the
+		// user's presets or a custom instruction
+		instruction := services.InstructionSelection{
+			Preset:      c.PostForm("preset"),
+			Instruction: c.PostForm("system_instruction"),
+		}
+		if presetIDValue := c.PostForm("preset_id"); presetIDValue != "" {
+			id, err := strconv.ParseUint(presetIDValue, 10, 64)
+			if err != nil {
+				errors.HandleError(c, errors.New400Error("Invalid preset_id"))
+				return
+			}
+			instruction.PresetID = uint(id)
+		}
+
+		generation, err := generationParamsForm(c)
+		if err != nil {
+			errors.HandleError(c, err)
 			return
 		}
 
-		// Create a temporary directory to store uploaded files
-		tempDir, err := os.MkdirTemp("", "user_pdfs_")
+		budget, err := corpusBudgetForm(c)
 		if err != nil {
-			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to create temporary directory: %v", err)))
+			errors.HandleError(c, err)
 			return
 		}
-		defer os.RemoveAll(tempDir) // Clean up the temporary directory when done
 
-		// Save uploaded files and collect their paths
-		var pdfPaths []string
+		var includeFigures bool
+		if value := c.PostForm("include_figures"); value != "" {
+			if includeFigures, err = strconv.ParseBool(value); err != nil {
+				errors.HandleError(c, errors.New400Error("Invalid include_figures"))
+				return
+			}
+		}
+		extractionMode := c.PostForm("extraction_mode")
+
+		// Uploaded PDFs are added to the library so they can be reused in later sessions
 		form, err := c.MultipartForm()
 		if err != nil {
 			errors.HandleError(c, errors.New400Error("Failed to parse multipart form"))
 			return
 		}
-		files := form.File["pdfs"]
-		for _, fileHeader := range files {
-			filename := filepath.Join(tempDir, fileHeader.Filename)
-			if err := c.SaveUploadedFile(fileHeader, filename); err != nil {
-				errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to save file %s: %v", fileHeader.Filename, err)))
+		for _, fileHeader := range form.File["pdfs"] {
+			document, err := saveUploadedDocument(c, documentService, user, fileHeader)
+			if err != nil {
+				errors.HandleError(c, err)
 				return
 			}
-			pdfPaths = append(pdfPaths, filename)
+			documentIDs = append(documentIDs, document.ID)
+		}
+
+		if len(arxivIDs) == 0 && len(documentIDs) == 0 {
+			errors.HandleError(c, errors.New400Error("At least one arXiv paper or document is required"))
+			return
 		}
 
-		sessionID, cachedContentName, err := researchChatService.StartResearchSession(c, arxivIDs, pdfPaths, priceTier)
+		session, err := researchChatService.StartResearchSession(c, services.ResearchSessionRequest{
+			ArxivIDs:       arxivIDs,
+			DocumentIDs:    documentIDs,
+			PriceTier:      priceTier,
+			ProjectID:      projectID,
+			WorkspaceID:    workspaceID,
+			Instruction:    instruction,
+			Generation:     generation,
+			Budget:         budget,
+			IncludeFigures: includeFigures,
+			ExtractionMode: extractionMode,
+		})
+		if stderrors.Is(err, services.ErrInvalidInstruction) {
+			handleInstructionError(c, err)
+			return
+		}
+		if stderrors.Is(err, services.ErrInvalidGenerationParams) || stderrors.Is(err, services.ErrInvalidCorpusBudget) || stderrors.Is(err, services.ErrCorpusOverBudget) ||
+			stderrors.Is(err, services.ErrFiguresRequireProTier) || stderrors.Is(err, services.ErrInvalidExtractionMode) {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+		if stderrors.Is(err, services.ErrInsufficientCredit) {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+			return
+		}
+		if stderrors.Is(err, gorm.ErrRecordNotFound) {
+			errors.HandleError(c, errors.New404Error("Document not found"))
+			return
+		}
 		if err != nil {
 			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to start research session: %v", err)))
 			return
 		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"cached_content_name": cachedContentName,
-			"session_id":          sessionID,
+			"cached_content_name": session.CacheName,
+			"session_id":          session.SessionID,
+			"document_ids":        documentIDs,
+			"workspace_id":        workspaceID,
+			"corpus":              corpusPlanJSON(session.Corpus),
 		})
 	}
 }
 
-func sendChatMessageHandler(researchChatService *services.ResearchChatService) gin.HandlerFunc {
+// maxEstimateDuration bounds the session durations that can be estimated
+const maxEstimateDuration = 24 * time.Hour
+
+// estimateResearchSessionHandler counts the tokens of a prospective session's corpus and
+// estimates its cost, so the cost can be shown before the session is started. Uploads must be
+// added to the library first and passed as document IDs.
+func estimateResearchSessionHandler(researchChatService *services.ResearchChatService, workspaceService *services.WorkspaceService) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
 		var request struct {
-			SessionID string `json:"session_id" binding:"required"`
-			Message   string `json:"message" binding:"required"`
+			ArxivIDs          []string `json:"arxiv_ids"`
+			DocumentIDs       []uint   `json:"document_ids"`
+			PriceTier         string   `json:"price_tier" binding:"required"`
+			WorkspaceID       *uint    `json:"workspace_id"`
+			Preset            string   `json:"preset"`
+			PresetID          uint     `json:"preset_id"`
+			SystemInstruction string   `json:"system_instruction"`
+			DurationMinutes   float64  `json:"duration_minutes"`
+			TokenBudget       int32    `json:"token_budget"`
+			AbstractFallback  bool     `json:"abstract_fallback"`
+			IncludeFigures    bool     `json:"include_figures"`
+			ExtractionMode    string   `json:"extraction_mode"`
 		}
-
 		if err := c.ShouldBindJSON(&request); err != nil {
 			errors.HandleError(c, errors.New400Error(err.Error()))
 			return
 		}
+		if request.PriceTier != "base" && request.PriceTier != "pro" {
+			errors.HandleError(c, errors.New400Error("Invalid price_tier. Must be 'base' or 'pro'."))
+			return
+		}
+		if len(request.ArxivIDs) == 0 && len(request.DocumentIDs) == 0 {
+			errors.HandleError(c, errors.New400Error("At least one arXiv paper or document is required"))
+			return
+		}
+		duration := time.Duration(request.DurationMinutes * float64(time.Minute))
+		if duration < 0 || duration > maxEstimateDuration {
+			errors.HandleError(c, errors.New400Error(fmt.Sprintf("duration_minutes must be between 0 and %.0f", maxEstimateDuration.Minutes())))
+			return
+		}
+		if request.WorkspaceID != nil {
+			if _, err := workspaceService.GetMember(c.Request.Context(), *request.WorkspaceID, user.ID); err != nil {
+				handleWorkspaceError(c, err, "failed to get workspace")
+				return
+			}
+		}
 
-		responseIterator, err := researchChatService.SendMessage(c.Request.Context(), request.SessionID, request.Message)
+		estimate, err := researchChatService.EstimateResearchSession(c.Request.Context(), user.ID, services.ResearchSessionRequest{
+			ArxivIDs:    request.ArxivIDs,
+			DocumentIDs: request.DocumentIDs,
+			PriceTier:   request.PriceTier,
+			WorkspaceID: request.WorkspaceID,
+			Instruction: services.InstructionSelection{
+				Preset:      request.Preset,
+				PresetID:    request.PresetID,
+				Instruction: request.SystemInstruction,
+			},
+			Budget:         corpusBudget(request.TokenBudget, request.AbstractFallback),
+			IncludeFigures: request.IncludeFigures,
+			ExtractionMode: request.ExtractionMode,
+		}, duration)
+		if stderrors.Is(err, services.ErrInvalidInstruction) {
+			handleInstructionError(c, err)
+			return
+		}
+		if stderrors.Is(err, services.ErrInvalidCorpusBudget) || stderrors.Is(err, services.ErrCorpusOverBudget) ||
+			stderrors.Is(err, services.ErrFiguresRequireProTier) || stderrors.Is(err, services.ErrInvalidExtractionMode) {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+		if stderrors.Is(err, gorm.ErrRecordNotFound) {
+			errors.HandleError(c, errors.New404Error("Document not found"))
+			return
+		}
 		if err != nil {
-			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to send message: %v", err)))
+			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to estimate research session: %v", err)))
 			return
 		}
 
-		// Stream the response back to the client
-		c.Stream(func(w io.Writer) bool {
-			response, err := responseIterator.Next()
-			if err == iterator.Done {
-				return false
-			}
-			if err != nil {
-				c.SSEvent("error", err.Error())
-				return false
-			}
+		c.JSON(http.StatusOK, gin.H{
+			"token_count":      estimate.TokenCount,
+			"duration_minutes": estimate.Duration.Minutes(),
+			"token_hours":      estimate.TokenHours,
+			"remaining_credit": estimate.RemainingCredit,
+			"affordable":       estimate.Affordable,
+			"corpus":           corpusPlanJSON(estimate.Corpus),
+		})
+	}
+}
 
-			if len(response.Candidates) > 0 && len(response.Candidates[0].Content.Parts) > 0 {
-				content := response.Candidates[0].Content.Parts[0].(genai.Text)
-				c.SSEvent("message", string(content))
+// corpusBudgetForm reads the optional token budget of a session creation form
+func corpusBudgetForm(c *gin.Context) (*services.CorpusBudget, error) {
+	value := c.PostForm("token_budget")
+	if value == "" {
+		return nil, nil
+	}
+	maxTokens, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return nil, errors.New400Error("Invalid token_budget")
+	}
+	var abstractFallback bool
+	if fallbackValue := c.PostForm("abstract_fallback"); fallbackValue != "" {
+		if abstractFallback, err = strconv.ParseBool(fallbackValue); err != nil {
+			return nil, errors.New400Error("Invalid abstract_fallback")
+		}
+	}
+	return corpusBudget(int32(maxTokens), abstractFallback), nil
+}
 
-				// Save AI response
-				if err := researchChatService.SaveAIResponse(request.SessionID, string(content)); err != nil {
-					c.SSEvent("error", fmt.Sprintf("Failed to save AI response: %v", err))
-					return false
-				}
-			}
+// corpusBudget returns the budget a token count asks for, nil for no budget
+func corpusBudget(maxTokens int32, abstractFallback bool) *services.CorpusBudget {
+	if maxTokens == 0 {
+		return nil
+	}
+	return &services.CorpusBudget{MaxTokens: maxTokens, AllowAbstracts: abstractFallback}
+}
 
-			return true
+// corpusPlanJSON reports the size of a session's corpus, how many figure images it caches and
+// what was trimmed to fit its budget
+func corpusPlanJSON(plan *services.CorpusPlan) gin.H {
+	trimmed := []gin.H{}
+	for _, doc := range plan.Trimmed() {
+		trimmed = append(trimmed, gin.H{
+			"position": doc.Index,
+			"title":    doc.Title,
+			"trims":    doc.Trims,
 		})
 	}
+	return gin.H{
+		"token_count":          plan.TokenCount,
+		"original_token_count": plan.OriginalTokenCount,
+		"figures":              len(plan.Figures),
+		"trimmed":              trimmed,
+	}
 }
 
-func terminateChatSessionHandler(researchChatService *services.ResearchChatService) gin.HandlerFunc {
+// generationParamsForm reads the optional generation parameters of a session creation form
+func generationParamsForm(c *gin.Context) (models.GenerationParams, error) {
+	params := models.GenerationParams{SafetyThreshold: c.PostForm("safety_threshold")}
+	var err error
+	if params.Temperature, err = float32Form(c, "temperature"); err != nil {
+		return params, err
+	}
+	if params.TopP, err = float32Form(c, "top_p"); err != nil {
+		return params, err
+	}
+	if value := c.PostForm("max_output_tokens"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return params, errors.New400Error("Invalid max_output_tokens")
+		}
+		tokens := int32(parsed)
+		params.MaxOutputTokens = &tokens
+	}
+	return params, nil
+}
+
+// float32Form reads an optional number form field, nil when it is absent
+func float32Form(c *gin.Context, name string) (*float32, error) {
+	value := c.PostForm(name)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		return nil, errors.New400Error("Invalid " + name)
+	}
+	number := float32(parsed)
+	return &number, nil
+}
+
+func getGenerationLimitsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"tiers": services.TierGenerationLimits()})
+}
+
+func sendChatMessageHandler(researchChatService *services.ResearchChatService, workspaceService *services.WorkspaceService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var request struct {
-			SessionID string `json:"session_id" binding:"required"`
+			SessionID   string `json:"session_id" binding:"required"`
+			Message     string `json:"message" binding:"required"`
+			Preset      string `json:"preset"`      // answer style for this prompt only
+			PresetID    uint   `json:"preset_id"`   // or one of the user's presets
+			Instruction string `json:"instruction"` // or a one-off instruction
 		}
 
 		if err := c.ShouldBindJSON(&request); err != nil {
@@ -237,61 +554,129 @@ func terminateChatSessionHandler(researchChatService *services.ResearchChatServi
 			return
 		}
 
-		err := researchChatService.EndResearchSession(c.Request.Context(), request.SessionID)
+		user, err := currentUser(c)
 		if err != nil {
-			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to terminate research session: %v", err)))
+			errors.HandleError(c, err)
+			return
+		}
+		chat, ok := authorizeSessionID(c, workspaceService, request.SessionID)
+		if !ok {
+			return
+		}
+		instruction, err := researchChatService.ResolveInstruction(c.Request.Context(), user.ID, services.InstructionSelection{
+			Preset:      request.Preset,
+			PresetID:    request.PresetID,
+			Instruction: request.Instruction,
+		})
+		if err != nil {
+			handleInstructionError(c, err)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Research session terminated successfully"})
+		// Collaborative sessions may be answering another participant's prompt
+		release, ok := acquireTurn(c, researchChatService, chat.SessionID)
+		if !ok {
+			return
+		}
+		defer release()
+
+		responseIterator, err := researchChatService.SendMessage(c.Request.Context(), chat.SessionID, request.Message, instruction)
+		if err != nil {
+			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to send message: %v", err)))
+			return
+		}
+
+		if _, err := researchChatService.SaveMessageToDB(c.Request.Context(), chat.SessionID, "user", request.Message, &user.ID); err != nil {
+			log.Error().Err(err).Msgf("Failed to save prompt of session %s", chat.SessionID)
+		}
+		streamAnswer(c, researchChatService, chat.SessionID, responseIterator)
 	}
 }
 
-func getChatHistoryHandler(researchChatService *services.ResearchChatService) gin.HandlerFunc {
+// streamAnswer sends an answer to the client as server-sent events while it is generated and
+// saves it once complete
+func streamAnswer(c *gin.Context, researchChatService *services.ResearchChatService, sessionID string, responseIterator *genai.GenerateContentResponseIterator) {
+	var answer strings.Builder
+	c.Stream(func(w io.Writer) bool {
+		response, err := responseIterator.Next()
+		if err == iterator.Done {
+			if _, err := researchChatService.SaveMessageToDB(c.Request.Context(), sessionID, "ai", answer.String(), nil); err != nil {
+				c.SSEvent("error", fmt.Sprintf("Failed to save AI response: %v", err))
+			}
+			return false
+		}
+		if err != nil {
+			c.SSEvent("error", err.Error())
+			return false
+		}
+
+		if len(response.Candidates) > 0 && response.Candidates[0].Content != nil && len(response.Candidates[0].Content.Parts) > 0 {
+			if content, ok := response.Candidates[0].Content.Parts[0].(genai.Text); ok {
+				answer.WriteString(string(content))
+				c.SSEvent("message", string(content))
+			}
+		}
+
+		return true
+	})
+}
+
+func terminateChatSessionHandler(researchChatService *services.ResearchChatService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user, exists := c.Get("user")
-		if !exists {
-			errors.HandleError(c, errors.New401Error())
-			return
+		var request struct {
+			SessionID string `json:"session_id" binding:"required"`
 		}
 
-		userModel, ok := user.(*models.User)
-		if !ok {
-			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to cast user to *models.User")))
+		if err := c.ShouldBindJSON(&request); err != nil {
+			errors.HandleError(c, errors.New400Error(err.Error()))
 			return
 		}
 
-		chats, err := researchChatService.GetUserChatHistory(userModel.ID)
+		err := researchChatService.EndResearchSession(c.Request.Context(), request.SessionID)
 		if err != nil {
-			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to retrieve chat history: %v", err)))
+			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to terminate research session: %v", err)))
 			return
 		}
 
-		// Process chats to return a more user-friendly format
-		var chatHistory []gin.H
-		for _, chat := range chats {
-			messages := make([]gin.H, len(chat.Messages))
-			for i, msg := range chat.Messages {
-				messages[i] = gin.H{
-					"type":      msg.Type,
-					"content":   msg.Content,
-					"timestamp": msg.Timestamp.Format(time.RFC3339),
-				}
-			}
+		c.JSON(http.StatusOK, gin.H{"message": "Research session terminated successfully"})
+	}
+}
 
-			chatHistory = append(chatHistory, gin.H{
-				"session_id":       chat.SessionID,
-				"messages":         messages,
-				"created_at":       chat.CreatedAt.Format(time.RFC3339),
-				"chat_duration":    chat.ChatDuration,
-				"token_count_used": chat.TokenCountUsed,
-				"price_tier":       chat.PriceTier,
-				"token_hours_used": chat.TokenHoursUsed,
-				"termination_time": chat.TerminationTime.Format(time.RFC3339),
-			})
-		}
+func chatHistoryJSON(chat *models.Chat) gin.H {
+	messages := make([]gin.H, len(chat.Messages))
+	for i := range chat.Messages {
+		messages[i] = messageJSON(&chat.Messages[i])
+	}
 
-		c.JSON(http.StatusOK, gin.H{"chat_history": chatHistory})
+	return gin.H{
+		"session_id":         chat.SessionID,
+		"title":              chat.Title,
+		"summary":            chat.Summary,
+		"project_id":         chat.ProjectID,
+		"active_branch_id":   chat.ActiveBranchID,
+		"instruction_preset": chat.InstructionPreset,
+		"system_instruction": chat.SystemInstruction,
+		"generation":         chat.Generation,
+		"messages":           messages,
+		"created_at":         chat.CreatedAt.Format(time.RFC3339),
+		"chat_duration":      chat.ChatDuration,
+		"token_count_used":   chat.TokenCountUsed,
+		"price_tier":         chat.PriceTier,
+		"token_hours_used":   chat.TokenHoursUsed,
+		"termination_time":   chat.TerminationTime.Format(time.RFC3339),
+	}
+}
+
+func messageJSON(msg *models.Message) gin.H {
+	return gin.H{
+		"id":          msg.ID,
+		"type":        msg.Type,
+		"content":     msg.Content,
+		"user_id":     msg.UserID,
+		"parent_id":   msg.ParentID,
+		"branch_id":   msg.BranchID,
+		"suggestions": msg.Suggestions,
+		"timestamp":   msg.Timestamp.Format(time.RFC3339),
 	}
 }
 
@@ -313,11 +698,12 @@ func getRawCacheHandler(researchChatService *services.ResearchChatService) gin.H
 	}
 }
 
-func purchaseCacheVolume(stripeService *services.StripeService) gin.HandlerFunc {
+func purchaseCacheVolume(stripeService *services.StripeService, workspaceService *services.WorkspaceService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var request struct {
-			PriceTier  string `json:"price_tier" binding:"required"`
-			TokenHours string `json:"token_hours" binding:"required"`
+			PriceTier   string `json:"price_tier" binding:"required"`
+			TokenHours  string `json:"token_hours" binding:"required"`
+			WorkspaceID *uint  `json:"workspace_id"` // top up a workspace pool instead of the user's own credit
 		}
 
 		if err := c.ShouldBindJSON(&request); err != nil {
@@ -347,7 +733,14 @@ func purchaseCacheVolume(stripeService *services.StripeService) gin.HandlerFunc
 			return
 		}
 
-		session, err := stripeService.CreateCheckoutSession(userModel.ID.String(), priceID, tokenHours, priceTier)
+		if request.WorkspaceID != nil {
+			if _, err := workspaceService.RequireRole(c.Request.Context(), *request.WorkspaceID, userModel.ID, models.WorkspaceRoleAdmin); err != nil {
+				handleWorkspaceError(c, err, "failed to get workspace")
+				return
+			}
+		}
+
+		session, err := stripeService.CreateCheckoutSession(userModel.ID.String(), priceID, tokenHours, priceTier, request.WorkspaceID)
 		if err != nil {
 			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to create checkout session: %v", err)))
 			return
@@ -357,7 +750,7 @@ func purchaseCacheVolume(stripeService *services.StripeService) gin.HandlerFunc
 	}
 }
 
-func stripeWebhookHandler(stripeService *services.StripeService, cacheManagementService *services.CacheManagementService, messageBroker *broker.Broker) gin.HandlerFunc {
+func stripeWebhookHandler(stripeService *services.StripeService, cacheManagementService *services.CacheManagementService, workspaceService *services.WorkspaceService, messageBroker *broker.Broker) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		const MaxBodyBytes = int64(65536)
 		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxBodyBytes)
@@ -387,7 +780,7 @@ func stripeWebhookHandler(stripeService *services.StripeService, cacheManagement
 				return
 			}
 
-			err = processSuccessfulCheckoutSession(session, cacheManagementService, messageBroker)
+			err = processSuccessfulCheckoutSession(session, cacheManagementService, workspaceService, messageBroker)
 			if err != nil {
 				fmt.Printf("Error processing checkout session: %v\n", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process checkout session"})
@@ -402,7 +795,7 @@ func stripeWebhookHandler(stripeService *services.StripeService, cacheManagement
 	}
 }
 
-func processSuccessfulCheckoutSession(session stripe.CheckoutSession, cacheManagementService *services.CacheManagementService, messageBroker *broker.Broker) error {
+func processSuccessfulCheckoutSession(session stripe.CheckoutSession, cacheManagementService *services.CacheManagementService, workspaceService *services.WorkspaceService, messageBroker *broker.Broker) error {
 	userID, err := uuid.Parse(session.ClientReferenceID)
 	if err != nil {
 		return fmt.Errorf("invalid user ID: %v", err)
@@ -415,6 +808,14 @@ func processSuccessfulCheckoutSession(session stripe.CheckoutSession, cacheManag
 
 	priceTier := session.Metadata["price_tier"]
 
+	if workspaceIDValue := session.Metadata["workspace_id"]; workspaceIDValue != "" {
+		workspaceID, err := strconv.ParseUint(workspaceIDValue, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid workspace ID: %v", err)
+		}
+		return topUpWorkspacePool(uint(workspaceID), userID, priceTier, tokenHours, cacheManagementService, workspaceService, messageBroker)
+	}
+
 	err = cacheManagementService.UpdateAllowedCacheUsage(context.Background(), userID, priceTier, tokenHours)
 	if err != nil {
 		return fmt.Errorf("failed to update allowed cache usage: %v", err)
@@ -425,8 +826,26 @@ func processSuccessfulCheckoutSession(session stripe.CheckoutSession, cacheManag
 	return nil
 }
 
+// topUpWorkspacePool credits a purchase to a workspace pool and notifies every member
+func topUpWorkspacePool(workspaceID uint, buyerID uuid.UUID, priceTier string, tokenHours float64, cacheManagementService *services.CacheManagementService, workspaceService *services.WorkspaceService, messageBroker *broker.Broker) error {
+	ctx := context.Background()
+	err := cacheManagementService.UpdateWorkspaceAllowedCacheUsage(ctx, workspaceID, buyerID, priceTier, tokenHours)
+	if err != nil {
+		return fmt.Errorf("failed to update workspace cache usage pool: %v", err)
+	}
+
+	memberIDs, err := workspaceService.MemberIDs(ctx, workspaceID)
+	if err != nil {
+		return err
+	}
+	for _, memberID := range memberIDs {
+		messageBroker.Publish("credit_update_"+memberID.String(), fmt.Sprintf("Credit updated for workspace %d", workspaceID))
+	}
+	return nil
+}
+
 // Stripe Webook versions that match the CLI API version
-func stripeWebhookHandler_clitest(stripeService *services.StripeService, cacheManagementService *services.CacheManagementService, messageBroker *broker.Broker) gin.HandlerFunc {
+func stripeWebhookHandler_clitest(stripeService *services.StripeService, cacheManagementService *services.CacheManagementService, workspaceService *services.WorkspaceService, messageBroker *broker.Broker) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		const MaxBodyBytes = int64(65536)
 		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxBodyBytes)
@@ -456,7 +875,7 @@ func stripeWebhookHandler_clitest(stripeService *services.StripeService, cacheMa
 				return
 			}
 
-			err = processSuccessfulCheckoutSession(session, cacheManagementService, messageBroker)
+			err = processSuccessfulCheckoutSession(session, cacheManagementService, workspaceService, messageBroker)
 			if err != nil {
 				fmt.Printf("Error processing checkout session: %v\n", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process checkout session"})
@@ -559,7 +978,7 @@ func getCacheUsageHandler(cacheManagementService *services.CacheManagementServic
 	}
 }
 
-func testBibParsingHandler(log zerolog.Logger) gin.HandlerFunc {
+func testBibParsingHandler(arxivMetadataService *services.ArxivMetadataService, log zerolog.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		arxivID := c.Param("arxiv_id")
 		if arxivID == "" {
@@ -567,7 +986,7 @@ func testBibParsingHandler(log zerolog.Logger) gin.HandlerFunc {
 			return
 		}
 
-		paperLoader := services.NewPaperLoader(log)
+		paperLoader := services.NewPaperLoader(log, arxivMetadataService)
 		err := paperLoader.TestBibParsing(arxivID)
 		if err != nil {
 			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to test bib parsing: %v", err)))
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/services"
+	"nexus_scholar_go_backend/internal/utils/auth"
+	"nexus_scholar_go_backend/internal/utils/errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+func SetupSettingsRoutes(r *gin.Engine, userService *services.UserService) {
+	api := r.Group("/api/settings", auth.AuthMiddleware(userService))
+	{
+		api.GET("", getSettingsHandler)
+		api.PUT("", updateSettingsHandler(userService))
+	}
+}
+
+func getSettingsHandler(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		errors.HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, settingsJSON(user))
+}
+
+func updateSettingsHandler(userService *services.UserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+		var request struct {
+			FollowUpSuggestions *bool `json:"follow_up_suggestions"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+
+		if err := userService.UpdateSettings(c.Request.Context(), user, services.UserSettingsInput{
+			FollowUpSuggestions: request.FollowUpSuggestions,
+		}); err != nil {
+			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to update settings: %v", err)))
+			return
+		}
+		c.JSON(http.StatusOK, settingsJSON(user))
+	}
+}
+
+func settingsJSON(user *models.User) gin.H {
+	return gin.H{
+		"follow_up_suggestions": !user.SuggestionsDisabled,
+	}
+}
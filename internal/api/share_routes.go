@@ -0,0 +1,187 @@
+package api
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/services"
+	"nexus_scholar_go_backend/internal/utils/auth"
+	"nexus_scholar_go_backend/internal/utils/errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func SetupShareRoutes(r *gin.Engine, shareLinkService *services.ShareLinkService, userService *services.UserService) {
+	api := r.Group("/api/shares", auth.AuthMiddleware(userService))
+	{
+		api.GET("", listShareLinksHandler(shareLinkService))
+		api.POST("", createShareLinkHandler(shareLinkService))
+		api.DELETE("/:id", revokeShareLinkHandler(shareLinkService))
+	}
+
+	// Public, read-only view of a shared chat
+	r.GET("/api/shared/:token", viewSharedChatHandler(shareLinkService))
+}
+
+func listShareLinksHandler(shareLinkService *services.ShareLinkService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		links, err := shareLinkService.ListShareLinks(c.Request.Context(), user.ID)
+		if err != nil {
+			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to list share links: %v", err)))
+			return
+		}
+
+		result := make([]gin.H, len(links))
+		for i := range links {
+			result[i] = shareLinkJSON(shareLinkService, &links[i])
+		}
+		c.JSON(http.StatusOK, gin.H{"share_links": result})
+	}
+}
+
+func createShareLinkHandler(shareLinkService *services.ShareLinkService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		var request struct {
+			SessionID      string   `json:"session_id" binding:"required"`
+			ExpiresInHours *float64 `json:"expires_in_hours"` // omitted or null: valid until revoked
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+
+		var expiresIn *time.Duration
+		if request.ExpiresInHours != nil {
+			if *request.ExpiresInHours <= 0 {
+				errors.HandleError(c, errors.New400Error("expires_in_hours must be positive"))
+				return
+			}
+			duration := time.Duration(*request.ExpiresInHours * float64(time.Hour))
+			expiresIn = &duration
+		}
+
+		link, err := shareLinkService.CreateShareLink(c.Request.Context(), user.ID, request.SessionID, expiresIn)
+		if err != nil {
+			handleShareLinkError(c, err, "failed to create share link")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"share_link": shareLinkJSON(shareLinkService, link)})
+	}
+}
+
+func revokeShareLinkHandler(shareLinkService *services.ShareLinkService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+		linkID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			errors.HandleError(c, errors.New400Error("Invalid share link id"))
+			return
+		}
+
+		link, err := shareLinkService.RevokeShareLink(c.Request.Context(), user.ID, uint(linkID))
+		if err != nil {
+			handleShareLinkError(c, err, "failed to revoke share link")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"share_link": shareLinkJSON(shareLinkService, link)})
+	}
+}
+
+func viewSharedChatHandler(shareLinkService *services.ShareLinkService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		shared, err := shareLinkService.ViewSharedChat(c.Request.Context(), c.Param("token"))
+		if err != nil {
+			handleShareLinkError(c, err, "failed to get shared chat")
+			return
+		}
+
+		// Only the timeline and the document list are public; authors and usage stay private
+		messages := make([]gin.H, len(shared.Chat.Messages))
+		for i, msg := range shared.Chat.Messages {
+			messages[i] = gin.H{
+				"type":      msg.Type,
+				"content":   msg.Content,
+				"timestamp": msg.Timestamp.Format(time.RFC3339),
+			}
+		}
+		documents := make([]gin.H, len(shared.Documents))
+		for i, doc := range shared.Documents {
+			documents[i] = gin.H{
+				"position": doc.Position,
+				"source":   doc.Source,
+				"arxiv_id": doc.ArxivID,
+				"title":    doc.Title,
+			}
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{
+			"created_at": shared.Chat.CreatedAt.Format(time.RFC3339),
+			"price_tier": shared.Chat.PriceTier,
+			"messages":   messages,
+			"documents":  documents,
+		})
+	}
+}
+
+func handleShareLinkError(c *gin.Context, err error, action string) {
+	if stderrors.Is(err, services.ErrShareLinkNotFound) || stderrors.Is(err, gorm.ErrRecordNotFound) {
+		errors.HandleError(c, errors.New404Error("Share link or session not found"))
+		return
+	}
+	errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("%s: %v", action, err)))
+}
+
+func shareLinkJSON(shareLinkService *services.ShareLinkService, link *models.ShareLink) gin.H {
+	status := "active"
+	switch {
+	case link.RevokedAt != nil:
+		status = "revoked"
+	case link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt):
+		status = "expired"
+	}
+	result := gin.H{
+		"id":             link.ID,
+		"session_id":     link.Chat.SessionID,
+		"token":          shareLinkService.Token(link),
+		"status":         status,
+		"view_count":     link.ViewCount,
+		"expires_at":     nil,
+		"revoked_at":     nil,
+		"last_viewed_at": nil,
+		"created_at":     link.CreatedAt.Format(time.RFC3339),
+	}
+	if link.ExpiresAt != nil {
+		result["expires_at"] = link.ExpiresAt.Format(time.RFC3339)
+	}
+	if link.RevokedAt != nil {
+		result["revoked_at"] = link.RevokedAt.Format(time.RFC3339)
+	}
+	if link.LastViewedAt != nil {
+		result["last_viewed_at"] = link.LastViewedAt.Format(time.RFC3339)
+	}
+	return result
+}
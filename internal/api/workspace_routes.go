@@ -0,0 +1,349 @@
+package api
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/services"
+	"nexus_scholar_go_backend/internal/utils/auth"
+	"nexus_scholar_go_backend/internal/utils/errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func SetupWorkspaceRoutes(r *gin.Engine, workspaceService *services.WorkspaceService, userService *services.UserService) {
+	api := r.Group("/api/workspaces", auth.AuthMiddleware(userService))
+	{
+		api.GET("", listWorkspacesHandler(workspaceService))
+		api.POST("", createWorkspaceHandler(workspaceService))
+		api.GET("/:id", getWorkspaceHandler(workspaceService))
+		api.PUT("/:id", updateWorkspaceHandler(workspaceService))
+		api.DELETE("/:id", deleteWorkspaceHandler(workspaceService))
+		api.POST("/:id/members", addWorkspaceMemberHandler(workspaceService))
+		api.PUT("/:id/members/:user_id", updateWorkspaceMemberHandler(workspaceService))
+		api.DELETE("/:id/members/:user_id", removeWorkspaceMemberHandler(workspaceService))
+		api.PUT("/:id/members/:user_id/limits", setWorkspaceMemberLimitHandler(workspaceService))
+		api.GET("/:id/sessions", getWorkspaceSessionsHandler(workspaceService))
+		api.GET("/:id/usage", getWorkspaceUsageHandler(workspaceService))
+	}
+}
+
+func listWorkspacesHandler(workspaceService *services.WorkspaceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		workspaces, err := workspaceService.ListWorkspaces(c.Request.Context(), user.ID)
+		if err != nil {
+			errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("failed to list workspaces: %v", err)))
+			return
+		}
+
+		result := make([]gin.H, len(workspaces))
+		for i := range workspaces {
+			result[i] = workspaceJSON(&workspaces[i])
+		}
+		c.JSON(http.StatusOK, gin.H{"workspaces": result})
+	}
+}
+
+func createWorkspaceHandler(workspaceService *services.WorkspaceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			errors.HandleError(c, err)
+			return
+		}
+
+		var request struct {
+			Name string `json:"name" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+
+		workspace, err := workspaceService.CreateWorkspace(c.Request.Context(), user.ID, request.Name)
+		if err != nil {
+			handleWorkspaceError(c, err, "failed to create workspace")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"workspace": workspaceJSON(workspace)})
+	}
+}
+
+func getWorkspaceHandler(workspaceService *services.WorkspaceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, workspaceID, ok := workspaceRequest(c)
+		if !ok {
+			return
+		}
+
+		workspace, err := workspaceService.GetWorkspace(c.Request.Context(), user.ID, workspaceID)
+		if err != nil {
+			handleWorkspaceError(c, err, "failed to get workspace")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"workspace": workspaceJSON(workspace)})
+	}
+}
+
+func updateWorkspaceHandler(workspaceService *services.WorkspaceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, workspaceID, ok := workspaceRequest(c)
+		if !ok {
+			return
+		}
+
+		var request struct {
+			Name string `json:"name" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+
+		workspace, err := workspaceService.UpdateWorkspace(c.Request.Context(), user.ID, workspaceID, request.Name)
+		if err != nil {
+			handleWorkspaceError(c, err, "failed to update workspace")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"workspace": workspaceJSON(workspace)})
+	}
+}
+
+func deleteWorkspaceHandler(workspaceService *services.WorkspaceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, workspaceID, ok := workspaceRequest(c)
+		if !ok {
+			return
+		}
+
+		if err := workspaceService.DeleteWorkspace(c.Request.Context(), user.ID, workspaceID); err != nil {
+			handleWorkspaceError(c, err, "failed to delete workspace")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Workspace deleted"})
+	}
+}
+
+func addWorkspaceMemberHandler(workspaceService *services.WorkspaceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, workspaceID, ok := workspaceRequest(c)
+		if !ok {
+			return
+		}
+
+		var request struct {
+			Email string `json:"email" binding:"required"`
+			Role  string `json:"role"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+		if request.Role == "" {
+			request.Role = models.WorkspaceRoleMember
+		}
+
+		member, err := workspaceService.AddMember(c.Request.Context(), user.ID, workspaceID, request.Email, request.Role)
+		if err != nil {
+			handleWorkspaceError(c, err, "failed to add workspace member")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"member": workspaceMemberJSON(member)})
+	}
+}
+
+func updateWorkspaceMemberHandler(workspaceService *services.WorkspaceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, workspaceID, memberID, ok := workspaceMemberRequest(c)
+		if !ok {
+			return
+		}
+
+		var request struct {
+			Role string `json:"role" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+
+		member, err := workspaceService.UpdateMemberRole(c.Request.Context(), user.ID, workspaceID, memberID, request.Role)
+		if err != nil {
+			handleWorkspaceError(c, err, "failed to update workspace member")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"member": workspaceMemberJSON(member)})
+	}
+}
+
+func removeWorkspaceMemberHandler(workspaceService *services.WorkspaceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, workspaceID, memberID, ok := workspaceMemberRequest(c)
+		if !ok {
+			return
+		}
+
+		if err := workspaceService.RemoveMember(c.Request.Context(), user.ID, workspaceID, memberID); err != nil {
+			handleWorkspaceError(c, err, "failed to remove workspace member")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+	}
+}
+
+func setWorkspaceMemberLimitHandler(workspaceService *services.WorkspaceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, workspaceID, memberID, ok := workspaceMemberRequest(c)
+		if !ok {
+			return
+		}
+
+		var request struct {
+			PriceTier       string   `json:"price_tier" binding:"required"`
+			TokenHoursLimit *float64 `json:"token_hours_limit"` // null removes the limit
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			errors.HandleError(c, errors.New400Error(err.Error()))
+			return
+		}
+
+		err := workspaceService.SetMemberLimit(c.Request.Context(), user.ID, workspaceID, memberID, request.PriceTier, request.TokenHoursLimit)
+		if err != nil {
+			handleWorkspaceError(c, err, "failed to set member limit")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Member limit updated"})
+	}
+}
+
+func getWorkspaceSessionsHandler(workspaceService *services.WorkspaceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, workspaceID, ok := workspaceRequest(c)
+		if !ok {
+			return
+		}
+
+		chats, err := workspaceService.GetWorkspaceSessions(c.Request.Context(), user.ID, workspaceID)
+		if err != nil {
+			handleWorkspaceError(c, err, "failed to get workspace sessions")
+			return
+		}
+
+		chatHistory := make([]gin.H, len(chats))
+		for i := range chats {
+			chatHistory[i] = chatHistoryJSON(&chats[i])
+			chatHistory[i]["user_id"] = chats[i].UserID
+		}
+		c.JSON(http.StatusOK, gin.H{"chat_history": chatHistory})
+	}
+}
+
+func getWorkspaceUsageHandler(workspaceService *services.WorkspaceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, workspaceID, ok := workspaceRequest(c)
+		if !ok {
+			return
+		}
+
+		usage, err := workspaceService.GetWorkspaceUsage(c.Request.Context(), user.ID, workspaceID)
+		if err != nil {
+			handleWorkspaceError(c, err, "failed to get workspace usage")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"usage": usage})
+	}
+}
+
+// workspaceRequest resolves the current user and the :id parameter, writing the error response if either fails
+func workspaceRequest(c *gin.Context) (*models.User, uint, bool) {
+	user, err := currentUser(c)
+	if err != nil {
+		errors.HandleError(c, err)
+		return nil, 0, false
+	}
+	workspaceID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		errors.HandleError(c, errors.New400Error("Invalid workspace id"))
+		return nil, 0, false
+	}
+	return user, uint(workspaceID), true
+}
+
+// workspaceMemberRequest is workspaceRequest plus the :user_id parameter
+func workspaceMemberRequest(c *gin.Context) (*models.User, uint, uuid.UUID, bool) {
+	user, workspaceID, ok := workspaceRequest(c)
+	if !ok {
+		return nil, 0, uuid.Nil, false
+	}
+	memberID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		errors.HandleError(c, errors.New400Error("Invalid user id"))
+		return nil, 0, uuid.Nil, false
+	}
+	return user, workspaceID, memberID, true
+}
+
+func handleWorkspaceError(c *gin.Context, err error, action string) {
+	switch {
+	case stderrors.Is(err, services.ErrInvalidWorkspace):
+		errors.HandleError(c, errors.New400Error(err.Error()))
+	case stderrors.Is(err, services.ErrWorkspaceForbidden):
+		errors.HandleError(c, errors.New403Error())
+	case stderrors.Is(err, services.ErrWorkspaceHasActiveSessions):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case stderrors.Is(err, gorm.ErrRecordNotFound):
+		errors.HandleError(c, errors.New404Error("Workspace or member not found"))
+	default:
+		errors.HandleError(c, errors.LogAndReturn500(fmt.Errorf("%s: %v", action, err)))
+	}
+}
+
+func workspaceJSON(w *models.Workspace) gin.H {
+	members := make([]gin.H, len(w.Members))
+	for i := range w.Members {
+		members[i] = workspaceMemberJSON(&w.Members[i])
+	}
+	return gin.H{
+		"id":         w.ID,
+		"name":       w.Name,
+		"owner_id":   w.OwnerID,
+		"members":    members,
+		"created_at": w.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func workspaceMemberJSON(m *models.WorkspaceMember) gin.H {
+	limits := gin.H{}
+	for _, limit := range m.Limits {
+		limits[limit.PriceTier] = limit.TokenHoursLimit
+	}
+	return gin.H{
+		"user_id":   m.UserID,
+		"email":     m.User.Email,
+		"name":      m.User.Name,
+		"role":      m.Role,
+		"limits":    limits,
+		"joined_at": m.CreatedAt.Format(time.RFC3339),
+	}
+}
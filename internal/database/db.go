@@ -42,11 +42,111 @@ func InitDB() error {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	return Migrate(DB)
+}
+
+// Migrate brings the schema of a database up to date
+func Migrate(db *gorm.DB) error {
+	if err := mergeDuplicateWorkspacePools(db); err != nil {
+		return fmt.Errorf("failed to merge duplicate workspace pools: %w", err)
+	}
+
 	// Auto Migrate the schema
-	err = DB.AutoMigrate(&models.User{}, &models.Paper{}, &models.PaperReference{}, &models.Chat{}, &models.Message{}, &models.Cache{}, &models.TierTokenBudget{})
+	err := db.AutoMigrate(&models.User{}, &models.Paper{}, &models.PaperReference{}, &models.Chat{}, &models.Message{}, &models.Cache{}, &models.TierTokenBudget{}, &models.ArxivMetadata{}, &models.ArxivHarvestState{}, &models.PaperVersion{}, &models.ChatDocument{}, &models.Notification{}, &models.Document{}, &models.DocumentTag{}, &models.Project{}, &models.ProjectDocument{}, &models.Workspace{}, &models.WorkspaceMember{}, &models.WorkspaceMemberLimit{}, &models.WorkspaceMemberUsage{}, &models.ShareLink{}, &models.ChatBranch{}, &models.InstructionPreset{}, &models.ExtractionTable{}, &models.ExtractionColumn{}, &models.ExtractionCell{}, &models.Report{}, &models.ReportSection{}, &models.Comparison{}, &models.ComparisonEntry{}, &models.Glossary{}, &models.GlossaryTerm{})
 	if err != nil {
 		return fmt.Errorf("failed to auto migrate: %w", err)
 	}
 
+	if err := createSearchIndexes(db); err != nil {
+		return fmt.Errorf("failed to create search indexes: %w", err)
+	}
+
+	if err := backfillChatBranches(db); err != nil {
+		return fmt.Errorf("failed to backfill chat branches: %w", err)
+	}
+
 	return nil
 }
+
+// mergeDuplicateWorkspacePools folds the pool rows that concurrent top-ups created for the
+// same workspace and tier into one, so the unique index on them can be created
+func mergeDuplicateWorkspacePools(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&models.TierTokenBudget{}) {
+		return nil
+	}
+	statements := []string{
+		`DELETE FROM tier_token_budgets WHERE workspace_id IS NOT NULL AND deleted_at IS NOT NULL`,
+		`UPDATE tier_token_budgets SET token_hours_bought = merged.bought, token_hours_used = merged.used
+			FROM (
+				SELECT MIN(id) AS id, SUM(token_hours_bought) AS bought, SUM(token_hours_used) AS used
+				FROM tier_token_budgets WHERE workspace_id IS NOT NULL
+				GROUP BY workspace_id, price_tier HAVING COUNT(*) > 1
+			) merged
+			WHERE tier_token_budgets.id = merged.id`,
+		`DELETE FROM tier_token_budgets duplicate USING tier_token_budgets kept
+			WHERE duplicate.workspace_id = kept.workspace_id AND duplicate.price_tier = kept.price_tier
+				AND duplicate.id > kept.id`,
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// createSearchIndexes sets up the full-text search columns and GIN indexes,
+// which AutoMigrate cannot express.
+func createSearchIndexes(db *gorm.DB) error {
+	statements := []string{
+		`ALTER TABLE arxiv_metadata ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+			setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+			setweight(to_tsvector('english', coalesce(authors, '')), 'B') ||
+			setweight(to_tsvector('english', coalesce(abstract, '')), 'C')
+		) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_arxiv_metadata_search_vector ON arxiv_metadata USING GIN (search_vector)`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+			to_tsvector('english', coalesce(content, ''))
+		) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_search_vector ON messages USING GIN (search_vector)`,
+	}
+
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillChatBranches turns the linear message lists of chats from before branching
+// existed into a main branch, each message following the previous one.
+func backfillChatBranches(db *gorm.DB) error {
+	statements := []string{
+		`INSERT INTO chat_branches (created_at, updated_at, chat_id, reason)
+			SELECT created_at, now(), id, 'main' FROM chats WHERE active_branch_id IS NULL`,
+		`UPDATE chats SET active_branch_id = chat_branches.id
+			FROM chat_branches
+			WHERE chat_branches.chat_id = chats.id AND chats.active_branch_id IS NULL`,
+		`UPDATE messages SET branch_id = linear.branch_id, parent_id = linear.parent_id
+			FROM (
+				SELECT messages.id, chats.active_branch_id AS branch_id,
+					lag(messages.id) OVER (PARTITION BY messages.chat_id ORDER BY messages.timestamp, messages.id) AS parent_id
+				FROM messages JOIN chats ON chats.id = messages.chat_id
+				WHERE messages.branch_id IS NULL
+			) linear
+			WHERE messages.id = linear.id`,
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
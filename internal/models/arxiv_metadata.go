@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ArxivMetadata is the local mirror of arXiv paper metadata, fed by OAI-PMH
+// harvests, bulk metadata dumps and (as a fallback) live API lookups.
+type ArxivMetadata struct {
+	gorm.Model
+	ArxivID       string `gorm:"type:varchar(20);uniqueIndex"`
+	Title         string `gorm:"type:text"`
+	Authors       string `gorm:"type:text"`
+	Abstract      string `gorm:"type:text"`
+	Categories    string
+	DOI           string
+	JournalRef    string
+	Comments      string `gorm:"type:text"`
+	PublishedDate string // YYYY-MM-DD
+	UpdatedDate   string // YYYY-MM-DD
+	LatestVersion int    // 0 when the source does not report versions
+	Source        string `gorm:"type:varchar(10)"` // oai, dump or api
+	HarvestedAt   time.Time
+}
+
+// ArxivHarvestState remembers how far the OAI-PMH harvest of a set has progressed
+type ArxivHarvestState struct {
+	gorm.Model
+	SetSpec         string `gorm:"uniqueIndex"`
+	LastHarvestedAt time.Time
+}
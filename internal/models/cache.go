@@ -11,6 +11,7 @@ type Cache struct {
 	gorm.Model
 	UserID          uuid.UUID `gorm:"type:uuid;index"`
 	SessionID       string    `gorm:"index;unique"`
+	WorkspaceID     *uint     `gorm:"index"` // set when the session is billed to a workspace pool
 	CacheName       string
 	PriceTier       string
 	TotalTokenCount int32
@@ -18,10 +19,14 @@ type Cache struct {
 	TerminationTime time.Time
 }
 
+// TierTokenBudget is the credit of a user, or of a workspace pool when WorkspaceID is set.
+// A workspace has one pool per tier; the unique index leaves users' own budgets, which have
+// no workspace, alone.
 type TierTokenBudget struct {
 	gorm.Model
 	UserID           uuid.UUID `gorm:"type:uuid;index"`
-	PriceTier        string    `gorm:"index"`
+	WorkspaceID      *uint     `gorm:"index;uniqueIndex:idx_workspace_tier_budget"`
+	PriceTier        string    `gorm:"index;uniqueIndex:idx_workspace_tier_budget"`
 	TokenHoursBought float64   // Total tokens bought for this model (in millions)
 	TokenHoursUsed   float64   // Cumulative tokens used for this model (in millions)
 }
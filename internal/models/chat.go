@@ -11,19 +11,74 @@ type Chat struct {
 	gorm.Model
 	UserID          uuid.UUID `gorm:"type:uuid;index"`
 	SessionID       string    `gorm:"index;unique"`
+	ProjectID       *uint     `gorm:"index"`
+	WorkspaceID     *uint     `gorm:"index"` // sessions in a workspace are visible to all its members
 	Messages        []Message
+	Documents       []ChatDocument
 	ChatDuration    float64 `gorm:"type:float"` // in seconds
 	TerminationTime time.Time
 	TokenCountUsed  int32
 	PriceTier       string
 	TokenHoursUsed  float64
+	// Title and Summary are generated after the first exchange and at termination. Once the
+	// user edits one of them, automatic summaries leave it alone.
+	Title              string `gorm:"type:varchar(200)"`
+	Summary            string `gorm:"type:text"`
+	TitleEdited        bool
+	SummaryEdited      bool
+	SummaryGeneratedAt *time.Time
+	ActiveBranchID     *uint // the branch new messages are appended to
+	// SystemInstruction is the answer style the session's cache was created with, on top of
+	// the instructions every session gets. InstructionPreset names the preset it came from.
+	SystemInstruction string `gorm:"type:text"`
+	InstructionPreset string `gorm:"type:varchar(100)"`
+	// Generation holds the sampling and safety settings the session currently answers with
+	Generation GenerationParams `gorm:"embedded;embeddedPrefix:generation_"`
+}
+
+// GenerationParams are the sampling and safety settings of a session. Unset fields use the
+// model's defaults.
+type GenerationParams struct {
+	Temperature     *float32 `json:"temperature"`
+	TopP            *float32 `json:"top_p"`
+	MaxOutputTokens *int32   `json:"max_output_tokens"`
+	// SafetyThreshold is block_none, block_only_high, block_medium_and_above or block_low_and_above
+	SafetyThreshold string `json:"safety_threshold" gorm:"type:varchar(30)"`
 }
 
 type Message struct {
 	gorm.Model
-	ChatID    uint   `gorm:"index"` // Foreign key to Chat
-	Chat      Chat   `gorm:"foreignKey:ChatID"`
-	Type      string `gorm:"type:varchar(20)"`
-	Content   string `gorm:"type:text"`
+	ChatID    uint       `gorm:"index"` // Foreign key to Chat
+	Chat      Chat       `gorm:"foreignKey:ChatID"`
+	UserID    *uuid.UUID `gorm:"type:uuid;index"` // author of a user message; nil for AI responses
+	Type      string     `gorm:"type:varchar(20)"`
+	Content   string     `gorm:"type:text"`
 	Timestamp time.Time
+	ParentID  *uint `gorm:"index"` // the message this one follows; nil for the first prompt
+	BranchID  *uint `gorm:"index"`
+	// Suggestions are the follow-up questions offered after an AI answer
+	Suggestions []string `gorm:"type:text;serializer:json"`
+}
+
+// ChatBranch is one line of a conversation tree. Editing a prompt or regenerating an answer
+// starts a new branch that continues from ForkedFromID; the messages before it are shared
+// with the branch it was forked from.
+type ChatBranch struct {
+	gorm.Model
+	ChatID       uint   `gorm:"index"`
+	ForkedFromID *uint  // last shared message; nil for the main branch or a fork of the first prompt
+	Reason       string `gorm:"type:varchar(20)"` // main, edit or regenerate
+}
+
+// ChatDocument records which document was loaded at which position of a session's corpus
+type ChatDocument struct {
+	gorm.Model
+	ChatID     uint   `gorm:"index"`
+	Position   int    // the N in <Document N>
+	Source     string `gorm:"type:varchar(20)"` // arxiv or upload
+	ArxivID    string `gorm:"type:varchar(20);index"`
+	DocumentID uint   `gorm:"index"` // library document, 0 for arXiv papers
+	Title      string
+	Trims      []string `gorm:"type:text;serializer:json"` // what was cut to fit the session's token budget
+	Figures    int      // figure and table images cached with the document
 }
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Comparison sets two or more documents of a session side by side along the same aspects.
+// It has one entry per document and aspect.
+type Comparison struct {
+	gorm.Model
+	ChatID         uint              `gorm:"index"`
+	UserID         uuid.UUID         `gorm:"type:uuid;index"` // who requested the comparison and is billed for it
+	Focus          string            `gorm:"type:text"`       // what to pay attention to, if anything
+	TokenCountUsed int32             // tokens read and written while generating
+	TokenHoursUsed float64           // what the comparison was billed, in million token-hours
+	Entries        []ComparisonEntry `gorm:"foreignKey:ComparisonID"`
+}
+
+// ComparisonEntry is what one document says about one aspect, with the passage it is based on
+type ComparisonEntry struct {
+	gorm.Model
+	ComparisonID     uint   `gorm:"index"`
+	DocumentPosition int    // the N in <Document N>
+	Aspect           string `gorm:"type:varchar(20)"` // problem, method, data, results, claims or contradictions
+	Summary          string `gorm:"type:text"`
+	Quote            string `gorm:"type:text"`
+}
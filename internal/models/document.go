@@ -0,0 +1,28 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Document is a PDF in a user's library. The original file and the text
+// extracted from it are kept in object storage under StorageKey and TextStorageKey.
+type Document struct {
+	gorm.Model
+	UserID         uuid.UUID `gorm:"type:uuid;index"`
+	Title          string
+	FileName       string
+	ContentType    string `gorm:"type:varchar(100)"`
+	SizeBytes      int64
+	ContentHash    string `gorm:"type:varchar(64);index"` // SHA-256 of the file, used to skip duplicate uploads
+	StorageKey     string
+	TextStorageKey string
+	TextLength     int
+	Tags           []DocumentTag
+}
+
+type DocumentTag struct {
+	gorm.Model
+	DocumentID uint   `gorm:"uniqueIndex:idx_document_tag"`
+	Name       string `gorm:"type:varchar(50);uniqueIndex:idx_document_tag;index"`
+}
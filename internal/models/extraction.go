@@ -0,0 +1,45 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ExtractionTable asks the same questions, its columns, of every document of a session.
+// Each document becomes a row of cells.
+type ExtractionTable struct {
+	gorm.Model
+	ChatID         uint               `gorm:"index"`
+	UserID         uuid.UUID          `gorm:"type:uuid;index"` // who created the table
+	Name           string             `gorm:"type:varchar(200)"`
+	Status         string             `gorm:"type:varchar(20)"` // pending, running, completed or failed
+	Error          string             `gorm:"type:text"`
+	Total          int                // documents to extract from
+	Completed      int                // documents extracted so far, including failed ones
+	Failed         int                // documents the model could not extract from
+	TokenCountUsed int32              // tokens read and written while extracting
+	TokenHoursUsed float64            // what the extraction was billed, in million token-hours
+	Columns        []ExtractionColumn `gorm:"foreignKey:TableID"`
+	Cells          []ExtractionCell   `gorm:"foreignKey:TableID"`
+}
+
+// ExtractionColumn is one question of an extraction table
+type ExtractionColumn struct {
+	gorm.Model
+	TableID     uint   `gorm:"index"`
+	Position    int    // order of the columns
+	Name        string `gorm:"type:varchar(100)"`
+	Description string `gorm:"type:text"`        // what to extract, as instructions for the model
+	Type        string `gorm:"type:varchar(20)"` // text, number, boolean or list
+}
+
+// ExtractionCell is the answer to one column for one document, with the passage it is based on
+type ExtractionCell struct {
+	gorm.Model
+	TableID          uint   `gorm:"index"`
+	ColumnID         uint   `gorm:"index"`
+	DocumentPosition int    // the N in <Document N>
+	Value            string `gorm:"type:text"`
+	Quote            string `gorm:"type:text"`
+	Found            bool   // false when the document does not report it
+}
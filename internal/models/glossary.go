@@ -0,0 +1,27 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Glossary indexes the key terms of a session's documents. It is built in the background
+// when the session starts.
+type Glossary struct {
+	gorm.Model
+	ChatID         uint           `gorm:"uniqueIndex"`
+	Status         string         `gorm:"type:varchar(20)"` // pending, running, completed or failed
+	Error          string         `gorm:"type:text"`
+	TokenCountUsed int32          // tokens read and written while building
+	TokenHoursUsed float64        // what building the glossary was billed, in million token-hours
+	Terms          []GlossaryTerm `gorm:"foreignKey:GlossaryID"`
+}
+
+// GlossaryTerm is a term, method, dataset or acronym of the corpus with its definition
+type GlossaryTerm struct {
+	gorm.Model
+	GlossaryID uint   `gorm:"index"`
+	Term       string `gorm:"type:varchar(200)"`
+	Kind       string `gorm:"type:varchar(20)"` // term, method, dataset or acronym
+	Definition string `gorm:"type:text"`
+	Documents  []int  `gorm:"type:text;serializer:json"` // positions of the documents it appears in
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// InstructionPreset is an answer style a user defined for their sessions, applied as the
+// system instruction of a session's cache or as an override of a single prompt
+type InstructionPreset struct {
+	gorm.Model
+	UserID      uuid.UUID `gorm:"type:uuid;index"`
+	Name        string    `gorm:"type:varchar(100)"`
+	Description string    `gorm:"type:text"`
+	Instruction string    `gorm:"type:text"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type Notification struct {
+	gorm.Model
+	UserID  uuid.UUID `gorm:"type:uuid;index"`
+	Type    string    `gorm:"type:varchar(50)"`
+	Title   string
+	Body    string `gorm:"type:text"`
+	ArxivID string `gorm:"type:varchar(20)"`
+	ReadAt  *time.Time
+}
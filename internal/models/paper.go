@@ -4,9 +4,26 @@ import "gorm.io/gorm"
 
 type Paper struct {
 	gorm.Model
-	Title    string
-	Authors  string
-	Abstract string
-	ArxivID  string `gorm:"type:varchar(20);unique"`
-	URL      string
+	Title           string
+	Authors         string
+	Abstract        string
+	ArxivID         string `gorm:"type:varchar(20);unique"`
+	URL             string
+	Version         int    // latest known arXiv version (the N in vN)
+	LastUpdated     string // arXiv "updated" date of the latest version
+	NotifiedVersion int    // latest version the paper's readers were notified of, 0 before the first check
+}
+
+// PaperVersion keeps the metadata of every arXiv version we have seen of a paper
+type PaperVersion struct {
+	gorm.Model
+	PaperID     uint   `gorm:"index"`
+	ArxivID     string `gorm:"type:varchar(20);index"`
+	Version     int
+	Title       string
+	Authors     string
+	Abstract    string `gorm:"type:text"`
+	LastUpdated string
+	DiffSummary string `gorm:"type:text"` // changes compared to the previous version
+	Text        string `gorm:"type:text"` // text extracted from the version's PDF, empty until fetched
 }
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Project groups the research sessions of a long-running piece of work around
+// a shared document set. New sessions can be started from that document set.
+type Project struct {
+	gorm.Model
+	UserID           uuid.UUID `gorm:"type:uuid;index"`
+	Name             string    `gorm:"type:varchar(200)"`
+	Description      string    `gorm:"type:text"`
+	DefaultPriceTier string    `gorm:"type:varchar(10)"`
+	Documents        []ProjectDocument
+}
+
+// ProjectDocument is one entry of a project's document set: an arXiv paper or a library document
+type ProjectDocument struct {
+	gorm.Model
+	ProjectID  uint   `gorm:"index"`
+	Position   int    // order in which the documents are loaded into sessions
+	ArxivID    string `gorm:"type:varchar(20)"`
+	DocumentID uint   // library document, 0 for arXiv papers
+}
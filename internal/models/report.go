@@ -0,0 +1,32 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Report is a literature review written over a session's documents: an outline is planned
+// first, then its sections are generated one at a time
+type Report struct {
+	gorm.Model
+	ChatID         uint            `gorm:"index"`
+	UserID         uuid.UUID       `gorm:"type:uuid;index"` // who requested the report and is billed for it
+	Topic          string          `gorm:"type:text"`
+	Title          string          `gorm:"type:varchar(200)"`
+	Status         string          `gorm:"type:varchar(20)"` // pending, running, completed or failed
+	Error          string          `gorm:"type:text"`
+	TokenCountUsed int32           // tokens read and written while generating
+	TokenHoursUsed float64         // what the report was billed, in million token-hours
+	Sections       []ReportSection `gorm:"foreignKey:ReportID"`
+}
+
+// ReportSection is one section of a report's outline and, once generated, its text
+type ReportSection struct {
+	gorm.Model
+	ReportID  uint   `gorm:"index"`
+	Position  int    // order of the sections
+	Title     string `gorm:"type:varchar(200)"`
+	Brief     string `gorm:"type:text"` // what the outline says the section covers
+	Content   string `gorm:"type:text"` // Markdown citing the documents as <Document N>
+	Generated bool
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ShareLink grants read-only public access to a chat's timeline. The URL token is the
+// TokenID signed with the server's share link secret.
+type ShareLink struct {
+	gorm.Model
+	ChatID       uint      `gorm:"index"`
+	Chat         Chat      `gorm:"foreignKey:ChatID"`
+	UserID       uuid.UUID `gorm:"type:uuid;index"`
+	TokenID      string    `gorm:"type:varchar(32);uniqueIndex"`
+	ExpiresAt    *time.Time
+	RevokedAt    *time.Time
+	ViewCount    int64
+	LastViewedAt *time.Time
+}
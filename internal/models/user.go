@@ -17,4 +17,7 @@ type User struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt gorm.DeletedAt `gorm:"index"`
+	// SuggestionsDisabled turns off the follow-up questions suggested after answers to the
+	// user's prompts, which are billed like any other generation
+	SuggestionsDisabled bool
 }
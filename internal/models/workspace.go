@@ -0,0 +1,49 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Workspace roles, from most to least privileged
+const (
+	WorkspaceRoleOwner  = "owner"
+	WorkspaceRoleAdmin  = "admin"
+	WorkspaceRoleMember = "member"
+)
+
+// Workspace lets a team share research sessions and a credit pool. The pool is
+// kept in TierTokenBudget rows with the workspace's ID.
+type Workspace struct {
+	gorm.Model
+	Name    string    `gorm:"type:varchar(200)"`
+	OwnerID uuid.UUID `gorm:"type:uuid;index"`
+	Members []WorkspaceMember
+}
+
+type WorkspaceMember struct {
+	gorm.Model
+	WorkspaceID uint      `gorm:"uniqueIndex:idx_workspace_member"`
+	UserID      uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_workspace_member;index"`
+	User        User      `gorm:"foreignKey:UserID;references:ID"`
+	Role        string    `gorm:"type:varchar(20)"`
+	Limits      []WorkspaceMemberLimit
+}
+
+// WorkspaceMemberLimit caps the token-hours of one tier a member may draw from the workspace pool
+type WorkspaceMemberLimit struct {
+	gorm.Model
+	WorkspaceMemberID uint    `gorm:"uniqueIndex:idx_workspace_member_tier"`
+	PriceTier         string  `gorm:"type:varchar(10);uniqueIndex:idx_workspace_member_tier"`
+	TokenHoursLimit   float64 // in million token-hours
+}
+
+// WorkspaceMemberUsage is what a member has drawn from the workspace pool on one tier, in
+// million token-hours, counted as the pool is debited
+type WorkspaceMemberUsage struct {
+	gorm.Model
+	WorkspaceID    uint      `gorm:"uniqueIndex:idx_workspace_member_usage"`
+	UserID         uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_workspace_member_usage"`
+	PriceTier      string    `gorm:"type:varchar(10);uniqueIndex:idx_workspace_member_usage"`
+	TokenHoursUsed float64
+}
@@ -0,0 +1,620 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/time/rate"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	arxivAPIBaseURL = "http://export.arxiv.org/api/query"
+	arxivOAIBaseURL = "https://export.arxiv.org/oai2"
+
+	// A first harvest of a set only looks back this far; older papers are
+	// expected to come from a bulk metadata dump.
+	defaultHarvestLookback = 7 * 24 * time.Hour
+	maxHarvestRetries      = 5
+	dumpImportBatchSize    = 500
+)
+
+var ErrArxivPaperNotFound = errors.New("arXiv paper not found")
+
+var arxivVersionPattern = regexp.MustCompile(`v(\d+)$`)
+
+// arxivDumpVersionDate is RFC 1123 without the zero padding of the day, as in the
+// versions of the metadata snapshot
+const arxivDumpVersionDate = "Mon, 2 Jan 2006 15:04:05 MST"
+
+// ArxivEntry represents the structure of an entry in the arXiv API response
+type ArxivEntry struct {
+	ID         string `xml:"id"`
+	Title      string `xml:"title"`
+	Summary    string `xml:"summary"`
+	Published  string `xml:"published"`
+	Updated    string `xml:"updated"`
+	DOI        string `xml:"http://arxiv.org/schemas/atom doi"`
+	JournalRef string `xml:"http://arxiv.org/schemas/atom journal_ref"`
+	Comment    string `xml:"http://arxiv.org/schemas/atom comment"`
+	Authors    []struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+	Categories []struct {
+		Term string `xml:"term,attr"`
+	} `xml:"category"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"link"`
+}
+
+// ArxivFeed represents the structure of the arXiv API response
+type ArxivFeed struct {
+	Entry ArxivEntry `xml:"entry"`
+}
+
+// oaiResponse is the subset of an OAI-PMH ListRecords response we care about
+type oaiResponse struct {
+	XMLName xml.Name `xml:"OAI-PMH"`
+	Error   *struct {
+		Code    string `xml:"code,attr"`
+		Message string `xml:",chardata"`
+	} `xml:"error"`
+	ListRecords struct {
+		Records         []oaiRecord `xml:"record"`
+		ResumptionToken string      `xml:"resumptionToken"`
+	} `xml:"ListRecords"`
+}
+
+type oaiRecord struct {
+	Header struct {
+		Status    string `xml:"status,attr"`
+		Datestamp string `xml:"datestamp"`
+	} `xml:"header"`
+	Metadata struct {
+		ArXiv struct {
+			ID      string `xml:"id"`
+			Created string `xml:"created"`
+			Updated string `xml:"updated"`
+			Authors []struct {
+				Keyname   string `xml:"keyname"`
+				Forenames string `xml:"forenames"`
+				Suffix    string `xml:"suffix"`
+			} `xml:"authors>author"`
+			Title      string `xml:"title"`
+			Categories string `xml:"categories"`
+			Comments   string `xml:"comments"`
+			JournalRef string `xml:"journal-ref"`
+			DOI        string `xml:"doi"`
+			Abstract   string `xml:"abstract"`
+		} `xml:"arXiv"`
+	} `xml:"metadata"`
+}
+
+// arxivDumpRecord is one line of the JSON-lines arXiv metadata snapshot
+type arxivDumpRecord struct {
+	ID         string `json:"id"`
+	Authors    string `json:"authors"`
+	Title      string `json:"title"`
+	Comments   string `json:"comments"`
+	JournalRef string `json:"journal-ref"`
+	DOI        string `json:"doi"`
+	Categories string `json:"categories"`
+	Abstract   string `json:"abstract"`
+	UpdateDate string `json:"update_date"`
+	Versions   []struct {
+		Version string `json:"version"`
+		Created string `json:"created"`
+	} `json:"versions"`
+}
+
+type ArxivSearchResult struct {
+	ArxivID       string  `json:"arxiv_id"`
+	Title         string  `json:"title"`
+	Authors       string  `json:"authors"`
+	Abstract      string  `json:"abstract"`
+	Categories    string  `json:"categories"`
+	PublishedDate string  `json:"published_date"`
+	Rank          float64 `json:"rank"`
+}
+
+// ArxivMetadataService serves paper metadata from the local mirror and keeps
+// the mirror fed. Live arXiv API calls are only made for papers the mirror does
+// not know yet, and are rate limited as requested by arXiv.
+type ArxivMetadataService struct {
+	db         *gorm.DB
+	httpClient *http.Client
+	limiter    *rate.Limiter
+	logger     zerolog.Logger
+}
+
+func NewArxivMetadataService(db *gorm.DB, requestInterval time.Duration, logger zerolog.Logger) *ArxivMetadataService {
+	return &ArxivMetadataService{
+		db:         db,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+		limiter:    rate.NewLimiter(rate.Every(requestInterval), 1),
+		logger:     logger,
+	}
+}
+
+// GetMetadata returns the metadata of a paper, preferring the local mirror and
+// falling back to the live arXiv API. A version suffix on the ID is ignored, the
+// mirror holds the latest version of each paper.
+func (s *ArxivMetadataService) GetMetadata(ctx context.Context, arxivID string) (map[string]string, error) {
+	arxivID = stripArxivVersion(arxivID)
+	var record models.ArxivMetadata
+	err := s.db.WithContext(ctx).Where("arxiv_id = ?", arxivID).First(&record).Error
+	if err == nil {
+		s.logger.Debug().Msgf("Serving metadata for ArxivID %s from local mirror", arxivID)
+		return arxivMetadataToMap(&record), nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		s.logger.Error().Err(err).Msgf("Failed to query metadata mirror for ArxivID: %s", arxivID)
+		return nil, fmt.Errorf("failed to query metadata mirror: %w", err)
+	}
+
+	live, err := s.FetchLiveMetadata(ctx, arxivID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.upsertMetadata(ctx, []models.ArxivMetadata{*live}); err != nil {
+		// The caller still gets the metadata, the mirror will catch up on a later lookup
+		s.logger.Warn().Err(err).Msgf("Failed to store live metadata for ArxivID: %s", arxivID)
+	}
+	return arxivMetadataToMap(live), nil
+}
+
+// RefreshMetadata fetches the current metadata from the live API and stores it
+// in the mirror, regardless of what the mirror already holds.
+func (s *ArxivMetadataService) RefreshMetadata(ctx context.Context, arxivID string) (map[string]string, error) {
+	live, err := s.FetchLiveMetadata(ctx, arxivID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.upsertMetadata(ctx, []models.ArxivMetadata{*live}); err != nil {
+		return nil, err
+	}
+	return arxivMetadataToMap(live), nil
+}
+
+// FetchLiveMetadata queries the arXiv API directly, bypassing the mirror
+func (s *ArxivMetadataService) FetchLiveMetadata(ctx context.Context, arxivID string) (*models.ArxivMetadata, error) {
+	arxivID = stripArxivVersion(arxivID)
+	entry, err := s.fetchArxivEntry(ctx, arxivID)
+	if err != nil {
+		return nil, err
+	}
+	record := arxivEntryToMetadata(entry, arxivID, time.Now())
+	return &record, nil
+}
+
+func arxivEntryToMetadata(entry *ArxivEntry, arxivID string, fetchedAt time.Time) models.ArxivMetadata {
+	var authors []string
+	for _, author := range entry.Authors {
+		authors = append(authors, author.Name)
+	}
+	var categories []string
+	for _, category := range entry.Categories {
+		categories = append(categories, category.Term)
+	}
+
+	return models.ArxivMetadata{
+		ArxivID:       arxivID,
+		Title:         normalizeWhitespace(entry.Title),
+		Authors:       strings.Join(authors, ", "),
+		Abstract:      strings.TrimSpace(entry.Summary),
+		Categories:    strings.Join(categories, " "),
+		DOI:           entry.DOI,
+		JournalRef:    normalizeWhitespace(entry.JournalRef),
+		Comments:      normalizeWhitespace(entry.Comment),
+		PublishedDate: dateOnly(entry.Published),
+		UpdatedDate:   dateOnly(entry.Updated),
+		LatestVersion: parseArxivVersion(entry.ID),
+		Source:        "api",
+		HarvestedAt:   fetchedAt,
+	}
+}
+
+func (s *ArxivMetadataService) fetchArxivEntry(ctx context.Context, arxivID string) (*ArxivEntry, error) {
+	s.logger.Info().Msgf("Fetching live metadata for paper with ArxivID: %s", arxivID)
+
+	if err := s.limiter.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("rate limiter wait failed: %w", err)
+	}
+
+	reqURL := fmt.Sprintf("%s?id_list=%s", arxivAPIBaseURL, url.QueryEscape(arxivID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build arXiv API request: %w", err)
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to fetch arXiv metadata for paper with ArxivID: %s", arxivID)
+		return nil, fmt.Errorf("failed to fetch arXiv metadata: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		s.logger.Error().Msgf("Unexpected status code %d fetching metadata for ArxivID: %s", resp.StatusCode, arxivID)
+		return nil, fmt.Errorf("unexpected status code from arXiv API: %d", resp.StatusCode)
+	}
+
+	entry, err := parseArxivFeed(resp.Body, arxivID)
+	if err != nil && !errors.Is(err, ErrArxivPaperNotFound) {
+		s.logger.Error().Err(err).Msgf("Failed to parse XML response for paper with ArxivID: %s", arxivID)
+	}
+	return entry, err
+}
+
+// parseArxivFeed reads the entry of a paper from an arXiv API response. The API answers
+// unknown IDs with an error entry rather than an error status.
+func parseArxivFeed(r io.Reader, arxivID string) (*ArxivEntry, error) {
+	var feed ArxivFeed
+	if err := xml.NewDecoder(r).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("failed to parse XML response: %v", err)
+	}
+	if feed.Entry.ID == "" || !strings.Contains(feed.Entry.ID, "/abs/") {
+		return nil, fmt.Errorf("%w: %s", ErrArxivPaperNotFound, arxivID)
+	}
+	return &feed.Entry, nil
+}
+
+// Search runs a ranked full-text search over title, authors and abstract
+func (s *ArxivMetadataService) Search(ctx context.Context, query string, limit, offset int) ([]ArxivSearchResult, int64, error) {
+	s.logger.Info().Str("query", query).Int("limit", limit).Int("offset", offset).Msg("Searching arXiv metadata mirror")
+
+	base := s.db.WithContext(ctx).Model(&models.ArxivMetadata{}).
+		Where("search_vector @@ websearch_to_tsquery('english', ?)", query).
+		Session(&gorm.Session{})
+
+	var total int64
+	if err := base.Count(&total).Error; err != nil {
+		s.logger.Error().Err(err).Msg("Failed to count search results")
+		return nil, 0, fmt.Errorf("failed to count search results: %w", err)
+	}
+
+	var results []ArxivSearchResult
+	err := base.
+		Select("arxiv_id, title, authors, abstract, categories, published_date, ts_rank(search_vector, websearch_to_tsquery('english', ?)) AS rank", query).
+		Order("rank DESC").
+		Limit(limit).
+		Offset(offset).
+		Scan(&results).Error
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to search metadata mirror")
+		return nil, 0, fmt.Errorf("failed to search metadata mirror: %w", err)
+	}
+
+	return results, total, nil
+}
+
+// Harvest pulls new and updated records of an OAI-PMH set ("cs", "stat", ...)
+// into the mirror, continuing from where the previous harvest stopped.
+func (s *ArxivMetadataService) Harvest(ctx context.Context, setSpec string) (int, error) {
+	harvestStart := time.Now().UTC()
+
+	var state models.ArxivHarvestState
+	err := s.db.WithContext(ctx).Where("set_spec = ?", setSpec).First(&state).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, fmt.Errorf("failed to load harvest state: %w", err)
+	}
+	from := state.LastHarvestedAt
+	if from.IsZero() {
+		from = harvestStart.Add(-defaultHarvestLookback)
+	}
+	s.logger.Info().Str("set", setSpec).Time("from", from).Msg("Starting OAI-PMH harvest")
+
+	params := url.Values{}
+	params.Set("verb", "ListRecords")
+	params.Set("metadataPrefix", "arXiv")
+	params.Set("from", from.Format("2006-01-02"))
+	if setSpec != "" {
+		params.Set("set", setSpec)
+	}
+
+	harvested := 0
+	for {
+		page, err := s.fetchOAIPage(ctx, params)
+		if err != nil {
+			return harvested, err
+		}
+		if page.Error != nil {
+			if page.Error.Code == "noRecordsMatch" {
+				break
+			}
+			return harvested, fmt.Errorf("OAI-PMH error %s: %s", page.Error.Code, strings.TrimSpace(page.Error.Message))
+		}
+
+		var records []models.ArxivMetadata
+		for _, record := range page.ListRecords.Records {
+			if record.Header.Status == "deleted" {
+				continue
+			}
+			records = append(records, oaiRecordToMetadata(record, harvestStart))
+		}
+		if err := s.upsertMetadata(ctx, records); err != nil {
+			return harvested, err
+		}
+		harvested += len(records)
+		s.logger.Debug().Str("set", setSpec).Int("harvested", harvested).Msg("Harvested OAI-PMH page")
+
+		token := strings.TrimSpace(page.ListRecords.ResumptionToken)
+		if token == "" {
+			break
+		}
+		params = url.Values{}
+		params.Set("verb", "ListRecords")
+		params.Set("resumptionToken", token)
+	}
+
+	state.SetSpec = setSpec
+	state.LastHarvestedAt = harvestStart
+	if err := s.db.WithContext(ctx).Save(&state).Error; err != nil {
+		return harvested, fmt.Errorf("failed to save harvest state: %w", err)
+	}
+
+	s.logger.Info().Str("set", setSpec).Int("records", harvested).Msg("OAI-PMH harvest completed")
+	return harvested, nil
+}
+
+func (s *ArxivMetadataService) fetchOAIPage(ctx context.Context, params url.Values) (*oaiResponse, error) {
+	for attempt := 0; attempt < maxHarvestRetries; attempt++ {
+		if err := s.limiter.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("rate limiter wait failed: %w", err)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, arxivOAIBaseURL+"?"+params.Encode(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build OAI-PMH request: %w", err)
+		}
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch OAI-PMH page: %w", err)
+		}
+
+		// arXiv uses 503 + Retry-After for flow control during harvests
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			resp.Body.Close()
+			wait := 30 * time.Second
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				wait = time.Duration(seconds) * time.Second
+			}
+			s.logger.Info().Dur("wait", wait).Msg("OAI-PMH endpoint asked us to retry later")
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(wait):
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unexpected status code from OAI-PMH endpoint: %d", resp.StatusCode)
+		}
+
+		var page oaiResponse
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse OAI-PMH response: %w", err)
+		}
+		return &page, nil
+	}
+	return nil, fmt.Errorf("OAI-PMH endpoint still unavailable after %d attempts", maxHarvestRetries)
+}
+
+// StartPeriodicHarvest harvests the given sets once and then on every interval
+// until the context is cancelled.
+func (s *ArxivMetadataService) StartPeriodicHarvest(ctx context.Context, sets []string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, set := range sets {
+			if _, err := s.Harvest(ctx, set); err != nil {
+				s.logger.Error().Err(err).Str("set", set).Msg("Periodic OAI-PMH harvest failed")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ImportMetadataDump loads a JSON-lines arXiv metadata snapshot into the mirror
+func (s *ArxivMetadataService) ImportMetadataDump(ctx context.Context, r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+
+	now := time.Now()
+	imported := 0
+	batch := make([]models.ArxivMetadata, 0, dumpImportBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := s.upsertMetadata(ctx, batch); err != nil {
+			return err
+		}
+		imported += len(batch)
+		batch = batch[:0]
+		s.logger.Info().Int("imported", imported).Msg("Imported metadata dump batch")
+		return nil
+	}
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		var record arxivDumpRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			s.logger.Warn().Err(err).Int("line", line).Msg("Skipping malformed metadata dump line")
+			continue
+		}
+		if record.ID == "" {
+			continue
+		}
+		batch = append(batch, dumpRecordToMetadata(record, now))
+		if len(batch) == dumpImportBatchSize {
+			if err := flush(); err != nil {
+				return imported, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return imported, fmt.Errorf("failed to read metadata dump: %w", err)
+	}
+	if err := flush(); err != nil {
+		return imported, err
+	}
+	return imported, nil
+}
+
+func (s *ArxivMetadataService) upsertMetadata(ctx context.Context, records []models.ArxivMetadata) error {
+	if len(records) == 0 {
+		return nil
+	}
+	updates := clause.AssignmentColumns([]string{
+		"title", "authors", "abstract", "categories", "doi", "journal_ref", "comments",
+		"published_date", "updated_date", "source", "harvested_at", "updated_at",
+	})
+	// OAI-PMH records carry no version, never let them reset a known one
+	updates = append(updates, clause.Assignment{
+		Column: clause.Column{Name: "latest_version"},
+		Value:  gorm.Expr("GREATEST(arxiv_metadata.latest_version, excluded.latest_version)"),
+	})
+
+	err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "arxiv_id"}},
+		DoUpdates: updates,
+	}).CreateInBatches(records, dumpImportBatchSize).Error
+	if err != nil {
+		s.logger.Error().Err(err).Int("count", len(records)).Msg("Failed to upsert arXiv metadata")
+		return fmt.Errorf("failed to upsert arXiv metadata: %w", err)
+	}
+	return nil
+}
+
+func oaiRecordToMetadata(record oaiRecord, harvestedAt time.Time) models.ArxivMetadata {
+	meta := record.Metadata.ArXiv
+
+	var authors []string
+	for _, author := range meta.Authors {
+		name := strings.TrimSpace(author.Forenames + " " + author.Keyname)
+		if author.Suffix != "" {
+			name += " " + author.Suffix
+		}
+		authors = append(authors, name)
+	}
+
+	updated := meta.Updated
+	if updated == "" {
+		updated = meta.Created
+	}
+
+	return models.ArxivMetadata{
+		ArxivID:       meta.ID,
+		Title:         normalizeWhitespace(meta.Title),
+		Authors:       strings.Join(authors, ", "),
+		Abstract:      strings.TrimSpace(meta.Abstract),
+		Categories:    meta.Categories,
+		DOI:           meta.DOI,
+		JournalRef:    normalizeWhitespace(meta.JournalRef),
+		Comments:      normalizeWhitespace(meta.Comments),
+		PublishedDate: meta.Created,
+		UpdatedDate:   updated,
+		Source:        "oai",
+		HarvestedAt:   harvestedAt,
+	}
+}
+
+func dumpRecordToMetadata(record arxivDumpRecord, importedAt time.Time) models.ArxivMetadata {
+	published := record.UpdateDate
+	if len(record.Versions) > 0 {
+		if created, err := time.Parse(arxivDumpVersionDate, record.Versions[0].Created); err == nil {
+			published = created.Format("2006-01-02")
+		}
+	}
+
+	return models.ArxivMetadata{
+		ArxivID:       record.ID,
+		Title:         normalizeWhitespace(record.Title),
+		Authors:       normalizeWhitespace(record.Authors),
+		Abstract:      strings.TrimSpace(record.Abstract),
+		Categories:    record.Categories,
+		DOI:           record.DOI,
+		JournalRef:    normalizeWhitespace(record.JournalRef),
+		Comments:      normalizeWhitespace(record.Comments),
+		PublishedDate: published,
+		UpdatedDate:   record.UpdateDate,
+		LatestVersion: len(record.Versions),
+		Source:        "dump",
+		HarvestedAt:   importedAt,
+	}
+}
+
+func arxivMetadataToMap(record *models.ArxivMetadata) map[string]string {
+	published := record.PublishedDate
+	if published == "" {
+		published = record.UpdatedDate
+	}
+	return map[string]string{
+		"title":          record.Title,
+		"authors":        record.Authors,
+		"abstract":       record.Abstract,
+		"pdf_url":        fmt.Sprintf("https://arxiv.org/pdf/%s", record.ArxivID),
+		"abstract_url":   fmt.Sprintf("https://arxiv.org/abs/%s", record.ArxivID),
+		"published_date": published,
+		"last_updated":   record.UpdatedDate,
+		"doi":            record.DOI,
+		"journal":        record.JournalRef,
+		"categories":     record.Categories,
+		"version":        strconv.Itoa(record.LatestVersion),
+	}
+}
+
+// parseArxivVersion extracts N from an abs URL or ID ending in vN
+func parseArxivVersion(id string) int {
+	match := arxivVersionPattern.FindStringSubmatch(id)
+	if len(match) < 2 {
+		return 0
+	}
+	version, _ := strconv.Atoi(match[1])
+	return version
+}
+
+// stripArxivVersion removes the vN suffix from an arXiv ID
+func stripArxivVersion(id string) string {
+	return arxivVersionPattern.ReplaceAllString(id, "")
+}
+
+func normalizeWhitespace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// dateOnly trims an RFC 3339 timestamp from the arXiv API to YYYY-MM-DD
+func dateOnly(timestamp string) string {
+	if len(timestamp) >= 10 {
+		return timestamp[:10]
+	}
+	return timestamp
+}
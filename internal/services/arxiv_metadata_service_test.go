@@ -0,0 +1,141 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The fixtures under testdata/arxiv are responses recorded from the arXiv API, the OAI-PMH
+// endpoint and the metadata snapshot, trimmed to a few records
+
+func openFixture(t *testing.T, name string) *os.File {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", "arxiv", name))
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestArxivEntryToMetadata(t *testing.T) {
+	fetchedAt := time.Date(2024, 5, 2, 9, 0, 0, 0, time.UTC)
+	entry, err := parseArxivFeed(openFixture(t, "api_entry.xml"), "1706.03762")
+	require.NoError(t, err)
+
+	assert.Equal(t, models.ArxivMetadata{
+		ArxivID:       "1706.03762",
+		Title:         "Attention Is All You Need",
+		Authors:       "Ashish Vaswani, Noam Shazeer, Niki Parmar",
+		Abstract:      "The dominant sequence transduction models are based on complex recurrent or\nconvolutional neural networks in an encoder-decoder configuration.",
+		Categories:    "cs.CL cs.LG",
+		DOI:           "10.48550/arXiv.1706.03762",
+		JournalRef:    "Advances in Neural Information Processing Systems 30 (2017)",
+		Comments:      "15 pages, 5 figures",
+		PublishedDate: "2017-06-12",
+		UpdatedDate:   "2023-08-02",
+		LatestVersion: 7,
+		Source:        "api",
+		HarvestedAt:   fetchedAt,
+	}, arxivEntryToMetadata(entry, "1706.03762", fetchedAt))
+}
+
+func TestParseArxivFeedNotFound(t *testing.T) {
+	_, err := parseArxivFeed(openFixture(t, "api_not_found.xml"), "1706.99999")
+	assert.True(t, errors.Is(err, ErrArxivPaperNotFound), "got %v", err)
+}
+
+func TestOAIRecordToMetadata(t *testing.T) {
+	harvestedAt := time.Date(2024, 5, 2, 9, 0, 0, 0, time.UTC)
+	var page oaiResponse
+	require.NoError(t, xml.NewDecoder(openFixture(t, "oai_list_records.xml")).Decode(&page))
+	require.Nil(t, page.Error)
+	require.Len(t, page.ListRecords.Records, 3)
+	assert.Equal(t, "6960524|1001", page.ListRecords.ResumptionToken)
+
+	assert.Equal(t, models.ArxivMetadata{
+		ArxivID:       "2101.00001",
+		Title:         "Analytical Engines for Sequence Models",
+		Authors:       "Ada Lovelace, Charles Babbage Jr, Collaboration",
+		Abstract:      "We study the engine.",
+		Categories:    "cs.LG stat.ML",
+		DOI:           "10.1000/engines.2024.4",
+		JournalRef:    "Journal of Engines 4 (2024)",
+		Comments:      "12 pages, 3 figures",
+		PublishedDate: "2020-12-31",
+		UpdatedDate:   "2024-04-25",
+		Source:        "oai",
+		HarvestedAt:   harvestedAt,
+	}, oaiRecordToMetadata(page.ListRecords.Records[0], harvestedAt))
+
+	// A paper that was never updated takes its creation date as the update date
+	never := oaiRecordToMetadata(page.ListRecords.Records[1], harvestedAt)
+	assert.Equal(t, "2021-01-02", never.UpdatedDate)
+	assert.Equal(t, "Grace Hopper", never.Authors)
+
+	assert.Equal(t, "deleted", page.ListRecords.Records[2].Header.Status)
+}
+
+func TestOAINoRecordsMatch(t *testing.T) {
+	var page oaiResponse
+	require.NoError(t, xml.NewDecoder(openFixture(t, "oai_no_records.xml")).Decode(&page))
+	require.NotNil(t, page.Error)
+	assert.Equal(t, "noRecordsMatch", page.Error.Code)
+	assert.Empty(t, page.ListRecords.Records)
+}
+
+func TestDumpRecordToMetadata(t *testing.T) {
+	importedAt := time.Date(2024, 5, 2, 9, 0, 0, 0, time.UTC)
+	var records []arxivDumpRecord
+	scanner := bufio.NewScanner(openFixture(t, "metadata_dump.jsonl"))
+	for scanner.Scan() {
+		var record arxivDumpRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, records, 2)
+
+	assert.Equal(t, models.ArxivMetadata{
+		ArxivID:       "0704.0001",
+		Title:         "Calculation of prompt diphoton production cross sections at Tevatron and LHC energies",
+		Authors:       `C. Bal\'azs, E. L. Berger, P. M. Nadolsky, C.-P. Yuan`,
+		Abstract:      "A fully differential calculation in perturbative quantum chromodynamics is\npresented for the production of massive photon pairs.",
+		Categories:    "hep-ph",
+		DOI:           "10.1103/PhysRevD.76.013009",
+		JournalRef:    "Phys.Rev.D76:013009,2007",
+		Comments:      "37 pages, 15 figures; published version",
+		PublishedDate: "2007-04-02",
+		UpdatedDate:   "2008-11-13",
+		LatestVersion: 2,
+		Source:        "dump",
+		HarvestedAt:   importedAt,
+	}, dumpRecordToMetadata(records[0], importedAt))
+
+	// Without versions the paper is dated by its last update
+	unversioned := dumpRecordToMetadata(records[1], importedAt)
+	assert.Equal(t, "2008-12-13", unversioned.PublishedDate)
+	assert.Equal(t, 0, unversioned.LatestVersion)
+	assert.Empty(t, unversioned.DOI)
+}
+
+func TestStripArxivVersion(t *testing.T) {
+	tests := map[string]string{
+		"2101.00001v2":     "2101.00001",
+		"2101.00001":       "2101.00001",
+		"hep-th/9901001v1": "hep-th/9901001",
+		"math/0211159":     "math/0211159",
+	}
+	for id, want := range tests {
+		assert.Equal(t, want, stripArxivVersion(id), id)
+	}
+}
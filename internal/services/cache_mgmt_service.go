@@ -32,12 +32,17 @@ type ModelGenerator interface {
 	GenerativeModelFromCachedContent(cc *genai.CachedContent) *genai.GenerativeModel
 }
 
+type ModelProvider interface {
+	GenerativeModel(name string) *genai.GenerativeModel
+}
+
 type GenAIClient interface {
 	ContentCreator
 	ContentRetriever
 	ContentDeleter
 	ContentUpdater
 	ModelGenerator
+	ModelProvider
 }
 
 type CacheManagementService struct {
@@ -46,6 +51,7 @@ type CacheManagementService struct {
 	expirationTime     time.Duration
 	cacheServiceDB     CacheServiceDB
 	chatServiceDB      ChatServiceDB
+	spendingLimiter    MemberSpendingLimiter
 	logger             zerolog.Logger
 }
 
@@ -55,6 +61,7 @@ func NewCacheManagementService(
 	expirationTime time.Duration,
 	cacheServiceDB CacheServiceDB,
 	chatServiceDB ChatServiceDB,
+	spendingLimiter MemberSpendingLimiter,
 	logger zerolog.Logger,
 ) *CacheManagementService {
 	return &CacheManagementService{
@@ -63,28 +70,28 @@ func NewCacheManagementService(
 		expirationTime:     expirationTime,
 		cacheServiceDB:     cacheServiceDB,
 		chatServiceDB:      chatServiceDB,
+		spendingLimiter:    spendingLimiter,
 		logger:             logger,
 	}
 }
 
-func (cms *CacheManagementService) CreateContentCache(ctx context.Context, userID uuid.UUID, sessionID string, priceTier, aggregatedContent string) (string, time.Time, error) {
+// CreateContentCache caches a session's corpus, and the images of its figures if any, with the
+// system instruction its answers follow
+func (cms *CacheManagementService) CreateContentCache(ctx context.Context, userID uuid.UUID, sessionID string, priceTier, aggregatedContent string, figures []FigureImage, systemInstruction string) (string, time.Time, error) {
 	cms.logger.Info().Str("userID", userID.String()).Str("sessionID", sessionID).Str("priceTier", priceTier).Msg("Creating content cache")
 
-	var modelName string
-	if priceTier == "pro" {
-		modelName = "gemini-1.5-pro-001"
-	} else {
-		modelName = "gemini-1.5-flash-001"
-	}
 	cc := &genai.CachedContent{
-		Model: modelName,
+		Model: cacheModelName(priceTier),
 		Expiration: genai.ExpireTimeOrTTL{
 			TTL: cms.expirationTime,
 		},
 		Contents: []*genai.Content{
-			genai.NewUserContent(genai.Text(aggregatedContent)),
+			genai.NewUserContent(corpusParts(aggregatedContent, figures)...),
 		},
 	}
+	if systemInstruction != "" {
+		cc.SystemInstruction = genai.NewUserContent(genai.Text(systemInstruction))
+	}
 
 	cachedContent, err := cms.genAIClient.CreateCachedContent(ctx, cc)
 	if err != nil {
@@ -106,6 +113,22 @@ func (cms *CacheManagementService) CreateContentCache(ctx context.Context, userI
 	return cacheName, cacheExpiryTime, nil
 }
 
+// CountTokens counts the tokens a corpus, its figure images and the system instruction would
+// take up in a cache of the tier, with the tokenizer of the tier's model, without creating the
+// cache
+func (cms *CacheManagementService) CountTokens(ctx context.Context, priceTier, aggregatedContent string, figures []FigureImage, systemInstruction string) (int32, error) {
+	model := cms.genAIClient.GenerativeModel(cacheModelName(priceTier))
+	if systemInstruction != "" {
+		model.SystemInstruction = genai.NewUserContent(genai.Text(systemInstruction))
+	}
+	resp, err := model.CountTokens(ctx, corpusParts(aggregatedContent, figures)...)
+	if err != nil {
+		cms.logger.Error().Err(err).Msg("Failed to count tokens")
+		return 0, fmt.Errorf("failed to count tokens: %v", err)
+	}
+	return resp.TotalTokens, nil
+}
+
 func (cms *CacheManagementService) ExtendCacheLifetime(ctx context.Context, cachedContentName string, newExpirationTime time.Time) error {
 	cms.logger.Info().Str("cachedContentName", cachedContentName).Time("newExpirationTime", newExpirationTime).Msg("Extending cache lifetime")
 
@@ -166,9 +189,8 @@ func (cms *CacheManagementService) RecordCacheTokenUsage(ctx context.Context, us
 		return fmt.Errorf("failed to update cache termination time: %v", err)
 	}
 
-	duration := terminationTime.Sub(cache.CreationTime)
-	durationSeconds := duration.Seconds()
-	tokenHours := float64(cache.TotalTokenCount) * durationSeconds / (3600 * 1_000_000)
+	durationSeconds := terminationTime.Sub(cache.CreationTime).Seconds()
+	tokenHours := cacheTokenHours(cache, terminationTime)
 
 	err = cms.chatServiceDB.UpdateChatMetrics(sessionID, durationSeconds, cache.TotalTokenCount, cache.PriceTier, tokenHours, terminationTime)
 	if err != nil {
@@ -176,7 +198,7 @@ func (cms *CacheManagementService) RecordCacheTokenUsage(ctx context.Context, us
 		return fmt.Errorf("failed to update chat metrics: %v", err)
 	}
 
-	err = cms.LogCacheUsage(ctx, userID, cache.PriceTier, tokenHours, durationSeconds, cache.TotalTokenCount)
+	err = cms.LogCacheUsage(ctx, userID, cache.WorkspaceID, cache.PriceTier, tokenHours, durationSeconds, cache.TotalTokenCount)
 	if err != nil {
 		cms.logger.Error().Err(err).Msg("Failed to log final cache usage")
 		return fmt.Errorf("failed to log final cache usage: %v", err)
@@ -219,42 +241,129 @@ func (cms *CacheManagementService) UpdateAllowedCacheUsage(ctx context.Context,
 		return fmt.Errorf("failed to get tier token budget: %v", err)
 	}
 
-	budget.TokenHoursBought += additionalTokens
-	err = cms.cacheServiceDB.UpdateTierTokenBudgetDB(budget)
+	err = cms.cacheServiceDB.AddTierTokenHoursBoughtDB(budget.ID, additionalTokens)
 	if err != nil {
 		cms.logger.Error().Err(err).Msg("Failed to update tier token budget")
 		return err
 	}
 
-	cms.logger.Info().Float64("newTokenHoursBought", budget.TokenHoursBought).Msg("Allowed cache usage updated successfully")
+	cms.logger.Info().Float64("newTokenHoursBought", budget.TokenHoursBought+additionalTokens).Msg("Allowed cache usage updated successfully")
 	return nil
 }
 
-func (cms *CacheManagementService) LogCacheUsage(ctx context.Context, userID uuid.UUID, priceTier string, tokenHoursUsed float64, chatDuration float64, tokenCountUsed int32) error {
+// LogCacheUsage debits the usage from the user's budget, or from the workspace pool when
+// the session is billed to a workspace
+func (cms *CacheManagementService) LogCacheUsage(ctx context.Context, userID uuid.UUID, workspaceID *uint, priceTier string, tokenHoursUsed float64, chatDuration float64, tokenCountUsed int32) error {
 	cms.logger.Info().Str("userID", userID.String()).Str("priceTier", priceTier).Float64("tokenHoursUsed", tokenHoursUsed).Float64("chatDuration", chatDuration).Int32("tokenCountUsed", tokenCountUsed).Msg("Logging cache usage")
 
-	budget, err := cms.cacheServiceDB.GetTierTokenBudgetDB(userID, priceTier)
+	budget, err := cms.getBudget(userID, workspaceID, priceTier)
 	if err != nil {
 		cms.logger.Error().Err(err).Msg("Failed to get tier token budget")
 		return fmt.Errorf("failed to get tier token budget: %v", err)
 	}
 
-	budget.TokenHoursUsed += tokenHoursUsed
-	if budget.TokenHoursUsed >= budget.TokenHoursBought {
-		budget.TokenHoursUsed = budget.TokenHoursBought
+	// Debited in place, as members of a workspace draw on its pool concurrently
+	if workspaceID != nil {
+		err = cms.cacheServiceDB.DebitWorkspaceTokenHoursDB(budget, userID, tokenHoursUsed)
+	} else {
+		err = cms.cacheServiceDB.AddTierTokenHoursUsedDB(budget.ID, tokenHoursUsed)
+	}
+	if err != nil {
+		cms.logger.Error().Err(err).Msg("Failed to update tier token budget")
+		return err
+	}
+	if budget.TokenHoursUsed+tokenHoursUsed >= budget.TokenHoursBought {
 		cms.logger.Info().Msg("Token hours used has reached or exceeded the bought limit")
 	}
 
-	err = cms.cacheServiceDB.UpdateTierTokenBudgetDB(budget)
+	cms.logger.Info().Float64("newTokenHoursUsed", budget.TokenHoursUsed+tokenHoursUsed).Msg("Cache usage logged successfully")
+	return nil
+}
+
+// UpdateWorkspaceAllowedCacheUsage tops up the shared pool of a workspace
+func (cms *CacheManagementService) UpdateWorkspaceAllowedCacheUsage(ctx context.Context, workspaceID uint, ownerID uuid.UUID, priceTier string, additionalTokens float64) error {
+	cms.logger.Info().Uint("workspaceID", workspaceID).Str("priceTier", priceTier).Float64("additionalTokens", additionalTokens).Msg("Updating workspace cache usage pool")
+
+	err := cms.cacheServiceDB.AddWorkspaceTokenHoursBoughtDB(workspaceID, ownerID, priceTier, additionalTokens)
 	if err != nil {
-		cms.logger.Error().Err(err).Msg("Failed to update tier token budget")
+		cms.logger.Error().Err(err).Msg("Failed to update workspace tier token budget")
 		return err
 	}
 
-	cms.logger.Info().Float64("newTokenHoursUsed", budget.TokenHoursUsed).Msg("Cache usage logged successfully")
+	cms.logger.Info().Msg("Workspace cache usage pool updated successfully")
 	return nil
 }
 
+// RemainingCredit returns the token hours the user can still spend on a tier. Sessions billed
+// to a workspace draw on the pool, capped by the member's spending limit. Both count what the
+// sessions running on the pool have used so far, as they are only debited once they end.
+func (cms *CacheManagementService) RemainingCredit(ctx context.Context, userID uuid.UUID, workspaceID *uint, priceTier string) (float64, error) {
+	budget, err := cms.getBudget(userID, workspaceID, priceTier)
+	if workspaceID != nil && err == gorm.ErrRecordNotFound {
+		// The pool has never been topped up on this tier
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get tier token budget: %w", err)
+	}
+	remaining := budget.TokenHoursBought - budget.TokenHoursUsed
+	if workspaceID == nil {
+		return remaining, nil
+	}
+
+	running, err := cms.cacheServiceDB.GetRunningWorkspaceCachesDB(*workspaceID, priceTier)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get running workspace sessions: %w", err)
+	}
+	now := time.Now()
+	for i := range running {
+		remaining -= cacheTokenHours(&running[i], now)
+	}
+
+	memberRemaining, limited, err := cms.spendingLimiter.RemainingMemberLimit(ctx, *workspaceID, userID, priceTier)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get member spending limit: %w", err)
+	}
+	if limited && memberRemaining < remaining {
+		remaining = memberRemaining
+	}
+	return remaining, nil
+}
+
+// cacheTokenHours is the usage of a cache from its creation until the given time, in million
+// token-hours
+func cacheTokenHours(cache *models.Cache, until time.Time) float64 {
+	return float64(cache.TotalTokenCount) * until.Sub(cache.CreationTime).Seconds() / (3600 * 1_000_000)
+}
+
+// corpusParts returns the parts a corpus is cached as: its markup, then each figure image
+// preceded by the name the markup refers to it by
+func corpusParts(aggregatedContent string, figures []FigureImage) []genai.Part {
+	parts := []genai.Part{genai.Text(aggregatedContent)}
+	for _, figure := range figures {
+		parts = append(parts,
+			genai.Text(fmt.Sprintf("Image %s (%s):", figure.Name, figure.Label)),
+			genai.ImageData("png", figure.PNG),
+		)
+	}
+	return parts
+}
+
+// cacheModelName returns the model the caches of a price tier are created for
+func cacheModelName(priceTier string) string {
+	if priceTier == "pro" {
+		return "gemini-1.5-pro-001"
+	}
+	return "gemini-1.5-flash-001"
+}
+
+func (cms *CacheManagementService) getBudget(userID uuid.UUID, workspaceID *uint, priceTier string) (*models.TierTokenBudget, error) {
+	if workspaceID != nil {
+		return cms.cacheServiceDB.GetWorkspaceTierTokenBudgetDB(*workspaceID, priceTier)
+	}
+	return cms.cacheServiceDB.GetTierTokenBudgetDB(userID, priceTier)
+}
+
 func (cms *CacheManagementService) GetNetTokensByTier(ctx context.Context, userID uuid.UUID) (float64, float64, error) {
 	cms.logger.Info().Str("userID", userID.String()).Msg("Getting net tokens by tier")
 
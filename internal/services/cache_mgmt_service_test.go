@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// fakeBudgetDB holds one personal budget and one workspace pool. Methods the tests do not
+// need are left to the nil CacheServiceDB and panic.
+type fakeBudgetDB struct {
+	CacheServiceDB
+	personal *models.TierTokenBudget
+	pool     *models.TierTokenBudget
+	running  []models.Cache
+	debited  map[uuid.UUID]float64 // workspace debits by member
+}
+
+func (f *fakeBudgetDB) GetTierTokenBudgetDB(userID uuid.UUID, priceTier string) (*models.TierTokenBudget, error) {
+	if f.personal == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return f.personal, nil
+}
+
+func (f *fakeBudgetDB) GetWorkspaceTierTokenBudgetDB(workspaceID uint, priceTier string) (*models.TierTokenBudget, error) {
+	if f.pool == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return f.pool, nil
+}
+
+func (f *fakeBudgetDB) GetRunningWorkspaceCachesDB(workspaceID uint, priceTier string) ([]models.Cache, error) {
+	return f.running, nil
+}
+
+func (f *fakeBudgetDB) AddTierTokenHoursUsedDB(budgetID uint, tokenHours float64) error {
+	f.personal.TokenHoursUsed += tokenHours
+	return nil
+}
+
+func (f *fakeBudgetDB) DebitWorkspaceTokenHoursDB(budget *models.TierTokenBudget, userID uuid.UUID, tokenHours float64) error {
+	f.pool.TokenHoursUsed += tokenHours
+	if f.debited == nil {
+		f.debited = make(map[uuid.UUID]float64)
+	}
+	f.debited[userID] += tokenHours
+	return nil
+}
+
+type fakeSpendingLimiter struct {
+	remaining float64
+	limited   bool
+	err       error
+}
+
+func (f fakeSpendingLimiter) RemainingMemberLimit(ctx context.Context, workspaceID uint, userID uuid.UUID, priceTier string) (float64, bool, error) {
+	return f.remaining, f.limited, f.err
+}
+
+func TestRemainingCredit(t *testing.T) {
+	workspaceID := uint(7)
+	hourOld := models.Cache{TotalTokenCount: 1_000_000, CreationTime: time.Now().Add(-time.Hour)}
+	limiterErr := errors.New("limit lookup failed")
+
+	tests := []struct {
+		name        string
+		db          *fakeBudgetDB
+		limiter     fakeSpendingLimiter
+		workspaceID *uint
+		remaining   float64
+		err         error
+	}{
+		{
+			name:      "personal budget",
+			db:        &fakeBudgetDB{personal: &models.TierTokenBudget{TokenHoursBought: 5, TokenHoursUsed: 2}},
+			remaining: 3,
+		},
+		{
+			name: "personal budget never bought",
+			db:   &fakeBudgetDB{},
+			err:  gorm.ErrRecordNotFound,
+		},
+		{
+			name:        "pool without a member limit",
+			db:          &fakeBudgetDB{pool: &models.TierTokenBudget{TokenHoursBought: 10, TokenHoursUsed: 4}},
+			workspaceID: &workspaceID,
+			remaining:   6,
+		},
+		{
+			name:        "pool less its running sessions",
+			db:          &fakeBudgetDB{pool: &models.TierTokenBudget{TokenHoursBought: 10, TokenHoursUsed: 4}, running: []models.Cache{hourOld, hourOld}},
+			workspaceID: &workspaceID,
+			remaining:   4,
+		},
+		{
+			name:        "member limit below the pool",
+			db:          &fakeBudgetDB{pool: &models.TierTokenBudget{TokenHoursBought: 10}},
+			limiter:     fakeSpendingLimiter{remaining: 1.5, limited: true},
+			workspaceID: &workspaceID,
+			remaining:   1.5,
+		},
+		{
+			name:        "pool below the member limit",
+			db:          &fakeBudgetDB{pool: &models.TierTokenBudget{TokenHoursBought: 10, TokenHoursUsed: 9}},
+			limiter:     fakeSpendingLimiter{remaining: 5, limited: true},
+			workspaceID: &workspaceID,
+			remaining:   1,
+		},
+		{
+			name:        "pool never topped up",
+			db:          &fakeBudgetDB{},
+			workspaceID: &workspaceID,
+			remaining:   0,
+		},
+		{
+			name:        "member limit lookup fails",
+			db:          &fakeBudgetDB{pool: &models.TierTokenBudget{TokenHoursBought: 10}},
+			limiter:     fakeSpendingLimiter{err: limiterErr},
+			workspaceID: &workspaceID,
+			err:         limiterErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			credit := NewCacheManagementService(nil, nil, 0, tt.db, nil, tt.limiter, zerolog.Nop())
+			remaining, err := credit.RemainingCredit(context.Background(), uuid.New(), tt.workspaceID, "base")
+			if tt.err != nil {
+				assert.True(t, errors.Is(err, tt.err), "got %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.InDelta(t, tt.remaining, remaining, 0.01)
+		})
+	}
+}
+
+func TestLogCacheUsage(t *testing.T) {
+	ctx := context.Background()
+	workspaceID := uint(7)
+	userID := uuid.New()
+	db := &fakeBudgetDB{
+		personal: &models.TierTokenBudget{TokenHoursBought: 5},
+		pool:     &models.TierTokenBudget{WorkspaceID: &workspaceID, TokenHoursBought: 10},
+	}
+	credit := NewCacheManagementService(nil, nil, 0, db, nil, fakeSpendingLimiter{}, zerolog.Nop())
+
+	require.NoError(t, credit.LogCacheUsage(ctx, userID, nil, "base", 1, 0, 1000))
+	assert.Equal(t, 1.0, db.personal.TokenHoursUsed)
+	assert.Zero(t, db.pool.TokenHoursUsed)
+
+	// Pool debits are charged to the member who drew on the pool
+	require.NoError(t, credit.LogCacheUsage(ctx, userID, &workspaceID, "base", 2, 0, 1000))
+	assert.Equal(t, 1.0, db.personal.TokenHoursUsed)
+	assert.Equal(t, 2.0, db.pool.TokenHoursUsed)
+	assert.Equal(t, map[uuid.UUID]float64{userID: 2}, db.debited)
+}
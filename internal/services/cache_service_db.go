@@ -6,6 +6,7 @@ import (
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type CacheServiceDB interface {
@@ -16,8 +17,15 @@ type CacheServiceDB interface {
 	DeleteCacheDB(sessionID string) error
 	GetTierTokenBudgetDB(userID uuid.UUID, priceTier string) (*models.TierTokenBudget, error)
 	CreateTierTokenBudgetDB(budget *models.TierTokenBudget) error
-	UpdateTierTokenBudgetDB(budget *models.TierTokenBudget) error
+	AddTierTokenHoursBoughtDB(budgetID uint, tokenHours float64) error
+	AddTierTokenHoursUsedDB(budgetID uint, tokenHours float64) error
+	DebitWorkspaceTokenHoursDB(budget *models.TierTokenBudget, userID uuid.UUID, tokenHours float64) error
 	GetAllTierTokenBudgetsDB(userID uuid.UUID) ([]models.TierTokenBudget, error)
+	SetCacheWorkspaceDB(sessionID string, workspaceID uint) error
+	GetWorkspaceTierTokenBudgetDB(workspaceID uint, priceTier string) (*models.TierTokenBudget, error)
+	AddWorkspaceTokenHoursBoughtDB(workspaceID uint, ownerID uuid.UUID, priceTier string, tokenHours float64) error
+	GetAllWorkspaceTierTokenBudgetsDB(workspaceID uint) ([]models.TierTokenBudget, error)
+	GetRunningWorkspaceCachesDB(workspaceID uint, priceTier string) ([]models.Cache, error)
 }
 
 type DefaultCacheService struct {
@@ -65,7 +73,7 @@ func (s *DefaultCacheService) DeleteCacheDB(sessionID string) error {
 
 func (s *DefaultCacheService) GetTierTokenBudgetDB(userID uuid.UUID, priceTier string) (*models.TierTokenBudget, error) {
 	var budget models.TierTokenBudget
-	err := s.db.Where("user_id = ? AND price_tier = ?", userID, priceTier).First(&budget).Error
+	err := s.db.Where("user_id = ? AND price_tier = ? AND workspace_id IS NULL", userID, priceTier).First(&budget).Error
 	if err != nil {
 		return nil, err
 	}
@@ -76,15 +84,92 @@ func (s *DefaultCacheService) CreateTierTokenBudgetDB(budget *models.TierTokenBu
 	return s.db.Create(budget).Error
 }
 
-func (s *DefaultCacheService) UpdateTierTokenBudgetDB(budget *models.TierTokenBudget) error {
-	return s.db.Save(budget).Error
+// AddTierTokenHoursBoughtDB credits a budget in place, so top-ups and concurrent usage of
+// the same budget do not overwrite each other
+func (s *DefaultCacheService) AddTierTokenHoursBoughtDB(budgetID uint, tokenHours float64) error {
+	return s.db.Model(&models.TierTokenBudget{}).Where("id = ?", budgetID).
+		Update("token_hours_bought", gorm.Expr("token_hours_bought + ?", tokenHours)).Error
+}
+
+// AddTierTokenHoursUsedDB debits a budget in place, never beyond what was bought
+func (s *DefaultCacheService) AddTierTokenHoursUsedDB(budgetID uint, tokenHours float64) error {
+	return s.db.Model(&models.TierTokenBudget{}).Where("id = ?", budgetID).
+		Update("token_hours_used", gorm.Expr("LEAST(token_hours_used + ?, token_hours_bought)", tokenHours)).Error
+}
+
+// DebitWorkspaceTokenHoursDB debits a workspace pool and counts the usage against the member
+// who drew on it, in one transaction
+func (s *DefaultCacheService) DebitWorkspaceTokenHoursDB(budget *models.TierTokenBudget, userID uuid.UUID, tokenHours float64) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.TierTokenBudget{}).Where("id = ?", budget.ID).
+			Update("token_hours_used", gorm.Expr("LEAST(token_hours_used + ?, token_hours_bought)", tokenHours)).Error
+		if err != nil {
+			return err
+		}
+		usage := &models.WorkspaceMemberUsage{WorkspaceID: *budget.WorkspaceID, UserID: userID, PriceTier: budget.PriceTier, TokenHoursUsed: tokenHours}
+		return tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "workspace_id"}, {Name: "user_id"}, {Name: "price_tier"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"token_hours_used": gorm.Expr("workspace_member_usages.token_hours_used + excluded.token_hours_used"),
+				"updated_at":       gorm.Expr("excluded.updated_at"),
+			}),
+		}).Create(usage).Error
+	})
 }
 
 func (s *DefaultCacheService) GetAllTierTokenBudgetsDB(userID uuid.UUID) ([]models.TierTokenBudget, error) {
 	var budgets []models.TierTokenBudget
-	err := s.db.Where("user_id = ?", userID).Find(&budgets).Error
+	err := s.db.Where("user_id = ? AND workspace_id IS NULL", userID).Find(&budgets).Error
+	if err != nil {
+		return nil, err
+	}
+	return budgets, nil
+}
+
+// SetCacheWorkspaceDB bills the session's cache to a workspace pool
+func (s *DefaultCacheService) SetCacheWorkspaceDB(sessionID string, workspaceID uint) error {
+	return s.db.Model(&models.Cache{}).Where("session_id = ?", sessionID).
+		Update("workspace_id", workspaceID).Error
+}
+
+func (s *DefaultCacheService) GetWorkspaceTierTokenBudgetDB(workspaceID uint, priceTier string) (*models.TierTokenBudget, error) {
+	var budget models.TierTokenBudget
+	err := s.db.Where("workspace_id = ? AND price_tier = ?", workspaceID, priceTier).First(&budget).Error
+	if err != nil {
+		return nil, err
+	}
+	return &budget, nil
+}
+
+// AddWorkspaceTokenHoursBoughtDB tops up a workspace pool, creating it on its first top-up.
+// Concurrent top-ups of a new pool add to the same row.
+func (s *DefaultCacheService) AddWorkspaceTokenHoursBoughtDB(workspaceID uint, ownerID uuid.UUID, priceTier string, tokenHours float64) error {
+	budget := &models.TierTokenBudget{UserID: ownerID, WorkspaceID: &workspaceID, PriceTier: priceTier, TokenHoursBought: tokenHours}
+	return s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "workspace_id"}, {Name: "price_tier"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"token_hours_bought": gorm.Expr("tier_token_budgets.token_hours_bought + excluded.token_hours_bought"),
+			"updated_at":         gorm.Expr("excluded.updated_at"),
+		}),
+	}).Create(budget).Error
+}
+
+func (s *DefaultCacheService) GetAllWorkspaceTierTokenBudgetsDB(workspaceID uint) ([]models.TierTokenBudget, error) {
+	var budgets []models.TierTokenBudget
+	err := s.db.Where("workspace_id = ?", workspaceID).Find(&budgets).Error
 	if err != nil {
 		return nil, err
 	}
 	return budgets, nil
 }
+
+// GetRunningWorkspaceCachesDB returns the caches of the sessions still drawing on a workspace
+// pool. Running sessions have no termination time yet, which is stored as the zero time.
+func (s *DefaultCacheService) GetRunningWorkspaceCachesDB(workspaceID uint, priceTier string) ([]models.Cache, error) {
+	var caches []models.Cache
+	err := s.db.Where("workspace_id = ? AND price_tier = ? AND termination_time < creation_time", workspaceID, priceTier).Find(&caches).Error
+	if err != nil {
+		return nil, err
+	}
+	return caches, nil
+}
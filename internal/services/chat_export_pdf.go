@@ -0,0 +1,253 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"nexus_scholar_go_backend/internal/utils/markdown"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+const (
+	pdfMargin     = 15.0
+	pdfLineHeight = 5.0
+	pdfFontSize   = 10.0
+	pdfListIndent = 6.0
+)
+
+// pdfHeadingSizes are the font sizes of Markdown headings by level
+var pdfHeadingSizes = [...]float64{16, 14, 12.5, 11.5, 11, 10.5}
+
+// transcriptPDF writes a transcript, or another Markdown document such as a report, with
+// the core PDF fonts. Text is translated to cp1252, so characters outside that code page
+// are replaced.
+type transcriptPDF struct {
+	pdf *gofpdf.Fpdf
+	tr  func(string) string
+}
+
+// newTranscriptPDF starts an A4 document with numbered pages
+func newTranscriptPDF(title string) *transcriptPDF {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin)
+	pdf.SetTitle(title, true)
+	pdf.AliasNbPages("")
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-pdfMargin + 3)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(120, 120, 120)
+		pdf.CellFormat(0, 4, fmt.Sprintf("Page %d/{nb}", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	pdf.AddPage()
+	return &transcriptPDF{pdf: pdf, tr: pdf.UnicodeTranslatorFromDescriptor("")}
+}
+
+func renderTranscriptPDF(t *Transcript) ([]byte, error) {
+	w := newTranscriptPDF(transcriptTitle(t.Chat))
+	pdf := w.pdf
+	w.heading(1, transcriptTitle(t.Chat))
+	if t.Chat.Summary != "" {
+		w.spans([]markdown.Span{{Text: t.Chat.Summary}})
+		pdf.Ln(pdfLineHeight + 1.5)
+	}
+	w.plainLines(transcriptUsageLines(t.Chat))
+
+	if len(t.Documents) > 0 {
+		w.heading(2, "Documents")
+		for _, doc := range t.Documents {
+			w.listItem(markdown.Block{Kind: markdown.ListItem, Spans: []markdown.Span{{Text: documentLabel(doc)}}})
+		}
+	}
+
+	w.heading(2, "Transcript")
+	for _, msg := range t.Messages {
+		w.messageHeader(msg)
+		for _, block := range markdown.Parse(msg.Content) {
+			w.block(block)
+		}
+		if len(msg.Citations) > 0 {
+			pdf.SetFont("Helvetica", "I", 9)
+			pdf.SetTextColor(90, 90, 90)
+			labels := make([]string, len(msg.Citations))
+			for i, doc := range msg.Citations {
+				labels[i] = documentLabel(doc)
+			}
+			pdf.MultiCell(0, 4.5, w.tr("Cited: "+strings.Join(labels, "; ")), "", "L", false)
+			pdf.Ln(2)
+		}
+	}
+
+	return w.output()
+}
+
+func (w *transcriptPDF) output() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := w.pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (w *transcriptPDF) resetStyle() {
+	w.pdf.SetFont("Helvetica", "", pdfFontSize)
+	w.pdf.SetTextColor(34, 34, 34)
+}
+
+func (w *transcriptPDF) heading(level int, text string) {
+	w.pdf.Ln(2)
+	w.pdf.SetFont("Helvetica", "B", pdfHeadingSizes[level-1])
+	w.pdf.SetTextColor(34, 34, 34)
+	w.pdf.MultiCell(0, pdfHeadingSizes[level-1]*0.5, w.tr(text), "", "L", false)
+	w.pdf.Ln(1.5)
+	w.resetStyle()
+}
+
+func (w *transcriptPDF) plainLines(lines []string) {
+	w.pdf.SetFont("Helvetica", "", 9)
+	w.pdf.SetTextColor(90, 90, 90)
+	for _, line := range lines {
+		w.pdf.MultiCell(0, 4.5, w.tr(line), "", "L", false)
+	}
+	w.resetStyle()
+}
+
+func (w *transcriptPDF) messageHeader(msg TranscriptMessage) {
+	w.pdf.Ln(2)
+	if msg.Type == "ai" {
+		w.pdf.SetFillColor(226, 236, 250)
+	} else {
+		w.pdf.SetFillColor(238, 238, 238)
+	}
+	w.pdf.SetFont("Helvetica", "B", pdfFontSize)
+	w.pdf.SetTextColor(34, 34, 34)
+	w.pdf.CellFormat(0, 6.5, w.tr(msg.speaker()+"  -  "+msg.Timestamp.Format(time.RFC3339)), "", 1, "L", true, 0, "")
+	w.pdf.Ln(1.5)
+	w.resetStyle()
+}
+
+func (w *transcriptPDF) block(block markdown.Block) {
+	switch block.Kind {
+	case markdown.Heading:
+		// Headings inside answers are kept below the transcript's own section headings
+		w.heading(min(block.Level+2, len(pdfHeadingSizes)), markdown.PlainText(block.Spans))
+	case markdown.Paragraph:
+		w.spans(block.Spans)
+		w.pdf.Ln(pdfLineHeight + 1.5)
+	case markdown.ListItem:
+		w.listItem(block)
+	case markdown.Quote:
+		left, _, _, _ := w.pdf.GetMargins()
+		w.pdf.SetLeftMargin(left + pdfListIndent)
+		w.pdf.SetX(left + pdfListIndent)
+		w.pdf.SetTextColor(90, 90, 90)
+		w.spans(block.Spans)
+		w.pdf.SetLeftMargin(left)
+		w.pdf.Ln(pdfLineHeight + 1.5)
+		w.resetStyle()
+	case markdown.CodeBlock:
+		w.pdf.SetFont("Courier", "", 9)
+		w.pdf.SetFillColor(244, 244, 244)
+		w.pdf.MultiCell(0, 4.5, w.tr(block.Code), "", "L", true)
+		w.pdf.Ln(1.5)
+		w.resetStyle()
+	case markdown.Table:
+		w.table(block.Rows)
+	case markdown.Rule:
+		left, _, right, _ := w.pdf.GetMargins()
+		pageWidth, _ := w.pdf.GetPageSize()
+		y := w.pdf.GetY() + 1
+		w.pdf.SetDrawColor(200, 200, 200)
+		w.pdf.Line(left, y, pageWidth-right, y)
+		w.pdf.Ln(3)
+	}
+}
+
+func (w *transcriptPDF) listItem(block markdown.Block) {
+	left, _, _, _ := w.pdf.GetMargins()
+	indent := left + float64(block.Level)*pdfListIndent
+	marker := "-"
+	if block.Ordered {
+		marker = strconv.Itoa(block.Number) + "."
+	}
+
+	w.pdf.SetX(indent)
+	w.pdf.CellFormat(pdfListIndent, pdfLineHeight, marker, "", 0, "L", false, 0, "")
+	// Wrapped lines of the item align with its text, not its marker
+	w.pdf.SetLeftMargin(indent + pdfListIndent)
+	w.spans(block.Spans)
+	w.pdf.SetLeftMargin(left)
+	w.pdf.Ln(pdfLineHeight + 0.5)
+}
+
+// spans writes styled inline text that wraps at the current margins
+func (w *transcriptPDF) spans(spans []markdown.Span) {
+	for _, span := range spans {
+		family, style := "Helvetica", ""
+		if span.Code {
+			family = "Courier"
+		}
+		if span.Bold {
+			style += "B"
+		}
+		if span.Italic {
+			style += "I"
+		}
+		w.pdf.SetFont(family, style, pdfFontSize)
+		w.pdf.Write(pdfLineHeight, w.tr(span.Text))
+	}
+	w.pdf.SetFont("Helvetica", "", pdfFontSize)
+}
+
+func (w *transcriptPDF) table(rows [][][]markdown.Span) {
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	if columns == 0 {
+		return
+	}
+
+	left, _, right, bottom := w.pdf.GetMargins()
+	pageWidth, pageHeight := w.pdf.GetPageSize()
+	cellWidth := (pageWidth - left - right) / float64(columns)
+	const cellPadding, cellLineHeight = 1.0, 4.5
+
+	w.pdf.SetDrawColor(180, 180, 180)
+	for i, row := range rows {
+		style := ""
+		if i == 0 {
+			style = "B"
+		}
+		w.pdf.SetFont("Helvetica", style, 9)
+
+		texts := make([]string, columns)
+		lines := 1
+		for col := range texts {
+			if col < len(row) {
+				texts[col] = w.tr(markdown.PlainText(row[col]))
+			}
+			lines = max(lines, len(w.pdf.SplitLines([]byte(texts[col]), cellWidth-2*cellPadding)))
+		}
+		rowHeight := float64(lines)*cellLineHeight + 2*cellPadding
+
+		if w.pdf.GetY()+rowHeight > pageHeight-bottom {
+			w.pdf.AddPage()
+			w.pdf.SetFont("Helvetica", style, 9)
+		}
+		y := w.pdf.GetY()
+		for col, text := range texts {
+			x := left + float64(col)*cellWidth
+			w.pdf.Rect(x, y, cellWidth, rowHeight, "D")
+			w.pdf.SetXY(x+cellPadding, y+cellPadding)
+			w.pdf.MultiCell(cellWidth-2*cellPadding, cellLineHeight, text, "", "L", false)
+		}
+		w.pdf.SetXY(left, y+rowHeight)
+	}
+	w.pdf.Ln(2)
+	w.resetStyle()
+}
@@ -0,0 +1,414 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/utils/markdown"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// Transcript export formats
+const (
+	ExportFormatMarkdown = "md"
+	ExportFormatPDF      = "pdf"
+	ExportFormatJSON     = "json"
+	ExportFormatHTML     = "html"
+)
+
+// ErrInvalidExportFormat is returned for formats other than md, pdf, json and html
+var ErrInvalidExportFormat = errors.New("invalid export format")
+
+// citationPattern finds references to the session's documents in AI answers
+var citationPattern = regexp.MustCompile(`Document\s+(\d+)`)
+
+// ChatExport is a rendered transcript ready to be downloaded
+type ChatExport struct {
+	Content     []byte
+	ContentType string
+	FileName    string
+}
+
+// Transcript is the persisted timeline of a session with everything the exporters render
+type Transcript struct {
+	Chat      *models.Chat
+	Documents []models.ChatDocument
+	Messages  []TranscriptMessage
+}
+
+type TranscriptMessage struct {
+	models.Message
+	Author    string                // display name of the author of a user message
+	Citations []models.ChatDocument // documents the message refers to as "Document N"
+}
+
+type ChatExportService struct {
+	db          *gorm.DB
+	authorizer  SessionAuthorizer
+	chatService ChatServiceDB
+	logger      zerolog.Logger
+}
+
+func NewChatExportService(db *gorm.DB, authorizer SessionAuthorizer, chatService ChatServiceDB, logger zerolog.Logger) *ChatExportService {
+	return &ChatExportService{
+		db:          db,
+		authorizer:  authorizer,
+		chatService: chatService,
+		logger:      logger,
+	}
+}
+
+// ExportChat renders the transcript of a session the user may access in the given format
+func (s *ChatExportService) ExportChat(ctx context.Context, userID uuid.UUID, sessionID, format string) (*ChatExport, error) {
+	if format == "" {
+		format = ExportFormatMarkdown
+	}
+	if format != ExportFormatMarkdown && format != ExportFormatPDF && format != ExportFormatJSON && format != ExportFormatHTML {
+		return nil, fmt.Errorf("%w: format must be md, pdf, json or html", ErrInvalidExportFormat)
+	}
+
+	transcript, err := s.GetTranscript(ctx, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &ChatExport{FileName: fmt.Sprintf("research-session-%s.%s", sessionID, format)}
+	switch format {
+	case ExportFormatMarkdown:
+		export.ContentType = "text/markdown; charset=utf-8"
+		export.Content = []byte(RenderTranscriptMarkdown(transcript))
+	case ExportFormatJSON:
+		export.ContentType = "application/json"
+		export.Content, err = json.MarshalIndent(transcriptJSON(transcript), "", "  ")
+	case ExportFormatHTML:
+		export.ContentType = "text/html; charset=utf-8"
+		export.Content, err = renderTranscriptHTML(transcript)
+	case ExportFormatPDF:
+		export.ContentType = "application/pdf"
+		export.Content, err = renderTranscriptPDF(transcript)
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to render %s export of session %s", format, sessionID)
+		return nil, fmt.Errorf("failed to render %s export: %w", format, err)
+	}
+	return export, nil
+}
+
+// GetTranscript loads the active branch of a session with authors and citations resolved
+func (s *ChatExportService) GetTranscript(ctx context.Context, userID uuid.UUID, sessionID string) (*Transcript, error) {
+	chat, err := s.authorizer.AuthorizeSession(ctx, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	documents, err := s.chatService.GetChatDocumentsFromDB(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat documents: %w", err)
+	}
+
+	messages, err := s.chatService.GetActiveBranchMessagesFromDB(chat.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat messages: %w", err)
+	}
+
+	authors, err := s.authorNames(ctx, messages)
+	if err != nil {
+		return nil, err
+	}
+
+	transcript := &Transcript{Chat: chat, Documents: documents}
+	for _, msg := range messages {
+		entry := TranscriptMessage{Message: msg}
+		if msg.UserID != nil {
+			entry.Author = authors[*msg.UserID]
+		}
+		if msg.Type == "ai" {
+			entry.Citations = citedDocuments(msg.Content, documents)
+		}
+		transcript.Messages = append(transcript.Messages, entry)
+	}
+	return transcript, nil
+}
+
+func (s *ChatExportService) authorNames(ctx context.Context, messages []models.Message) (map[uuid.UUID]string, error) {
+	var ids []uuid.UUID
+	for _, msg := range messages {
+		if msg.UserID != nil {
+			ids = append(ids, *msg.UserID)
+		}
+	}
+	names := make(map[uuid.UUID]string)
+	if len(ids) == 0 {
+		return names, nil
+	}
+
+	var users []models.User
+	if err := s.db.WithContext(ctx).Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to get message authors: %w", err)
+	}
+	for _, user := range users {
+		name := user.Name
+		if name == "" {
+			name = user.Email
+		}
+		names[user.ID] = name
+	}
+	return names, nil
+}
+
+// citedDocuments returns the session documents referred to in content, in citation order
+func citedDocuments(content string, documents []models.ChatDocument) []models.ChatDocument {
+	byPosition := make(map[int]models.ChatDocument, len(documents))
+	for _, doc := range documents {
+		byPosition[doc.Position] = doc
+	}
+	var cited []models.ChatDocument
+	seen := make(map[int]bool)
+	for _, match := range citationPattern.FindAllStringSubmatch(content, -1) {
+		position, _ := strconv.Atoi(match[1])
+		if doc, ok := byPosition[position]; ok && !seen[position] {
+			seen[position] = true
+			cited = append(cited, doc)
+		}
+	}
+	return cited
+}
+
+// speaker is the label of a message in rendered transcripts
+func (m TranscriptMessage) speaker() string {
+	if m.Type == "ai" {
+		return "AI"
+	}
+	if m.Author != "" {
+		return m.Author
+	}
+	return "User"
+}
+
+func documentLabel(doc models.ChatDocument) string {
+	label := fmt.Sprintf("Document %d: %s", doc.Position, doc.Title)
+	if doc.ArxivID != "" {
+		label += fmt.Sprintf(" (arXiv %s)", doc.ArxivID)
+	}
+	return label
+}
+
+// transcriptTitle is the session's title, or a generic heading for sessions without one
+func transcriptTitle(chat *models.Chat) string {
+	if chat.Title != "" {
+		return chat.Title
+	}
+	return "Research session"
+}
+
+func transcriptUsageLines(chat *models.Chat) []string {
+	lines := []string{
+		"Session: " + chat.SessionID,
+		"Started: " + chat.CreatedAt.Format(time.RFC3339),
+		"Price tier: " + chat.PriceTier,
+	}
+	if !chat.TerminationTime.IsZero() {
+		lines = append(lines,
+			"Ended: "+chat.TerminationTime.Format(time.RFC3339),
+			fmt.Sprintf("Duration: %s", (time.Duration(chat.ChatDuration)*time.Second).String()),
+			fmt.Sprintf("Tokens cached: %d", chat.TokenCountUsed),
+			fmt.Sprintf("Token-hours used: %.6f", chat.TokenHoursUsed),
+		)
+	}
+	return lines
+}
+
+// RenderTranscriptMarkdown renders a transcript as a Markdown document. AI answers are
+// already Markdown and are included verbatim.
+func RenderTranscriptMarkdown(t *Transcript) string {
+	var b strings.Builder
+	b.WriteString("# " + transcriptTitle(t.Chat) + "\n\n")
+	if t.Chat.Summary != "" {
+		b.WriteString(t.Chat.Summary + "\n\n")
+	}
+	for _, line := range transcriptUsageLines(t.Chat) {
+		b.WriteString("- " + line + "\n")
+	}
+
+	if len(t.Documents) > 0 {
+		b.WriteString("\n## Documents\n\n")
+		for _, doc := range t.Documents {
+			b.WriteString("- " + documentLabel(doc) + "\n")
+		}
+	}
+
+	b.WriteString("\n## Transcript\n")
+	for _, msg := range t.Messages {
+		fmt.Fprintf(&b, "\n### %s · %s\n\n", msg.speaker(), msg.Timestamp.Format(time.RFC3339))
+		b.WriteString(strings.TrimSpace(msg.Content) + "\n")
+		if len(msg.Citations) > 0 {
+			b.WriteString("\n**Cited:**\n\n")
+			for _, doc := range msg.Citations {
+				b.WriteString("- " + documentLabel(doc) + "\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+type transcriptDocumentJSON struct {
+	Position   int    `json:"position"`
+	Source     string `json:"source"`
+	ArxivID    string `json:"arxiv_id,omitempty"`
+	DocumentID uint   `json:"document_id,omitempty"`
+	Title      string `json:"title"`
+}
+
+type transcriptMessageJSON struct {
+	Type      string     `json:"type"`
+	Content   string     `json:"content"`
+	Timestamp string     `json:"timestamp"`
+	UserID    *uuid.UUID `json:"user_id,omitempty"`
+	Author    string     `json:"author,omitempty"`
+	Citations []int      `json:"citations,omitempty"` // positions of the cited documents
+}
+
+type transcriptJSONDocument struct {
+	SessionID       string  `json:"session_id"`
+	Title           string  `json:"title"`
+	Summary         string  `json:"summary"`
+	CreatedAt       string  `json:"created_at"`
+	TerminationTime string  `json:"termination_time,omitempty"`
+	PriceTier       string  `json:"price_tier"`
+	ChatDuration    float64 `json:"chat_duration"`
+	TokenCountUsed  int32   `json:"token_count_used"`
+	TokenHoursUsed  float64 `json:"token_hours_used"`
+	// The answer style and generation settings the session ended with, for reproducibility
+	SystemInstruction string                   `json:"system_instruction,omitempty"`
+	Generation        models.GenerationParams  `json:"generation"`
+	Documents         []transcriptDocumentJSON `json:"documents"`
+	Messages          []transcriptMessageJSON  `json:"messages"`
+}
+
+func transcriptJSON(t *Transcript) transcriptJSONDocument {
+	doc := transcriptJSONDocument{
+		SessionID:         t.Chat.SessionID,
+		Title:             t.Chat.Title,
+		Summary:           t.Chat.Summary,
+		CreatedAt:         t.Chat.CreatedAt.Format(time.RFC3339),
+		PriceTier:         t.Chat.PriceTier,
+		ChatDuration:      t.Chat.ChatDuration,
+		TokenCountUsed:    t.Chat.TokenCountUsed,
+		TokenHoursUsed:    t.Chat.TokenHoursUsed,
+		SystemInstruction: t.Chat.SystemInstruction,
+		Generation:        t.Chat.Generation,
+		Documents:         []transcriptDocumentJSON{},
+		Messages:          []transcriptMessageJSON{},
+	}
+	if !t.Chat.TerminationTime.IsZero() {
+		doc.TerminationTime = t.Chat.TerminationTime.Format(time.RFC3339)
+	}
+	for _, d := range t.Documents {
+		doc.Documents = append(doc.Documents, transcriptDocumentJSON{
+			Position:   d.Position,
+			Source:     d.Source,
+			ArxivID:    d.ArxivID,
+			DocumentID: d.DocumentID,
+			Title:      d.Title,
+		})
+	}
+	for _, msg := range t.Messages {
+		entry := transcriptMessageJSON{
+			Type:      msg.Type,
+			Content:   msg.Content,
+			Timestamp: msg.Timestamp.Format(time.RFC3339),
+			UserID:    msg.UserID,
+			Author:    msg.Author,
+		}
+		for _, cited := range msg.Citations {
+			entry.Citations = append(entry.Citations, cited.Position)
+		}
+		doc.Messages = append(doc.Messages, entry)
+	}
+	return doc
+}
+
+var transcriptHTMLTemplate = template.Must(template.New("transcript").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; max-width: 48rem; margin: 2rem auto; padding: 0 1rem; line-height: 1.5; color: #222; }
+.meta { color: #555; font-size: 0.9rem; }
+.message { border-top: 1px solid #ddd; padding: 0.5rem 0; }
+.message h3 { font-size: 0.95rem; margin: 0.5rem 0; }
+.message.ai h3 { color: #1a5fb4; }
+.cited { font-size: 0.85rem; color: #555; }
+pre { background: #f4f4f4; padding: 0.75rem; overflow-x: auto; }
+code { font-family: Menlo, Consolas, monospace; font-size: 0.9em; }
+blockquote { border-left: 3px solid #ccc; margin: 0; padding-left: 1rem; color: #555; }
+table { border-collapse: collapse; } th, td { border: 1px solid #ccc; padding: 0.25rem 0.5rem; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Chat.Summary}}<p>{{.Chat.Summary}}</p>{{end}}
+<ul class="meta">{{range .Usage}}<li>{{.}}</li>{{end}}</ul>
+{{if .Documents}}<h2>Documents</h2>
+<ul>{{range .Documents}}<li>{{.}}</li>{{end}}</ul>{{end}}
+<h2>Transcript</h2>
+{{range .Messages}}<div class="message {{.Type}}">
+<h3>{{.Speaker}} · <time>{{.Timestamp}}</time></h3>
+{{.Body}}
+{{if .Citations}}<div class="cited">Cited: {{range $i, $c := .Citations}}{{if $i}}; {{end}}{{$c}}{{end}}</div>{{end}}
+</div>
+{{end}}</body>
+</html>
+`))
+
+func renderTranscriptHTML(t *Transcript) ([]byte, error) {
+	type htmlMessage struct {
+		Type      string
+		Speaker   string
+		Timestamp string
+		Body      template.HTML
+		Citations []string
+	}
+	data := struct {
+		Chat      *models.Chat
+		Title     string
+		Usage     []string
+		Documents []string
+		Messages  []htmlMessage
+	}{Chat: t.Chat, Title: transcriptTitle(t.Chat), Usage: transcriptUsageLines(t.Chat)}
+
+	for _, doc := range t.Documents {
+		data.Documents = append(data.Documents, documentLabel(doc))
+	}
+	for _, msg := range t.Messages {
+		entry := htmlMessage{
+			Type:      msg.Type,
+			Speaker:   msg.speaker(),
+			Timestamp: msg.Timestamp.Format(time.RFC3339),
+			// RenderHTML escapes all text, so the result is safe to embed
+			Body: template.HTML(markdown.RenderHTML(markdown.Parse(msg.Content))),
+		}
+		for _, doc := range msg.Citations {
+			entry.Citations = append(entry.Citations, documentLabel(doc))
+		}
+		data.Messages = append(data.Messages, entry)
+	}
+
+	var buf bytes.Buffer
+	if err := transcriptHTMLTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
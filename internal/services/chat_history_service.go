@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidCursor is returned for pagination cursors that were not issued by this service
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// firstPromptLength caps the preview of a session's first prompt in history listings
+const firstPromptLength = 200
+
+// ChatHistoryFilter narrows and pages a user's chat history. Zero values do not filter.
+type ChatHistoryFilter struct {
+	PriceTier string
+	From      time.Time // sessions created at or after
+	To        time.Time // sessions created before
+	ProjectID *uint
+	Cursor    string // from the previous page's NextCursor; empty for the first page
+	Limit     int
+}
+
+// ChatDocumentSummary identifies a document loaded into a session
+type ChatDocumentSummary struct {
+	Position   int      `json:"position"`
+	Source     string   `json:"source"`
+	ArxivID    string   `json:"arxiv_id,omitempty"`
+	DocumentID uint     `json:"document_id,omitempty"`
+	Title      string   `json:"title"`
+	Trims      []string `json:"trims,omitempty"` // what was cut to fit the session's token budget
+	Figures    int      `json:"figures,omitempty"`
+}
+
+// ChatSummary is a session as shown in history listings, without message bodies
+type ChatSummary struct {
+	ChatID          uint                  `json:"-"`
+	SessionID       string                `json:"session_id"`
+	Title           string                `json:"title"`
+	Summary         string                `json:"summary"`
+	ProjectID       *uint                 `json:"project_id"`
+	WorkspaceID     *uint                 `json:"workspace_id"`
+	CreatedAt       time.Time             `json:"created_at"`
+	PriceTier       string                `json:"price_tier"`
+	ChatDuration    float64               `json:"chat_duration"`
+	TokenCountUsed  int32                 `json:"token_count_used"`
+	TokenHoursUsed  float64               `json:"token_hours_used"`
+	TerminationTime time.Time             `json:"termination_time"`
+	FirstPrompt     string                `json:"first_prompt"`
+	MessageCount    int64                 `json:"message_count"`
+	Documents       []ChatDocumentSummary `json:"documents" gorm:"-"`
+}
+
+// ChatHistoryPage is one page of a chat history listing; NextCursor is empty on the last page
+type ChatHistoryPage struct {
+	Chats      []ChatSummary `json:"chats"`
+	NextCursor string        `json:"next_cursor"`
+}
+
+// MessagePage is one page of a session's messages in chronological order
+type MessagePage struct {
+	Messages   []models.Message
+	NextCursor string
+}
+
+type ChatHistoryService struct {
+	db         *gorm.DB
+	authorizer SessionAuthorizer
+	logger     zerolog.Logger
+}
+
+func NewChatHistoryService(db *gorm.DB, authorizer SessionAuthorizer, logger zerolog.Logger) *ChatHistoryService {
+	return &ChatHistoryService{
+		db:         db,
+		authorizer: authorizer,
+		logger:     logger,
+	}
+}
+
+// ListChats returns a page of the user's sessions, most recent first
+func (s *ChatHistoryService) ListChats(ctx context.Context, userID uuid.UUID, filter ChatHistoryFilter) (*ChatHistoryPage, error) {
+	query := s.db.WithContext(ctx).Model(&models.Chat{}).Where("user_id = ?", userID)
+	if filter.PriceTier != "" {
+		query = query.Where("price_tier = ?", filter.PriceTier)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.ProjectID != nil {
+		query = query.Where("project_id = ?", *filter.ProjectID)
+	}
+	if filter.Cursor != "" {
+		createdAt, id, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("(created_at, id) < (?, ?)", createdAt, id)
+	}
+
+	var chats []ChatSummary
+	err := query.
+		Select(`id AS chat_id, session_id, title, summary, project_id, workspace_id, created_at, price_tier,
+			chat_duration, token_count_used, token_hours_used, termination_time,
+			(SELECT count(*) FROM messages WHERE messages.chat_id = chats.id AND messages.deleted_at IS NULL) AS message_count,
+			coalesce((SELECT left(messages.content, ?) FROM messages
+				WHERE messages.chat_id = chats.id AND messages.type = 'user' AND messages.deleted_at IS NULL
+				ORDER BY messages.timestamp LIMIT 1), '') AS first_prompt`, firstPromptLength).
+		Order("created_at DESC, id DESC").
+		Limit(filter.Limit + 1).
+		Scan(&chats).Error
+	if err != nil {
+		s.logger.Error().Err(err).Str("userID", userID.String()).Msg("Failed to list chat history")
+		return nil, fmt.Errorf("failed to list chat history: %w", err)
+	}
+
+	page := &ChatHistoryPage{Chats: chats}
+	if len(chats) > filter.Limit {
+		page.Chats = chats[:filter.Limit]
+		last := page.Chats[len(page.Chats)-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ChatID)
+	}
+
+	chatIDs := make([]uint, len(page.Chats))
+	for i, chat := range page.Chats {
+		chatIDs[i] = chat.ChatID
+	}
+	documents, err := chatDocumentSummaries(ctx, s.db, chatIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range page.Chats {
+		page.Chats[i].Documents = documents[page.Chats[i].ChatID]
+	}
+	if page.Chats == nil {
+		page.Chats = []ChatSummary{}
+	}
+	return page, nil
+}
+
+// ListMessages returns a page of the messages of a session the user may access, oldest first
+func (s *ChatHistoryService) ListMessages(ctx context.Context, userID uuid.UUID, sessionID, cursor string, limit int) (*MessagePage, error) {
+	chat, err := s.authorizer.AuthorizeSession(ctx, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := s.db.WithContext(ctx).Where("chat_id = ?", chat.ID)
+	if cursor != "" {
+		timestamp, id, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("(timestamp, id) > (?, ?)", timestamp, id)
+	}
+
+	var messages []models.Message
+	if err := query.Order("timestamp ASC, id ASC").Limit(limit + 1).Find(&messages).Error; err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to list messages of session %s", sessionID)
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+
+	page := &MessagePage{Messages: messages}
+	if len(messages) > limit {
+		page.Messages = messages[:limit]
+		last := page.Messages[len(page.Messages)-1]
+		page.NextCursor = encodeCursor(last.Timestamp, last.ID)
+	}
+	return page, nil
+}
+
+// chatDocumentSummaries loads the documents of several sessions at once, keyed by chat ID.
+// Every requested chat gets a non-nil list.
+func chatDocumentSummaries(ctx context.Context, db *gorm.DB, chatIDs []uint) (map[uint][]ChatDocumentSummary, error) {
+	byChat := make(map[uint][]ChatDocumentSummary, len(chatIDs))
+	if len(chatIDs) == 0 {
+		return byChat, nil
+	}
+	for _, id := range chatIDs {
+		byChat[id] = []ChatDocumentSummary{}
+	}
+
+	var documents []models.ChatDocument
+	err := db.WithContext(ctx).Where("chat_id IN ?", chatIDs).Order("chat_id, position").Find(&documents).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session documents: %w", err)
+	}
+	for _, doc := range documents {
+		byChat[doc.ChatID] = append(byChat[doc.ChatID], ChatDocumentSummary{
+			Position:   doc.Position,
+			Source:     doc.Source,
+			ArxivID:    doc.ArxivID,
+			DocumentID: doc.DocumentID,
+			Title:      doc.Title,
+			Trims:      doc.Trims,
+			Figures:    doc.Figures,
+		})
+	}
+	return byChat, nil
+}
+
+// Cursors encode the sort key of the last item of a page, opaque to clients
+func encodeCursor(t time.Time, id uint) string {
+	raw := t.UTC().Format(time.RFC3339Nano) + "|" + strconv.FormatUint(uint64(id), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (time.Time, uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	timestamp, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	parsedID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	return t, uint(parsedID), nil
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		t    time.Time
+		id   uint
+	}{
+		{"whole seconds", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), 1},
+		{"nanoseconds", time.Date(2024, 3, 1, 12, 0, 0, 123456789, time.UTC), 42},
+		{"another time zone", time.Date(2024, 3, 1, 12, 0, 0, 500, time.FixedZone("CET", 3600)), 7},
+		{"largest ID", time.Date(1999, 12, 31, 23, 59, 59, 0, time.UTC), ^uint(0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor := encodeCursor(tt.t, tt.id)
+			assert.NotContains(t, cursor, "|", "cursors are opaque")
+			decoded, id, err := decodeCursor(cursor)
+			require.NoError(t, err)
+			assert.True(t, tt.t.Equal(decoded), "got %v", decoded)
+			assert.Equal(t, tt.id, id)
+		})
+	}
+}
+
+func TestDecodeCursorRejectsForgedCursors(t *testing.T) {
+	encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "not a cursor!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte("2024-03-01T12:00:00Z|1"))},
+		{"no separator", encode("2024-03-01T12:00:00Z")},
+		{"bad timestamp", encode("yesterday|1")},
+		{"missing ID", encode("2024-03-01T12:00:00Z|")},
+		{"negative ID", encode("2024-03-01T12:00:00Z|-1")},
+		{"ID out of range", encode("2024-03-01T12:00:00Z|18446744073709551616")},
+		{"trailing garbage", encode("2024-03-01T12:00:00Z|1|2")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := decodeCursor(tt.cursor)
+			assert.True(t, errors.Is(err, ErrInvalidCursor), "got %v", err)
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// Markers ts_headline puts around matched words. Control characters never occur in
+// chat messages, so the snippet can be split on them safely.
+const (
+	highlightStart = "\x02"
+	highlightStop  = "\x03"
+)
+
+const snippetOptions = "StartSel=" + highlightStart + ", StopSel=" + highlightStop +
+	", MaxWords=35, MinWords=15, MaxFragments=2, FragmentDelimiter=\" … \""
+
+// promptContextLength caps how much of the prompt behind a matched answer is returned
+const promptContextLength = 300
+
+// ChatSearchParams narrows a chat search. Zero values do not filter.
+type ChatSearchParams struct {
+	Query      string
+	From       time.Time // messages at or after
+	To         time.Time // messages before
+	PriceTier  string
+	ArxivID    string // sessions that loaded this arXiv paper
+	DocumentID uint   // sessions that loaded this library document
+	Limit      int
+	Offset     int
+}
+
+// SnippetFragment is a piece of a search snippet; Match marks the words that matched the query
+type SnippetFragment struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match"`
+}
+
+// ChatSearchResult is a matching message with the session it belongs to
+type ChatSearchResult struct {
+	MessageID        uint                  `json:"message_id"`
+	Type             string                `json:"type"`
+	Timestamp        time.Time             `json:"timestamp"`
+	Rank             float64               `json:"rank"`
+	Snippet          []SnippetFragment     `json:"snippet"`
+	SessionID        string                `json:"session_id"`
+	SessionTitle     string                `json:"session_title"`
+	SessionSummary   string                `json:"session_summary"`
+	SessionCreatedAt time.Time             `json:"session_created_at"`
+	PriceTier        string                `json:"price_tier"`
+	Prompt           string                `json:"prompt,omitempty"` // the user message an AI answer replied to
+	Documents        []ChatDocumentSummary `json:"documents"`
+}
+
+type chatSearchRow struct {
+	MessageID        uint
+	Type             string
+	Timestamp        time.Time
+	Rank             float64
+	Headline         string
+	ChatID           uint
+	SessionID        string
+	SessionTitle     string
+	SessionSummary   string
+	SessionCreatedAt time.Time
+	PriceTier        string
+	Prompt           string
+}
+
+type ChatSearchService struct {
+	db     *gorm.DB
+	logger zerolog.Logger
+}
+
+func NewChatSearchService(db *gorm.DB, logger zerolog.Logger) *ChatSearchService {
+	return &ChatSearchService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Search runs a ranked full-text search over the messages of the user's chats
+func (s *ChatSearchService) Search(ctx context.Context, userID uuid.UUID, params ChatSearchParams) ([]ChatSearchResult, int64, error) {
+	s.logger.Info().Str("userID", userID.String()).Str("query", params.Query).Msg("Searching chat history")
+
+	base := s.db.WithContext(ctx).Table("messages").
+		Joins("JOIN chats ON chats.id = messages.chat_id AND chats.deleted_at IS NULL").
+		Where("messages.deleted_at IS NULL").
+		Where("chats.user_id = ?", userID).
+		Where("messages.search_vector @@ websearch_to_tsquery('english', ?)", params.Query)
+	if !params.From.IsZero() {
+		base = base.Where("messages.timestamp >= ?", params.From)
+	}
+	if !params.To.IsZero() {
+		base = base.Where("messages.timestamp < ?", params.To)
+	}
+	if params.PriceTier != "" {
+		base = base.Where("chats.price_tier = ?", params.PriceTier)
+	}
+	if params.ArxivID != "" {
+		base = base.Where("EXISTS (SELECT 1 FROM chat_documents WHERE chat_documents.chat_id = chats.id AND chat_documents.deleted_at IS NULL AND chat_documents.arxiv_id = ?)", params.ArxivID)
+	}
+	if params.DocumentID != 0 {
+		base = base.Where("EXISTS (SELECT 1 FROM chat_documents WHERE chat_documents.chat_id = chats.id AND chat_documents.deleted_at IS NULL AND chat_documents.document_id = ?)", params.DocumentID)
+	}
+	base = base.Session(&gorm.Session{})
+
+	var total int64
+	if err := base.Count(&total).Error; err != nil {
+		s.logger.Error().Err(err).Msg("Failed to count chat search results")
+		return nil, 0, fmt.Errorf("failed to count chat search results: %w", err)
+	}
+
+	var rows []chatSearchRow
+	err := base.
+		Select(`messages.id AS message_id, messages.type, messages.timestamp,
+			ts_rank(messages.search_vector, websearch_to_tsquery('english', ?)) AS rank,
+			ts_headline('english', messages.content, websearch_to_tsquery('english', ?), ?) AS headline,
+			chats.id AS chat_id, chats.session_id, chats.title AS session_title, chats.summary AS session_summary, chats.created_at AS session_created_at, chats.price_tier,
+			coalesce(left(prompt.content, ?), '') AS prompt`,
+			params.Query, params.Query, snippetOptions, promptContextLength).
+		// An answer replies to the prompt it follows in its branch, not the latest one before it
+		Joins(`LEFT JOIN messages prompt ON messages.type = 'ai' AND prompt.id = messages.parent_id
+			AND prompt.type = 'user' AND prompt.deleted_at IS NULL`).
+		Order("rank DESC, messages.timestamp DESC").
+		Limit(params.Limit).
+		Offset(params.Offset).
+		Scan(&rows).Error
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to search chat history")
+		return nil, 0, fmt.Errorf("failed to search chat history: %w", err)
+	}
+
+	chatIDs := make([]uint, len(rows))
+	for i, row := range rows {
+		chatIDs[i] = row.ChatID
+	}
+	documents, err := chatDocumentSummaries(ctx, s.db, chatIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	results := make([]ChatSearchResult, len(rows))
+	for i, row := range rows {
+		results[i] = ChatSearchResult{
+			MessageID:        row.MessageID,
+			Type:             row.Type,
+			Timestamp:        row.Timestamp,
+			Rank:             row.Rank,
+			Snippet:          parseHeadline(row.Headline),
+			SessionID:        row.SessionID,
+			SessionTitle:     row.SessionTitle,
+			SessionSummary:   row.SessionSummary,
+			SessionCreatedAt: row.SessionCreatedAt,
+			PriceTier:        row.PriceTier,
+			Prompt:           row.Prompt,
+			Documents:        documents[row.ChatID],
+		}
+	}
+	return results, total, nil
+}
+
+// parseHeadline splits a ts_headline result into plain and highlighted fragments
+func parseHeadline(headline string) []SnippetFragment {
+	var fragments []SnippetFragment
+	for {
+		start := strings.Index(headline, highlightStart)
+		if start < 0 {
+			break
+		}
+		stop := strings.Index(headline[start:], highlightStop)
+		if stop < 0 {
+			break
+		}
+		if start > 0 {
+			fragments = append(fragments, SnippetFragment{Text: headline[:start]})
+		}
+		fragments = append(fragments, SnippetFragment{Text: headline[start+len(highlightStart) : start+stop], Match: true})
+		headline = headline[start+stop+len(highlightStop):]
+	}
+	if headline != "" {
+		fragments = append(fragments, SnippetFragment{Text: headline})
+	}
+	return fragments
+}
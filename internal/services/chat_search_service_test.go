@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHeadline(t *testing.T) {
+	tests := []struct {
+		name      string
+		headline  string
+		fragments []SnippetFragment
+	}{
+		{"no match", "plain text", []SnippetFragment{{Text: "plain text"}}},
+		{"empty", "", nil},
+		{"match in the middle", "the \x02attention\x03 layer", []SnippetFragment{
+			{Text: "the "}, {Text: "attention", Match: true}, {Text: " layer"},
+		}},
+		{"matches at both ends", "\x02graph\x03 and \x02attention\x03", []SnippetFragment{
+			{Text: "graph", Match: true}, {Text: " and "}, {Text: "attention", Match: true},
+		}},
+		{"unterminated match", "the \x02attention layer", []SnippetFragment{{Text: "the \x02attention layer"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.fragments, parseHeadline(tt.headline))
+		})
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	db := openTestDB(t)
+	chatDB := NewChatServiceDB(db)
+	search := NewChatSearchService(db, zerolog.Nop())
+	user, other := createTestUser(t, db), createTestUser(t, db)
+
+	recent, recentMessages := createTestChat(t, chatDB, user.ID,
+		"how does attention scale", "attention scales quadratically with the sequence")
+	require.NoError(t, chatDB.SaveChatDocumentsToDB(recent, []models.ChatDocument{{Position: 1, Source: "arxiv", ArxivID: "1706.03762"}}))
+	old, oldMessages := createTestChat(t, chatDB, user.ID,
+		"attention in graph networks", "graph attention networks weigh their neighbours")
+	require.NoError(t, chatDB.SaveChatDocumentsToDB(old, []models.ChatDocument{{Position: 1, Source: "upload", DocumentID: 9}}))
+	require.NoError(t, db.Model(&models.Chat{}).Where("session_id = ?", old).Update("price_tier", "pro").Error)
+	monthAgo := time.Now().AddDate(0, -1, 0)
+	for _, message := range oldMessages {
+		require.NoError(t, db.Model(message).Update("timestamp", monthAgo).Error)
+	}
+	createTestChat(t, chatDB, other.ID, "attention please")
+
+	contents := make(map[uint]string)
+	for _, message := range append(recentMessages, oldMessages...) {
+		contents[message.ID] = message.Content
+	}
+	weekAgo := time.Now().AddDate(0, 0, -7)
+
+	tests := []struct {
+		name    string
+		params  ChatSearchParams
+		matches []string
+		total   int64
+	}{
+		{"only the user's chats", ChatSearchParams{Query: "attention"}, []string{
+			"how does attention scale", "attention scales quadratically with the sequence",
+			"attention in graph networks", "graph attention networks weigh their neighbours",
+		}, 4},
+		{"stemmed words", ChatSearchParams{Query: "scaling"}, []string{
+			"how does attention scale", "attention scales quadratically with the sequence",
+		}, 2},
+		{"excluded words", ChatSearchParams{Query: "graph -neighbours"}, []string{"attention in graph networks"}, 1},
+		{"from", ChatSearchParams{Query: "attention", From: weekAgo}, []string{
+			"how does attention scale", "attention scales quadratically with the sequence",
+		}, 2},
+		{"to", ChatSearchParams{Query: "attention", To: weekAgo}, []string{
+			"attention in graph networks", "graph attention networks weigh their neighbours",
+		}, 2},
+		{"price tier", ChatSearchParams{Query: "attention", PriceTier: "pro"}, []string{
+			"attention in graph networks", "graph attention networks weigh their neighbours",
+		}, 2},
+		{"arXiv paper", ChatSearchParams{Query: "attention", ArxivID: "1706.03762"}, []string{
+			"how does attention scale", "attention scales quadratically with the sequence",
+		}, 2},
+		{"library document", ChatSearchParams{Query: "attention", DocumentID: 9}, []string{
+			"attention in graph networks", "graph attention networks weigh their neighbours",
+		}, 2},
+		{"no match", ChatSearchParams{Query: "convolution"}, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.params.Limit = 20
+			results, total, err := search.Search(context.Background(), user.ID, tt.params)
+			require.NoError(t, err)
+			assert.Equal(t, tt.total, total)
+			var matches []string
+			for _, result := range results {
+				matches = append(matches, contents[result.MessageID])
+			}
+			assert.ElementsMatch(t, tt.matches, matches)
+		})
+	}
+
+	// The total counts every match, the page only some
+	results, total, err := search.Search(context.Background(), user.ID, ChatSearchParams{Query: "attention", Limit: 1, Offset: 1})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), total)
+	assert.Len(t, results, 1)
+
+	// Answers come with the prompt they replied to and highlight the matched words
+	results, _, err = search.Search(context.Background(), user.ID, ChatSearchParams{Query: "quadratically", Limit: 20})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "how does attention scale", results[0].Prompt)
+	assert.Contains(t, results[0].Snippet, SnippetFragment{Text: "quadratically", Match: true})
+	require.Len(t, results[0].Documents, 1)
+}
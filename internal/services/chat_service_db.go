@@ -1,6 +1,7 @@
 package services
 
 import (
+	"errors"
 	"log"
 	"nexus_scholar_go_backend/internal/models"
 	"time"
@@ -13,13 +14,27 @@ import (
 // ChatService defines the interface for chat-related operations
 type ChatServiceDB interface {
 	SaveChatToDB(userID uuid.UUID, sessionID string) error
-	SaveMessageToDB(sessionID, msgType, content string) error
+	SaveMessageToDB(sessionID, msgType, content string, authorID *uuid.UUID) (*models.Message, error)
 	GetChatBySessionIDFromDB(sessionID string) (*models.Chat, error)
 	GetChatsByUserIDFromDB(userID uuid.UUID) ([]models.Chat, error)
 	DeleteChatBySessionIDFromDB(sessionID string) error
 	GetMessagesByChatIDFromDB(chatID uint) ([]models.Message, error)
 	UpdateChatMetrics(sessionID string, chatDuration float64, tokenCountUsed int32, priceTier string, tokenHoursUsed float64, terminationTime time.Time) error
 	GetHistoricalChatMetricsByUserID(userID uuid.UUID, log zerolog.Logger) ([]models.Chat, error)
+	SaveChatDocumentsToDB(sessionID string, documents []models.ChatDocument) error
+	GetChatDocumentsFromDB(sessionID string) ([]models.ChatDocument, error)
+	SetChatProjectDB(sessionID string, projectID *uint) error
+	GetChatsByProjectIDFromDB(projectID uint) ([]models.Chat, error)
+	SetChatWorkspaceDB(sessionID string, workspaceID uint) error
+	SetChatInstructionDB(sessionID, presetName, instruction string) error
+	SetChatGenerationDB(sessionID string, params models.GenerationParams) error
+	GetChatsByWorkspaceIDFromDB(workspaceID uint) ([]models.Chat, error)
+	GetMessageFromDB(sessionID string, messageID uint) (*models.Message, error)
+	GetMessagePathFromDB(messageID *uint) ([]models.Message, error)
+	GetActiveBranchMessagesFromDB(sessionID string) ([]models.Message, error)
+	CreateBranchDB(sessionID string, forkedFromID *uint, reason string) (*models.ChatBranch, error)
+	SetActiveBranchDB(sessionID string, branchID uint) error
+	GetBranchesFromDB(sessionID string) ([]models.ChatBranch, error)
 }
 
 // DefaultChatService implements ChatService
@@ -32,29 +47,74 @@ func NewChatServiceDB(db *gorm.DB) ChatServiceDB {
 	return &DefaultChatService{db: db}
 }
 
-// SaveChat creates a new chat session or updates an existing one
+// SaveChat creates a new chat session with its main branch, or updates an existing one
 func (s *DefaultChatService) SaveChatToDB(userID uuid.UUID, sessionID string) error {
-	chat := &models.Chat{
-		UserID:    userID,
-		SessionID: sessionID,
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		chat := &models.Chat{
+			UserID:    userID,
+			SessionID: sessionID,
+		}
+		if err := tx.Where(models.Chat{SessionID: sessionID}).Assign(chat).FirstOrCreate(chat).Error; err != nil {
+			return err
+		}
+		if chat.ActiveBranchID != nil {
+			return nil
+		}
+		branch := &models.ChatBranch{ChatID: chat.ID, Reason: "main"}
+		if err := tx.Create(branch).Error; err != nil {
+			return err
+		}
+		return tx.Model(chat).Update("active_branch_id", branch.ID).Error
+	})
+}
+
+// SaveMessage appends a new message to the active branch of a chat, attributed to authorID if set
+func (s *DefaultChatService) SaveMessageToDB(sessionID, msgType, content string, authorID *uuid.UUID) (*models.Message, error) {
+	var message *models.Message
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var chat models.Chat
+		if err := tx.Where("session_id = ?", sessionID).First(&chat).Error; err != nil {
+			return err
+		}
+		message = &models.Message{
+			ChatID:    chat.ID,
+			UserID:    authorID,
+			Type:      msgType,
+			Content:   content,
+			Timestamp: time.Now(),
+			BranchID:  chat.ActiveBranchID,
+		}
+		if chat.ActiveBranchID != nil {
+			tip, err := branchTip(tx, *chat.ActiveBranchID)
+			if err != nil {
+				return err
+			}
+			message.ParentID = tip
+		}
+		return tx.Create(message).Error
+	})
+	if err != nil {
+		return nil, err
 	}
-	result := s.db.Where(models.Chat{SessionID: sessionID}).Assign(chat).FirstOrCreate(chat)
-	return result.Error
+	return message, nil
 }
 
-// SaveMessage adds a new message to an existing chat
-func (s *DefaultChatService) SaveMessageToDB(sessionID, msgType, content string) error {
-	var chat models.Chat
-	if err := s.db.Where("session_id = ?", sessionID).First(&chat).Error; err != nil {
-		return err
+// branchTip returns the last message of a branch, which is the message it was forked from
+// while the branch is still empty
+func branchTip(tx *gorm.DB, branchID uint) (*uint, error) {
+	var message models.Message
+	err := tx.Where("branch_id = ?", branchID).Order("timestamp desc, id desc").First(&message).Error
+	if err == nil {
+		return &message.ID, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
 	}
-	message := &models.Message{
-		ChatID:    chat.ID,
-		Type:      msgType,
-		Content:   content,
-		Timestamp: time.Now(),
+	var branch models.ChatBranch
+	if err := tx.First(&branch, branchID).Error; err != nil {
+		return nil, err
 	}
-	return s.db.Create(message).Error
+	return branch.ForkedFromID, nil
 }
 
 // GetChatBySessionID retrieves a chat and its messages by session ID
@@ -142,3 +202,196 @@ func (s *DefaultChatService) GetHistoricalChatMetricsByUserID(userID uuid.UUID,
 	log.Info().Str("userID", userID.String()).Int("chatCount", len(chats)).Msg("Successfully retrieved historical chat metrics")
 	return chats, nil
 }
+
+// SaveChatDocumentsToDB records the documents that make up a session's corpus
+func (s *DefaultChatService) SaveChatDocumentsToDB(sessionID string, documents []models.ChatDocument) error {
+	if len(documents) == 0 {
+		return nil
+	}
+	var chat models.Chat
+	if err := s.db.Where("session_id = ?", sessionID).First(&chat).Error; err != nil {
+		return err
+	}
+	for i := range documents {
+		documents[i].ChatID = chat.ID
+	}
+	return s.db.Create(&documents).Error
+}
+
+// GetChatDocumentsFromDB retrieves the documents of a session ordered by their position in the corpus
+func (s *DefaultChatService) GetChatDocumentsFromDB(sessionID string) ([]models.ChatDocument, error) {
+	var documents []models.ChatDocument
+	result := s.db.Joins("JOIN chats ON chats.id = chat_documents.chat_id").
+		Where("chats.session_id = ?", sessionID).
+		Order("chat_documents.position asc").
+		Find(&documents)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return documents, nil
+}
+
+// SetChatProjectDB files a chat under a project, or removes it from its project when projectID is nil
+func (s *DefaultChatService) SetChatProjectDB(sessionID string, projectID *uint) error {
+	result := s.db.Model(&models.Chat{}).Where("session_id = ?", sessionID).Update("project_id", projectID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// SetChatInstructionDB records the answer style a chat's cache was created with
+func (s *DefaultChatService) SetChatInstructionDB(sessionID, presetName, instruction string) error {
+	result := s.db.Model(&models.Chat{}).Where("session_id = ?", sessionID).Updates(map[string]interface{}{
+		"instruction_preset": presetName,
+		"system_instruction": instruction,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// SetChatGenerationDB records the generation parameters a chat answers with
+func (s *DefaultChatService) SetChatGenerationDB(sessionID string, params models.GenerationParams) error {
+	result := s.db.Model(&models.Chat{}).Where("session_id = ?", sessionID).Updates(map[string]interface{}{
+		"generation_temperature":       params.Temperature,
+		"generation_top_p":             params.TopP,
+		"generation_max_output_tokens": params.MaxOutputTokens,
+		"generation_safety_threshold":  params.SafetyThreshold,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetChatsByProjectIDFromDB retrieves the chats of a project with their messages, oldest first
+func (s *DefaultChatService) GetChatsByProjectIDFromDB(projectID uint) ([]models.Chat, error) {
+	var chats []models.Chat
+	result := s.db.Preload("Messages").Where("project_id = ?", projectID).Order("created_at asc").Find(&chats)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return chats, nil
+}
+
+// SetChatWorkspaceDB shares a chat with the members of a workspace
+func (s *DefaultChatService) SetChatWorkspaceDB(sessionID string, workspaceID uint) error {
+	return s.db.Model(&models.Chat{}).Where("session_id = ?", sessionID).Update("workspace_id", workspaceID).Error
+}
+
+// GetChatsByWorkspaceIDFromDB retrieves the chats shared with a workspace with their messages, newest first
+func (s *DefaultChatService) GetChatsByWorkspaceIDFromDB(workspaceID uint) ([]models.Chat, error) {
+	var chats []models.Chat
+	result := s.db.Preload("Messages").Where("workspace_id = ?", workspaceID).Order("created_at desc").Find(&chats)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return chats, nil
+}
+
+// GetMessageFromDB retrieves a message of a chat by its ID
+func (s *DefaultChatService) GetMessageFromDB(sessionID string, messageID uint) (*models.Message, error) {
+	var message models.Message
+	result := s.db.Joins("JOIN chats ON chats.id = messages.chat_id").
+		Where("chats.session_id = ? AND messages.id = ?", sessionID, messageID).
+		First(&message)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &message, nil
+}
+
+// GetMessagePathFromDB retrieves the conversation that leads up to and includes a message,
+// oldest first. A nil messageID is the empty conversation.
+func (s *DefaultChatService) GetMessagePathFromDB(messageID *uint) ([]models.Message, error) {
+	messages := []models.Message{}
+	if messageID == nil {
+		return messages, nil
+	}
+	result := s.db.Raw(`WITH RECURSIVE path AS (
+			SELECT * FROM messages WHERE id = ? AND deleted_at IS NULL
+			UNION ALL
+			SELECT messages.* FROM messages JOIN path ON messages.id = path.parent_id
+			WHERE messages.deleted_at IS NULL
+		)
+		SELECT * FROM path ORDER BY timestamp asc, id asc`, *messageID).Scan(&messages)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return messages, nil
+}
+
+// GetActiveBranchMessagesFromDB retrieves the conversation along a chat's active branch, oldest first
+func (s *DefaultChatService) GetActiveBranchMessagesFromDB(sessionID string) ([]models.Message, error) {
+	var chat models.Chat
+	if err := s.db.Where("session_id = ?", sessionID).First(&chat).Error; err != nil {
+		return nil, err
+	}
+	if chat.ActiveBranchID == nil {
+		return s.GetMessagesByChatIDFromDB(chat.ID)
+	}
+	tip, err := branchTip(s.db, *chat.ActiveBranchID)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetMessagePathFromDB(tip)
+}
+
+// CreateBranchDB starts a new branch after forkedFromID and makes it the chat's active branch
+func (s *DefaultChatService) CreateBranchDB(sessionID string, forkedFromID *uint, reason string) (*models.ChatBranch, error) {
+	branch := &models.ChatBranch{ForkedFromID: forkedFromID, Reason: reason}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var chat models.Chat
+		if err := tx.Where("session_id = ?", sessionID).First(&chat).Error; err != nil {
+			return err
+		}
+		branch.ChatID = chat.ID
+		if err := tx.Create(branch).Error; err != nil {
+			return err
+		}
+		return tx.Model(&chat).Update("active_branch_id", branch.ID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return branch, nil
+}
+
+// SetActiveBranchDB switches a chat to one of its branches
+func (s *DefaultChatService) SetActiveBranchDB(sessionID string, branchID uint) error {
+	result := s.db.Exec(`UPDATE chats SET active_branch_id = chat_branches.id
+		FROM chat_branches
+		WHERE chats.session_id = ? AND chat_branches.id = ? AND chat_branches.chat_id = chats.id AND chat_branches.deleted_at IS NULL`,
+		sessionID, branchID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetBranchesFromDB retrieves the branches of a chat, oldest first
+func (s *DefaultChatService) GetBranchesFromDB(sessionID string) ([]models.ChatBranch, error) {
+	var branches []models.ChatBranch
+	result := s.db.Joins("JOIN chats ON chats.id = chat_branches.chat_id").
+		Where("chats.session_id = ?", sessionID).
+		Order("chat_branches.created_at asc, chat_branches.id asc").
+		Find(&branches)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return branches, nil
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// fakeChatSession records what a running session is given. Methods the tests do not need are
+// left to the nil ChatSessionManager and panic.
+type fakeChatSession struct {
+	ChatSessionManager
+	history []models.Message
+	prompts []string
+}
+
+func (f *fakeChatSession) SetChatHistory(sessionID string, messages []models.Message) error {
+	f.history = messages
+	return nil
+}
+
+func (f *fakeChatSession) StreamChatMessage(ctx context.Context, sessionID string, message, instruction string) (*genai.GenerateContentResponseIterator, error) {
+	f.prompts = append(f.prompts, message)
+	return nil, nil
+}
+
+func messageContents(messages []models.Message) []string {
+	contents := make([]string, len(messages))
+	for i, message := range messages {
+		contents[i] = message.Content
+	}
+	return contents
+}
+
+// createTestChat creates a session with the given prompts and answers on its main branch
+func createTestChat(t *testing.T, chatDB ChatServiceDB, userID uuid.UUID, contents ...string) (string, []*models.Message) {
+	t.Helper()
+	sessionID := uuid.New().String()
+	require.NoError(t, chatDB.SaveChatToDB(userID, sessionID))
+	var messages []*models.Message
+	for i, content := range contents {
+		msgType, author := "user", &userID
+		if i%2 == 1 {
+			msgType, author = "ai", nil
+		}
+		message, err := chatDB.SaveMessageToDB(sessionID, msgType, content, author)
+		require.NoError(t, err)
+		messages = append(messages, message)
+	}
+	return sessionID, messages
+}
+
+func TestMessageTree(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	chatDB := NewChatServiceDB(db)
+	session := &fakeChatSession{}
+	research := NewResearchChatService(nil, nil, session, chatDB, nil, 0, nil, "", nil, nil, nil, nil, zerolog.Nop())
+	user := createTestUser(t, db)
+	sessionID, main := createTestChat(t, chatDB, user.ID, "p1", "a1", "p2", "a2")
+
+	active := func() []string {
+		t.Helper()
+		messages, err := chatDB.GetActiveBranchMessagesFromDB(sessionID)
+		require.NoError(t, err)
+		return messageContents(messages)
+	}
+
+	// Each message follows the previous one on the main branch
+	assert.Nil(t, main[0].ParentID)
+	for i := 1; i < len(main); i++ {
+		assert.Equal(t, main[i-1].ID, *main[i].ParentID)
+		assert.Equal(t, *main[0].BranchID, *main[i].BranchID)
+	}
+	assert.Equal(t, []string{"p1", "a1", "p2", "a2"}, active())
+	path, err := chatDB.GetMessagePathFromDB(&main[2].ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"p1", "a1", "p2"}, messageContents(path))
+
+	// Editing a prompt forks before it; the empty branch ends at the message it was forked from
+	_, edit, err := research.EditMessage(ctx, sessionID, main[2].ID, "p2 edited")
+	require.NoError(t, err)
+	assert.Equal(t, "edit", edit.Reason)
+	assert.Equal(t, main[1].ID, *edit.ForkedFromID)
+	assert.Equal(t, []string{"p1", "a1"}, messageContents(session.history))
+	assert.Equal(t, []string{"p1", "a1"}, active())
+	edited, err := chatDB.SaveMessageToDB(sessionID, "user", "p2 edited", &user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, main[1].ID, *edited.ParentID)
+	_, err = chatDB.SaveMessageToDB(sessionID, "ai", "a2 edited", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"p1", "a1", "p2 edited", "a2 edited"}, active())
+
+	// Regenerating asks the last prompt again on a branch forked after it
+	_, regenerate, err := research.RegenerateAnswer(ctx, sessionID)
+	require.NoError(t, err)
+	assert.Equal(t, edited.ID, *regenerate.ForkedFromID)
+	assert.Equal(t, []string{"p1", "a1"}, messageContents(session.history))
+	assert.Equal(t, "p2 edited", session.prompts[len(session.prompts)-1])
+	assert.Equal(t, []string{"p1", "a1", "p2 edited"}, active())
+	regenerated, err := chatDB.SaveMessageToDB(sessionID, "ai", "a2 regenerated", nil)
+	require.NoError(t, err)
+	assert.Equal(t, edited.ID, *regenerated.ParentID)
+	assert.Equal(t, []string{"p1", "a1", "p2 edited", "a2 regenerated"}, active())
+
+	// Switching back restores the main branch for the model too
+	require.NoError(t, research.SwitchBranch(sessionID, *main[0].BranchID))
+	assert.Equal(t, []string{"p1", "a1", "p2", "a2"}, active())
+	assert.Equal(t, []string{"p1", "a1", "p2", "a2"}, messageContents(session.history))
+
+	// Editing the first prompt starts over from an empty conversation
+	_, first, err := research.EditMessage(ctx, sessionID, main[0].ID, "p1 edited")
+	require.NoError(t, err)
+	assert.Nil(t, first.ForkedFromID)
+	assert.Empty(t, session.history)
+	assert.Empty(t, active())
+	_, _, err = research.RegenerateAnswer(ctx, sessionID)
+	assert.True(t, errors.Is(err, ErrInvalidBranchOperation), "got %v", err)
+
+	// Answers cannot be edited
+	_, _, err = research.EditMessage(ctx, sessionID, main[1].ID, "a1 edited")
+	assert.True(t, errors.Is(err, ErrInvalidBranchOperation), "got %v", err)
+
+	branches, err := chatDB.GetBranchesFromDB(sessionID)
+	require.NoError(t, err)
+	reasons := make([]string, len(branches))
+	for i, branch := range branches {
+		reasons[i] = branch.Reason
+	}
+	assert.Equal(t, []string{"main", "edit", "regenerate", "edit"}, reasons)
+}
+
+func TestSetActiveBranchRejectsOtherChats(t *testing.T) {
+	db := openTestDB(t)
+	chatDB := NewChatServiceDB(db)
+	user := createTestUser(t, db)
+	sessionID, mine := createTestChat(t, chatDB, user.ID, "p1", "a1")
+	otherSessionID, _ := createTestChat(t, chatDB, user.ID, "q1", "b1")
+	other, err := chatDB.CreateBranchDB(otherSessionID, nil, "edit")
+	require.NoError(t, err)
+
+	err = chatDB.SetActiveBranchDB(sessionID, other.ID)
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "got %v", err)
+	chat, err := chatDB.GetChatBySessionIDFromDB(sessionID)
+	require.NoError(t, err)
+	assert.Equal(t, *mine[0].BranchID, *chat.ActiveBranchID)
+
+	// Messages of another chat are not found through this one either
+	_, err = chatDB.GetMessageFromDB(otherSessionID, mine[0].ID)
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "got %v", err)
+}
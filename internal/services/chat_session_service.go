@@ -5,12 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"nexus_scholar_go_backend/cmd/api/config"
+	"nexus_scholar_go_backend/internal/models"
 	"sync"
 	"time"
 
 	"github.com/google/generative-ai-go/genai"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
+	"gorm.io/gorm"
 )
 
 // Add this new struct
@@ -24,6 +26,7 @@ const (
 
 type ChatSessionInfo struct {
 	Session           *genai.ChatSession
+	Model             *genai.GenerativeModel // the model Session sends prompts to
 	CachedContentName string
 	LastActivity      time.Time
 	CacheExpiresAt    time.Time
@@ -31,6 +34,9 @@ type ChatSessionInfo struct {
 	WarningTime       time.Time
 	isTerminated      bool
 	mutex             *sync.RWMutex
+	// turn is held from sending a prompt until its streamed answer has been read, so
+	// prompts from several participants never interleave on Session
+	turn *sync.Mutex
 }
 
 type ChatMessage struct {
@@ -46,6 +52,7 @@ type ChatSessionService struct {
 	chatService    ChatServiceDB
 	CacheManager   CacheManager
 	cacheServiceDB CacheServiceDB
+	summarizer     SessionSummarizer
 	cfg            *config.Config
 	logger         zerolog.Logger
 }
@@ -61,6 +68,7 @@ func NewChatSessionService(
 	chatService ChatServiceDB,
 	cacheServiceDB CacheServiceDB,
 	CacheManager CacheManager,
+	summarizer SessionSummarizer,
 	cfg *config.Config,
 	logger zerolog.Logger,
 ) *ChatSessionService {
@@ -69,6 +77,7 @@ func NewChatSessionService(
 		chatService:    chatService,
 		cacheServiceDB: cacheServiceDB,
 		CacheManager:   CacheManager,
+		summarizer:     summarizer,
 		cfg:            cfg,
 		sessions:       make(map[string]*ChatSessionInfo),
 		logger:         logger,
@@ -77,7 +86,7 @@ func NewChatSessionService(
 	return css
 }
 
-func (css *ChatSessionService) StartChatSession(ctx context.Context, userID uuid.UUID, cachedContentName string, sessionID string, cacheExpiryTime time.Time) error {
+func (css *ChatSessionService) StartChatSession(ctx context.Context, userID uuid.UUID, cachedContentName string, sessionID string, cacheExpiryTime time.Time, params models.GenerationParams) error {
 	css.logger.Info().Msgf("Starting chat session for user ID: %s, session ID: %s, cached content name: %s", userID, sessionID, cachedContentName)
 	// Get the GenerativeModel using the CacheManagementService
 	model, err := css.CacheManager.GetGenerativeModel(ctx, cachedContentName)
@@ -85,6 +94,7 @@ func (css *ChatSessionService) StartChatSession(ctx context.Context, userID uuid
 		return err
 	}
 
+	applyGenerationParams(model, params)
 	session := model.StartChat()
 
 	if err := css.chatService.SaveChatToDB(userID, sessionID); err != nil {
@@ -97,11 +107,13 @@ func (css *ChatSessionService) StartChatSession(ctx context.Context, userID uuid
 
 	css.sessions[sessionID] = &ChatSessionInfo{
 		Session:           session,
+		Model:             model,
 		CachedContentName: cachedContentName,
 		LastActivity:      time.Now(),
 		CacheExpiresAt:    cacheExpiryTime,
 		UserID:            userID,
 		mutex:             &sync.RWMutex{},
+		turn:              &sync.Mutex{},
 	}
 	return nil
 }
@@ -180,6 +192,7 @@ var (
 	ErrSessionNotFound = errors.New("session not found")
 	ErrCacheDelete     = errors.New("failed to delete cache")
 	ErrDBDelete        = errors.New("failed to delete chat from database")
+	ErrTurnInProgress  = errors.New("another prompt is being answered in this session")
 )
 
 type TerminationReason int
@@ -213,21 +226,98 @@ func (css *ChatSessionService) TerminateSession(ctx context.Context, sessionID s
 		return fmt.Errorf("failed to delete cached content: %w", err)
 	}
 
+	css.summarizer.SummarizeAsync(sessionID, SummaryAtTermination)
 	return nil
 }
 
-func (css *ChatSessionService) StreamChatMessage(ctx context.Context, sessionID string, message string) (*genai.GenerateContentResponseIterator, error) {
+// AcquireTurn reserves the session for one prompt. The caller must call release once the
+// streamed answer has been fully read. It fails with ErrTurnInProgress instead of waiting.
+func (css *ChatSessionService) AcquireTurn(sessionID string) (release func(), err error) {
+	css.sessionsMutex.RLock()
+	sessionInfo, ok := css.sessions[sessionID]
+	css.sessionsMutex.RUnlock()
+
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	if !sessionInfo.turn.TryLock() {
+		return nil, ErrTurnInProgress
+	}
+	var once sync.Once
+	return func() { once.Do(sessionInfo.turn.Unlock) }, nil
+}
+
+// StreamChatMessage sends a prompt to the session's chat. A non-empty instruction overrides the
+// session's answer style for this prompt only; it is sent alongside the prompt, not stored with it.
+func (css *ChatSessionService) StreamChatMessage(ctx context.Context, sessionID string, message, instruction string) (*genai.GenerateContentResponseIterator, error) {
 	sessionInfo, exists := css.getAndUpdateSession(sessionID)
 	if !exists {
 		return nil, errors.New("chat session not found")
 	}
 
-	formattedMessage := css.formatMessage(message)
-	responseIterator := sessionInfo.Session.SendMessageStream(ctx, genai.Text(formattedMessage))
+	parts := []genai.Part{genai.Text(message)}
+	if instruction != "" {
+		parts = append(parts, genai.Text("Instead of the usual answer style, for this answer only: "+instruction))
+	}
+	responseIterator := sessionInfo.Session.SendMessageStream(ctx, parts...)
 
 	return responseIterator, nil
 }
 
+// SetGenerationParams changes the sampling and safety settings of a running session from its
+// next prompt on, within the limits of the session's price tier, and records them on the chat
+func (css *ChatSessionService) SetGenerationParams(sessionID string, params models.GenerationParams) error {
+	css.sessionsMutex.RLock()
+	sessionInfo, ok := css.sessions[sessionID]
+	css.sessionsMutex.RUnlock()
+
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	cache, err := css.cacheServiceDB.GetCacheDB(sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to get cache: %w", err)
+	}
+	if err := ValidateGenerationParams(cache.PriceTier, params); err != nil {
+		return err
+	}
+	if err := css.chatService.SetChatGenerationDB(sessionID, params); err != nil {
+		return fmt.Errorf("failed to save generation parameters: %w", err)
+	}
+
+	sessionInfo.mutex.Lock()
+	applyGenerationParams(sessionInfo.Model, params)
+	sessionInfo.mutex.Unlock()
+	return nil
+}
+
+// SetChatHistory replaces the conversation the model sees with messages, oldest first, so that
+// the next prompt continues another branch of the chat
+func (css *ChatSessionService) SetChatHistory(sessionID string, messages []models.Message) error {
+	css.sessionsMutex.RLock()
+	sessionInfo, ok := css.sessions[sessionID]
+	css.sessionsMutex.RUnlock()
+
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	history := make([]*genai.Content, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Type == "ai" {
+			history = append(history, &genai.Content{Role: "model", Parts: []genai.Part{genai.Text(msg.Content)}})
+		} else {
+			history = append(history, genai.NewUserContent(genai.Text(msg.Content)))
+		}
+	}
+
+	sessionInfo.mutex.Lock()
+	sessionInfo.Session.History = history
+	sessionInfo.mutex.Unlock()
+	return nil
+}
+
 func (css *ChatSessionService) getAndUpdateSession(sessionID string) (*ChatSessionInfo, bool) {
 	css.sessionsMutex.RLock()
 	sessionInfo, ok := css.sessions[sessionID]
@@ -245,10 +335,6 @@ func (css *ChatSessionService) getAndUpdateSession(sessionID string) (*ChatSessi
 	return sessionInfo, true
 }
 
-func (css *ChatSessionService) formatMessage(message string) string {
-	return message + "\n\nFormat your answer in markdown with easily readable paragraphs."
-}
-
 func (css *ChatSessionService) periodicCleanup() {
 	cacheCleanupTicker := time.NewTicker(css.cfg.CacheCleanupDelay)
 	sessionCleanupTicker := time.NewTicker(css.cfg.SessionMemoryTimeout)
@@ -278,6 +364,7 @@ func (css *ChatSessionService) cleanupExpiredCaches() {
 				css.logger.Error().Err(err).Msgf("Failed to record cache token usage for session %s", sessionID)
 			} else {
 				css.logger.Info().Msgf("Successfully recorded cache token usage for session %s", sessionID)
+				css.summarizer.SummarizeAsync(sessionID, SummaryAtTermination)
 			}
 		}
 	}
@@ -376,18 +463,13 @@ func (css *ChatSessionService) ExtendSession(ctx context.Context, sessionID stri
 	return nil
 }
 
-func (css *ChatSessionService) calculateRealTimeTokenUsage(sessionID string) (float64, string, error) {
+func (css *ChatSessionService) calculateRealTimeTokenUsage(sessionID string) (float64, *models.Cache, error) {
 	cache, err := css.cacheServiceDB.GetCacheDB(sessionID)
 	if err != nil {
-		return 0, "", fmt.Errorf("failed to get cache: %w", err)
+		return 0, nil, fmt.Errorf("failed to get cache: %w", err)
 	}
 
-	now := time.Now()
-	duration := now.Sub(cache.CreationTime)
-	durationSeconds := duration.Seconds()
-	tokenHoursUsage := float64(cache.TotalTokenCount) * durationSeconds / (3600 * 1_000_000) // Convert to million token-hours
-
-	return tokenHoursUsage, cache.PriceTier, nil
+	return cacheTokenHours(cache, time.Now()), cache, nil
 }
 
 func (css *ChatSessionService) CheckCreditStatus(sessionID string) (isRemainingCreditLow bool, isCreditZero bool, remainingCredit float64, err error) {
@@ -399,19 +481,27 @@ func (css *ChatSessionService) CheckCreditStatus(sessionID string) (isRemainingC
 		return false, false, 0, ErrSessionNotFound
 	}
 
-	tokenHoursUsedInCurrentSession, priceTier, err := css.calculateRealTimeTokenUsage(sessionID)
+	tokenHoursUsedInCurrentSession, cache, err := css.calculateRealTimeTokenUsage(sessionID)
 	if err != nil {
 		css.logger.Error().Err(err).Msg("Error calculating token hours used")
 		return false, false, 0, nil
 	}
 
-	budget, err := css.cacheServiceDB.GetTierTokenBudgetDB(sessionInfo.UserID, priceTier)
-	if err != nil {
+	credit, err := css.CacheManager.RemainingCredit(context.Background(), sessionInfo.UserID, cache.WorkspaceID, cache.PriceTier)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// The session's owner has left the workspace it bills to, so nothing pays for it
+		css.logger.Warn().Msgf("Owner of session %s is no longer a member of its workspace", sessionID)
+		credit = 0
+	} else if err != nil {
 		css.logger.Error().Err(err).Msg("Error checking credit status")
-		return false, false, 0, nil
+		return false, false, 0, fmt.Errorf("failed to check remaining credit: %w", err)
 	}
 
-	remainingCredit = budget.TokenHoursBought - budget.TokenHoursUsed - tokenHoursUsedInCurrentSession
+	// Workspace credit already counts the sessions running on the pool, this one included
+	remainingCredit = credit
+	if cache.WorkspaceID == nil {
+		remainingCredit -= tokenHoursUsedInCurrentSession
+	}
 	isRemainingCreditLow = remainingCredit < (999_999.0 / 1_000_000.0 * 10.0 / 60.0) //Threshold of 1 million tokens for ten mintues
 	isCreditZero = remainingCredit <= 0
 
@@ -0,0 +1,288 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const (
+	// summaryModelName is the cheap model that titles and summarizes sessions
+	summaryModelName = "gemini-1.5-flash-8b"
+	summaryTimeout   = time.Minute
+
+	maxChatTitleLength   = 200
+	maxChatSummaryLength = 2000
+
+	// The transcript sent for summarization is cut down to about this many characters
+	summaryTranscriptLength = 20000
+	summaryMessageLength    = 1500
+)
+
+// ErrInvalidChatDetails is returned when a user-edited title or summary fails validation
+var ErrInvalidChatDetails = errors.New("invalid chat details")
+
+// SummaryTrigger tells why a session is being summarized
+type SummaryTrigger string
+
+const (
+	SummaryAfterFirstExchange SummaryTrigger = "first_exchange"
+	SummaryAtTermination      SummaryTrigger = "termination"
+)
+
+// TextModelProvider returns models that are not bound to a cached context
+type TextModelProvider interface {
+	GenerativeModel(name string) *genai.GenerativeModel
+}
+
+// ChatDetailsInput holds the user-editable title and summary of a chat; nil fields are unchanged
+type ChatDetailsInput struct {
+	Title   *string
+	Summary *string
+}
+
+type ChatSummaryService struct {
+	db          *gorm.DB
+	chatService ChatServiceDB
+	textModels  TextModelProvider
+	meter       UsageMeter
+	authorizer  SessionAuthorizer
+	inFlight    map[string]bool
+	pending     map[string]SummaryTrigger // requests made while a summary was running
+	mutex       sync.Mutex
+	logger      zerolog.Logger
+}
+
+func NewChatSummaryService(db *gorm.DB, chatService ChatServiceDB, textModels TextModelProvider, meter UsageMeter, authorizer SessionAuthorizer, logger zerolog.Logger) *ChatSummaryService {
+	return &ChatSummaryService{
+		db:          db,
+		chatService: chatService,
+		textModels:  textModels,
+		meter:       meter,
+		authorizer:  authorizer,
+		inFlight:    make(map[string]bool),
+		pending:     make(map[string]SummaryTrigger),
+		logger:      logger,
+	}
+}
+
+// SummarizeAsync titles and summarizes a session in the background. Only one summary of a
+// session runs at a time; a request made while one is running is run once it finishes, so a
+// summary at termination is not lost to the summary of the first exchange.
+func (s *ChatSummaryService) SummarizeAsync(sessionID string, trigger SummaryTrigger) {
+	s.mutex.Lock()
+	if s.inFlight[sessionID] {
+		// A termination summary is not replaced by a first exchange one
+		if s.pending[sessionID] != SummaryAtTermination {
+			s.pending[sessionID] = trigger
+		}
+		s.mutex.Unlock()
+		return
+	}
+	s.inFlight[sessionID] = true
+	s.mutex.Unlock()
+
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), summaryTimeout)
+			if err := s.Summarize(ctx, sessionID, trigger); err != nil {
+				s.logger.Error().Err(err).Str("trigger", string(trigger)).Msgf("Failed to summarize session %s", sessionID)
+			}
+			cancel()
+
+			s.mutex.Lock()
+			next, ok := s.pending[sessionID]
+			delete(s.pending, sessionID)
+			if !ok {
+				delete(s.inFlight, sessionID)
+				s.mutex.Unlock()
+				return
+			}
+			s.mutex.Unlock()
+			trigger = next
+		}
+	}()
+}
+
+// Summarize generates a session's title and summary with the summary model and bills it to
+// the session owner's base-tier budget. It does nothing when the summary is up to date, or
+// after the first exchange when the session has been summarized before.
+func (s *ChatSummaryService) Summarize(ctx context.Context, sessionID string, trigger SummaryTrigger) error {
+	var chat models.Chat
+	if err := s.db.WithContext(ctx).Where("session_id = ?", sessionID).First(&chat).Error; err != nil {
+		return fmt.Errorf("failed to get chat: %w", err)
+	}
+	if chat.TitleEdited && chat.SummaryEdited {
+		return nil
+	}
+	if trigger == SummaryAfterFirstExchange && chat.SummaryGeneratedAt != nil {
+		return nil
+	}
+
+	// Only the branch being followed is summarized
+	messages, err := s.chatService.GetActiveBranchMessagesFromDB(sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to get chat messages: %w", err)
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	if chat.SummaryGeneratedAt != nil && !messages[len(messages)-1].Timestamp.After(*chat.SummaryGeneratedAt) {
+		return nil
+	}
+
+	remaining, err := s.meter.RemainingCredit(ctx, chat.UserID, nil, "base")
+	if err != nil {
+		return fmt.Errorf("failed to get remaining credit: %w", err)
+	}
+	if remaining <= 0 {
+		s.logger.Info().Str("userID", chat.UserID.String()).Msgf("Skipping summary of session %s: no base-tier credit left", sessionID)
+		return nil
+	}
+
+	model := s.textModels.GenerativeModel(summaryModelName)
+	model.SetTemperature(0.2)
+	model.SetMaxOutputTokens(512)
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"title":   {Type: genai.TypeString, Description: "A specific title of at most 8 words"},
+			"summary": {Type: genai.TypeString, Description: "Two to three sentences on what was asked and found"},
+		},
+		Required: []string{"title", "summary"},
+	}
+
+	resp, err := model.GenerateContent(ctx, genai.Text(summaryPrompt(messages)))
+	if err != nil {
+		return fmt.Errorf("failed to generate summary: %w", err)
+	}
+	if _, _, err := billGeneration(ctx, s.meter, chat.UserID, nil, "base", resp); err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to bill summary of session %s", sessionID)
+	}
+
+	var generated struct {
+		Title   string `json:"title"`
+		Summary string `json:"summary"`
+	}
+	if err := json.Unmarshal([]byte(responseText(resp)), &generated); err != nil {
+		return fmt.Errorf("failed to parse generated summary: %w", err)
+	}
+
+	updates := map[string]interface{}{"summary_generated_at": time.Now()}
+	if title := truncateRunes(strings.TrimSpace(generated.Title), maxChatTitleLength); !chat.TitleEdited && title != "" {
+		updates["title"] = title
+	}
+	if summary := truncateRunes(strings.TrimSpace(generated.Summary), maxChatSummaryLength); !chat.SummaryEdited && summary != "" {
+		updates["summary"] = summary
+	}
+	if err := s.db.WithContext(ctx).Model(&chat).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to save summary: %w", err)
+	}
+	s.logger.Info().Str("trigger", string(trigger)).Msgf("Summarized session %s", sessionID)
+	return nil
+}
+
+// UpdateChatDetails lets a participant of a session rename it or rewrite its summary.
+// Edited fields are no longer overwritten by automatic summaries.
+func (s *ChatSummaryService) UpdateChatDetails(ctx context.Context, userID uuid.UUID, sessionID string, input ChatDetailsInput) (*models.Chat, error) {
+	chat, err := s.authorizer.AuthorizeSession(ctx, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if input.Title != nil {
+		title := strings.TrimSpace(*input.Title)
+		if title == "" || len([]rune(title)) > maxChatTitleLength {
+			return nil, fmt.Errorf("%w: title must be 1 to %d characters", ErrInvalidChatDetails, maxChatTitleLength)
+		}
+		updates["title"] = title
+		updates["title_edited"] = true
+	}
+	if input.Summary != nil {
+		summary := strings.TrimSpace(*input.Summary)
+		if len([]rune(summary)) > maxChatSummaryLength {
+			return nil, fmt.Errorf("%w: summary must be at most %d characters", ErrInvalidChatDetails, maxChatSummaryLength)
+		}
+		updates["summary"] = summary
+		updates["summary_edited"] = true
+	}
+	if len(updates) == 0 {
+		return chat, nil
+	}
+
+	if err := s.db.WithContext(ctx).Model(chat).Updates(updates).Error; err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to update details of session %s", sessionID)
+		return nil, fmt.Errorf("failed to update chat details: %w", err)
+	}
+	return chat, nil
+}
+
+// summaryPrompt asks for a title and summary of a transcript. Long transcripts keep the
+// first exchange and as many of the latest messages as fit.
+func summaryPrompt(messages []models.Message) string {
+	lines := make([]string, len(messages))
+	for i, msg := range messages {
+		speaker := "User"
+		if msg.Type == "ai" {
+			speaker = "Assistant"
+		}
+		lines[i] = speaker + ": " + truncateRunes(strings.TrimSpace(msg.Content), summaryMessageLength)
+	}
+
+	head := min(2, len(lines))
+	length := 0
+	for _, line := range lines[:head] {
+		length += len(line)
+	}
+	tail := len(lines)
+	for tail > head && length+len(lines[tail-1]) <= summaryTranscriptLength {
+		tail--
+		length += len(lines[tail])
+	}
+	kept := append([]string{}, lines[:head]...)
+	if tail > head {
+		kept = append(kept, "[…]")
+	}
+	kept = append(kept, lines[tail:]...)
+
+	return "Below is a conversation between a researcher and an assistant about a set of research papers. " +
+		"Give it a short, specific title and summarize in two to three sentences what was asked and what was found. " +
+		"Write in the language of the conversation.\n\n" + strings.Join(kept, "\n\n")
+}
+
+func responseText(resp *genai.GenerateContentResponse) string {
+	var b strings.Builder
+	for _, candidate := range resp.Candidates {
+		if candidate.Content == nil {
+			continue
+		}
+		for _, part := range candidate.Content.Parts {
+			if text, ok := part.(genai.Text); ok {
+				b.WriteString(string(text))
+			}
+		}
+		break
+	}
+	return b.String()
+}
+
+func truncateRunes(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+	return string(runes[:limit])
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -13,18 +14,19 @@ import (
 )
 
 type GCSService struct {
-	Client *storage.Client
-	logger zerolog.Logger
+	Client    *storage.Client
+	projectID string
+	logger    zerolog.Logger
 }
 
-func NewGCSService(ctx context.Context, logger zerolog.Logger) (*GCSService, error) {
+func NewGCSService(ctx context.Context, projectID string, logger zerolog.Logger) (*GCSService, error) {
 	client, err := initGCSClient(ctx, logger)
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to initialize GCS client")
 		return nil, fmt.Errorf("failed to initialize GCS client: %w", err)
 	}
 	logger.Info().Msg("GCS client initialized successfully")
-	return &GCSService{Client: client, logger: logger}, nil
+	return &GCSService{Client: client, projectID: projectID, logger: logger}, nil
 }
 
 func initGCSClient(ctx context.Context, logger zerolog.Logger) (*storage.Client, error) {
@@ -36,6 +38,21 @@ func initGCSClient(ctx context.Context, logger zerolog.Logger) (*storage.Client,
 	return storage.NewClient(ctx, option.WithCredentialsFile(GOOGLE_APPLICATION_CREDENTIALS))
 }
 
+func (s *GCSService) EnsureBucket(ctx context.Context, bucketName string) error {
+	bucket := s.Client.Bucket(bucketName)
+	_, err := bucket.Attrs(ctx)
+	if err == storage.ErrBucketNotExist {
+		s.logger.Info().Msgf("Bucket %s does not exist. Creating...", bucketName)
+		if err := bucket.Create(ctx, s.projectID, nil); err != nil {
+			return fmt.Errorf("failed to create bucket: %w", err)
+		}
+		s.logger.Info().Msgf("Bucket %s created successfully", bucketName)
+	} else if err != nil {
+		return fmt.Errorf("failed to get bucket attributes: %w", err)
+	}
+	return nil
+}
+
 func (s *GCSService) UploadFile(ctx context.Context, bucketName, objectName string, content io.Reader) error {
 	s.logger.Info().Msgf("Uploading file to bucket: %s, object: %s", bucketName, objectName)
 	bucket := s.Client.Bucket(bucketName)
@@ -53,30 +70,28 @@ func (s *GCSService) UploadFile(ctx context.Context, bucketName, objectName stri
 	return nil
 }
 
-func (s *GCSService) DownloadFile(ctx context.Context, bucketName, objectName string) ([]byte, error) {
+func (s *GCSService) DownloadFile(ctx context.Context, bucketName, objectName string) (io.ReadCloser, error) {
 	s.logger.Info().Msgf("Downloading file from bucket: %s, object: %s", bucketName, objectName)
 	bucket := s.Client.Bucket(bucketName)
 	obj := bucket.Object(objectName)
 	reader, err := obj.NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, fmt.Errorf("GCS object %s: %w", objectName, ErrStorageObjectNotFound)
+	}
 	if err != nil {
 		s.logger.Error().Err(err).Msgf("Failed to create reader for GCS object: %s", objectName)
 		return nil, fmt.Errorf("failed to create reader for GCS object: %w", err)
 	}
-	defer reader.Close()
-	content, err := io.ReadAll(reader)
-	if err != nil {
-		s.logger.Error().Err(err).Msgf("Failed to read content from GCS object: %s", objectName)
-		return nil, fmt.Errorf("failed to read content from GCS object: %w", err)
-	}
-	s.logger.Info().Msgf("File downloaded successfully from bucket: %s, object: %s", bucketName, objectName)
-	return content, nil
+	return reader, nil
 }
 
 func (s *GCSService) DeleteFile(ctx context.Context, bucketName, objectName string) error {
 	s.logger.Info().Msgf("Deleting file from bucket: %s, object: %s", bucketName, objectName)
 	bucket := s.Client.Bucket(bucketName)
 	obj := bucket.Object(objectName)
-	if err := obj.Delete(ctx); err != nil {
+	if err := obj.Delete(ctx); errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("GCS object %s: %w", objectName, ErrStorageObjectNotFound)
+	} else if err != nil {
 		s.logger.Error().Err(err).Msgf("Failed to delete GCS object: %s", objectName)
 		return fmt.Errorf("failed to delete GCS object: %w", err)
 	}
@@ -0,0 +1,462 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const (
+	minComparedDocuments     = 2
+	maxComparedDocuments     = 6
+	maxComparisonFocusLength = 1000
+	comparisonTimeout        = 3 * time.Minute
+)
+
+// ErrInvalidComparison is returned when a comparison request fails validation
+var ErrInvalidComparison = errors.New("invalid comparison")
+
+// comparisonAspects are the rows of every comparison, in order
+var comparisonAspects = []struct {
+	Key         string
+	Label       string
+	Description string
+}{
+	{"problem", "Problem", "The research problem or question the document addresses"},
+	{"method", "Method", "The approach, model or study design"},
+	{"data", "Data", "Datasets, corpora, participants or experimental material, with their sizes"},
+	{"results", "Results", "The main quantitative or qualitative results"},
+	{"claims", "Claims", "The central claims and conclusions the authors draw"},
+	{"contradictions", "Contradictions", "Where the document disagrees with or contradicts the other compared documents; empty if nowhere"},
+}
+
+// ComparisonInput selects the documents to compare by their position in the session's corpus
+type ComparisonInput struct {
+	Documents []int
+	Focus     string
+}
+
+// ComparisonMatrix lays a comparison out with one row per aspect and one column per document
+type ComparisonMatrix struct {
+	Documents []ComparedDocument `json:"documents"`
+	Rows      []ComparisonRow    `json:"rows"`
+}
+
+type ComparedDocument struct {
+	Position int    `json:"position"`
+	Title    string `json:"title"`
+	ArxivID  string `json:"arxiv_id,omitempty"`
+}
+
+type ComparisonRow struct {
+	Aspect string           `json:"aspect"`
+	Label  string           `json:"label"`
+	Cells  []ComparisonCell `json:"cells"` // in the order of the documents
+}
+
+type ComparisonCell struct {
+	Summary string `json:"summary"`
+	Quote   string `json:"quote"`
+}
+
+type ComparisonService struct {
+	db          *gorm.DB
+	authorizer  SessionAuthorizer
+	chatService ChatServiceDB
+	meter       UsageMeter
+	corpus      corpusAccess
+	logger      zerolog.Logger
+}
+
+func NewComparisonService(
+	db *gorm.DB,
+	authorizer SessionAuthorizer,
+	chatService ChatServiceDB,
+	meter UsageMeter,
+	cacheManager CacheManager,
+	cacheServiceDB CacheServiceDB,
+	activity SessionActivity,
+	logger zerolog.Logger,
+) *ComparisonService {
+	return &ComparisonService{
+		db:          db,
+		authorizer:  authorizer,
+		chatService: chatService,
+		meter:       meter,
+		corpus: corpusAccess{
+			cacheManager:   cacheManager,
+			cacheServiceDB: cacheServiceDB,
+			activity:       activity,
+		},
+		logger: logger,
+	}
+}
+
+// Compare generates a side-by-side comparison of documents of a running session against its
+// cached corpus, saves it and bills it to the user on the session's price tier
+func (s *ComparisonService) Compare(ctx context.Context, userID uuid.UUID, sessionID string, input ComparisonInput) (*models.Comparison, *ComparisonMatrix, error) {
+	chat, err := s.authorizer.AuthorizeSession(ctx, userID, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	documents, err := s.chatService.GetChatDocumentsFromDB(sessionID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get session documents: %w", err)
+	}
+	compared, err := validateComparisonInput(&input, documents)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	model, cache, err := s.corpus.model(ctx, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkJobCredit(ctx, s.meter, userID, cache); err != nil {
+		return nil, nil, err
+	}
+
+	model.SetTemperature(0.2)
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = comparisonSchema(compared)
+
+	genCtx, cancel := context.WithTimeout(ctx, comparisonTimeout)
+	defer cancel()
+	resp, err := model.GenerateContent(genCtx, genai.Text(comparisonPrompt(compared, input.Focus)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate comparison: %w", err)
+	}
+	tokens, tokenHours, err := billJob(ctx, s.meter, userID, cache, resp)
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to bill comparison in session %s", sessionID)
+	}
+
+	var answers map[string]map[string]ComparisonCell
+	if err := json.Unmarshal([]byte(responseText(resp)), &answers); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse comparison: %w", err)
+	}
+
+	comparison := &models.Comparison{
+		ChatID:         chat.ID,
+		UserID:         userID,
+		Focus:          input.Focus,
+		TokenCountUsed: tokens,
+		TokenHoursUsed: tokenHours,
+	}
+	for _, doc := range compared {
+		answer := answers[comparedDocumentKey(doc.Position)]
+		for _, aspect := range comparisonAspects {
+			cell := answer[aspect.Key]
+			comparison.Entries = append(comparison.Entries, models.ComparisonEntry{
+				DocumentPosition: doc.Position,
+				Aspect:           aspect.Key,
+				Summary:          strings.TrimSpace(cell.Summary),
+				Quote:            strings.TrimSpace(cell.Quote),
+			})
+		}
+	}
+	if err := s.db.WithContext(ctx).Create(comparison).Error; err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to save comparison for session %s", sessionID)
+		return nil, nil, fmt.Errorf("failed to save comparison: %w", err)
+	}
+
+	if err := s.corpus.keepAlive(ctx, sessionID); err != nil {
+		s.logger.Warn().Err(err).Msgf("Failed to record activity of session %s", sessionID)
+	}
+	return comparison, comparisonMatrix(comparison, documents), nil
+}
+
+// ListComparisons returns the comparisons of a session with the matrices they render to,
+// newest first
+func (s *ComparisonService) ListComparisons(ctx context.Context, userID uuid.UUID, sessionID string) ([]models.Comparison, []*ComparisonMatrix, error) {
+	chat, err := s.authorizer.AuthorizeSession(ctx, userID, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var comparisons []models.Comparison
+	err = s.db.WithContext(ctx).Preload("Entries", orderedEntries).Where("chat_id = ?", chat.ID).Order("created_at desc").Find(&comparisons).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list comparisons: %w", err)
+	}
+	documents, err := s.chatService.GetChatDocumentsFromDB(sessionID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get session documents: %w", err)
+	}
+	matrices := make([]*ComparisonMatrix, len(comparisons))
+	for i := range comparisons {
+		matrices[i] = comparisonMatrix(&comparisons[i], documents)
+	}
+	return comparisons, matrices, nil
+}
+
+// GetComparison returns a saved comparison of the session and its matrix
+func (s *ComparisonService) GetComparison(ctx context.Context, userID uuid.UUID, sessionID string, comparisonID uint) (*models.Comparison, *ComparisonMatrix, error) {
+	chat, err := s.authorizer.AuthorizeSession(ctx, userID, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var comparison models.Comparison
+	if err := s.db.WithContext(ctx).Preload("Entries", orderedEntries).Where("id = ? AND chat_id = ?", comparisonID, chat.ID).First(&comparison).Error; err != nil {
+		return nil, nil, err
+	}
+	documents, err := s.chatService.GetChatDocumentsFromDB(sessionID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get session documents: %w", err)
+	}
+	return &comparison, comparisonMatrix(&comparison, documents), nil
+}
+
+// ExportComparison renders a saved comparison as a Markdown table, as CSV with a quote column
+// after every document column, or as JSON
+func (s *ComparisonService) ExportComparison(ctx context.Context, userID uuid.UUID, sessionID string, comparisonID uint, format string) (*ChatExport, error) {
+	if format == "" {
+		format = ExportFormatMarkdown
+	}
+	if format != ExportFormatMarkdown && format != ExportFormatCSV && format != ExportFormatJSON {
+		return nil, fmt.Errorf("%w: format must be md, csv or json", ErrInvalidExportFormat)
+	}
+	comparison, matrix, err := s.GetComparison(ctx, userID, sessionID, comparisonID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &ChatExport{FileName: fmt.Sprintf("comparison-%d.%s", comparison.ID, format)}
+	switch format {
+	case ExportFormatMarkdown:
+		export.ContentType = "text/markdown; charset=utf-8"
+		export.Content = []byte(renderComparisonMarkdown(comparison, matrix))
+	case ExportFormatCSV:
+		export.ContentType = "text/csv; charset=utf-8"
+		export.Content, err = comparisonCSV(matrix)
+	case ExportFormatJSON:
+		export.ContentType = "application/json"
+		export.Content, err = json.MarshalIndent(matrix, "", "  ")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to render %s export: %w", format, err)
+	}
+	return export, nil
+}
+
+// DeleteComparison deletes a comparison; only its requester and the session's owner may
+func (s *ComparisonService) DeleteComparison(ctx context.Context, userID uuid.UUID, sessionID string, comparisonID uint) error {
+	chat, err := s.authorizer.AuthorizeSession(ctx, userID, sessionID)
+	if err != nil {
+		return err
+	}
+	var comparison models.Comparison
+	if err := s.db.WithContext(ctx).Where("id = ? AND chat_id = ?", comparisonID, chat.ID).First(&comparison).Error; err != nil {
+		return err
+	}
+	if comparison.UserID != userID && chat.UserID != userID {
+		return gorm.ErrRecordNotFound
+	}
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("comparison_id = ?", comparison.ID).Delete(&models.ComparisonEntry{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&comparison).Error
+	})
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to delete comparison %d", comparisonID)
+		return fmt.Errorf("failed to delete comparison: %w", err)
+	}
+	return nil
+}
+
+// orderedEntries keeps the entries of a comparison, and so its documents, in the order they
+// were generated in
+func orderedEntries(db *gorm.DB) *gorm.DB {
+	return db.Order("id asc")
+}
+
+// validateComparisonInput checks the selection and returns the selected documents in the
+// order they were given
+func validateComparisonInput(input *ComparisonInput, documents []models.ChatDocument) ([]models.ChatDocument, error) {
+	input.Focus = strings.TrimSpace(input.Focus)
+	if len([]rune(input.Focus)) > maxComparisonFocusLength {
+		return nil, fmt.Errorf("%w: focus must be at most %d characters", ErrInvalidComparison, maxComparisonFocusLength)
+	}
+	if len(input.Documents) < minComparedDocuments || len(input.Documents) > maxComparedDocuments {
+		return nil, fmt.Errorf("%w: select %d to %d documents", ErrInvalidComparison, minComparedDocuments, maxComparedDocuments)
+	}
+	byPosition := make(map[int]models.ChatDocument, len(documents))
+	for _, doc := range documents {
+		byPosition[doc.Position] = doc
+	}
+	compared := make([]models.ChatDocument, 0, len(input.Documents))
+	seen := make(map[int]bool, len(input.Documents))
+	for _, position := range input.Documents {
+		doc, ok := byPosition[position]
+		if !ok {
+			return nil, fmt.Errorf("%w: the session has no document %d", ErrInvalidComparison, position)
+		}
+		if seen[position] {
+			return nil, fmt.Errorf("%w: document %d is selected twice", ErrInvalidComparison, position)
+		}
+		seen[position] = true
+		compared = append(compared, doc)
+	}
+	return compared, nil
+}
+
+// comparedDocumentKey names a document in the response schema
+func comparedDocumentKey(position int) string {
+	return "document_" + strconv.Itoa(position)
+}
+
+func comparisonSchema(compared []models.ChatDocument) *genai.Schema {
+	cell := func(description string) *genai.Schema {
+		return &genai.Schema{
+			Type:        genai.TypeObject,
+			Description: description,
+			Properties: map[string]*genai.Schema{
+				"summary": {Type: genai.TypeString, Description: "One to three sentences"},
+				"quote":   {Type: genai.TypeString, Description: "Verbatim passage of the document that supports the summary"},
+			},
+			Required: []string{"summary", "quote"},
+		}
+	}
+	aspects := make(map[string]*genai.Schema, len(comparisonAspects))
+	required := make([]string, len(comparisonAspects))
+	for i, aspect := range comparisonAspects {
+		aspects[aspect.Key] = cell(aspect.Description)
+		required[i] = aspect.Key
+	}
+
+	properties := make(map[string]*genai.Schema, len(compared))
+	documentKeys := make([]string, len(compared))
+	for i, doc := range compared {
+		key := comparedDocumentKey(doc.Position)
+		documentKeys[i] = key
+		properties[key] = &genai.Schema{
+			Type:        genai.TypeObject,
+			Description: fmt.Sprintf("Document %d", doc.Position),
+			Properties:  aspects,
+			Required:    required,
+		}
+	}
+	return &genai.Schema{Type: genai.TypeObject, Properties: properties, Required: documentKeys}
+}
+
+func comparisonPrompt(compared []models.ChatDocument, focus string) string {
+	var documents, aspects strings.Builder
+	for _, doc := range compared {
+		fmt.Fprintf(&documents, "- <Document %d> (%q) as %s\n", doc.Position, doc.Title, comparedDocumentKey(doc.Position))
+	}
+	for _, aspect := range comparisonAspects {
+		fmt.Fprintf(&aspects, "- %s: %s\n", aspect.Key, aspect.Description)
+	}
+	prompt := "Compare the following documents side by side, ignoring the other documents in the context:\n" +
+		documents.String() +
+		"\nFor every document and every aspect below, summarize what that document says and give a short verbatim quote " +
+		"of the same document as evidence. If a document does not address an aspect, leave its summary and quote empty.\n" +
+		aspects.String()
+	if focus != "" {
+		prompt += "\nPay particular attention to: " + focus
+	}
+	return prompt
+}
+
+func comparisonMatrix(comparison *models.Comparison, documents []models.ChatDocument) *ComparisonMatrix {
+	byPosition := make(map[int]models.ChatDocument, len(documents))
+	for _, doc := range documents {
+		byPosition[doc.Position] = doc
+	}
+
+	matrix := &ComparisonMatrix{}
+	column := make(map[int]int)
+	cells := make(map[string]map[int]ComparisonCell)
+	for _, entry := range comparison.Entries {
+		if _, ok := column[entry.DocumentPosition]; !ok {
+			column[entry.DocumentPosition] = len(matrix.Documents)
+			doc := byPosition[entry.DocumentPosition]
+			matrix.Documents = append(matrix.Documents, ComparedDocument{Position: entry.DocumentPosition, Title: doc.Title, ArxivID: doc.ArxivID})
+		}
+		if cells[entry.Aspect] == nil {
+			cells[entry.Aspect] = make(map[int]ComparisonCell)
+		}
+		cells[entry.Aspect][entry.DocumentPosition] = ComparisonCell{Summary: entry.Summary, Quote: entry.Quote}
+	}
+
+	for _, aspect := range comparisonAspects {
+		row := ComparisonRow{Aspect: aspect.Key, Label: aspect.Label, Cells: make([]ComparisonCell, len(matrix.Documents))}
+		for _, doc := range matrix.Documents {
+			row.Cells[column[doc.Position]] = cells[aspect.Key][doc.Position]
+		}
+		matrix.Rows = append(matrix.Rows, row)
+	}
+	return matrix
+}
+
+func renderComparisonMarkdown(comparison *models.Comparison, matrix *ComparisonMatrix) string {
+	var b strings.Builder
+	b.WriteString("# Comparison\n\n")
+	if comparison.Focus != "" {
+		b.WriteString("*Focus: " + comparison.Focus + "*\n\n")
+	}
+
+	b.WriteString("| Aspect |")
+	for _, doc := range matrix.Documents {
+		b.WriteString(" " + markdownTableCell(fmt.Sprintf("Document %d: %s", doc.Position, doc.Title)) + " |")
+	}
+	b.WriteString("\n|---|" + strings.Repeat("---|", len(matrix.Documents)) + "\n")
+	for _, row := range matrix.Rows {
+		b.WriteString("| **" + row.Label + "** |")
+		for _, cell := range row.Cells {
+			text := cell.Summary
+			if cell.Quote != "" {
+				text += ` *"` + cell.Quote + `"*`
+			}
+			if text == "" {
+				text = "–"
+			}
+			b.WriteString(" " + markdownTableCell(text) + " |")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// markdownTableCell keeps text on one line and escapes the column separator
+func markdownTableCell(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	return strings.ReplaceAll(text, "|", `\|`)
+}
+
+func comparisonCSV(matrix *ComparisonMatrix) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	header := []string{"Aspect"}
+	for _, doc := range matrix.Documents {
+		label := fmt.Sprintf("Document %d: %s", doc.Position, doc.Title)
+		header = append(header, label, label+" (quote)")
+	}
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+	for _, row := range matrix.Rows {
+		record := []string{row.Label}
+		for _, cell := range row.Cells {
+			record = append(record, cell.Summary, cell.Quote)
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
@@ -7,131 +7,321 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
+	"sync"
+	"time"
+
+	"nexus_scholar_go_backend/internal/utils/docir"
 
 	"github.com/rs/zerolog"
 )
 
+// The text extracted from arXiv papers is kept for a while, so estimating a session and then
+// starting it downloads and extracts each paper once
+const (
+	extractionCacheTTL        = 30 * time.Minute
+	maxExtractionCacheEntries = 200
+)
+
 // ContentAggregationService handles the aggregation of content from various sources
 type ContentAggregationService struct {
-	arxivBaseURL string
-	logger       zerolog.Logger
+	arxivBaseURL       string
+	arxivSourceURL     string
+	equationRecognizer EquationRecognizer // nil when none is configured
+	extracted          map[string]extractedPaper
+	mutex              sync.Mutex
+	logger             zerolog.Logger
+}
+
+type extractedPaper struct {
+	content     string
+	source      *docir.Document // the paper converted from its LaTeX source, in the math mode
+	title       string
+	figures     []FigureImage
+	hasFigures  bool // whether figures were extracted, a paper may have none
+	extractedAt time.Time
 }
 
-func NewContentAggregationService(arxivBaseURL string, logger zerolog.Logger) *ContentAggregationService {
+// NewContentAggregationService returns a service downloading PDFs from arxivBaseURL and, in
+// the math extraction mode, LaTeX sources from arxivSourceURL. equationRecognizer may be nil.
+func NewContentAggregationService(arxivBaseURL, arxivSourceURL string, equationRecognizer EquationRecognizer, logger zerolog.Logger) *ContentAggregationService {
 	return &ContentAggregationService{
-		arxivBaseURL: arxivBaseURL,
-		logger:       logger,
+		arxivBaseURL:       arxivBaseURL,
+		arxivSourceURL:     arxivSourceURL,
+		equationRecognizer: equationRecognizer,
+		extracted:          make(map[string]extractedPaper),
+		logger:             logger,
 	}
 }
 
-func (s *ContentAggregationService) AggregateDocuments(arxivIDs []string, userPDFs []string) (string, error) {
+// UserDocument is a document from the user's library whose text was extracted on upload
+type UserDocument struct {
+	DocumentID uint
+	Title      string
+	Content    string
+	Figures    []FigureImage // only loaded when the session caches figures
+}
+
+// AggregatedDocument describes one document of an aggregated corpus
+type AggregatedDocument struct {
+	Index      int // the N in <Document N>
+	Title      string
+	ArxivID    string
+	DocumentID uint     // library document, 0 for arXiv papers
+	Source     string   // arxiv or upload
+	Trims      []string // what was cut from the document to fit a token budget, if anything
+	Figures    int      // figure and table images cached with the document
+}
+
+// CorpusDocument is a document of a corpus with its structured text, before it is aggregated
+type CorpusDocument struct {
+	AggregatedDocument
+	Document docir.Document
+	Figures  []FigureImage // images the document's figures and tables may refer to
+}
+
+func (s *ContentAggregationService) AggregateDocuments(arxivIDs []string, userDocuments []UserDocument) (string, []AggregatedDocument, error) {
+	corpus, err := s.CollectDocuments(arxivIDs, userDocuments, ExtractionOptions{})
+	if err != nil {
+		return "", nil, err
+	}
+	return s.RenderCorpus(corpus)
+}
+
+// CollectDocuments gathers the text of a corpus's documents, arXiv papers first and then the
+// documents from the user's library, and structures it into sections and references. With
+// options.Figures, the figures and tables of arXiv papers are cropped from their PDFs and those
+// of library documents are taken from the user documents, and linked to their captions. With
+// options.Math, arXiv papers are converted from their LaTeX source when they have one.
+func (s *ContentAggregationService) CollectDocuments(arxivIDs []string, userDocuments []UserDocument, options ExtractionOptions) ([]CorpusDocument, error) {
 	s.logger.Info().Msg("Starting to aggregate documents")
-	var aggregatedContent strings.Builder
-	var summary strings.Builder
+	var corpus []CorpusDocument
 	documentCount := 0
 
 	// Process arXiv papers
 	for _, id := range arxivIDs {
 		s.logger.Info().Msgf("Processing arXiv paper with ID: %s", id)
 		documentCount++
-		content, title, err := s.processArXivPaper(id)
+		paper, err := s.processArXivPaper(id, options)
 		if err != nil {
 			s.logger.Error().Err(err).Msgf("Failed to process arXiv paper with ID: %s", id)
-			return "", fmt.Errorf("failed to process arXiv paper %s: %v", id, err)
+			return nil, fmt.Errorf("failed to process arXiv paper %s: %v", id, err)
+		}
+		doc := CorpusDocument{
+			AggregatedDocument: AggregatedDocument{Index: documentCount, Title: paper.title, ArxivID: id, Source: "arxiv"},
+		}
+		if paper.source != nil {
+			// The cached paper is shared, and corpus features edit their documents
+			doc.Document = paper.source.Clone()
+			doc.Document.Index = documentCount
+		} else {
+			doc.Document = docir.FromText(documentCount, paper.title, paper.content)
 		}
-		documentString := fmt.Sprintf("<Document %d>\n<title>Title: %s</title>\n%s\n</Document %d>\n", documentCount, title, content, documentCount)
-		aggregatedContent.WriteString(documentString)
-		summary.WriteString(fmt.Sprintf("Document %d: %s (arXiv ID: %s)\n", documentCount, title, id))
+		if options.Figures {
+			attachFigures(&doc, paper.figures)
+		}
+		corpus = append(corpus, doc)
 	}
 
-	// Process user-provided PDFs
-	for _, pdfPath := range userPDFs {
-		if pdfPath == "" {
-			continue
-		}
-		s.logger.Info().Msgf("Processing user PDF with path: %s", pdfPath)
+	// Process documents from the user's library
+	for _, doc := range userDocuments {
+		s.logger.Info().Msgf("Adding library document with ID: %d", doc.DocumentID)
 		documentCount++
-		content, title, err := s.ProcessUserPDF(pdfPath)
+		corpusDoc := CorpusDocument{
+			AggregatedDocument: AggregatedDocument{Index: documentCount, Title: doc.Title, DocumentID: doc.DocumentID, Source: "upload"},
+			Document:           docir.FromText(documentCount, doc.Title, doc.Content),
+		}
+		if options.Figures {
+			attachFigures(&corpusDoc, doc.Figures)
+		}
+		corpus = append(corpus, corpusDoc)
+	}
+	return corpus, nil
+}
+
+// RenderCorpus aggregates collected documents into the markup of a cache, preceded by a
+// summary of the documents that also tells what was trimmed from them
+func (s *ContentAggregationService) RenderCorpus(corpus []CorpusDocument) (string, []AggregatedDocument, error) {
+	var aggregatedContent strings.Builder
+	var summary strings.Builder
+	documents := make([]AggregatedDocument, len(corpus))
+	for i, doc := range corpus {
+		markup, err := docir.Marshal(&doc.Document)
 		if err != nil {
-			s.logger.Error().Err(err).Msgf("Failed to process user PDF with path: %s", pdfPath)
-			return "", fmt.Errorf("failed to process PDF %s: %v", pdfPath, err)
+			return "", nil, fmt.Errorf("failed to render document %d: %w", doc.Index, err)
+		}
+		aggregatedContent.WriteString(markup)
+		if doc.Source == "arxiv" {
+			summary.WriteString(fmt.Sprintf("Document %d: %s (arXiv ID: %s)", doc.Index, doc.Title, doc.ArxivID))
+		} else {
+			summary.WriteString(fmt.Sprintf("Document %d: %s (User PDF)", doc.Index, doc.Title))
 		}
-		documentString := fmt.Sprintf("<Document %d>\n<title>Title: %s</title>\n%s\n</Document%d>\n", documentCount, title, content, documentCount)
-		aggregatedContent.WriteString(documentString)
-		summary.WriteString(fmt.Sprintf("Document %d: %s (User PDF)\n", documentCount, title))
+		if len(doc.Trims) > 0 {
+			summary.WriteString(fmt.Sprintf(" [trimmed to fit the token budget: %s]", strings.Join(doc.Trims, ", ")))
+		}
+		figures := len(doc.Document.Images())
+		if figures > 0 {
+			summary.WriteString(fmt.Sprintf(" [%d figure and table images follow the documents]", figures))
+		}
+		summary.WriteString("\n")
+		documents[i] = doc.AggregatedDocument
+		documents[i].Figures = figures
 	}
 
 	// Prepend the summary to the aggregated content
 	finalContent := fmt.Sprintf("Summary of Documents:\n%s\n\nAggregated Content:\n%s", summary.String(), aggregatedContent.String())
+	return finalContent, documents, nil
+}
+
+// processArXivPaper returns the text of a paper and, with options.Figures, its figures. A
+// paper extracted without figures is downloaded again when its figures are needed. Papers are
+// kept apart per extraction mode.
+func (s *ContentAggregationService) processArXivPaper(arxivID string, options ExtractionOptions) (extractedPaper, error) {
+	key := arxivID
+	if options.Math {
+		key += "#" + ExtractionModeMath
+	}
+	if paper, ok := s.cachedExtraction(key, options.Figures); ok {
+		s.logger.Info().Msgf("Using extracted text of arXiv paper with ID: %s", arxivID)
+		return paper, nil
+	}
+	paper, err := s.extractArXivPaper(arxivID, options)
+	if err != nil {
+		return extractedPaper{}, err
+	}
+	s.cacheExtraction(key, paper)
+	return paper, nil
+}
 
-	return finalContent, nil
+func (s *ContentAggregationService) cachedExtraction(key string, withFigures bool) (extractedPaper, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	paper, ok := s.extracted[key]
+	if !ok || time.Since(paper.extractedAt) > extractionCacheTTL || (withFigures && !paper.hasFigures) {
+		return extractedPaper{}, false
+	}
+	return paper, true
+}
+
+// cacheExtraction keeps the text of a paper, making room by dropping expired entries and,
+// if that is not enough, the oldest one
+func (s *ContentAggregationService) cacheExtraction(key string, paper extractedPaper) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.extracted) >= maxExtractionCacheEntries {
+		var oldestID string
+		var oldest time.Time
+		for id, cached := range s.extracted {
+			if time.Since(cached.extractedAt) > extractionCacheTTL {
+				delete(s.extracted, id)
+				continue
+			}
+			if oldestID == "" || cached.extractedAt.Before(oldest) {
+				oldestID, oldest = id, cached.extractedAt
+			}
+		}
+		if len(s.extracted) >= maxExtractionCacheEntries {
+			delete(s.extracted, oldestID)
+		}
+	}
+	s.extracted[key] = paper
 }
 
-func (s *ContentAggregationService) processArXivPaper(arxivID string) (string, string, error) {
+func (s *ContentAggregationService) extractArXivPaper(arxivID string, options ExtractionOptions) (extractedPaper, error) {
 	s.logger.Info().Msgf("Processing arXiv paper with ID: %s", arxivID)
 	// Fetch metadata from the database
 	paperMetadata, err := GetReferenceByArxivID(arxivID)
 	if err != nil {
 		s.logger.Error().Err(err).Msgf("Failed to fetch metadata for arXiv paper with ID: %s", arxivID)
-		return "", "", fmt.Errorf("failed to fetch metadata from the database: %v", err)
+		return extractedPaper{}, fmt.Errorf("failed to fetch metadata from the database: %v", err)
 	}
+	paper := extractedPaper{title: paperMetadata.Title, extractedAt: time.Now()}
 
-	// Download the PDF from arXiv
-	pdfURL := fmt.Sprintf("%s%s.pdf", s.arxivBaseURL, arxivID)
-	resp, err := http.Get(pdfURL)
+	// The LaTeX source has the equations as written; papers without one are read from the PDF
+	if options.Math {
+		source, err := s.convertArXivSource(arxivID, paperMetadata.Title)
+		if err != nil {
+			s.logger.Warn().Err(err).Msgf("Failed to convert the source of arXiv paper with ID: %s, extracting its PDF", arxivID)
+		} else {
+			paper.source = source
+			if !options.Figures {
+				return paper, nil
+			}
+		}
+	}
+
+	pdfPath, err := s.downloadArXivPDF(arxivID)
 	if err != nil {
-		s.logger.Error().Err(err).Msgf("Failed to download arXiv paper with ID: %s", arxivID)
-		return "", "", fmt.Errorf("failed to download arXiv paper: %v", err)
+		return extractedPaper{}, err
 	}
-	defer resp.Body.Close()
+	defer os.Remove(pdfPath)
 
-	if resp.StatusCode != http.StatusOK {
-		s.logger.Error().Msgf("Unexpected status code when downloading arXiv paper with ID: %s. Status code: %d", arxivID, resp.StatusCode)
-		return "", "", fmt.Errorf("unexpected status code when downloading arXiv paper: %d", resp.StatusCode)
+	// Process the PDF file
+	if paper.source == nil {
+		extract := s.ExtractTextFromPDF
+		if options.Math {
+			extract = s.ExtractMathTextFromPDF
+		}
+		if paper.content, err = extract(pdfPath); err != nil {
+			s.logger.Error().Err(err).Msgf("Failed to extract text from PDF for arXiv paper with ID: %s", arxivID)
+			return extractedPaper{}, fmt.Errorf("failed to extract text from PDF: %v", err)
+		}
 	}
 
-	// Create a temporary file to store the PDF
-	tempFile, err := os.CreateTemp("", "arxiv-*.pdf")
-	if err != nil {
-		s.logger.Error().Err(err).Msgf("Failed to create temporary file for arXiv paper with ID: %s", arxivID)
-		return "", "", fmt.Errorf("failed to create temporary file: %v", err)
+	// Figures are cropped while the PDF is at hand
+	if options.Figures {
+		figures, err := s.ExtractFiguresFromPDF(pdfPath)
+		if err != nil {
+			s.logger.Error().Err(err).Msgf("Failed to extract figures from PDF for arXiv paper with ID: %s", arxivID)
+			return extractedPaper{}, fmt.Errorf("failed to extract figures from PDF: %v", err)
+		}
+		paper.figures, paper.hasFigures = figures, true
 	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
+	return paper, nil
+}
 
-	// Save the PDF content to the temporary file
-	_, err = io.Copy(tempFile, resp.Body)
+// ExtractArXivVersionText extracts the text of one version of an arXiv paper, so versions can
+// be compared
+func (s *ContentAggregationService) ExtractArXivVersionText(arxivID string, version int) (string, error) {
+	pdfPath, err := s.downloadArXivPDF(fmt.Sprintf("%sv%d", stripArxivVersion(arxivID), version))
 	if err != nil {
-		s.logger.Error().Err(err).Msgf("Failed to save PDF content for arXiv paper with ID: %s", arxivID)
-		return "", "", fmt.Errorf("failed to save PDF content: %v", err)
+		return "", err
 	}
+	defer os.Remove(pdfPath)
+	return s.ExtractTextFromPDF(pdfPath)
+}
 
-	// Process the PDF file
-	content, err := s.ExtractTextFromPDF(tempFile.Name())
+// downloadArXivPDF saves the PDF arXiv serves under name, an ID with or without a version, to
+// a temporary file the caller removes
+func (s *ContentAggregationService) downloadArXivPDF(name string) (string, error) {
+	pdfURL := fmt.Sprintf("%s%s.pdf", s.arxivBaseURL, name)
+	resp, err := http.Get(pdfURL)
 	if err != nil {
-		s.logger.Error().Err(err).Msgf("Failed to extract text from PDF for arXiv paper with ID: %s", arxivID)
-		return "", "", fmt.Errorf("failed to extract text from PDF: %v", err)
+		s.logger.Error().Err(err).Msgf("Failed to download arXiv paper with ID: %s", name)
+		return "", fmt.Errorf("failed to download arXiv paper: %v", err)
 	}
+	defer resp.Body.Close()
 
-	return content, paperMetadata.Title, nil
-}
-
-func (s *ContentAggregationService) ProcessUserPDF(pdfPath string) (string, string, error) {
-	s.logger.Info().Msgf("Processing user PDF with path: %s", pdfPath)
+	if resp.StatusCode != http.StatusOK {
+		s.logger.Error().Msgf("Unexpected status code when downloading arXiv paper with ID: %s. Status code: %d", name, resp.StatusCode)
+		return "", fmt.Errorf("unexpected status code when downloading arXiv paper: %d", resp.StatusCode)
+	}
 
-	// Extract content
-	content, err := s.ExtractTextFromPDF(pdfPath)
+	// Create a temporary file to store the PDF
+	tempFile, err := os.CreateTemp("", "arxiv-*.pdf")
 	if err != nil {
-		s.logger.Error().Err(err).Msgf("Failed to extract text from PDF for path: %s", pdfPath)
-		return "", "", fmt.Errorf("failed to extract text from PDF: %v", err)
+		s.logger.Error().Err(err).Msgf("Failed to create temporary file for arXiv paper with ID: %s", name)
+		return "", fmt.Errorf("failed to create temporary file: %v", err)
 	}
+	defer tempFile.Close()
 
-	title := filepath.Base(pdfPath)
-	title = strings.TrimSuffix(title, ".pdf")
-
-	return content, title, nil
+	// Save the PDF content to the temporary file
+	if _, err := io.Copy(tempFile, resp.Body); err != nil {
+		os.Remove(tempFile.Name())
+		s.logger.Error().Err(err).Msgf("Failed to save PDF content for arXiv paper with ID: %s", name)
+		return "", fmt.Errorf("failed to save PDF content: %v", err)
+	}
+	return tempFile.Name(), nil
 }
 
 func (s *ContentAggregationService) ExtractTextFromPDF(pdfPath string) (string, error) {
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"nexus_scholar_go_backend/internal/utils/docir"
+	"nexus_scholar_go_backend/internal/utils/papertext"
+)
+
+// Trims the corpus planner applies, in the order it tries them
+const (
+	TrimBoilerplate = "boilerplate"
+	TrimReferences  = "references"
+	TrimAppendices  = "appendices"
+	TrimFigures     = "figures"
+	TrimAbstract    = "abstract_only"
+)
+
+var (
+	// ErrInvalidCorpusBudget is returned for token budgets no corpus can be planned for
+	ErrInvalidCorpusBudget = errors.New("invalid token budget")
+	// ErrCorpusOverBudget is returned when a corpus still exceeds its token budget after every
+	// trim the budget allows
+	ErrCorpusOverBudget = errors.New("the documents do not fit the token budget")
+)
+
+// abstractOnlyNote is noted on documents cut down to their abstract, so answers do not treat
+// the abstract as the whole paper
+const abstractOnlyNote = "Only the abstract of this document was loaded to fit the session's token budget."
+
+// abstractFallbackLength is how much of a document without an abstract is kept in its place
+const abstractFallbackLength = 2000
+
+// CorpusBudget asks for a corpus to be trimmed until it fits a number of tokens
+type CorpusBudget struct {
+	MaxTokens int32
+	// AllowAbstracts lets the planner cut the lowest-priority documents, the last ones of the
+	// corpus, down to their abstracts when dropping sections is not enough
+	AllowAbstracts bool
+}
+
+// CorpusPlan is the content a session's cache is created from and what was trimmed to get it
+type CorpusPlan struct {
+	Content            string
+	Documents          []AggregatedDocument
+	Figures            []FigureImage // images the content refers to, cached after it
+	TokenCount         int32         // tokens of the content, the figures and the system instruction
+	OriginalTokenCount int32         // tokens before anything was trimmed
+}
+
+// Trimmed returns the documents that were trimmed
+func (p *CorpusPlan) Trimmed() []AggregatedDocument {
+	var trimmed []AggregatedDocument
+	for _, doc := range p.Documents {
+		if len(doc.Trims) > 0 {
+			trimmed = append(trimmed, doc)
+		}
+	}
+	return trimmed
+}
+
+type corpusTrim struct {
+	name  string
+	apply func(*docir.Document) bool
+}
+
+// sectionTrims drop parts of every document, least valuable first
+var sectionTrims = []corpusTrim{
+	{TrimBoilerplate, func(d *docir.Document) bool { return d.DropLines(papertext.Boilerplate(d.Text())) }},
+	{TrimReferences, (*docir.Document).DropReferences},
+	{TrimAppendices, (*docir.Document).DropAppendices},
+	{TrimFigures, (*docir.Document).DropImages},
+}
+
+// planCorpus renders a corpus and, while it is over budget, trims it: first sections of every
+// document, then, if allowed, whole documents down to their abstracts starting from the last.
+// Without a budget the corpus is rendered and counted as is.
+func planCorpus(
+	corpus []CorpusDocument,
+	budget *CorpusBudget,
+	render func([]CorpusDocument) (string, []AggregatedDocument, error),
+	count func(content string, figures []FigureImage) (int32, error),
+) (*CorpusPlan, error) {
+	if budget != nil && budget.MaxTokens <= 0 {
+		return nil, fmt.Errorf("%w: it must be a positive number of tokens", ErrInvalidCorpusBudget)
+	}
+	// Trims are applied to copies, the collected documents stay as they were
+	corpus = append([]CorpusDocument(nil), corpus...)
+	for i := range corpus {
+		corpus[i].Document = corpus[i].Document.Clone()
+	}
+
+	plan := &CorpusPlan{}
+	measure := func() error {
+		content, documents, err := render(corpus)
+		if err != nil {
+			return err
+		}
+		plan.Content, plan.Documents, plan.Figures = content, documents, corpusFigures(corpus)
+		tokens, err := count(plan.Content, plan.Figures)
+		if err != nil {
+			return err
+		}
+		plan.TokenCount = tokens
+		return nil
+	}
+	if err := measure(); err != nil {
+		return nil, err
+	}
+	plan.OriginalTokenCount = plan.TokenCount
+	fits := func() bool { return budget == nil || plan.TokenCount <= budget.MaxTokens }
+
+	for _, trim := range sectionTrims {
+		if fits() {
+			return plan, nil
+		}
+		changed := false
+		for i := range corpus {
+			if trim.apply(&corpus[i].Document) {
+				corpus[i].Trims = append(append([]string(nil), corpus[i].Trims...), trim.name)
+				changed = true
+			}
+		}
+		if changed {
+			if err := measure(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if budget.AllowAbstracts {
+		for i := len(corpus) - 1; i >= 0 && !fits(); i-- {
+			if !corpus[i].Document.AbstractOnly(abstractFallbackLength, abstractOnlyNote) {
+				continue
+			}
+			corpus[i].Trims = append(append([]string(nil), corpus[i].Trims...), TrimAbstract)
+			if err := measure(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !fits() {
+		return nil, fmt.Errorf("%w: %d tokens after trimming, the budget is %d", ErrCorpusOverBudget, plan.TokenCount, budget.MaxTokens)
+	}
+	return plan, nil
+}
+
+// corpusFigures returns the images the documents of a corpus still refer to, in order
+func corpusFigures(corpus []CorpusDocument) []FigureImage {
+	var figures []FigureImage
+	for _, doc := range corpus {
+		referenced := make(map[string]bool)
+		for _, name := range doc.Document.Images() {
+			referenced[name] = true
+		}
+		for _, figure := range doc.Figures {
+			if referenced[figure.Name] {
+				figures = append(figures, figure)
+			}
+		}
+	}
+	return figures
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"nexus_scholar_go_backend/internal/utils/docir"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// words returns n words the fake token counter counts
+func words(n int) string {
+	return strings.TrimSpace(strings.Repeat("qq ", n))
+}
+
+// countWords counts a token per word of the documents and 50 per figure image
+func countWords(content string, figures []FigureImage) (int32, error) {
+	return int32(strings.Count(content, "qq") + 50*len(figures)), nil
+}
+
+// testCorpus has 1085 tokens: a paper with an abstract, boilerplate, an appendix, references
+// and a figure image (255), then one without an abstract (830)
+func testCorpus() []CorpusDocument {
+	return []CorpusDocument{
+		{
+			AggregatedDocument: AggregatedDocument{Index: 1, Title: "With abstract", Source: "arxiv"},
+			Document: docir.Document{Index: 1, Title: "With abstract", Sections: []docir.Section{
+				{Kind: docir.SectionAbstract, Heading: "Abstract", Blocks: []docir.Block{{Kind: docir.Paragraph, Text: words(10)}}},
+				{Kind: docir.SectionBody, Heading: "1 Introduction", Blocks: []docir.Block{
+					{Kind: docir.Paragraph, Text: words(100) + "\n© 2024 " + words(20)},
+					{Kind: docir.Figure, Label: "Figure 1", Text: words(5), Image: "document-1-figure-1.png"},
+				}},
+				{Kind: docir.SectionAppendix, Heading: "A Proofs", Blocks: []docir.Block{{Kind: docir.Paragraph, Text: words(40)}}},
+			}, References: []docir.Reference{{Label: "[1]", Text: words(30)}}},
+			Figures: []FigureImage{{Label: "Figure 1", Name: "document-1-figure-1.png"}},
+		},
+		{
+			AggregatedDocument: AggregatedDocument{Index: 2, Title: "Without abstract", Source: "upload"},
+			Document: docir.Document{Index: 2, Title: "Without abstract", Sections: []docir.Section{
+				{Kind: docir.SectionBody, Blocks: []docir.Block{
+					{Kind: docir.Paragraph, Text: words(400)},
+					{Kind: docir.Paragraph, Text: words(400)},
+				}},
+			}, References: []docir.Reference{{Label: "[1]", Text: words(30)}}},
+		},
+	}
+}
+
+func TestPlanCorpus(t *testing.T) {
+	render := (&ContentAggregationService{}).RenderCorpus
+	tests := []struct {
+		name    string
+		budget  *CorpusBudget
+		tokens  int32
+		trims   [][]string // per document
+		figures int
+		err     error
+	}{
+		{name: "no budget", tokens: 1085, trims: [][]string{nil, nil}, figures: 1},
+		{name: "within budget", budget: &CorpusBudget{MaxTokens: 1085}, tokens: 1085, trims: [][]string{nil, nil}, figures: 1},
+		{
+			name:   "boilerplate first",
+			budget: &CorpusBudget{MaxTokens: 1070}, tokens: 1065, figures: 1,
+			trims: [][]string{{TrimBoilerplate}, nil},
+		},
+		{
+			name:   "then references and appendices",
+			budget: &CorpusBudget{MaxTokens: 1000}, tokens: 965, figures: 1,
+			trims: [][]string{{TrimBoilerplate, TrimReferences, TrimAppendices}, {TrimReferences}},
+		},
+		{
+			name:   "then figure images",
+			budget: &CorpusBudget{MaxTokens: 950}, tokens: 915,
+			trims: [][]string{{TrimBoilerplate, TrimReferences, TrimAppendices, TrimFigures}, {TrimReferences}},
+		},
+		{name: "abstracts not allowed", budget: &CorpusBudget{MaxTokens: 900}, err: ErrCorpusOverBudget},
+		{
+			name:   "last document cut to its opening without an abstract",
+			budget: &CorpusBudget{MaxTokens: 900, AllowAbstracts: true}, tokens: 515,
+			trims: [][]string{{TrimBoilerplate, TrimReferences, TrimAppendices, TrimFigures}, {TrimReferences, TrimAbstract}},
+		},
+		{
+			name:   "then earlier documents to their abstracts",
+			budget: &CorpusBudget{MaxTokens: 500, AllowAbstracts: true}, tokens: 410,
+			trims: [][]string{{TrimBoilerplate, TrimReferences, TrimAppendices, TrimFigures, TrimAbstract}, {TrimReferences, TrimAbstract}},
+		},
+		{name: "over budget after every trim", budget: &CorpusBudget{MaxTokens: 400, AllowAbstracts: true}, err: ErrCorpusOverBudget},
+		{name: "invalid budget", budget: &CorpusBudget{MaxTokens: 0}, err: ErrInvalidCorpusBudget},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corpus := testCorpus()
+			plan, err := planCorpus(corpus, tt.budget, render, countWords)
+			if tt.err != nil {
+				assert.True(t, errors.Is(err, tt.err), "got %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.tokens, plan.TokenCount)
+			assert.Equal(t, int32(1085), plan.OriginalTokenCount)
+			assert.Len(t, plan.Figures, tt.figures)
+			require.Len(t, plan.Documents, 2)
+			for i, doc := range plan.Documents {
+				assert.Equal(t, tt.trims[i], doc.Trims, "trims of document %d", doc.Index)
+			}
+			assert.Equal(t, strings.Contains(plan.Content, abstractOnlyNote), tt.tokens < 900)
+			// The collected documents are left as they were
+			assert.Equal(t, testCorpus(), corpus)
+		})
+	}
+}
+
+func TestPlanCorpusCountError(t *testing.T) {
+	failing := errors.New("count failed")
+	_, err := planCorpus(testCorpus(), nil, (&ContentAggregationService{}).RenderCorpus, func(string, []FigureImage) (int32, error) {
+		return 0, failing
+	})
+	assert.True(t, errors.Is(err, failing))
+}
@@ -0,0 +1,339 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const (
+	maxDocumentTags      = 20
+	maxDocumentTagLength = 50
+)
+
+// ErrInvalidDocumentTag is returned when tags are empty, too long or too many
+var ErrInvalidDocumentTag = errors.New("invalid document tag")
+
+// DocumentService manages the per-user library of uploaded PDFs, which can be
+// reused across research sessions without uploading them again.
+type DocumentService struct {
+	db              *gorm.DB
+	textExtractor   PDFTextExtractor
+	figureExtractor FigureExtractor
+	mathExtractor   MathTextExtractor
+	cloudStorage    CloudStorageManager
+	bucketName      string
+	logger          zerolog.Logger
+}
+
+func NewDocumentService(db *gorm.DB, textExtractor PDFTextExtractor, figureExtractor FigureExtractor, mathExtractor MathTextExtractor, cloudStorage CloudStorageManager, bucketName string, logger zerolog.Logger) *DocumentService {
+	return &DocumentService{
+		db:              db,
+		textExtractor:   textExtractor,
+		figureExtractor: figureExtractor,
+		mathExtractor:   mathExtractor,
+		cloudStorage:    cloudStorage,
+		bucketName:      bucketName,
+		logger:          logger,
+	}
+}
+
+// UploadDocument extracts the text of a PDF and stores both in the user's library.
+// Uploading a file the user already has returns the existing document.
+func (s *DocumentService) UploadDocument(ctx context.Context, userID uuid.UUID, fileName string, content io.Reader) (*models.Document, error) {
+	s.logger.Info().Str("userID", userID.String()).Msgf("Uploading document %s to library", fileName)
+
+	// pdftotext works on files, so spool the upload to disk while hashing it
+	tempFile, err := os.CreateTemp("", "library-*.pdf")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tempFile, hasher), content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save uploaded file: %w", err)
+	}
+	contentHash := hex.EncodeToString(hasher.Sum(nil))
+
+	var existing models.Document
+	err = s.db.WithContext(ctx).Preload("Tags").Where("user_id = ? AND content_hash = ?", userID, contentHash).First(&existing).Error
+	if err == nil {
+		s.logger.Info().Msgf("Document %s is already in the library as document %d", fileName, existing.ID)
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to check for duplicate document: %w", err)
+	}
+
+	text, err := s.textExtractor.ExtractTextFromPDF(tempFile.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract text from PDF: %w", err)
+	}
+
+	objectID := uuid.New().String()
+	document := &models.Document{
+		UserID:         userID,
+		Title:          strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName)),
+		FileName:       filepath.Base(fileName),
+		ContentType:    "application/pdf",
+		SizeBytes:      size,
+		ContentHash:    contentHash,
+		StorageKey:     fmt.Sprintf("documents/%s/%s.pdf", userID, objectID),
+		TextStorageKey: fmt.Sprintf("documents/%s/%s.txt", userID, objectID),
+		TextLength:     len(text),
+	}
+
+	if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind uploaded file: %w", err)
+	}
+	if err := s.cloudStorage.UploadFile(ctx, s.bucketName, document.StorageKey, tempFile); err != nil {
+		return nil, fmt.Errorf("failed to store document: %w", err)
+	}
+	if err := s.cloudStorage.UploadFile(ctx, s.bucketName, document.TextStorageKey, strings.NewReader(text)); err != nil {
+		s.deleteStoredFiles(ctx, document)
+		return nil, fmt.Errorf("failed to store document text: %w", err)
+	}
+
+	if err := s.db.WithContext(ctx).Create(document).Error; err != nil {
+		s.logger.Error().Err(err).Msg("Failed to save document")
+		s.deleteStoredFiles(ctx, document)
+		return nil, fmt.Errorf("failed to save document: %w", err)
+	}
+
+	s.logger.Info().Msgf("Document %d added to library of user %s", document.ID, userID)
+	return document, nil
+}
+
+// ListDocuments returns the user's documents, newest first, optionally only those with the given tag
+func (s *DocumentService) ListDocuments(ctx context.Context, userID uuid.UUID, tag string) ([]models.Document, error) {
+	query := s.db.WithContext(ctx).Preload("Tags").Where("documents.user_id = ?", userID)
+	if tag != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM document_tags WHERE document_tags.document_id = documents.id AND document_tags.name = ? AND document_tags.deleted_at IS NULL)", normalizeTag(tag))
+	}
+
+	var documents []models.Document
+	if err := query.Order("documents.created_at desc").Find(&documents).Error; err != nil {
+		s.logger.Error().Err(err).Msg("Failed to list documents")
+		return nil, fmt.Errorf("failed to list documents: %w", err)
+	}
+	return documents, nil
+}
+
+// GetDocument returns one of the user's documents. It returns gorm.ErrRecordNotFound
+// when the document does not exist or belongs to another user.
+func (s *DocumentService) GetDocument(ctx context.Context, userID uuid.UUID, documentID uint) (*models.Document, error) {
+	var document models.Document
+	err := s.db.WithContext(ctx).Preload("Tags").Where("id = ? AND user_id = ?", documentID, userID).First(&document).Error
+	if err != nil {
+		return nil, err
+	}
+	return &document, nil
+}
+
+// OpenDocumentFile streams the original PDF of a document; the caller must close the reader
+func (s *DocumentService) OpenDocumentFile(ctx context.Context, userID uuid.UUID, documentID uint) (*models.Document, io.ReadCloser, error) {
+	document, err := s.GetDocument(ctx, userID, documentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := s.cloudStorage.DownloadFile(ctx, s.bucketName, document.StorageKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open document file: %w", err)
+	}
+	return document, reader, nil
+}
+
+// GetDocumentText returns the text extracted from a document when it was uploaded
+func (s *DocumentService) GetDocumentText(ctx context.Context, userID uuid.UUID, documentID uint) (string, error) {
+	document, err := s.GetDocument(ctx, userID, documentID)
+	if err != nil {
+		return "", err
+	}
+	return s.readText(ctx, document)
+}
+
+// GetUserDocuments loads the given library documents with their text, in the requested order.
+// With options.Figures, their figures and tables are cropped from the stored PDFs. With
+// options.Math, their text is extracted again with the equations as LaTeX, if the math
+// extractor can recognize equations; the text stored on upload is used otherwise.
+func (s *DocumentService) GetUserDocuments(ctx context.Context, userID uuid.UUID, documentIDs []uint, options ExtractionOptions) ([]UserDocument, error) {
+	reextract := options.Math && s.mathExtractor.RecognizesEquations()
+	userDocuments := make([]UserDocument, 0, len(documentIDs))
+	for _, documentID := range documentIDs {
+		document, err := s.GetDocument(ctx, userID, documentID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("document %d not found in library: %w", documentID, err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load document %d: %w", documentID, err)
+		}
+		userDocument := UserDocument{DocumentID: document.ID, Title: document.Title}
+		if !reextract {
+			if userDocument.Content, err = s.readText(ctx, document); err != nil {
+				return nil, err
+			}
+		}
+		if reextract || options.Figures {
+			if err := s.extractFromPDF(ctx, document, &userDocument, options); err != nil {
+				return nil, err
+			}
+		}
+		userDocuments = append(userDocuments, userDocument)
+	}
+	return userDocuments, nil
+}
+
+// extractFromPDF extracts what the options ask for from the stored PDF of a document, which
+// the extractors need on disk: the text with its equations in the math mode, when the stored
+// text was not loaded, and the figures and tables
+func (s *DocumentService) extractFromPDF(ctx context.Context, document *models.Document, userDocument *UserDocument, options ExtractionOptions) error {
+	reader, err := s.cloudStorage.DownloadFile(ctx, s.bucketName, document.StorageKey)
+	if err != nil {
+		return fmt.Errorf("failed to open document file %d: %w", document.ID, err)
+	}
+	defer reader.Close()
+
+	tempFile, err := os.CreateTemp("", "library-*.pdf")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+	if _, err := io.Copy(tempFile, reader); err != nil {
+		return fmt.Errorf("failed to read document file %d: %w", document.ID, err)
+	}
+
+	if options.Math && userDocument.Content == "" {
+		if userDocument.Content, err = s.mathExtractor.ExtractMathTextFromPDF(tempFile.Name()); err != nil {
+			return fmt.Errorf("failed to extract text of document %d: %w", document.ID, err)
+		}
+	}
+	if options.Figures {
+		if userDocument.Figures, err = s.figureExtractor.ExtractFiguresFromPDF(tempFile.Name()); err != nil {
+			return fmt.Errorf("failed to extract figures of document %d: %w", document.ID, err)
+		}
+	}
+	return nil
+}
+
+// SetTags replaces the tags of a document. Tags are trimmed, lower-cased and de-duplicated.
+func (s *DocumentService) SetTags(ctx context.Context, userID uuid.UUID, documentID uint, tags []string) (*models.Document, error) {
+	names, err := normalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	document, err := s.GetDocument(ctx, userID, documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("document_id = ?", document.ID).Delete(&models.DocumentTag{}).Error; err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return nil
+		}
+		newTags := make([]models.DocumentTag, len(names))
+		for i, name := range names {
+			newTags[i] = models.DocumentTag{DocumentID: document.ID, Name: name}
+		}
+		return tx.Create(&newTags).Error
+	})
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to set tags of document %d", documentID)
+		return nil, fmt.Errorf("failed to set document tags: %w", err)
+	}
+	return s.GetDocument(ctx, userID, documentID)
+}
+
+// DeleteDocument removes a document and its stored files from the user's library, and from
+// the projects it belongs to. Sessions that already used the document keep their own copy of
+// its text.
+func (s *DocumentService) DeleteDocument(ctx context.Context, userID uuid.UUID, documentID uint) error {
+	document, err := s.GetDocument(ctx, userID, documentID)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("document_id = ?", document.ID).Delete(&models.DocumentTag{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("document_id = ?", document.ID).Delete(&models.ProjectDocument{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(document).Error
+	})
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to delete document %d", documentID)
+		return fmt.Errorf("failed to delete document: %w", err)
+	}
+
+	s.deleteStoredFiles(ctx, document)
+	return nil
+}
+
+func (s *DocumentService) readText(ctx context.Context, document *models.Document) (string, error) {
+	reader, err := s.cloudStorage.DownloadFile(ctx, s.bucketName, document.TextStorageKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to open text of document %d: %w", document.ID, err)
+	}
+	defer reader.Close()
+
+	var text strings.Builder
+	text.Grow(document.TextLength)
+	if _, err := io.Copy(&text, reader); err != nil {
+		return "", fmt.Errorf("failed to read text of document %d: %w", document.ID, err)
+	}
+	return text.String(), nil
+}
+
+func (s *DocumentService) deleteStoredFiles(ctx context.Context, document *models.Document) {
+	for _, key := range []string{document.StorageKey, document.TextStorageKey} {
+		err := s.cloudStorage.DeleteFile(ctx, s.bucketName, key)
+		if err != nil && !errors.Is(err, ErrStorageObjectNotFound) {
+			s.logger.Error().Err(err).Msgf("Failed to delete stored file %s", key)
+		}
+	}
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	var names []string
+	for _, tag := range tags {
+		name := normalizeTag(tag)
+		if name == "" || len(name) > maxDocumentTagLength {
+			return nil, fmt.Errorf("%w: tags must be 1 to %d characters", ErrInvalidDocumentTag, maxDocumentTagLength)
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) > maxDocumentTags {
+		return nil, fmt.Errorf("%w: at most %d tags per document", ErrInvalidDocumentTag, maxDocumentTags)
+	}
+	sort.Strings(names)
+	return names, nil
+}
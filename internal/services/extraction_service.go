@@ -0,0 +1,570 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/utils/broker"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const (
+	maxExtractionColumns       = 20
+	maxColumnNameLength        = 100
+	maxColumnDescriptionLength = 1000
+	extractionDocumentTimeout  = 2 * time.Minute
+
+	ExportFormatCSV = "csv"
+)
+
+// Column types of extraction tables
+const (
+	ColumnText    = "text"
+	ColumnNumber  = "number"
+	ColumnBoolean = "boolean"
+	ColumnList    = "list"
+)
+
+// ErrInvalidExtraction is returned when an extraction table definition fails validation
+var ErrInvalidExtraction = errors.New("invalid extraction table")
+
+// ExtractionColumnInput defines one question of an extraction table
+type ExtractionColumnInput struct {
+	Name        string
+	Description string
+	Type        string // text, number, boolean or list; defaults to text
+}
+
+// ExtractionTableInput defines an extraction table
+type ExtractionTableInput struct {
+	Name    string
+	Columns []ExtractionColumnInput
+}
+
+// ExtractionRow is one document of an extraction table with its cells by column ID
+type ExtractionRow struct {
+	Document models.ChatDocument
+	Cells    map[uint]models.ExtractionCell
+}
+
+type ExtractionService struct {
+	db          *gorm.DB
+	authorizer  SessionAuthorizer
+	chatService ChatServiceDB
+	meter       UsageMeter
+	corpus      corpusAccess
+	logger      zerolog.Logger
+}
+
+func NewExtractionService(
+	db *gorm.DB,
+	authorizer SessionAuthorizer,
+	chatService ChatServiceDB,
+	meter UsageMeter,
+	cacheManager CacheManager,
+	cacheServiceDB CacheServiceDB,
+	activity SessionActivity,
+	messageBroker *broker.Broker,
+	logger zerolog.Logger,
+) *ExtractionService {
+	return &ExtractionService{
+		db:          db,
+		authorizer:  authorizer,
+		chatService: chatService,
+		meter:       meter,
+		corpus: corpusAccess{
+			cacheManager:   cacheManager,
+			cacheServiceDB: cacheServiceDB,
+			activity:       activity,
+			messageBroker:  messageBroker,
+		},
+		logger: logger,
+	}
+}
+
+// CreateTable defines an extraction table over the documents of a running session and starts
+// extracting in the background, billed to the user on the session's price tier. Progress is
+// published on the session's job topic.
+func (s *ExtractionService) CreateTable(ctx context.Context, userID uuid.UUID, sessionID string, input ExtractionTableInput) (*models.ExtractionTable, error) {
+	chat, err := s.authorizer.AuthorizeSession(ctx, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateExtractionInput(&input); err != nil {
+		return nil, err
+	}
+	documents, err := s.chatService.GetChatDocumentsFromDB(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session documents: %w", err)
+	}
+	if len(documents) == 0 {
+		return nil, fmt.Errorf("%w: the session has no documents", ErrInvalidExtraction)
+	}
+	if _, cache, err := s.corpus.model(ctx, sessionID); err != nil {
+		return nil, err
+	} else if err := checkJobCredit(ctx, s.meter, userID, cache); err != nil {
+		return nil, err
+	}
+
+	table := &models.ExtractionTable{
+		ChatID: chat.ID,
+		UserID: userID,
+		Name:   input.Name,
+		Status: JobPending,
+		Total:  len(documents),
+	}
+	for i, column := range input.Columns {
+		table.Columns = append(table.Columns, models.ExtractionColumn{
+			Position:    i,
+			Name:        column.Name,
+			Description: column.Description,
+			Type:        column.Type,
+		})
+	}
+	if err := s.db.WithContext(ctx).Create(table).Error; err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to create extraction table for session %s", sessionID)
+		return nil, fmt.Errorf("failed to create extraction table: %w", err)
+	}
+
+	go s.run(table.ID, sessionID)
+	return table, nil
+}
+
+// ListTables returns the extraction tables of a session with their columns, newest first
+func (s *ExtractionService) ListTables(ctx context.Context, userID uuid.UUID, sessionID string) ([]models.ExtractionTable, error) {
+	chat, err := s.authorizer.AuthorizeSession(ctx, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var tables []models.ExtractionTable
+	err = s.db.WithContext(ctx).
+		Preload("Columns", func(db *gorm.DB) *gorm.DB { return db.Order("position asc") }).
+		Where("chat_id = ?", chat.ID).
+		Order("created_at desc").
+		Find(&tables).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list extraction tables: %w", err)
+	}
+	return tables, nil
+}
+
+// GetTable returns an extraction table with its rows, one per document of the session
+func (s *ExtractionService) GetTable(ctx context.Context, userID uuid.UUID, sessionID string, tableID uint) (*models.ExtractionTable, []ExtractionRow, error) {
+	chat, err := s.authorizer.AuthorizeSession(ctx, userID, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var table models.ExtractionTable
+	err = s.db.WithContext(ctx).
+		Preload("Columns", func(db *gorm.DB) *gorm.DB { return db.Order("position asc") }).
+		Preload("Cells").
+		Where("id = ? AND chat_id = ?", tableID, chat.ID).
+		First(&table).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	documents, err := s.chatService.GetChatDocumentsFromDB(sessionID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get session documents: %w", err)
+	}
+
+	rows := make([]ExtractionRow, len(documents))
+	byPosition := make(map[int]int, len(documents))
+	for i, doc := range documents {
+		rows[i] = ExtractionRow{Document: doc, Cells: map[uint]models.ExtractionCell{}}
+		byPosition[doc.Position] = i
+	}
+	for _, cell := range table.Cells {
+		if i, ok := byPosition[cell.DocumentPosition]; ok {
+			rows[i].Cells[cell.ColumnID] = cell
+		}
+	}
+	return &table, rows, nil
+}
+
+// DeleteTable deletes an extraction table; only its creator and the session's owner may.
+// A running extraction stops after the document it is working on.
+func (s *ExtractionService) DeleteTable(ctx context.Context, userID uuid.UUID, sessionID string, tableID uint) error {
+	chat, err := s.authorizer.AuthorizeSession(ctx, userID, sessionID)
+	if err != nil {
+		return err
+	}
+	var table models.ExtractionTable
+	if err := s.db.WithContext(ctx).Where("id = ? AND chat_id = ?", tableID, chat.ID).First(&table).Error; err != nil {
+		return err
+	}
+	if table.UserID != userID && chat.UserID != userID {
+		return gorm.ErrRecordNotFound
+	}
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("table_id = ?", table.ID).Delete(&models.ExtractionCell{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("table_id = ?", table.ID).Delete(&models.ExtractionColumn{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&table).Error
+	})
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to delete extraction table %d", tableID)
+		return fmt.Errorf("failed to delete extraction table: %w", err)
+	}
+	return nil
+}
+
+// ExportTable renders an extraction table as CSV, with a quote column after every value
+// column, or as JSON
+func (s *ExtractionService) ExportTable(ctx context.Context, userID uuid.UUID, sessionID string, tableID uint, format string) (*ChatExport, error) {
+	if format != ExportFormatCSV && format != ExportFormatJSON {
+		return nil, fmt.Errorf("%w: %q, expected csv or json", ErrInvalidExportFormat, format)
+	}
+	table, rows, err := s.GetTable(ctx, userID, sessionID, tableID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &ChatExport{FileName: fmt.Sprintf("extraction-%d.%s", table.ID, format)}
+	if format == ExportFormatJSON {
+		export.ContentType = "application/json"
+		export.Content, err = json.MarshalIndent(extractionJSON(table, rows), "", "  ")
+	} else {
+		export.ContentType = "text/csv; charset=utf-8"
+		export.Content, err = extractionCSV(table, rows)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to render %s export: %w", format, err)
+	}
+	return export, nil
+}
+
+// run extracts the documents of a table that have no cells yet, one at a time, and bills each
+// to the table's creator. The table fails once the creator's budget is used up.
+func (s *ExtractionService) run(tableID uint, sessionID string) {
+	ctx := context.Background()
+	var table models.ExtractionTable
+	err := s.db.Preload("Columns", func(db *gorm.DB) *gorm.DB { return db.Order("position asc") }).First(&table, tableID).Error
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to load extraction table %d", tableID)
+		return
+	}
+	documents, err := s.chatService.GetChatDocumentsFromDB(sessionID)
+	if err != nil {
+		s.fail(&table, sessionID, fmt.Errorf("failed to get session documents: %w", err))
+		return
+	}
+	var extracted []int
+	if err := s.db.Model(&models.ExtractionCell{}).Where("table_id = ?", table.ID).Distinct().Pluck("document_position", &extracted).Error; err != nil {
+		s.fail(&table, sessionID, fmt.Errorf("failed to get extracted documents: %w", err))
+		return
+	}
+	done := make(map[int]bool, len(extracted))
+	for _, position := range extracted {
+		done[position] = true
+	}
+
+	table.Status = JobRunning
+	s.save(&table, sessionID)
+
+	for _, doc := range documents {
+		if done[doc.Position] {
+			continue
+		}
+		// Stop when the table was deleted meanwhile
+		if err := s.db.Select("id").First(&models.ExtractionTable{}, table.ID).Error; err != nil {
+			return
+		}
+
+		model, cache, err := s.corpus.model(ctx, sessionID)
+		if err != nil {
+			s.fail(&table, sessionID, err)
+			return
+		}
+		if err := checkJobCredit(ctx, s.meter, table.UserID, cache); err != nil {
+			s.fail(&table, sessionID, err)
+			return
+		}
+		docCtx, cancel := context.WithTimeout(ctx, extractionDocumentTimeout)
+		resp, cells, err := extractDocument(docCtx, model, table.Columns, doc)
+		cancel()
+		if resp != nil {
+			tokens, tokenHours, err := billJob(ctx, s.meter, table.UserID, cache, resp)
+			if err != nil {
+				s.logger.Error().Err(err).Msgf("Failed to bill document %d of extraction table %d", doc.Position, table.ID)
+			}
+			table.TokenCountUsed += tokens
+			table.TokenHoursUsed += tokenHours
+		}
+		if err != nil {
+			s.logger.Error().Err(err).Msgf("Failed to extract document %d for table %d", doc.Position, table.ID)
+			table.Failed++
+		} else if err := s.db.Create(&cells).Error; err != nil {
+			s.fail(&table, sessionID, fmt.Errorf("failed to save cells: %w", err))
+			return
+		}
+		table.Completed++
+		s.save(&table, sessionID)
+
+		if err := s.corpus.keepAlive(ctx, sessionID); err != nil {
+			s.logger.Warn().Err(err).Msgf("Failed to record activity of session %s", sessionID)
+		}
+	}
+
+	table.Status = JobCompleted
+	s.save(&table, sessionID)
+	s.logger.Info().Msgf("Extraction table %d completed with %d of %d documents failed", table.ID, table.Failed, table.Total)
+}
+
+func (s *ExtractionService) fail(table *models.ExtractionTable, sessionID string, err error) {
+	s.logger.Error().Err(err).Msgf("Extraction table %d failed", table.ID)
+	table.Status = JobFailed
+	table.Error = err.Error()
+	s.save(table, sessionID)
+}
+
+// save records the progress of a table and publishes it
+func (s *ExtractionService) save(table *models.ExtractionTable, sessionID string) {
+	err := s.db.Model(table).Updates(map[string]interface{}{
+		"status":           table.Status,
+		"error":            table.Error,
+		"completed":        table.Completed,
+		"failed":           table.Failed,
+		"token_count_used": table.TokenCountUsed,
+		"token_hours_used": table.TokenHoursUsed,
+	}).Error
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to save progress of extraction table %d", table.ID)
+	}
+	s.corpus.publish(sessionID, JobEvent{
+		Kind:      "extraction",
+		JobID:     table.ID,
+		Status:    table.Status,
+		Completed: table.Completed,
+		Total:     table.Total,
+		Error:     table.Error,
+	})
+}
+
+// extractDocument answers every column of a table for one document with a response
+// constrained to a JSON schema of the columns. The response is returned whenever the model
+// answered, even if the answer cannot be parsed, so it is billed.
+func extractDocument(ctx context.Context, model *genai.GenerativeModel, columns []models.ExtractionColumn, doc models.ChatDocument) (*genai.GenerateContentResponse, []models.ExtractionCell, error) {
+	properties := make(map[string]*genai.Schema, len(columns))
+	required := make([]string, len(columns))
+	var fields strings.Builder
+	for i, column := range columns {
+		key := columnKey(i)
+		required[i] = key
+		properties[key] = &genai.Schema{
+			Type:        genai.TypeObject,
+			Description: column.Name,
+			Properties: map[string]*genai.Schema{
+				"value": columnValueSchema(column.Type),
+				"quote": {Type: genai.TypeString, Description: "Verbatim passage of the document that supports the value"},
+				"found": {Type: genai.TypeBoolean, Description: "Whether the document reports this at all"},
+			},
+			Required: []string{"value", "quote", "found"},
+		}
+		fmt.Fprintf(&fields, "- %s: %s", key, column.Name)
+		if column.Description != "" {
+			fmt.Fprintf(&fields, " (%s)", column.Description)
+		}
+		fields.WriteString("\n")
+	}
+
+	model.SetTemperature(0)
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = &genai.Schema{Type: genai.TypeObject, Properties: properties, Required: required}
+
+	prompt := fmt.Sprintf("Extract the following fields from <Document %d> (%q) only, ignoring the other documents. "+
+		"For every field give its value and a short verbatim quote of the document that supports it. "+
+		"If the document does not report a field, set found to false and leave value and quote empty.\n\nFields:\n%s",
+		doc.Position, doc.Title, fields.String())
+	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate extraction: %w", err)
+	}
+
+	var answers map[string]struct {
+		Value json.RawMessage `json:"value"`
+		Quote string          `json:"quote"`
+		Found bool            `json:"found"`
+	}
+	if err := json.Unmarshal([]byte(responseText(resp)), &answers); err != nil {
+		return resp, nil, fmt.Errorf("failed to parse extraction: %w", err)
+	}
+
+	cells := make([]models.ExtractionCell, len(columns))
+	for i, column := range columns {
+		answer := answers[columnKey(i)]
+		cells[i] = models.ExtractionCell{
+			TableID:          column.TableID,
+			ColumnID:         column.ID,
+			DocumentPosition: doc.Position,
+			Found:            answer.Found,
+		}
+		if answer.Found {
+			cells[i].Value = cellText(answer.Value)
+			cells[i].Quote = strings.TrimSpace(answer.Quote)
+		}
+	}
+	return resp, cells, nil
+}
+
+// columnKey names a column in the response schema; user-chosen names may not be valid keys
+func columnKey(position int) string {
+	return "field_" + strconv.Itoa(position+1)
+}
+
+func columnValueSchema(columnType string) *genai.Schema {
+	switch columnType {
+	case ColumnNumber:
+		return &genai.Schema{Type: genai.TypeNumber}
+	case ColumnBoolean:
+		return &genai.Schema{Type: genai.TypeBoolean}
+	case ColumnList:
+		return &genai.Schema{Type: genai.TypeArray, Items: &genai.Schema{Type: genai.TypeString}}
+	default:
+		return &genai.Schema{Type: genai.TypeString}
+	}
+}
+
+// cellText renders a JSON value of the response as the text of a cell
+func cellText(raw json.RawMessage) string {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return ""
+	}
+	switch v := value.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "yes"
+		}
+		return "no"
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			if text, ok := item.(string); ok && strings.TrimSpace(text) != "" {
+				items = append(items, strings.TrimSpace(text))
+			}
+		}
+		return strings.Join(items, "; ")
+	default:
+		return ""
+	}
+}
+
+func validateExtractionInput(input *ExtractionTableInput) error {
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" || len([]rune(input.Name)) > maxChatTitleLength {
+		return fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalidExtraction, maxChatTitleLength)
+	}
+	if len(input.Columns) == 0 || len(input.Columns) > maxExtractionColumns {
+		return fmt.Errorf("%w: a table needs 1 to %d columns", ErrInvalidExtraction, maxExtractionColumns)
+	}
+	names := make(map[string]bool, len(input.Columns))
+	for i := range input.Columns {
+		column := &input.Columns[i]
+		column.Name = strings.TrimSpace(column.Name)
+		column.Description = strings.TrimSpace(column.Description)
+		if column.Name == "" || len([]rune(column.Name)) > maxColumnNameLength {
+			return fmt.Errorf("%w: column names must be 1 to %d characters", ErrInvalidExtraction, maxColumnNameLength)
+		}
+		if names[strings.ToLower(column.Name)] {
+			return fmt.Errorf("%w: duplicate column %q", ErrInvalidExtraction, column.Name)
+		}
+		names[strings.ToLower(column.Name)] = true
+		if len([]rune(column.Description)) > maxColumnDescriptionLength {
+			return fmt.Errorf("%w: column descriptions must be at most %d characters", ErrInvalidExtraction, maxColumnDescriptionLength)
+		}
+		switch column.Type {
+		case "":
+			column.Type = ColumnText
+		case ColumnText, ColumnNumber, ColumnBoolean, ColumnList:
+		default:
+			return fmt.Errorf("%w: column type must be text, number, boolean or list", ErrInvalidExtraction)
+		}
+	}
+	return nil
+}
+
+func extractionCSV(table *models.ExtractionTable, rows []ExtractionRow) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	header := []string{"Document", "Title"}
+	for _, column := range table.Columns {
+		header = append(header, column.Name, column.Name+" (quote)")
+	}
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		record := []string{strconv.Itoa(row.Document.Position), row.Document.Title}
+		for _, column := range table.Columns {
+			cell := row.Cells[column.ID]
+			record = append(record, cell.Value, cell.Quote)
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+type extractionCellJSON struct {
+	Value string `json:"value"`
+	Quote string `json:"quote"`
+	Found bool   `json:"found"`
+}
+
+type extractionRowJSON struct {
+	Document int                           `json:"document"`
+	Title    string                        `json:"title"`
+	Cells    map[string]extractionCellJSON `json:"cells"` // by column name; missing until extracted
+}
+
+type extractionColumnJSON struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+}
+
+type extractionTableJSON struct {
+	Name    string                 `json:"name"`
+	Status  string                 `json:"status"`
+	Columns []extractionColumnJSON `json:"columns"`
+	Rows    []extractionRowJSON    `json:"rows"`
+}
+
+func extractionJSON(table *models.ExtractionTable, rows []ExtractionRow) extractionTableJSON {
+	doc := extractionTableJSON{Name: table.Name, Status: table.Status}
+	for _, column := range table.Columns {
+		doc.Columns = append(doc.Columns, extractionColumnJSON{Name: column.Name, Description: column.Description, Type: column.Type})
+	}
+	for _, row := range rows {
+		entry := extractionRowJSON{Document: row.Document.Position, Title: row.Document.Title, Cells: map[string]extractionCellJSON{}}
+		for _, column := range table.Columns {
+			if cell, ok := row.Cells[column.ID]; ok {
+				entry.Cells[column.Name] = extractionCellJSON{Value: cell.Value, Quote: cell.Quote, Found: cell.Found}
+			}
+		}
+		doc.Rows = append(doc.Rows, entry)
+	}
+	return doc
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"nexus_scholar_go_backend/internal/utils/docir"
+	"nexus_scholar_go_backend/internal/utils/pdflayout"
+)
+
+// ErrFiguresRequireProTier is returned when figure images are asked for on a tier that does
+// not cache them
+var ErrFiguresRequireProTier = errors.New("figure and table images are only cached on the pro tier")
+
+const (
+	// figureDPI is the resolution figures are rendered at, enough to read axis labels
+	figureDPI = 150
+	// maxFiguresPerDocument bounds how many images a document adds to a cache
+	maxFiguresPerDocument = 20
+)
+
+// FigureImage is a figure or table of a document cropped from its PDF
+type FigureImage struct {
+	Label string // "Figure 2" or "Table 1", as in the document's structured text
+	Page  int
+	PNG   []byte
+	// Name is how the corpus markup refers to the image, set once the image is attached to a
+	// document of a corpus
+	Name string
+}
+
+// figureImageName returns the name of a figure's image in a corpus, such as
+// "document-2-figure-3.png"
+func figureImageName(documentIndex int, label string) string {
+	return fmt.Sprintf("document-%d-%s.png", documentIndex, strings.ToLower(strings.ReplaceAll(label, " ", "-")))
+}
+
+// attachFigures links the figures of a document to their captions in its structured text.
+// Figures whose caption was not recognized in the text are left out.
+func attachFigures(doc *CorpusDocument, figures []FigureImage) {
+	for _, figure := range figures {
+		name := figureImageName(doc.Index, figure.Label)
+		attached := false
+		for i := range doc.Document.Sections {
+			for j := range doc.Document.Sections[i].Blocks {
+				block := &doc.Document.Sections[i].Blocks[j]
+				if (block.Kind == docir.Figure || block.Kind == docir.Table) && block.Label == figure.Label && block.Image == "" {
+					block.Image = name
+					attached = true
+					break
+				}
+			}
+			if attached {
+				break
+			}
+		}
+		if attached {
+			figure.Name = name
+			doc.Figures = append(doc.Figures, figure)
+		}
+	}
+}
+
+// ExtractFiguresFromPDF crops the figures and tables of a PDF into PNG images. They are
+// located from their captions in the page layout, see pdflayout.Regions. Figures that cannot
+// be rendered are skipped.
+func (s *ContentAggregationService) ExtractFiguresFromPDF(pdfPath string) ([]FigureImage, error) {
+	s.logger.Info().Msgf("Extracting figures from PDF with path: %s", pdfPath)
+	if _, err := exec.LookPath("pdftoppm"); err != nil {
+		s.logger.Error().Err(err).Msg("pdftoppm is not installed")
+		return nil, fmt.Errorf("pdftoppm is not installed: %v", err)
+	}
+
+	pages, err := s.pageLayout(pdfPath)
+	if err != nil {
+		return nil, err
+	}
+	regions := pdflayout.Regions(pages)
+	if len(regions) > maxFiguresPerDocument {
+		regions = regions[:maxFiguresPerDocument]
+	}
+
+	tempDir, err := os.MkdirTemp("", "figures-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var figures []FigureImage
+	for i, region := range regions {
+		image, err := renderRegion(pdfPath, region, filepath.Join(tempDir, strconv.Itoa(i)))
+		if err != nil {
+			s.logger.Error().Err(err).Msgf("Failed to render %s on page %d of PDF with path: %s", region.Label, region.Page, pdfPath)
+			continue
+		}
+		figures = append(figures, FigureImage{Label: region.Label, Page: region.Page, PNG: image})
+	}
+	s.logger.Info().Msgf("Extracted %d figures and tables from PDF with path: %s", len(figures), pdfPath)
+	return figures, nil
+}
+
+// pageLayout reads the layout of the text of a PDF's pages
+func (s *ContentAggregationService) pageLayout(pdfPath string) ([]pdflayout.Page, error) {
+	cmd := exec.Command("pdftotext", "-bbox-layout", "-q", pdfPath, "-")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to run pdftotext for PDF with path: %s", pdfPath)
+		return nil, fmt.Errorf("failed to run pdftotext: %v", err)
+	}
+	return pdflayout.Parse(&out)
+}
+
+// renderRegion renders a region of a page to a PNG with pdftoppm, whose crop box is in pixels
+func renderRegion(pdfPath string, region pdflayout.Region, outputPrefix string) ([]byte, error) {
+	pixels := func(points float64) string {
+		return strconv.Itoa(int(points * figureDPI / 72))
+	}
+	page := strconv.Itoa(region.Page)
+	cmd := exec.Command("pdftoppm",
+		"-png",
+		"-r", strconv.Itoa(figureDPI),
+		"-f", page, "-l", page,
+		"-x", pixels(region.Box.XMin),
+		"-y", pixels(region.Box.YMin),
+		"-W", pixels(region.Box.Width()),
+		"-H", pixels(region.Box.Height()),
+		"-singlefile",
+		pdfPath,
+		outputPrefix,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to run pdftoppm: %v: %s", err, output)
+	}
+	return os.ReadFile(outputPrefix + ".png")
+}
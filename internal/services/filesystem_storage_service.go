@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// Uploads are written to a temporary file next to the target and renamed into
+// place, so readers never observe a partially written object.
+const fsUploadTempPrefix = ".upload-"
+
+// FileSystemStorage stores objects on the local filesystem. Each bucket is a
+// directory under the root and object names map to paths inside it.
+type FileSystemStorage struct {
+	rootDir string
+	logger  zerolog.Logger
+}
+
+func NewFileSystemStorage(rootDir string, logger zerolog.Logger) (*FileSystemStorage, error) {
+	absRoot, err := filepath.Abs(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve storage root: %w", err)
+	}
+	if err := os.MkdirAll(absRoot, 0o750); err != nil {
+		logger.Error().Err(err).Msgf("Failed to create storage root: %s", absRoot)
+		return nil, fmt.Errorf("failed to create storage root: %w", err)
+	}
+	logger.Info().Msgf("Filesystem storage initialized at %s", absRoot)
+	return &FileSystemStorage{rootDir: absRoot, logger: logger}, nil
+}
+
+func (s *FileSystemStorage) EnsureBucket(ctx context.Context, bucketName string) error {
+	bucketDir, err := s.bucketDir(bucketName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(bucketDir, 0o750); err != nil {
+		return fmt.Errorf("failed to create bucket directory: %w", err)
+	}
+	return nil
+}
+
+func (s *FileSystemStorage) UploadFile(ctx context.Context, bucketName, objectName string, content io.Reader) error {
+	s.logger.Info().Msgf("Uploading file to bucket: %s, object: %s", bucketName, objectName)
+	objectPath, err := s.objectPath(bucketName, objectName)
+	if err != nil {
+		return err
+	}
+	if err := s.checkBucket(bucketName); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(objectPath)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("failed to create object directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, fsUploadTempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := io.Copy(tmp, content); err != nil {
+		tmp.Close()
+		s.logger.Error().Err(err).Msgf("Failed to write object: %s", objectName)
+		return fmt.Errorf("failed to write object: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), objectPath); err != nil {
+		return fmt.Errorf("failed to move object into place: %w", err)
+	}
+	s.logger.Info().Msgf("File uploaded successfully to bucket: %s, object: %s", bucketName, objectName)
+	return nil
+}
+
+func (s *FileSystemStorage) DownloadFile(ctx context.Context, bucketName, objectName string) (io.ReadCloser, error) {
+	s.logger.Info().Msgf("Downloading file from bucket: %s, object: %s", bucketName, objectName)
+	objectPath, err := s.objectPath(bucketName, objectName)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(objectPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("object %s: %w", objectName, ErrStorageObjectNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open object: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat object: %w", err)
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, fmt.Errorf("object %s: %w", objectName, ErrStorageObjectNotFound)
+	}
+	return file, nil
+}
+
+func (s *FileSystemStorage) DeleteFile(ctx context.Context, bucketName, objectName string) error {
+	s.logger.Info().Msgf("Deleting file from bucket: %s, object: %s", bucketName, objectName)
+	objectPath, err := s.objectPath(bucketName, objectName)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(objectPath); errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return fmt.Errorf("object %s: %w", objectName, ErrStorageObjectNotFound)
+	}
+	if err := os.Remove(objectPath); err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to delete object: %s", objectName)
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+
+	// Remove directories left empty by the delete, stopping at the bucket
+	bucketDir, _ := s.bucketDir(bucketName)
+	for dir := filepath.Dir(objectPath); dir != bucketDir; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	s.logger.Info().Msgf("File deleted successfully from bucket: %s, object: %s", bucketName, objectName)
+	return nil
+}
+
+func (s *FileSystemStorage) ListFiles(ctx context.Context, bucketName string) ([]string, error) {
+	s.logger.Info().Msgf("Listing files in bucket: %s", bucketName)
+	if err := s.checkBucket(bucketName); err != nil {
+		return nil, err
+	}
+	bucketDir, _ := s.bucketDir(bucketName)
+
+	var fileNames []string
+	err := filepath.WalkDir(bucketDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), fsUploadTempPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(bucketDir, path)
+		if err != nil {
+			return err
+		}
+		fileNames = append(fileNames, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to list files in bucket: %s", bucketName)
+		return nil, fmt.Errorf("failed to list files in bucket: %w", err)
+	}
+	sort.Strings(fileNames)
+	s.logger.Info().Msgf("Listed %d files in bucket: %s", len(fileNames), bucketName)
+	return fileNames, nil
+}
+
+func (s *FileSystemStorage) bucketDir(bucketName string) (string, error) {
+	if bucketName == "" || bucketName == "." || bucketName == ".." || strings.ContainsAny(bucketName, `/\`) {
+		return "", fmt.Errorf("invalid bucket name: %q", bucketName)
+	}
+	return filepath.Join(s.rootDir, bucketName), nil
+}
+
+func (s *FileSystemStorage) checkBucket(bucketName string) error {
+	bucketDir, err := s.bucketDir(bucketName)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(bucketDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("bucket %s does not exist", bucketName)
+	}
+	return nil
+}
+
+// objectPath maps an object name onto the bucket directory, rejecting names that
+// would escape it or that do not map onto a file path one-to-one.
+func (s *FileSystemStorage) objectPath(bucketName, objectName string) (string, error) {
+	bucketDir, err := s.bucketDir(bucketName)
+	if err != nil {
+		return "", err
+	}
+	if objectName == "" || strings.Contains(objectName, `\`) {
+		return "", fmt.Errorf("invalid object name: %q", objectName)
+	}
+	for _, segment := range strings.Split(objectName, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.HasPrefix(segment, fsUploadTempPrefix) {
+			return "", fmt.Errorf("invalid object name: %q", objectName)
+		}
+	}
+	return filepath.Join(bucketDir, filepath.FromSlash(objectName)), nil
+}
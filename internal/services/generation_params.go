@@ -0,0 +1,107 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// ErrInvalidGenerationParams is returned when generation parameters are out of range or
+// beyond the limits of the session's price tier
+var ErrInvalidGenerationParams = errors.New("invalid generation parameters")
+
+// Safety thresholds a session may answer with, from the most to the least permissive
+const (
+	SafetyBlockNone           = "block_none"
+	SafetyBlockOnlyHigh       = "block_only_high"
+	SafetyBlockMediumAndAbove = "block_medium_and_above"
+	SafetyBlockLowAndAbove    = "block_low_and_above"
+)
+
+var safetyThresholds = map[string]genai.HarmBlockThreshold{
+	SafetyBlockNone:           genai.HarmBlockNone,
+	SafetyBlockOnlyHigh:       genai.HarmBlockOnlyHigh,
+	SafetyBlockMediumAndAbove: genai.HarmBlockMediumAndAbove,
+	SafetyBlockLowAndAbove:    genai.HarmBlockLowAndAbove,
+}
+
+// safetyCategories are the harm categories the Gemini models rate answers on
+var safetyCategories = []genai.HarmCategory{
+	genai.HarmCategoryHarassment,
+	genai.HarmCategoryHateSpeech,
+	genai.HarmCategorySexuallyExplicit,
+	genai.HarmCategoryDangerousContent,
+}
+
+// GenerationLimits bound the generation parameters sessions of a price tier may use
+type GenerationLimits struct {
+	MaxTemperature   float32  `json:"max_temperature"`
+	MaxOutputTokens  int32    `json:"max_output_tokens"`
+	SafetyThresholds []string `json:"safety_thresholds"`
+}
+
+var tierGenerationLimits = map[string]GenerationLimits{
+	"base": {
+		MaxTemperature:   1,
+		MaxOutputTokens:  4096,
+		SafetyThresholds: []string{SafetyBlockOnlyHigh, SafetyBlockMediumAndAbove, SafetyBlockLowAndAbove},
+	},
+	"pro": {
+		MaxTemperature:   2,
+		MaxOutputTokens:  8192,
+		SafetyThresholds: []string{SafetyBlockNone, SafetyBlockOnlyHigh, SafetyBlockMediumAndAbove, SafetyBlockLowAndAbove},
+	},
+}
+
+// TierGenerationLimits returns the generation limits of every price tier
+func TierGenerationLimits() map[string]GenerationLimits {
+	return tierGenerationLimits
+}
+
+// ValidateGenerationParams checks generation parameters against their valid ranges and the
+// limits of the price tier
+func ValidateGenerationParams(priceTier string, params models.GenerationParams) error {
+	limits, ok := tierGenerationLimits[priceTier]
+	if !ok {
+		return fmt.Errorf("%w: unknown price tier %q", ErrInvalidGenerationParams, priceTier)
+	}
+	if t := params.Temperature; t != nil && (*t < 0 || *t > limits.MaxTemperature) {
+		return fmt.Errorf("%w: temperature must be between 0 and %g on the %s tier", ErrInvalidGenerationParams, limits.MaxTemperature, priceTier)
+	}
+	if p := params.TopP; p != nil && (*p <= 0 || *p > 1) {
+		return fmt.Errorf("%w: top_p must be greater than 0 and at most 1", ErrInvalidGenerationParams)
+	}
+	if n := params.MaxOutputTokens; n != nil && (*n < 1 || *n > limits.MaxOutputTokens) {
+		return fmt.Errorf("%w: max_output_tokens must be between 1 and %d on the %s tier", ErrInvalidGenerationParams, limits.MaxOutputTokens, priceTier)
+	}
+	if params.SafetyThreshold != "" {
+		if _, ok := safetyThresholds[params.SafetyThreshold]; !ok {
+			return fmt.Errorf("%w: unknown safety_threshold %q", ErrInvalidGenerationParams, params.SafetyThreshold)
+		}
+		allowed := false
+		for _, threshold := range limits.SafetyThresholds {
+			allowed = allowed || threshold == params.SafetyThreshold
+		}
+		if !allowed {
+			return fmt.Errorf("%w: safety_threshold %s is not available on the %s tier", ErrInvalidGenerationParams, params.SafetyThreshold, priceTier)
+		}
+	}
+	return nil
+}
+
+// applyGenerationParams configures a model with validated generation parameters. A chat
+// started from the model picks up the change with its next prompt.
+func applyGenerationParams(model *genai.GenerativeModel, params models.GenerationParams) {
+	model.Temperature = params.Temperature
+	model.TopP = params.TopP
+	model.MaxOutputTokens = params.MaxOutputTokens
+	model.SafetySettings = nil
+	if threshold, ok := safetyThresholds[params.SafetyThreshold]; ok {
+		for _, category := range safetyCategories {
+			model.SafetySettings = append(model.SafetySettings, &genai.SafetySetting{Category: category, Threshold: threshold})
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateGenerationParams(t *testing.T) {
+	float := func(f float32) *float32 { return &f }
+	tokens := func(n int32) *int32 { return &n }
+
+	tests := []struct {
+		name      string
+		priceTier string
+		params    models.GenerationParams
+		valid     bool
+	}{
+		{"defaults", "base", models.GenerationParams{}, true},
+		{"unknown tier", "free", models.GenerationParams{}, false},
+		{"zero temperature", "base", models.GenerationParams{Temperature: float(0)}, true},
+		{"negative temperature", "pro", models.GenerationParams{Temperature: float(-0.1)}, false},
+		{"base temperature limit", "base", models.GenerationParams{Temperature: float(1)}, true},
+		{"over the base temperature limit", "base", models.GenerationParams{Temperature: float(1.5)}, false},
+		{"pro temperature limit", "pro", models.GenerationParams{Temperature: float(2)}, true},
+		{"over the pro temperature limit", "pro", models.GenerationParams{Temperature: float(2.1)}, false},
+		{"top_p of 1", "base", models.GenerationParams{TopP: float(1)}, true},
+		{"zero top_p", "pro", models.GenerationParams{TopP: float(0)}, false},
+		{"top_p over 1", "pro", models.GenerationParams{TopP: float(1.1)}, false},
+		{"one output token", "base", models.GenerationParams{MaxOutputTokens: tokens(1)}, true},
+		{"no output tokens", "base", models.GenerationParams{MaxOutputTokens: tokens(0)}, false},
+		{"base output limit", "base", models.GenerationParams{MaxOutputTokens: tokens(4096)}, true},
+		{"over the base output limit", "base", models.GenerationParams{MaxOutputTokens: tokens(8192)}, false},
+		{"pro output limit", "pro", models.GenerationParams{MaxOutputTokens: tokens(8192)}, true},
+		{"over the pro output limit", "pro", models.GenerationParams{MaxOutputTokens: tokens(8193)}, false},
+		{"base safety threshold", "base", models.GenerationParams{SafetyThreshold: SafetyBlockOnlyHigh}, true},
+		{"no blocking on base", "base", models.GenerationParams{SafetyThreshold: SafetyBlockNone}, false},
+		{"no blocking on pro", "pro", models.GenerationParams{SafetyThreshold: SafetyBlockNone}, true},
+		{"unknown safety threshold", "pro", models.GenerationParams{SafetyThreshold: "block_all"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateGenerationParams(tt.priceTier, tt.params)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, ErrInvalidGenerationParams), "got %v", err)
+			}
+		})
+	}
+}
+
+func TestApplyGenerationParams(t *testing.T) {
+	model := &genai.GenerativeModel{}
+	temperature := float32(0.3)
+	applyGenerationParams(model, models.GenerationParams{Temperature: &temperature, SafetyThreshold: SafetyBlockLowAndAbove})
+	assert.Equal(t, &temperature, model.Temperature)
+	assert.Nil(t, model.MaxOutputTokens)
+	assert.Len(t, model.SafetySettings, len(safetyCategories))
+	for _, setting := range model.SafetySettings {
+		assert.Equal(t, genai.HarmBlockLowAndAbove, setting.Threshold)
+	}
+
+	// Clearing the threshold goes back to the model's default safety settings
+	applyGenerationParams(model, models.GenerationParams{})
+	assert.Nil(t, model.Temperature)
+	assert.Empty(t, model.SafetySettings)
+}
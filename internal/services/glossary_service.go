@@ -0,0 +1,324 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/utils/broker"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const (
+	glossaryTimeout      = 5 * time.Minute
+	glossaryOutputTokens = 8192
+	maxGlossaryTerms     = 150
+	maxGlossaryTermLen   = 200
+)
+
+// Kinds of glossary terms
+const (
+	GlossaryKindTerm    = "term"
+	GlossaryKindMethod  = "method"
+	GlossaryKindDataset = "dataset"
+	GlossaryKindAcronym = "acronym"
+)
+
+var glossaryKinds = []string{GlossaryKindTerm, GlossaryKindMethod, GlossaryKindDataset, GlossaryKindAcronym}
+
+// ErrInvalidGlossary is returned for glossary requests that do not fit the glossary's state
+var ErrInvalidGlossary = errors.New("invalid glossary request")
+
+type GlossaryService struct {
+	db          *gorm.DB
+	authorizer  SessionAuthorizer
+	chatService ChatServiceDB
+	meter       UsageMeter
+	corpus      corpusAccess
+	inFlight    map[string]bool
+	mutex       sync.Mutex
+	logger      zerolog.Logger
+}
+
+func NewGlossaryService(
+	db *gorm.DB,
+	authorizer SessionAuthorizer,
+	chatService ChatServiceDB,
+	meter UsageMeter,
+	cacheManager CacheManager,
+	cacheServiceDB CacheServiceDB,
+	messageBroker *broker.Broker,
+	logger zerolog.Logger,
+) *GlossaryService {
+	return &GlossaryService{
+		db:          db,
+		authorizer:  authorizer,
+		chatService: chatService,
+		meter:       meter,
+		corpus: corpusAccess{
+			cacheManager:   cacheManager,
+			cacheServiceDB: cacheServiceDB,
+			messageBroker:  messageBroker,
+		},
+		inFlight: make(map[string]bool),
+		logger:   logger,
+	}
+}
+
+// BuildGlossaryAsync builds a session's glossary in the background. Only one build of a
+// session runs at a time; it reports false when one is already running.
+func (s *GlossaryService) BuildGlossaryAsync(sessionID string) bool {
+	s.mutex.Lock()
+	if s.inFlight[sessionID] {
+		s.mutex.Unlock()
+		return false
+	}
+	s.inFlight[sessionID] = true
+	s.mutex.Unlock()
+
+	go func() {
+		defer func() {
+			s.mutex.Lock()
+			delete(s.inFlight, sessionID)
+			s.mutex.Unlock()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), glossaryTimeout)
+		defer cancel()
+		if err := s.BuildGlossary(ctx, sessionID); err != nil {
+			s.logger.Error().Err(err).Msgf("Failed to build glossary of session %s", sessionID)
+		}
+	}()
+	return true
+}
+
+// BuildGlossary extracts the key terms, methods, datasets and acronyms of a session's corpus
+// with their definitions and the documents they appear in, replacing any earlier glossary.
+// It is billed to the session's owner like the session itself.
+func (s *GlossaryService) BuildGlossary(ctx context.Context, sessionID string) error {
+	chat, err := s.chatService.GetChatBySessionIDFromDB(sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to get chat: %w", err)
+	}
+	glossary := models.Glossary{ChatID: chat.ID}
+	if err := s.db.WithContext(ctx).Where("chat_id = ?", chat.ID).FirstOrCreate(&glossary).Error; err != nil {
+		return fmt.Errorf("failed to create glossary: %w", err)
+	}
+	glossary.Status = JobRunning
+	glossary.Error = ""
+	s.save(&glossary, sessionID)
+
+	if err := s.build(ctx, chat, &glossary); err != nil {
+		glossary.Status = JobFailed
+		glossary.Error = err.Error()
+		s.save(&glossary, sessionID)
+		return err
+	}
+	glossary.Status = JobCompleted
+	s.save(&glossary, sessionID)
+	s.logger.Info().Msgf("Built glossary of session %s with %d terms", sessionID, len(glossary.Terms))
+	return nil
+}
+
+// RebuildGlossary builds a session's glossary again, for sessions started before glossaries
+// existed or whose glossary failed
+func (s *GlossaryService) RebuildGlossary(ctx context.Context, userID uuid.UUID, sessionID string) error {
+	if _, err := s.authorizer.AuthorizeSession(ctx, userID, sessionID); err != nil {
+		return err
+	}
+	if _, _, err := s.corpus.model(ctx, sessionID); err != nil {
+		return err
+	}
+	if !s.BuildGlossaryAsync(sessionID) {
+		return fmt.Errorf("%w: the glossary is already being built", ErrInvalidGlossary)
+	}
+	return nil
+}
+
+// GetGlossary returns a session's glossary with its terms in alphabetical order, only those
+// of the given kind if set
+func (s *GlossaryService) GetGlossary(ctx context.Context, userID uuid.UUID, sessionID, kind string) (*models.Glossary, error) {
+	chat, err := s.authorizer.AuthorizeSession(ctx, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var glossary models.Glossary
+	err = s.db.WithContext(ctx).
+		Preload("Terms", func(db *gorm.DB) *gorm.DB {
+			if kind != "" {
+				db = db.Where("kind = ?", kind)
+			}
+			return db.Order("lower(term) asc")
+		}).
+		Where("chat_id = ?", chat.ID).
+		First(&glossary).Error
+	if err != nil {
+		return nil, err
+	}
+	return &glossary, nil
+}
+
+func (s *GlossaryService) build(ctx context.Context, chat *models.Chat, glossary *models.Glossary) error {
+	documents, err := s.chatService.GetChatDocumentsFromDB(chat.SessionID)
+	if err != nil {
+		return fmt.Errorf("failed to get session documents: %w", err)
+	}
+	model, cache, err := s.corpus.model(ctx, chat.SessionID)
+	if err != nil {
+		return err
+	}
+	if err := checkJobCredit(ctx, s.meter, chat.UserID, cache); err != nil {
+		return err
+	}
+
+	model.SetTemperature(0.1)
+	model.SetMaxOutputTokens(glossaryOutputTokens)
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"terms": {
+				Type: genai.TypeArray,
+				Items: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"term":       {Type: genai.TypeString},
+						"kind":       {Type: genai.TypeString, Enum: glossaryKinds},
+						"definition": {Type: genai.TypeString, Description: "One or two sentences; for acronyms, the expansion first"},
+						"documents":  {Type: genai.TypeArray, Items: &genai.Schema{Type: genai.TypeInteger}, Description: "The N of every <Document N> the term appears in"},
+					},
+					Required: []string{"term", "kind", "definition", "documents"},
+				},
+			},
+		},
+		Required: []string{"terms"},
+	}
+
+	resp, err := model.GenerateContent(ctx, genai.Text(glossaryPrompt()))
+	if err != nil {
+		return fmt.Errorf("failed to generate glossary: %w", err)
+	}
+	tokens, tokenHours, err := billJob(ctx, s.meter, chat.UserID, cache, resp)
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to bill glossary of session %s", chat.SessionID)
+	}
+	glossary.TokenCountUsed += tokens
+	glossary.TokenHoursUsed += tokenHours
+
+	var generated struct {
+		Terms []struct {
+			Term       string `json:"term"`
+			Kind       string `json:"kind"`
+			Definition string `json:"definition"`
+			Documents  []int  `json:"documents"`
+		} `json:"terms"`
+	}
+	if err := json.Unmarshal([]byte(responseText(resp)), &generated); err != nil {
+		return fmt.Errorf("failed to parse glossary: %w", err)
+	}
+
+	positions := make(map[int]bool, len(documents))
+	for _, doc := range documents {
+		positions[doc.Position] = true
+	}
+	seen := make(map[string]bool)
+	var terms []models.GlossaryTerm
+	for _, entry := range generated.Terms {
+		term := truncateRunes(strings.TrimSpace(entry.Term), maxGlossaryTermLen)
+		if term == "" || seen[strings.ToLower(term)] {
+			continue
+		}
+		seen[strings.ToLower(term)] = true
+		kind := entry.Kind
+		if !isGlossaryKind(kind) {
+			kind = GlossaryKindTerm
+		}
+		// Only positions of the session's documents are kept, each once
+		var found []int
+		for _, position := range entry.Documents {
+			if positions[position] && !containsInt(found, position) {
+				found = append(found, position)
+			}
+		}
+		sort.Ints(found)
+		terms = append(terms, models.GlossaryTerm{
+			GlossaryID: glossary.ID,
+			Term:       term,
+			Kind:       kind,
+			Definition: strings.TrimSpace(entry.Definition),
+			Documents:  found,
+		})
+		if len(terms) == maxGlossaryTerms {
+			break
+		}
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("glossary_id = ?", glossary.ID).Delete(&models.GlossaryTerm{}).Error; err != nil {
+			return err
+		}
+		if len(terms) == 0 {
+			return nil
+		}
+		return tx.Create(&terms).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save glossary terms: %w", err)
+	}
+	glossary.Terms = terms
+	return nil
+}
+
+// save records the state of a glossary and publishes it
+func (s *GlossaryService) save(glossary *models.Glossary, sessionID string) {
+	err := s.db.Model(glossary).Updates(map[string]interface{}{
+		"status":           glossary.Status,
+		"error":            glossary.Error,
+		"token_count_used": glossary.TokenCountUsed,
+		"token_hours_used": glossary.TokenHoursUsed,
+	}).Error
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to save glossary of session %s", sessionID)
+	}
+	event := JobEvent{Kind: "glossary", JobID: glossary.ID, Status: glossary.Status, Total: 1, Error: glossary.Error}
+	if glossary.Status == JobCompleted {
+		event.Completed = 1
+	}
+	s.corpus.publish(sessionID, event)
+}
+
+func isGlossaryKind(kind string) bool {
+	for _, k := range glossaryKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func glossaryPrompt() string {
+	return fmt.Sprintf("Build a glossary of the documents in the context. List up to %d of the most important entries: "+
+		"key technical terms, named methods and models, datasets and benchmarks, and acronyms. "+
+		"For every entry give its kind, a definition of one or two sentences based on how the documents use it "+
+		"(for acronyms, start with the expansion) and the N of every <Document N> it appears in. "+
+		"Leave out general vocabulary that any reader of the field would know.", maxGlossaryTerms)
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const (
+	maxPresetNameLength  = 100
+	maxInstructionLength = 4000
+
+	// baseSystemInstruction applies to every session, whatever answer style is chosen
+	baseSystemInstruction = "You answer questions about the research documents in the context. " +
+		"Format your answers in markdown with easily readable paragraphs."
+)
+
+// ErrInvalidInstruction is returned when a preset or an instruction fails validation, or a
+// selected preset does not exist
+var ErrInvalidInstruction = errors.New("invalid instruction")
+
+// BuiltinPreset is an answer style available to every user
+type BuiltinPreset struct {
+	Key         string `json:"key"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Instruction string `json:"instruction"`
+}
+
+var builtinPresets = []BuiltinPreset{
+	{
+		Key:         "literature_review",
+		Name:        "Literature review",
+		Description: "Synthesizes the documents into themes, agreements and open questions",
+		Instruction: "Act as a researcher writing a literature review. Organize answers by theme rather than by document, " +
+			"compare how the documents approach each theme, point out where they agree and disagree, " +
+			"and end with the gaps and open questions. Cite every claim as <Document N>.",
+	},
+	{
+		Key:         "peer_review",
+		Name:        "Critical peer review",
+		Description: "Assesses methods, evidence and claims like a demanding reviewer",
+		Instruction: "Act as a critical but fair peer reviewer. Assess the soundness of the methods, whether the evidence " +
+			"supports the claims, missing baselines or controls, threats to validity and reproducibility. " +
+			"Separate major from minor concerns and cite the passages you rely on as <Document N>.",
+	},
+	{
+		Key:         "explain_like_student",
+		Name:        "Explain like I'm a student",
+		Description: "Plain-language explanations for readers new to the field",
+		Instruction: "Explain for an undergraduate student who is new to the field. Define technical terms when they first " +
+			"appear, prefer intuition and examples over formalism, build up from the basics and keep paragraphs short. " +
+			"Still cite the documents as <Document N>.",
+	},
+	{
+		Key:         "methods_extraction",
+		Name:        "Methods extraction",
+		Description: "Extracts datasets, methods, parameters and metrics precisely",
+		Instruction: "Extract methodological details precisely and without interpretation: study design, datasets and their sizes, " +
+			"models or procedures, hyperparameters and settings, evaluation metrics and reported results. " +
+			"Prefer tables with one row per document, write \"not reported\" for missing details and cite each value as <Document N>.",
+	},
+}
+
+// InstructionSelection is a user's choice of answer style: free text, one of their presets by
+// ID, or a built-in preset by key, in that order of precedence. The zero value means none.
+type InstructionSelection struct {
+	Preset      string
+	PresetID    uint
+	Instruction string
+}
+
+// ResolvedInstruction is the instruction text of a selection and the name of its preset
+type ResolvedInstruction struct {
+	PresetName  string
+	Instruction string
+}
+
+// PresetInput holds the user-editable fields of a preset
+type PresetInput struct {
+	Name        string
+	Description string
+	Instruction string
+}
+
+type InstructionPresetService struct {
+	db     *gorm.DB
+	logger zerolog.Logger
+}
+
+func NewInstructionPresetService(db *gorm.DB, logger zerolog.Logger) *InstructionPresetService {
+	return &InstructionPresetService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// BuiltinPresets returns the answer styles every user can choose from
+func (s *InstructionPresetService) BuiltinPresets() []BuiltinPreset {
+	return builtinPresets
+}
+
+// ListPresets returns the user's own presets in the order they were created
+func (s *InstructionPresetService) ListPresets(ctx context.Context, userID uuid.UUID) ([]models.InstructionPreset, error) {
+	var presets []models.InstructionPreset
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at asc").Find(&presets).Error; err != nil {
+		s.logger.Error().Err(err).Msg("Failed to list instruction presets")
+		return nil, fmt.Errorf("failed to list presets: %w", err)
+	}
+	return presets, nil
+}
+
+// GetPreset returns one of the user's presets. It returns gorm.ErrRecordNotFound when the
+// preset does not exist or belongs to another user.
+func (s *InstructionPresetService) GetPreset(ctx context.Context, userID uuid.UUID, presetID uint) (*models.InstructionPreset, error) {
+	var preset models.InstructionPreset
+	if err := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", presetID, userID).First(&preset).Error; err != nil {
+		return nil, err
+	}
+	return &preset, nil
+}
+
+func (s *InstructionPresetService) CreatePreset(ctx context.Context, userID uuid.UUID, input PresetInput) (*models.InstructionPreset, error) {
+	if err := validatePresetInput(&input); err != nil {
+		return nil, err
+	}
+	preset := &models.InstructionPreset{
+		UserID:      userID,
+		Name:        input.Name,
+		Description: input.Description,
+		Instruction: input.Instruction,
+	}
+	if err := s.db.WithContext(ctx).Create(preset).Error; err != nil {
+		s.logger.Error().Err(err).Msg("Failed to create instruction preset")
+		return nil, fmt.Errorf("failed to create preset: %w", err)
+	}
+	return preset, nil
+}
+
+// UpdatePreset replaces the fields of a preset. Sessions already started with it keep the
+// instruction they were created with.
+func (s *InstructionPresetService) UpdatePreset(ctx context.Context, userID uuid.UUID, presetID uint, input PresetInput) (*models.InstructionPreset, error) {
+	preset, err := s.GetPreset(ctx, userID, presetID)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePresetInput(&input); err != nil {
+		return nil, err
+	}
+	err = s.db.WithContext(ctx).Model(preset).Updates(map[string]interface{}{
+		"name":        input.Name,
+		"description": input.Description,
+		"instruction": input.Instruction,
+	}).Error
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to update instruction preset %d", presetID)
+		return nil, fmt.Errorf("failed to update preset: %w", err)
+	}
+	return preset, nil
+}
+
+func (s *InstructionPresetService) DeletePreset(ctx context.Context, userID uuid.UUID, presetID uint) error {
+	preset, err := s.GetPreset(ctx, userID, presetID)
+	if err != nil {
+		return err
+	}
+	if err := s.db.WithContext(ctx).Delete(preset).Error; err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to delete instruction preset %d", presetID)
+		return fmt.Errorf("failed to delete preset: %w", err)
+	}
+	return nil
+}
+
+// ResolveInstruction returns the instruction text of a user's selection, or nil when nothing
+// was selected
+func (s *InstructionPresetService) ResolveInstruction(ctx context.Context, userID uuid.UUID, selection InstructionSelection) (*ResolvedInstruction, error) {
+	if instruction := strings.TrimSpace(selection.Instruction); instruction != "" {
+		if len([]rune(instruction)) > maxInstructionLength {
+			return nil, fmt.Errorf("%w: instruction must be at most %d characters", ErrInvalidInstruction, maxInstructionLength)
+		}
+		return &ResolvedInstruction{Instruction: instruction}, nil
+	}
+	if selection.PresetID != 0 {
+		preset, err := s.GetPreset(ctx, userID, selection.PresetID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: preset %d not found", ErrInvalidInstruction, selection.PresetID)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get preset: %w", err)
+		}
+		return &ResolvedInstruction{PresetName: preset.Name, Instruction: preset.Instruction}, nil
+	}
+	if selection.Preset != "" {
+		for _, preset := range builtinPresets {
+			if preset.Key == selection.Preset {
+				return &ResolvedInstruction{PresetName: preset.Name, Instruction: preset.Instruction}, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: unknown preset %q", ErrInvalidInstruction, selection.Preset)
+	}
+	return nil, nil
+}
+
+// SystemInstruction is the system instruction of a session's cache: the instructions every
+// session gets followed by the chosen answer style, if any
+func SystemInstruction(resolved *ResolvedInstruction) string {
+	if resolved == nil {
+		return baseSystemInstruction
+	}
+	return baseSystemInstruction + "\n\n" + resolved.Instruction
+}
+
+func validatePresetInput(input *PresetInput) error {
+	input.Name = strings.TrimSpace(input.Name)
+	input.Description = strings.TrimSpace(input.Description)
+	input.Instruction = strings.TrimSpace(input.Instruction)
+	if input.Name == "" || len([]rune(input.Name)) > maxPresetNameLength {
+		return fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalidInstruction, maxPresetNameLength)
+	}
+	if input.Instruction == "" || len([]rune(input.Instruction)) > maxInstructionLength {
+		return fmt.Errorf("%w: instruction must be 1 to %d characters", ErrInvalidInstruction, maxInstructionLength)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveInstruction(t *testing.T) {
+	presets := NewInstructionPresetService(nil, zerolog.Nop())
+	peerReview := builtinPresets[1]
+
+	tests := []struct {
+		name      string
+		selection InstructionSelection
+		want      *ResolvedInstruction
+		err       error
+	}{
+		{"nothing selected", InstructionSelection{}, nil, nil},
+		{"blank instruction", InstructionSelection{Instruction: " \n\t"}, nil, nil},
+		{"free text", InstructionSelection{Instruction: "  Answer in French.  "}, &ResolvedInstruction{Instruction: "Answer in French."}, nil},
+		{"free text at the limit", InstructionSelection{Instruction: strings.Repeat("é", maxInstructionLength)},
+			&ResolvedInstruction{Instruction: strings.Repeat("é", maxInstructionLength)}, nil},
+		{"free text over the limit", InstructionSelection{Instruction: strings.Repeat("a", maxInstructionLength+1)}, nil, ErrInvalidInstruction},
+		{"built-in preset", InstructionSelection{Preset: "peer_review"},
+			&ResolvedInstruction{PresetName: peerReview.Name, Instruction: peerReview.Instruction}, nil},
+		{"unknown built-in preset", InstructionSelection{Preset: "haiku"}, nil, ErrInvalidInstruction},
+		{"free text before presets", InstructionSelection{Instruction: "Be brief.", PresetID: 3, Preset: "peer_review"},
+			&ResolvedInstruction{Instruction: "Be brief."}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, err := presets.ResolveInstruction(context.Background(), uuid.New(), tt.selection)
+			if tt.err != nil {
+				assert.True(t, errors.Is(err, tt.err), "got %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, resolved)
+		})
+	}
+}
+
+func TestResolvePresetInstruction(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	presets := NewInstructionPresetService(db, zerolog.Nop())
+	user, other := createTestUser(t, db), createTestUser(t, db)
+	preset, err := presets.CreatePreset(ctx, user.ID, PresetInput{Name: " Terse ", Instruction: " One paragraph at most. "})
+	require.NoError(t, err)
+
+	// A user's own preset comes before a built-in one
+	resolved, err := presets.ResolveInstruction(ctx, user.ID, InstructionSelection{PresetID: preset.ID, Preset: "peer_review"})
+	require.NoError(t, err)
+	assert.Equal(t, &ResolvedInstruction{PresetName: "Terse", Instruction: "One paragraph at most."}, resolved)
+
+	// Other users' presets are not found
+	_, err = presets.ResolveInstruction(ctx, other.ID, InstructionSelection{PresetID: preset.ID})
+	assert.True(t, errors.Is(err, ErrInvalidInstruction), "got %v", err)
+
+	require.NoError(t, presets.DeletePreset(ctx, user.ID, preset.ID))
+	_, err = presets.ResolveInstruction(ctx, user.ID, InstructionSelection{PresetID: preset.ID})
+	assert.True(t, errors.Is(err, ErrInvalidInstruction), "got %v", err)
+}
+
+func TestSystemInstruction(t *testing.T) {
+	assert.Equal(t, baseSystemInstruction, SystemInstruction(nil))
+	assert.Equal(t, baseSystemInstruction+"\n\nBe brief.", SystemInstruction(&ResolvedInstruction{Instruction: "Be brief."}))
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nexus_scholar_go_backend/internal/utils/docir"
+	"nexus_scholar_go_backend/internal/utils/latexsrc"
+	"nexus_scholar_go_backend/internal/utils/pdflayout"
+)
+
+// Extraction modes of a research session. The text mode extracts the text of PDFs as
+// pdftotext lays it out; the math mode recovers equations as LaTeX, from the arXiv source of
+// a paper when there is one and otherwise with the equation recognizer, if one is configured.
+const (
+	ExtractionModeText = "text"
+	ExtractionModeMath = "math"
+)
+
+// ErrInvalidExtractionMode is returned for extraction modes other than text and math
+var ErrInvalidExtractionMode = errors.New("extraction mode must be text or math")
+
+const (
+	// maxArXivSourceSize bounds the download of a paper's source, figures included
+	maxArXivSourceSize = 50 << 20
+	// equationRecognitionTimeout bounds how long the recognizer may take for one PDF
+	equationRecognitionTimeout = 5 * time.Minute
+)
+
+// ExtractionOptions tells what is extracted from the documents of a corpus besides their text
+type ExtractionOptions struct {
+	Figures bool // crop figures and tables into images
+	Math    bool // recover equations as LaTeX
+}
+
+// HTTPEquationRecognizer recognizes equations with an external service. The PDF is posted
+// as the "file" field of a multipart form, and the service answers with the equations it
+// found:
+//
+//	{"equations": [{"page": 1, "x_min": 72, "y_min": 300, "x_max": 540, "y_max": 330, "latex": "E = mc^2"}]}
+//
+// Pages are numbered from 1 and boxes are in points from the top left corner of the page.
+type HTTPEquationRecognizer struct {
+	url        string
+	token      string
+	httpClient *http.Client
+}
+
+// NewHTTPEquationRecognizer returns a recognizer posting to url, with token as a bearer
+// token when it is not empty
+func NewHTTPEquationRecognizer(url, token string) *HTTPEquationRecognizer {
+	return &HTTPEquationRecognizer{
+		url:        url,
+		token:      token,
+		httpClient: &http.Client{Timeout: equationRecognitionTimeout},
+	}
+}
+
+type recognizedEquations struct {
+	Equations []struct {
+		Page  int     `json:"page"`
+		XMin  float64 `json:"x_min"`
+		YMin  float64 `json:"y_min"`
+		XMax  float64 `json:"x_max"`
+		YMax  float64 `json:"y_max"`
+		LaTeX string  `json:"latex"`
+	} `json:"equations"`
+}
+
+func (r *HTTPEquationRecognizer) RecognizeEquations(pdfPath string) ([]pdflayout.Equation, error) {
+	file, err := os.Open(pdfPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PDF: %w", err)
+	}
+	defer file.Close()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filepath.Base(pdfPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create form: %w", err)
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return nil, fmt.Errorf("failed to read PDF: %w", err)
+	}
+	if err := form.Close(); err != nil {
+		return nil, fmt.Errorf("failed to create form: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.url, &body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call equation recognizer: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code from equation recognizer: %d", resp.StatusCode)
+	}
+
+	var recognized recognizedEquations
+	if err := json.NewDecoder(resp.Body).Decode(&recognized); err != nil {
+		return nil, fmt.Errorf("failed to decode recognized equations: %w", err)
+	}
+	equations := make([]pdflayout.Equation, 0, len(recognized.Equations))
+	for _, e := range recognized.Equations {
+		equations = append(equations, pdflayout.Equation{
+			Page:  e.Page,
+			Box:   pdflayout.Box{XMin: e.XMin, YMin: e.YMin, XMax: e.XMax, YMax: e.YMax},
+			LaTeX: e.LaTeX,
+		})
+	}
+	return equations, nil
+}
+
+// RecognizesEquations tells whether the service has an equation recognizer. Without one, the
+// math mode only helps arXiv papers that have a LaTeX source.
+func (s *ContentAggregationService) RecognizesEquations() bool {
+	return s.equationRecognizer != nil
+}
+
+// ExtractMathTextFromPDF extracts the text of a PDF with the equations the recognizer finds
+// written as LaTeX between \[ and \] in place of their glyphs. Without a recognizer, or when
+// it fails, the plain text is extracted instead.
+func (s *ContentAggregationService) ExtractMathTextFromPDF(pdfPath string) (string, error) {
+	if s.equationRecognizer == nil {
+		return s.ExtractTextFromPDF(pdfPath)
+	}
+	s.logger.Info().Msgf("Extracting text and equations from PDF with path: %s", pdfPath)
+	equations, err := s.equationRecognizer.RecognizeEquations(pdfPath)
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to recognize equations of PDF with path: %s, extracting plain text", pdfPath)
+		return s.ExtractTextFromPDF(pdfPath)
+	}
+	pages, err := s.pageLayout(pdfPath)
+	if err != nil {
+		return "", err
+	}
+	content := pdflayout.Text(pages, equations)
+	if strings.TrimSpace(content) == "" {
+		s.logger.Error().Msgf("No text content extracted from PDF with path: %s", pdfPath)
+		return "", fmt.Errorf("no text content extracted from PDF")
+	}
+	s.logger.Info().Msgf("Recognized %d equations in PDF with path: %s", len(equations), pdfPath)
+	return content, nil
+}
+
+// downloadArXivSource fetches the source arXiv keeps of a paper: a gzipped tar of its LaTeX
+// files or a single gzipped file, or its PDF when the paper was submitted as one
+func (s *ContentAggregationService) downloadArXivSource(arxivID string) ([]byte, error) {
+	resp, err := http.Get(s.arxivSourceURL + arxivID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download source: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code when downloading source: %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxArXivSourceSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source: %v", err)
+	}
+	if len(data) > maxArXivSourceSize {
+		return nil, fmt.Errorf("source is larger than %d bytes", maxArXivSourceSize)
+	}
+	return data, nil
+}
+
+// convertArXivSource structures a paper from its LaTeX source. latexsrc.ErrNoSource is
+// returned for papers without one.
+func (s *ContentAggregationService) convertArXivSource(arxivID, title string) (*docir.Document, error) {
+	download, err := s.downloadArXivSource(arxivID)
+	if err != nil {
+		return nil, err
+	}
+	// Numbered once the paper is placed in a corpus
+	document, err := latexsrc.Convert(1, title, download)
+	if err != nil {
+		return nil, err
+	}
+	return &document, nil
+}
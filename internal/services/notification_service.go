@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/utils/broker"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// NotificationTopic is the broker topic live notifications for a user are published on
+func NotificationTopic(userID uuid.UUID) string {
+	return "notification_" + userID.String()
+}
+
+// NotificationJSON is how notifications are sent to clients, over REST and WebSocket alike
+type NotificationJSON struct {
+	ID        uint       `json:"id"`
+	Type      string     `json:"type"`
+	Title     string     `json:"title"`
+	Body      string     `json:"body"`
+	ArxivID   string     `json:"arxiv_id"`
+	ReadAt    *time.Time `json:"read_at"`
+	CreatedAt string     `json:"created_at"`
+}
+
+func NewNotificationJSON(n *models.Notification) NotificationJSON {
+	return NotificationJSON{
+		ID:        n.ID,
+		Type:      n.Type,
+		Title:     n.Title,
+		Body:      n.Body,
+		ArxivID:   n.ArxivID,
+		ReadAt:    n.ReadAt,
+		CreatedAt: n.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+type NotificationService struct {
+	db            *gorm.DB
+	messageBroker *broker.Broker
+	logger        zerolog.Logger
+}
+
+func NewNotificationService(db *gorm.DB, messageBroker *broker.Broker, logger zerolog.Logger) *NotificationService {
+	return &NotificationService{
+		db:            db,
+		messageBroker: messageBroker,
+		logger:        logger,
+	}
+}
+
+// Notify stores the notification and pushes it to the user's open WebSocket connections.
+// Connections that are not keeping up miss the push but find the notification in the list.
+func (s *NotificationService) Notify(ctx context.Context, notification *models.Notification) error {
+	s.logger.Info().Str("userID", notification.UserID.String()).Str("type", notification.Type).Msg("Creating notification")
+
+	if err := s.db.WithContext(ctx).Create(notification).Error; err != nil {
+		s.logger.Error().Err(err).Msg("Failed to save notification")
+		return fmt.Errorf("failed to save notification: %w", err)
+	}
+
+	s.messageBroker.Publish(NotificationTopic(notification.UserID), NewNotificationJSON(notification))
+	return nil
+}
+
+// ListNotifications returns the user's most recent notifications, newest first
+func (s *NotificationService) ListNotifications(ctx context.Context, userID uuid.UUID, unreadOnly bool, limit int) ([]models.Notification, error) {
+	query := s.db.WithContext(ctx).Where("user_id = ?", userID)
+	if unreadOnly {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var notifications []models.Notification
+	if err := query.Order("created_at desc").Limit(limit).Find(&notifications).Error; err != nil {
+		s.logger.Error().Err(err).Msg("Failed to list notifications")
+		return nil, fmt.Errorf("failed to list notifications: %w", err)
+	}
+	return notifications, nil
+}
+
+// MarkAsRead marks one of the user's notifications as read. It returns
+// gorm.ErrRecordNotFound when the notification does not belong to the user.
+func (s *NotificationService) MarkAsRead(ctx context.Context, userID uuid.UUID, notificationID uint) error {
+	result := s.db.WithContext(ctx).Model(&models.Notification{}).
+		Where("id = ? AND user_id = ? AND read_at IS NULL", notificationID, userID).
+		Update("read_at", gorm.Expr("NOW()"))
+	if result.Error != nil {
+		return fmt.Errorf("failed to mark notification as read: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		var count int64
+		s.db.WithContext(ctx).Model(&models.Notification{}).Where("id = ? AND user_id = ?", notificationID, userID).Count(&count)
+		if count == 0 {
+			return gorm.ErrRecordNotFound
+		}
+	}
+	return nil
+}
+
+// MarkAllAsRead marks every unread notification of the user as read
+func (s *NotificationService) MarkAllAsRead(ctx context.Context, userID uuid.UUID) error {
+	err := s.db.WithContext(ctx).Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Update("read_at", gorm.Expr("NOW()")).Error
+	if err != nil {
+		return fmt.Errorf("failed to mark notifications as read: %w", err)
+	}
+	return nil
+}
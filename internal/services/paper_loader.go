@@ -4,6 +4,7 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,19 +15,19 @@ import (
 	"nexus_scholar_go_backend/internal/utils/errors"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 
-	"encoding/xml"
-
 	"github.com/rs/zerolog"
 )
 
 type PaperLoader struct {
-	logger zerolog.Logger
+	metadataService *ArxivMetadataService
+	logger          zerolog.Logger
 }
 
-func NewPaperLoader(logger zerolog.Logger) *PaperLoader {
-	return &PaperLoader{logger: logger}
+func NewPaperLoader(logger zerolog.Logger, metadataService *ArxivMetadataService) *PaperLoader {
+	return &PaperLoader{metadataService: metadataService, logger: logger}
 }
 
 func (pl *PaperLoader) ProcessPaper(arxivID string) (map[string]interface{}, error) {
@@ -136,13 +137,7 @@ func (pl *PaperLoader) ProcessPaper(arxivID string) (map[string]interface{}, err
 	}
 
 	// Create or update the paper in the database
-	paper, err := CreateOrUpdatePaper(map[string]interface{}{
-		"title":    metadata["title"],
-		"authors":  strings.Split(metadata["authors"], ", "),
-		"abstract": metadata["abstract"],
-		"pdf_url":  metadata["pdf_url"],
-		"arxiv_id": arxivID,
-	})
+	paper, err := CreateOrUpdatePaper(paperDataFromMetadata(arxivID, metadata))
 	if err != nil {
 		pl.logger.Error().Err(err).Msg("Failed to create or update paper")
 		return nil, fmt.Errorf("failed to create or update paper: %v", err)
@@ -535,79 +530,26 @@ func (pl *PaperLoader) detectArxivID(reference string) string {
 	return ""
 }
 
-// ArxivEntry represents the structure of an entry in the arXiv API response
-type ArxivEntry struct {
-	Title     string `xml:"title"`
-	Summary   string `xml:"summary"`
-	Published string `xml:"published"`
-	Updated   string `xml:"updated"`
-	Authors   []struct {
-		Name string `xml:"name"`
-	} `xml:"author"`
-	Links []struct {
-		Href string `xml:"href,attr"`
-		Rel  string `xml:"rel,attr"`
-		Type string `xml:"type,attr"`
-	} `xml:"link"`
-}
-
-// ArxivFeed represents the structure of the arXiv API response
-type ArxivFeed struct {
-	Entry ArxivEntry `xml:"entry"`
+// paperDataFromMetadata converts arXiv metadata into the input of CreateOrUpdatePaper. The
+// metadata is that of the latest version, and one paper is kept for all its versions.
+func paperDataFromMetadata(arxivID string, metadata map[string]string) map[string]interface{} {
+	version, _ := strconv.Atoi(metadata["version"])
+	return map[string]interface{}{
+		"title":        metadata["title"],
+		"authors":      strings.Split(metadata["authors"], ", "),
+		"abstract":     metadata["abstract"],
+		"pdf_url":      metadata["pdf_url"],
+		"arxiv_id":     stripArxivVersion(arxivID),
+		"version":      version,
+		"last_updated": metadata["last_updated"],
+	}
 }
 
+// GetPaperMetadata returns the paper's metadata from the local arXiv mirror,
+// falling back to a rate-limited live API call.
 func (pl *PaperLoader) GetPaperMetadata(arxivID string) (map[string]string, error) {
 	pl.logger.Info().Msgf("Fetching metadata for paper with ArxivID: %s", arxivID)
-
-	url := fmt.Sprintf("http://export.arxiv.org/api/query?id_list=%s", arxivID)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		pl.logger.Error().Err(err).Msgf("Failed to fetch arXiv metadata for paper with ArxivID: %s", arxivID)
-		return nil, fmt.Errorf("failed to fetch arXiv metadata: %v", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		pl.logger.Error().Err(err).Msgf("Failed to read response body for paper with ArxivID: %s", arxivID)
-		return nil, fmt.Errorf("failed to read response body: %v", err)
-	}
-
-	var feed ArxivFeed
-	err = xml.Unmarshal(body, &feed)
-	if err != nil {
-		pl.logger.Error().Err(err).Msgf("Failed to parse XML response for paper with ArxivID: %s", arxivID)
-		return nil, fmt.Errorf("failed to parse XML response: %v", err)
-	}
-
-	entry := feed.Entry
-
-	// Extract authors
-	var authors []string
-	for _, author := range entry.Authors {
-		authors = append(authors, author.Name)
-	}
-
-	// Find PDF URL
-	pdfURL := ""
-	for _, link := range entry.Links {
-		if link.Type == "application/pdf" {
-			pdfURL = link.Href
-			break
-		}
-	}
-
-	metadata := map[string]string{
-		"title":          entry.Title,
-		"authors":        strings.Join(authors, ", "),
-		"abstract":       entry.Summary,
-		"pdf_url":        pdfURL,
-		"published_date": entry.Published,
-		"last_updated":   entry.Updated,
-	}
-
-	return metadata, nil
+	return pl.metadataService.GetMetadata(context.Background(), arxivID)
 }
 
 // GetPaperByArxivID retrieves a paper from the database by its ArxivID
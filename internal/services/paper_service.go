@@ -1,44 +1,123 @@
 package services
 
 import (
+	"fmt"
 	"nexus_scholar_go_backend/internal/database"
 	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/utils/textdiff"
 	"strings"
+
+	"gorm.io/gorm"
 )
 
-// CreateOrUpdatePaper creates a new paper or updates an existing one in the database
+// CreateOrUpdatePaper creates a new paper or updates an existing one in the database.
+// When paperData carries a newer arXiv version than the stored one, the new
+// version is recorded as a PaperVersion with a diff summary against the previous one.
 func CreateOrUpdatePaper(paperData map[string]interface{}) (*models.Paper, error) {
 	arxivID := paperData["arxiv_id"].(string)
+	title := paperData["title"].(string)
+	authors := strings.Join(paperData["authors"].([]string), ", ")
+	abstract := paperData["abstract"].(string)
+	version, _ := paperData["version"].(int)
+	lastUpdated, _ := paperData["last_updated"].(string)
 
 	var paper models.Paper
 	result := database.DB.Where("arxiv_id = ?", arxivID).First(&paper)
 
 	if result.Error != nil {
-		// Paper doesn't exist, create a new one
+		// Paper doesn't exist, create a new one along with its first known version
 		paper = models.Paper{
-			Title:    paperData["title"].(string),
-			Authors:  strings.Join(paperData["authors"].([]string), ", "),
-			Abstract: paperData["abstract"].(string),
-			ArxivID:  arxivID,
-			URL:      paperData["pdf_url"].(string),
+			Title:       title,
+			Authors:     authors,
+			Abstract:    abstract,
+			ArxivID:     arxivID,
+			URL:         paperData["pdf_url"].(string),
+			Version:     version,
+			LastUpdated: lastUpdated,
 		}
-		if err := database.DB.Create(&paper).Error; err != nil {
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&paper).Error; err != nil {
+				return err
+			}
+			return tx.Create(newPaperVersion(&paper, "")).Error
+		})
+		if err != nil {
 			return nil, err
 		}
-	} else {
-		// Paper exists, update it
-		paper.Title = paperData["title"].(string)
-		paper.Authors = strings.Join(paperData["authors"].([]string), ", ")
-		paper.Abstract = paperData["abstract"].(string)
-		paper.URL = paperData["pdf_url"].(string)
-		if err := database.DB.Save(&paper).Error; err != nil {
-			return nil, err
+		return &paper, nil
+	}
+
+	isNewVersion := version > paper.Version
+	diffSummary := ""
+	if isNewVersion {
+		diffSummary = summarizePaperChanges(&paper, title, authors, abstract)
+	}
+
+	// Paper exists, update it
+	paper.Title = title
+	paper.Authors = authors
+	paper.Abstract = abstract
+	paper.URL = paperData["pdf_url"].(string)
+	if isNewVersion {
+		paper.Version = version
+		paper.LastUpdated = lastUpdated
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&paper).Error; err != nil {
+			return err
+		}
+		if !isNewVersion {
+			return nil
 		}
+		return tx.Create(newPaperVersion(&paper, diffSummary)).Error
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return &paper, nil
 }
 
+func newPaperVersion(paper *models.Paper, diffSummary string) *models.PaperVersion {
+	return &models.PaperVersion{
+		PaperID:     paper.ID,
+		ArxivID:     paper.ArxivID,
+		Version:     paper.Version,
+		Title:       paper.Title,
+		Authors:     paper.Authors,
+		Abstract:    paper.Abstract,
+		LastUpdated: paper.LastUpdated,
+		DiffSummary: diffSummary,
+	}
+}
+
+// summarizePaperChanges describes how the new metadata differs from the stored paper, empty
+// when it does not. How the text differs is added once the version's text is extracted.
+func summarizePaperChanges(previous *models.Paper, title, authors, abstract string) string {
+	var parts []string
+	if titleDiff := textdiff.Summarize(previous.Title, title); titleDiff.Changed() {
+		parts = append(parts, fmt.Sprintf("Title changed from %q to %q", previous.Title, title))
+	}
+	if authorsDiff := textdiff.Summarize(previous.Authors, authors); authorsDiff.Changed() {
+		parts = append(parts, "Authors: "+authorsDiff.String(5))
+	}
+	if abstractDiff := textdiff.Summarize(previous.Abstract, abstract); abstractDiff.Changed() {
+		parts = append(parts, "Abstract: "+abstractDiff.String(10))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// GetPaperVersions lists all recorded versions of a paper, oldest first
+func GetPaperVersions(arxivID string) ([]models.PaperVersion, error) {
+	var versions []models.PaperVersion
+	result := database.DB.Where("arxiv_id = ?", arxivID).Order("version asc").Find(&versions)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return versions, nil
+}
+
 // GetPaperByID retrieves a paper from the database by its ID
 func GetPaperByID(id uint) (*models.Paper, error) {
 	var paper models.Paper
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/utils/textdiff"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// PaperVersionService watches the arXiv papers users have loaded into research
+// sessions and notifies those users when a paper gets a new version.
+type PaperVersionService struct {
+	db                  *gorm.DB
+	metadataService     *ArxivMetadataService
+	textExtractor       PaperTextExtractor
+	notificationService *NotificationService
+	logger              zerolog.Logger
+}
+
+func NewPaperVersionService(db *gorm.DB, metadataService *ArxivMetadataService, textExtractor PaperTextExtractor, notificationService *NotificationService, logger zerolog.Logger) *PaperVersionService {
+	return &PaperVersionService{
+		db:                  db,
+		metadataService:     metadataService,
+		textExtractor:       textExtractor,
+		notificationService: notificationService,
+		logger:              logger,
+	}
+}
+
+// StartPeriodicCheck runs CheckForNewVersions on every interval until the context is cancelled
+func (s *PaperVersionService) StartPeriodicCheck(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.CheckForNewVersions(ctx); err != nil {
+				s.logger.Error().Err(err).Msg("Paper version check failed")
+			}
+		}
+	}
+}
+
+// CheckForNewVersions looks up the latest arXiv version of every paper used in a session.
+// Sessions keep the ID as it was given, with a version suffix if the user asked for one; all
+// versions of a paper are checked as one paper.
+func (s *PaperVersionService) CheckForNewVersions(ctx context.Context) error {
+	var sessionIDs []string
+	err := s.db.WithContext(ctx).Model(&models.ChatDocument{}).
+		Where("arxiv_id <> ''").
+		Distinct().
+		Pluck("arxiv_id", &sessionIDs).Error
+	if err != nil {
+		return fmt.Errorf("failed to list papers used in sessions: %w", err)
+	}
+	var arxivIDs []string
+	seen := make(map[string]bool)
+	for _, id := range sessionIDs {
+		if id = stripArxivVersion(id); !seen[id] {
+			seen[id] = true
+			arxivIDs = append(arxivIDs, id)
+		}
+	}
+
+	s.logger.Info().Int("papers", len(arxivIDs)).Msg("Checking papers for new arXiv versions")
+	for _, arxivID := range arxivIDs {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := s.checkPaper(ctx, arxivID); err != nil {
+			s.logger.Error().Err(err).Msgf("Failed to check versions of paper %s", arxivID)
+		}
+	}
+	return nil
+}
+
+// checkPaper records the latest version of a paper and notifies its readers of versions they
+// have not been notified of yet, however the version was recorded: here or when the paper was
+// fetched through the API in the meantime
+func (s *PaperVersionService) checkPaper(ctx context.Context, arxivID string) error {
+	var paper models.Paper
+	err := s.db.WithContext(ctx).Where("arxiv_id = ?", arxivID).First(&paper).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to load paper: %w", err)
+	}
+	isKnown := err == nil
+
+	metadata, err := s.metadataService.RefreshMetadata(ctx, arxivID)
+	if err != nil {
+		return err
+	}
+	latestVersion, _ := strconv.Atoi(metadata["version"])
+	if !isKnown || latestVersion > paper.Version {
+		updated, err := CreateOrUpdatePaper(paperDataFromMetadata(arxivID, metadata))
+		if err != nil {
+			return fmt.Errorf("failed to record new paper version: %w", err)
+		}
+		paper = *updated
+	}
+	if paper.Version == 0 {
+		return nil
+	}
+
+	var version models.PaperVersion
+	err = s.db.WithContext(ctx).Where("arxiv_id = ? AND version = ?", arxivID, paper.Version).First(&version).Error
+	if err != nil {
+		return fmt.Errorf("failed to load paper version: %w", err)
+	}
+	// The text of every version is kept, so the next one can be compared against it
+	if version.Text == "" {
+		if err := s.recordText(ctx, &version); err != nil {
+			s.logger.Error().Err(err).Msgf("Failed to extract text of version v%d of paper %s", version.Version, arxivID)
+		}
+	}
+
+	if paper.Version <= paper.NotifiedVersion {
+		return nil
+	}
+	// Papers checked for the first time only get a baseline
+	if paper.NotifiedVersion == 0 {
+		s.logger.Info().Msgf("Recorded baseline version v%d of paper %s", paper.Version, arxivID)
+	} else if err := s.notifyReaders(ctx, &paper, &version); err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Model(&paper).Update("notified_version", paper.Version).Error
+}
+
+// recordText stores the text of a version and adds how it differs from the text of the
+// previous version to the version's diff summary
+func (s *PaperVersionService) recordText(ctx context.Context, version *models.PaperVersion) error {
+	text, err := s.textExtractor.ExtractArXivVersionText(version.ArxivID, version.Version)
+	if err != nil {
+		return err
+	}
+
+	var previous models.PaperVersion
+	err = s.db.WithContext(ctx).
+		Where("arxiv_id = ? AND version < ? AND text <> ''", version.ArxivID, version.Version).
+		Order("version desc").
+		First(&previous).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to load previous paper version: %w", err)
+	}
+	if err == nil {
+		if textDiff := textdiff.SummarizeText(previous.Text, text); textDiff.Changed() {
+			version.DiffSummary = strings.TrimSpace(version.DiffSummary + "\n\nText: " + textDiff.String(10))
+		} else if version.DiffSummary == "" {
+			version.DiffSummary = "No changes to the metadata or the text"
+		}
+	}
+	version.Text = text
+
+	return s.db.WithContext(ctx).Model(version).Updates(map[string]interface{}{
+		"text":         version.Text,
+		"diff_summary": version.DiffSummary,
+	}).Error
+}
+
+// notifyReaders notifies the users who read any version of the paper
+func (s *PaperVersionService) notifyReaders(ctx context.Context, paper *models.Paper, version *models.PaperVersion) error {
+	var userIDs []uuid.UUID
+	err := s.db.WithContext(ctx).Model(&models.Chat{}).
+		Joins("JOIN chat_documents ON chat_documents.chat_id = chats.id").
+		Where("(chat_documents.arxiv_id = ? OR chat_documents.arxiv_id LIKE ?) AND chat_documents.deleted_at IS NULL", paper.ArxivID, paper.ArxivID+"v%").
+		Distinct().
+		Pluck("chats.user_id", &userIDs).Error
+	if err != nil {
+		return fmt.Errorf("failed to find readers of paper: %w", err)
+	}
+
+	// Without the text of both versions only the metadata could be compared
+	body := version.DiffSummary
+	if body == "" {
+		body = "Title, authors and abstract are unchanged"
+	}
+	s.logger.Info().Int("users", len(userIDs)).Msgf("Notifying readers of new version v%d of paper %s", version.Version, paper.ArxivID)
+	for _, userID := range userIDs {
+		notification := &models.Notification{
+			UserID:  userID,
+			Type:    "paper_version",
+			Title:   fmt.Sprintf("New version (v%d) of \"%s\"", version.Version, paper.Title),
+			Body:    body,
+			ArxivID: paper.ArxivID,
+		}
+		if err := s.notificationService.Notify(ctx, notification); err != nil {
+			s.logger.Error().Err(err).Str("userID", userID.String()).Msg("Failed to notify user of new paper version")
+		}
+	}
+	return nil
+}
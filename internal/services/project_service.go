@@ -0,0 +1,333 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const maxProjectNameLength = 200
+
+// ErrInvalidProject is returned when project fields fail validation
+var ErrInvalidProject = errors.New("invalid project")
+
+// ProjectInput holds the user-editable fields of a project
+type ProjectInput struct {
+	Name             string
+	Description      string
+	DefaultPriceTier string   // base or pro, defaults to base
+	ArxivIDs         []string // document set: arXiv papers first,
+	DocumentIDs      []uint   // then library documents
+}
+
+// ProjectTierUsage sums the usage of a project's sessions on one price tier
+type ProjectTierUsage struct {
+	PriceTier      string  `json:"price_tier"`
+	Sessions       int64   `json:"sessions"`
+	TokenHoursUsed float64 `json:"token_hours_used"`
+	TokenCountUsed int64   `json:"token_count_used"`
+	ChatDuration   float64 `json:"chat_duration"` // in seconds
+}
+
+// ProjectUsage is the usage rollup of all sessions in a project
+type ProjectUsage struct {
+	Sessions       int64              `json:"sessions"`
+	TokenHoursUsed float64            `json:"token_hours_used"`
+	ChatDuration   float64            `json:"chat_duration"`
+	ByTier         []ProjectTierUsage `json:"by_tier"`
+}
+
+type ProjectService struct {
+	db          *gorm.DB
+	chatService ChatServiceDB
+	logger      zerolog.Logger
+}
+
+func NewProjectService(db *gorm.DB, chatService ChatServiceDB, logger zerolog.Logger) *ProjectService {
+	return &ProjectService{
+		db:          db,
+		chatService: chatService,
+		logger:      logger,
+	}
+}
+
+func (s *ProjectService) CreateProject(ctx context.Context, userID uuid.UUID, input ProjectInput) (*models.Project, error) {
+	if err := s.validateInput(ctx, userID, &input); err != nil {
+		return nil, err
+	}
+
+	project := &models.Project{
+		UserID:           userID,
+		Name:             input.Name,
+		Description:      input.Description,
+		DefaultPriceTier: input.DefaultPriceTier,
+		Documents:        projectDocuments(input),
+	}
+	if err := s.db.WithContext(ctx).Create(project).Error; err != nil {
+		s.logger.Error().Err(err).Msg("Failed to create project")
+		return nil, fmt.Errorf("failed to create project: %w", err)
+	}
+	s.logger.Info().Str("userID", userID.String()).Msgf("Created project %d", project.ID)
+	return project, nil
+}
+
+// ListProjects returns the user's projects, most recently updated first
+func (s *ProjectService) ListProjects(ctx context.Context, userID uuid.UUID) ([]models.Project, error) {
+	var projects []models.Project
+	err := s.db.WithContext(ctx).
+		Preload("Documents", func(db *gorm.DB) *gorm.DB { return db.Order("position asc") }).
+		Where("user_id = ?", userID).
+		Order("updated_at desc").
+		Find(&projects).Error
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to list projects")
+		return nil, fmt.Errorf("failed to list projects: %w", err)
+	}
+	return projects, nil
+}
+
+// GetProject returns one of the user's projects. It returns gorm.ErrRecordNotFound
+// when the project does not exist or belongs to another user.
+func (s *ProjectService) GetProject(ctx context.Context, userID uuid.UUID, projectID uint) (*models.Project, error) {
+	var project models.Project
+	err := s.db.WithContext(ctx).
+		Preload("Documents", func(db *gorm.DB) *gorm.DB { return db.Order("position asc") }).
+		Where("id = ? AND user_id = ?", projectID, userID).
+		First(&project).Error
+	if err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+// UpdateProject replaces the fields and the document set of a project
+func (s *ProjectService) UpdateProject(ctx context.Context, userID uuid.UUID, projectID uint, input ProjectInput) (*models.Project, error) {
+	project, err := s.GetProject(ctx, userID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.validateInput(ctx, userID, &input); err != nil {
+		return nil, err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(project).Updates(map[string]interface{}{
+			"name":               input.Name,
+			"description":        input.Description,
+			"default_price_tier": input.DefaultPriceTier,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("project_id = ?", project.ID).Delete(&models.ProjectDocument{}).Error; err != nil {
+			return err
+		}
+		documents := projectDocuments(input)
+		if len(documents) == 0 {
+			return nil
+		}
+		for i := range documents {
+			documents[i].ProjectID = project.ID
+		}
+		return tx.Create(&documents).Error
+	})
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to update project %d", projectID)
+		return nil, fmt.Errorf("failed to update project: %w", err)
+	}
+	return s.GetProject(ctx, userID, projectID)
+}
+
+// DeleteProject deletes a project. Its sessions are kept and simply no longer belong to a project.
+func (s *ProjectService) DeleteProject(ctx context.Context, userID uuid.UUID, projectID uint) error {
+	project, err := s.GetProject(ctx, userID, projectID)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Chat{}).Where("project_id = ?", project.ID).Update("project_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("project_id = ?", project.ID).Delete(&models.ProjectDocument{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(project).Error
+	})
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to delete project %d", projectID)
+		return fmt.Errorf("failed to delete project: %w", err)
+	}
+	return nil
+}
+
+// AddSession files one of the user's sessions under the project
+func (s *ProjectService) AddSession(ctx context.Context, userID uuid.UUID, projectID uint, sessionID string) error {
+	if _, err := s.GetProject(ctx, userID, projectID); err != nil {
+		return err
+	}
+	if err := s.checkChatOwner(userID, sessionID); err != nil {
+		return err
+	}
+	if err := s.chatService.SetChatProjectDB(sessionID, &projectID); err != nil {
+		return fmt.Errorf("failed to add session to project: %w", err)
+	}
+	return nil
+}
+
+// RemoveSession takes a session out of the project
+func (s *ProjectService) RemoveSession(ctx context.Context, userID uuid.UUID, projectID uint, sessionID string) error {
+	if _, err := s.GetProject(ctx, userID, projectID); err != nil {
+		return err
+	}
+	chat, err := s.chatService.GetChatBySessionIDFromDB(sessionID)
+	if err != nil {
+		return err
+	}
+	if chat.UserID != userID || chat.ProjectID == nil || *chat.ProjectID != projectID {
+		return gorm.ErrRecordNotFound
+	}
+	if err := s.chatService.SetChatProjectDB(sessionID, nil); err != nil {
+		return fmt.Errorf("failed to remove session from project: %w", err)
+	}
+	return nil
+}
+
+// GetProjectChatHistory returns the project's sessions with their messages
+func (s *ProjectService) GetProjectChatHistory(ctx context.Context, userID uuid.UUID, projectID uint) ([]models.Chat, error) {
+	if _, err := s.GetProject(ctx, userID, projectID); err != nil {
+		return nil, err
+	}
+	chats, err := s.chatService.GetChatsByProjectIDFromDB(projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get project chat history: %w", err)
+	}
+	return chats, nil
+}
+
+// GetProjectUsage rolls up the usage of the project's sessions per price tier
+func (s *ProjectService) GetProjectUsage(ctx context.Context, userID uuid.UUID, projectID uint) (*ProjectUsage, error) {
+	if _, err := s.GetProject(ctx, userID, projectID); err != nil {
+		return nil, err
+	}
+
+	var byTier []ProjectTierUsage
+	err := s.db.WithContext(ctx).Model(&models.Chat{}).
+		Select("price_tier, COUNT(*) AS sessions, COALESCE(SUM(token_hours_used), 0) AS token_hours_used, "+
+			"COALESCE(SUM(token_count_used), 0) AS token_count_used, COALESCE(SUM(chat_duration), 0) AS chat_duration").
+		Where("project_id = ?", projectID).
+		Group("price_tier").
+		Order("price_tier").
+		Scan(&byTier).Error
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to compute usage of project %d", projectID)
+		return nil, fmt.Errorf("failed to compute project usage: %w", err)
+	}
+
+	usage := &ProjectUsage{ByTier: byTier}
+	for _, tier := range byTier {
+		usage.Sessions += tier.Sessions
+		usage.TokenHoursUsed += tier.TokenHoursUsed
+		usage.ChatDuration += tier.ChatDuration
+	}
+	if usage.ByTier == nil {
+		usage.ByTier = []ProjectTierUsage{}
+	}
+	return usage, nil
+}
+
+// SessionRequest builds the request for a new research session over the project's
+// document set. An empty price tier falls back to the project's default.
+func (s *ProjectService) SessionRequest(ctx context.Context, userID uuid.UUID, projectID uint, priceTier string) (ResearchSessionRequest, error) {
+	project, err := s.GetProject(ctx, userID, projectID)
+	if err != nil {
+		return ResearchSessionRequest{}, err
+	}
+	if len(project.Documents) == 0 {
+		return ResearchSessionRequest{}, fmt.Errorf("%w: the project has no documents", ErrInvalidProject)
+	}
+	if priceTier == "" {
+		priceTier = project.DefaultPriceTier
+	}
+
+	req := ResearchSessionRequest{PriceTier: priceTier, ProjectID: &project.ID}
+	for _, doc := range project.Documents {
+		if doc.ArxivID != "" {
+			req.ArxivIDs = append(req.ArxivIDs, doc.ArxivID)
+		} else {
+			req.DocumentIDs = append(req.DocumentIDs, doc.DocumentID)
+		}
+	}
+	return req, nil
+}
+
+func (s *ProjectService) validateInput(ctx context.Context, userID uuid.UUID, input *ProjectInput) error {
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" || len(input.Name) > maxProjectNameLength {
+		return fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalidProject, maxProjectNameLength)
+	}
+	if input.DefaultPriceTier == "" {
+		input.DefaultPriceTier = "base"
+	}
+	if input.DefaultPriceTier != "base" && input.DefaultPriceTier != "pro" {
+		return fmt.Errorf("%w: default_price_tier must be 'base' or 'pro'", ErrInvalidProject)
+	}
+
+	if len(input.DocumentIDs) > 0 {
+		var count int64
+		err := s.db.WithContext(ctx).Model(&models.Document{}).
+			Where("id IN ? AND user_id = ?", input.DocumentIDs, userID).
+			Count(&count).Error
+		if err != nil {
+			return fmt.Errorf("failed to check project documents: %w", err)
+		}
+		if count != int64(len(uniqueIDs(input.DocumentIDs))) {
+			return fmt.Errorf("%w: unknown library document", ErrInvalidProject)
+		}
+	}
+	return nil
+}
+
+func (s *ProjectService) checkChatOwner(userID uuid.UUID, sessionID string) error {
+	chat, err := s.chatService.GetChatBySessionIDFromDB(sessionID)
+	if err != nil {
+		return err
+	}
+	if chat.UserID != userID {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func projectDocuments(input ProjectInput) []models.ProjectDocument {
+	var documents []models.ProjectDocument
+	for _, arxivID := range input.ArxivIDs {
+		if arxivID = strings.TrimSpace(arxivID); arxivID == "" {
+			continue
+		}
+		documents = append(documents, models.ProjectDocument{Position: len(documents) + 1, ArxivID: arxivID})
+	}
+	for _, documentID := range uniqueIDs(input.DocumentIDs) {
+		documents = append(documents, models.ProjectDocument{Position: len(documents) + 1, DocumentID: documentID})
+	}
+	return documents
+}
+
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	var unique []uint
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
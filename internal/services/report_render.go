@@ -0,0 +1,109 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/utils/markdown"
+)
+
+// reportCitationPattern finds the <Document N> citations of generated report sections
+var reportCitationPattern = regexp.MustCompile(`<Document\s+(\d+)>`)
+
+// reportReference is an entry of a report's bibliography, numbered by document position
+type reportReference struct {
+	Position int
+	Text     string
+}
+
+func reportCitedPositions(content string) []int {
+	var positions []int
+	for _, match := range reportCitationPattern.FindAllStringSubmatch(content, -1) {
+		position, _ := strconv.Atoi(match[1])
+		positions = append(positions, position)
+	}
+	return positions
+}
+
+// reportCitations turns <Document N> citations into [N], the numbering of the bibliography.
+// Left as they are, Markdown renderers would take them for HTML tags.
+func reportCitations(content string) string {
+	return reportCitationPattern.ReplaceAllString(content, "[$1]")
+}
+
+// referenceText formats a bibliography entry from a paper's reference data, or from the
+// document alone for uploads and papers without one
+func referenceText(doc models.ChatDocument, ref *models.PaperReference) string {
+	var parts []string
+	if ref != nil && ref.Title != "" {
+		head := strings.TrimSpace(ref.Author)
+		if ref.Year != "" {
+			head = strings.TrimSpace(head + " (" + ref.Year + ")")
+		}
+		parts = append(parts, head, ref.Title, ref.Journal)
+		if ref.DOI != "" {
+			parts = append(parts, "DOI: "+ref.DOI)
+		}
+	} else {
+		parts = append(parts, doc.Title)
+	}
+	if doc.ArxivID != "" {
+		parts = append(parts, "arXiv:"+doc.ArxivID)
+	} else if doc.Source == "upload" {
+		parts = append(parts, "Uploaded document")
+	}
+
+	var kept []string
+	for _, part := range parts {
+		if part = strings.TrimSuffix(strings.TrimSpace(part), "."); part != "" {
+			kept = append(kept, part)
+		}
+	}
+	text := strings.Join(kept, ". ") + "."
+	if ref != nil && ref.URL != "" {
+		text += " " + ref.URL
+	}
+	return text
+}
+
+func renderReportMarkdown(report *models.Report, references []reportReference) string {
+	var b strings.Builder
+	b.WriteString("# " + report.Title + "\n\n")
+	if report.Topic != "" {
+		b.WriteString("*Literature review on " + report.Topic + "*\n")
+	}
+	for _, section := range report.Sections {
+		fmt.Fprintf(&b, "\n## %s\n\n", section.Title)
+		b.WriteString(reportCitations(strings.TrimSpace(section.Content)) + "\n")
+	}
+
+	b.WriteString("\n## References\n\n")
+	for _, ref := range references {
+		fmt.Fprintf(&b, "[%d] %s\n\n", ref.Position, ref.Text)
+	}
+	return b.String()
+}
+
+func renderReportPDF(report *models.Report, references []reportReference) ([]byte, error) {
+	w := newTranscriptPDF(report.Title)
+	w.heading(1, report.Title)
+	if report.Topic != "" {
+		w.plainLines([]string{"Literature review on " + report.Topic})
+	}
+	for _, section := range report.Sections {
+		w.heading(2, section.Title)
+		for _, block := range markdown.Parse(reportCitations(section.Content)) {
+			w.block(block)
+		}
+	}
+
+	w.heading(2, "References")
+	for _, ref := range references {
+		w.spans([]markdown.Span{{Text: fmt.Sprintf("[%d] %s", ref.Position, ref.Text)}})
+		w.pdf.Ln(pdfLineHeight + 1.5)
+	}
+	return w.output()
+}
@@ -0,0 +1,555 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/utils/broker"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const (
+	maxReportTopicLength = 1000
+	maxReportSections    = 8
+	reportSectionTokens  = 2048
+	reportStepTimeout    = 3 * time.Minute
+
+	defaultReportTopic = "the research documents in the context"
+)
+
+var (
+	// ErrInvalidReport is returned for report requests that fail validation or do not fit
+	// the report's state
+	ErrInvalidReport = errors.New("invalid report request")
+	// ErrReportNotReady is returned when a report is downloaded before it is completed
+	ErrReportNotReady = errors.New("the report is not completed yet")
+)
+
+type ReportService struct {
+	db          *gorm.DB
+	authorizer  SessionAuthorizer
+	chatService ChatServiceDB
+	meter       UsageMeter
+	corpus      corpusAccess
+	inFlight    map[uint]bool
+	mutex       sync.Mutex
+	logger      zerolog.Logger
+}
+
+func NewReportService(
+	db *gorm.DB,
+	authorizer SessionAuthorizer,
+	chatService ChatServiceDB,
+	meter UsageMeter,
+	cacheManager CacheManager,
+	cacheServiceDB CacheServiceDB,
+	activity SessionActivity,
+	messageBroker *broker.Broker,
+	logger zerolog.Logger,
+) *ReportService {
+	return &ReportService{
+		db:          db,
+		authorizer:  authorizer,
+		chatService: chatService,
+		meter:       meter,
+		corpus: corpusAccess{
+			cacheManager:   cacheManager,
+			cacheServiceDB: cacheServiceDB,
+			activity:       activity,
+			messageBroker:  messageBroker,
+		},
+		inFlight: make(map[uint]bool),
+		logger:   logger,
+	}
+}
+
+// CreateReport starts writing a literature review over the documents of a running session,
+// billed to the user on the session's price tier. An empty topic reviews the whole corpus.
+func (s *ReportService) CreateReport(ctx context.Context, userID uuid.UUID, sessionID, topic string) (*models.Report, error) {
+	chat, err := s.authorizer.AuthorizeSession(ctx, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	topic = strings.TrimSpace(topic)
+	if len([]rune(topic)) > maxReportTopicLength {
+		return nil, fmt.Errorf("%w: topic must be at most %d characters", ErrInvalidReport, maxReportTopicLength)
+	}
+	if _, cache, err := s.corpus.model(ctx, sessionID); err != nil {
+		return nil, err
+	} else if err := checkJobCredit(ctx, s.meter, userID, cache); err != nil {
+		return nil, err
+	}
+
+	report := &models.Report{
+		ChatID: chat.ID,
+		UserID: userID,
+		Topic:  topic,
+		Status: JobPending,
+	}
+	if err := s.db.WithContext(ctx).Create(report).Error; err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to create report for session %s", sessionID)
+		return nil, fmt.Errorf("failed to create report: %w", err)
+	}
+
+	s.start(report.ID, sessionID)
+	return report, nil
+}
+
+// ResumeReport continues a failed report from the first section that was not written, for
+// instance after the requester's credit was topped up
+func (s *ReportService) ResumeReport(ctx context.Context, userID uuid.UUID, sessionID string, reportID uint) (*models.Report, error) {
+	report, err := s.GetReport(ctx, userID, sessionID, reportID)
+	if err != nil {
+		return nil, err
+	}
+	if report.UserID != userID {
+		return nil, fmt.Errorf("%w: only the user who requested a report can resume it", ErrInvalidReport)
+	}
+	if report.Status == JobCompleted {
+		return nil, fmt.Errorf("%w: the report is already completed", ErrInvalidReport)
+	}
+	if _, cache, err := s.corpus.model(ctx, sessionID); err != nil {
+		return nil, err
+	} else if err := checkJobCredit(ctx, s.meter, userID, cache); err != nil {
+		return nil, err
+	}
+
+	if !s.start(report.ID, sessionID) {
+		return nil, fmt.Errorf("%w: the report is already being written", ErrInvalidReport)
+	}
+	report.Status = JobPending
+	report.Error = ""
+	return report, nil
+}
+
+// ResumeInterrupted restarts the reports that were being written when the server stopped.
+// Reports of sessions that ended meanwhile fail and can be downloaded no further.
+func (s *ReportService) ResumeInterrupted(ctx context.Context) {
+	var pending []struct {
+		ID        uint
+		SessionID string
+	}
+	err := s.db.WithContext(ctx).Table("reports").
+		Select("reports.id, chats.session_id").
+		Joins("JOIN chats ON chats.id = reports.chat_id").
+		Where("reports.status IN ? AND reports.deleted_at IS NULL", []string{JobPending, JobRunning}).
+		Scan(&pending).Error
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to find interrupted reports")
+		return
+	}
+	for _, report := range pending {
+		s.logger.Info().Msgf("Resuming report %d of session %s", report.ID, report.SessionID)
+		s.start(report.ID, report.SessionID)
+	}
+}
+
+// ListReports returns the reports of a session with their outlines, newest first
+func (s *ReportService) ListReports(ctx context.Context, userID uuid.UUID, sessionID string) ([]models.Report, error) {
+	chat, err := s.authorizer.AuthorizeSession(ctx, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var reports []models.Report
+	err = s.db.WithContext(ctx).
+		Preload("Sections", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id", "report_id", "position", "title", "brief", "generated").Order("position asc")
+		}).
+		Where("chat_id = ?", chat.ID).
+		Order("created_at desc").
+		Find(&reports).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reports: %w", err)
+	}
+	return reports, nil
+}
+
+// GetReport returns a report of the session with the sections written so far
+func (s *ReportService) GetReport(ctx context.Context, userID uuid.UUID, sessionID string, reportID uint) (*models.Report, error) {
+	chat, err := s.authorizer.AuthorizeSession(ctx, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var report models.Report
+	err = s.db.WithContext(ctx).
+		Preload("Sections", func(db *gorm.DB) *gorm.DB { return db.Order("position asc") }).
+		Where("id = ? AND chat_id = ?", reportID, chat.ID).
+		First(&report).Error
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// DownloadReport renders a completed report as Markdown or PDF, followed by a bibliography of
+// the documents it cites
+func (s *ReportService) DownloadReport(ctx context.Context, userID uuid.UUID, sessionID string, reportID uint, format string) (*ChatExport, error) {
+	if format == "" {
+		format = ExportFormatMarkdown
+	}
+	if format != ExportFormatMarkdown && format != ExportFormatPDF {
+		return nil, fmt.Errorf("%w: format must be md or pdf", ErrInvalidExportFormat)
+	}
+	report, err := s.GetReport(ctx, userID, sessionID, reportID)
+	if err != nil {
+		return nil, err
+	}
+	if report.Status != JobCompleted {
+		return nil, ErrReportNotReady
+	}
+	documents, err := s.chatService.GetChatDocumentsFromDB(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session documents: %w", err)
+	}
+	references, err := s.bibliography(ctx, report, documents)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &ChatExport{FileName: fmt.Sprintf("report-%d.%s", report.ID, format)}
+	if format == ExportFormatPDF {
+		export.ContentType = "application/pdf"
+		export.Content, err = renderReportPDF(report, references)
+	} else {
+		export.ContentType = "text/markdown; charset=utf-8"
+		export.Content = []byte(renderReportMarkdown(report, references))
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to render %s of report %d", format, report.ID)
+		return nil, fmt.Errorf("failed to render %s report: %w", format, err)
+	}
+	return export, nil
+}
+
+// DeleteReport deletes a report; only its requester and the session's owner may. A report
+// being written stops after the current section.
+func (s *ReportService) DeleteReport(ctx context.Context, userID uuid.UUID, sessionID string, reportID uint) error {
+	chat, err := s.authorizer.AuthorizeSession(ctx, userID, sessionID)
+	if err != nil {
+		return err
+	}
+	var report models.Report
+	if err := s.db.WithContext(ctx).Where("id = ? AND chat_id = ?", reportID, chat.ID).First(&report).Error; err != nil {
+		return err
+	}
+	if report.UserID != userID && chat.UserID != userID {
+		return gorm.ErrRecordNotFound
+	}
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("report_id = ?", report.ID).Delete(&models.ReportSection{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&report).Error
+	})
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to delete report %d", reportID)
+		return fmt.Errorf("failed to delete report: %w", err)
+	}
+	return nil
+}
+
+// start writes a report in the background unless it is already being written
+func (s *ReportService) start(reportID uint, sessionID string) bool {
+	s.mutex.Lock()
+	if s.inFlight[reportID] {
+		s.mutex.Unlock()
+		return false
+	}
+	s.inFlight[reportID] = true
+	s.mutex.Unlock()
+
+	go func() {
+		defer func() {
+			s.mutex.Lock()
+			delete(s.inFlight, reportID)
+			s.mutex.Unlock()
+		}()
+		s.run(reportID, sessionID)
+	}()
+	return true
+}
+
+// run plans the outline of a report if it has none yet and writes the sections that are
+// missing, saving each one as it is done
+func (s *ReportService) run(reportID uint, sessionID string) {
+	ctx := context.Background()
+	var report models.Report
+	err := s.db.Preload("Sections", func(db *gorm.DB) *gorm.DB { return db.Order("position asc") }).First(&report, reportID).Error
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to load report %d", reportID)
+		return
+	}
+
+	report.Status = JobRunning
+	report.Error = ""
+	s.save(&report, sessionID)
+
+	if len(report.Sections) == 0 {
+		if err := s.planOutline(ctx, &report, sessionID); err != nil {
+			s.fail(&report, sessionID, err)
+			return
+		}
+		s.save(&report, sessionID)
+	}
+
+	for i := range report.Sections {
+		section := &report.Sections[i]
+		if section.Generated {
+			continue
+		}
+		// Stop when the report was deleted meanwhile
+		if err := s.db.Select("id").First(&models.Report{}, report.ID).Error; err != nil {
+			return
+		}
+		if err := s.writeSection(ctx, &report, section, sessionID); err != nil {
+			s.fail(&report, sessionID, err)
+			return
+		}
+		s.save(&report, sessionID)
+
+		if err := s.corpus.keepAlive(ctx, sessionID); err != nil {
+			s.logger.Warn().Err(err).Msgf("Failed to record activity of session %s", sessionID)
+		}
+	}
+
+	report.Status = JobCompleted
+	s.save(&report, sessionID)
+	s.logger.Info().Msgf("Report %d completed with %d sections, %d tokens used", report.ID, len(report.Sections), report.TokenCountUsed)
+}
+
+func (s *ReportService) planOutline(ctx context.Context, report *models.Report, sessionID string) error {
+	text, err := s.generate(ctx, report, sessionID, func(model *genai.GenerativeModel) {
+		model.SetTemperature(0.2)
+		model.ResponseMIMEType = "application/json"
+		model.ResponseSchema = &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"title": {Type: genai.TypeString, Description: "A specific title for the review"},
+				"sections": {
+					Type: genai.TypeArray,
+					Items: &genai.Schema{
+						Type: genai.TypeObject,
+						Properties: map[string]*genai.Schema{
+							"title": {Type: genai.TypeString},
+							"brief": {Type: genai.TypeString, Description: "What the section covers and which documents it draws on"},
+						},
+						Required: []string{"title", "brief"},
+					},
+				},
+			},
+			Required: []string{"title", "sections"},
+		}
+	}, outlinePrompt(report))
+	if err != nil {
+		return fmt.Errorf("failed to plan outline: %w", err)
+	}
+
+	var outline struct {
+		Title    string `json:"title"`
+		Sections []struct {
+			Title string `json:"title"`
+			Brief string `json:"brief"`
+		} `json:"sections"`
+	}
+	if err := json.Unmarshal([]byte(text), &outline); err != nil {
+		return fmt.Errorf("failed to parse outline: %w", err)
+	}
+
+	var sections []models.ReportSection
+	for _, planned := range outline.Sections {
+		title := truncateRunes(strings.TrimSpace(planned.Title), maxChatTitleLength)
+		if title == "" {
+			continue
+		}
+		sections = append(sections, models.ReportSection{
+			ReportID: report.ID,
+			Position: len(sections),
+			Title:    title,
+			Brief:    strings.TrimSpace(planned.Brief),
+		})
+		if len(sections) == maxReportSections {
+			break
+		}
+	}
+	if len(sections) == 0 {
+		return errors.New("the outline has no sections")
+	}
+	if err := s.db.Create(&sections).Error; err != nil {
+		return fmt.Errorf("failed to save outline: %w", err)
+	}
+	report.Sections = sections
+	report.Title = truncateRunes(strings.TrimSpace(outline.Title), maxChatTitleLength)
+	if report.Title == "" {
+		report.Title = "Literature review"
+	}
+	return nil
+}
+
+func (s *ReportService) writeSection(ctx context.Context, report *models.Report, section *models.ReportSection, sessionID string) error {
+	text, err := s.generate(ctx, report, sessionID, func(model *genai.GenerativeModel) {
+		model.SetTemperature(0.4)
+		model.SetMaxOutputTokens(reportSectionTokens)
+	}, sectionPrompt(report, section))
+	if err != nil {
+		return fmt.Errorf("failed to write section %q: %w", section.Title, err)
+	}
+
+	section.Content = text
+	section.Generated = true
+	err = s.db.Model(section).Updates(map[string]interface{}{
+		"content":   section.Content,
+		"generated": true,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to save section %q: %w", section.Title, err)
+	}
+	return nil
+}
+
+// generate runs one step of a report against the session's corpus and bills it to the
+// report's requester
+func (s *ReportService) generate(ctx context.Context, report *models.Report, sessionID string, configure func(*genai.GenerativeModel), prompt string) (string, error) {
+	model, cache, err := s.corpus.model(ctx, sessionID)
+	if err != nil {
+		return "", err
+	}
+	if err := checkJobCredit(ctx, s.meter, report.UserID, cache); err != nil {
+		return "", err
+	}
+	configure(model)
+
+	stepCtx, cancel := context.WithTimeout(ctx, reportStepTimeout)
+	defer cancel()
+	resp, err := model.GenerateContent(stepCtx, genai.Text(prompt))
+	if err != nil {
+		return "", err
+	}
+	tokens, tokenHours, err := billJob(ctx, s.meter, report.UserID, cache, resp)
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to bill report %d", report.ID)
+	}
+	report.TokenCountUsed += tokens
+	report.TokenHoursUsed += tokenHours
+
+	text := strings.TrimSpace(responseText(resp))
+	if text == "" {
+		return "", errors.New("the model returned no text")
+	}
+	return text, nil
+}
+
+func (s *ReportService) fail(report *models.Report, sessionID string, err error) {
+	s.logger.Error().Err(err).Msgf("Report %d failed", report.ID)
+	report.Status = JobFailed
+	report.Error = err.Error()
+	s.save(report, sessionID)
+}
+
+// save records the progress of a report and publishes it
+func (s *ReportService) save(report *models.Report, sessionID string) {
+	err := s.db.Model(report).Updates(map[string]interface{}{
+		"status":           report.Status,
+		"error":            report.Error,
+		"title":            report.Title,
+		"token_count_used": report.TokenCountUsed,
+		"token_hours_used": report.TokenHoursUsed,
+	}).Error
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to save progress of report %d", report.ID)
+	}
+
+	written := 0
+	for _, section := range report.Sections {
+		if section.Generated {
+			written++
+		}
+	}
+	s.corpus.publish(sessionID, JobEvent{
+		Kind:      "report",
+		JobID:     report.ID,
+		Status:    report.Status,
+		Completed: written,
+		Total:     len(report.Sections),
+		Error:     report.Error,
+	})
+}
+
+// bibliography lists the session's documents a report cites, in the order of their
+// positions, with the reference data of arXiv papers
+func (s *ReportService) bibliography(ctx context.Context, report *models.Report, documents []models.ChatDocument) ([]reportReference, error) {
+	cited := make(map[int]bool)
+	for _, section := range report.Sections {
+		for _, position := range reportCitedPositions(section.Content) {
+			cited[position] = true
+		}
+	}
+
+	var arxivIDs []string
+	for _, doc := range documents {
+		if (len(cited) == 0 || cited[doc.Position]) && doc.ArxivID != "" {
+			arxivIDs = append(arxivIDs, doc.ArxivID)
+		}
+	}
+	papers := make(map[string]*models.PaperReference)
+	if len(arxivIDs) > 0 {
+		// A paper's own entry is the reference whose parent is the paper itself
+		var refs []models.PaperReference
+		if err := s.db.WithContext(ctx).Where("arxiv_id IN ? AND parent_arxiv_id = arxiv_id", arxivIDs).Find(&refs).Error; err != nil {
+			return nil, fmt.Errorf("failed to get paper references: %w", err)
+		}
+		for i := range refs {
+			papers[refs[i].ArxivID] = &refs[i]
+		}
+	}
+
+	var references []reportReference
+	for _, doc := range documents {
+		// A report without citations lists the whole corpus
+		if len(cited) > 0 && !cited[doc.Position] {
+			continue
+		}
+		references = append(references, reportReference{
+			Position: doc.Position,
+			Text:     referenceText(doc, papers[doc.ArxivID]),
+		})
+	}
+	return references, nil
+}
+
+func reportTopic(report *models.Report) string {
+	if report.Topic != "" {
+		return report.Topic
+	}
+	return defaultReportTopic
+}
+
+func outlinePrompt(report *models.Report) string {
+	return fmt.Sprintf("Plan a literature review on %s, based only on the documents in the context. "+
+		"Give it a title and an outline of 3 to %d sections organized by theme rather than by document, "+
+		"from an introduction to open problems and future directions. "+
+		"For every section, briefly describe what it covers and which documents it draws on as <Document N>.",
+		reportTopic(report), maxReportSections)
+}
+
+func sectionPrompt(report *models.Report, section *models.ReportSection) string {
+	var outline strings.Builder
+	for _, other := range report.Sections {
+		fmt.Fprintf(&outline, "%d. %s: %s\n", other.Position+1, other.Title, other.Brief)
+	}
+	return fmt.Sprintf("You are writing a literature review titled %q on %s. Its outline is:\n\n%s\n"+
+		"Write section %d, %q, which covers: %s\n\n"+
+		"Write only the body of this section in Markdown, without its title, using ### for subsections if needed. "+
+		"Synthesize across the documents instead of summarizing them one by one, and leave out what belongs to other sections. "+
+		"Cite every claim with the document it comes from as <Document N>, and only use the documents in the context.",
+		report.Title, reportTopic(report), outline.String(), section.Position+1, section.Title, section.Brief)
+}
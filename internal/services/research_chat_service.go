@@ -1,8 +1,11 @@
 package services
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
@@ -21,6 +24,10 @@ type ResearchChatService struct {
 	chatService        ChatServiceDB
 	cacheServiceDB     CacheServiceDB
 	cloudStorage       CloudStorageManager
+	documentLibrary    DocumentLibrary
+	summarizer         SessionSummarizer
+	instructions       InstructionResolver
+	glossary           GlossaryBuilder
 	cacheExpiration    time.Duration
 	bucketName         string
 	logger             zerolog.Logger
@@ -35,6 +42,10 @@ func NewResearchChatService(
 	cacheExpiration time.Duration,
 	cloudStorage CloudStorageManager,
 	bucketName string,
+	documentLibrary DocumentLibrary,
+	summarizer SessionSummarizer,
+	instructions InstructionResolver,
+	glossary GlossaryBuilder,
 	logger zerolog.Logger,
 ) *ResearchChatService {
 	return &ResearchChatService{
@@ -46,42 +57,182 @@ func NewResearchChatService(
 		cacheExpiration:    cacheExpiration,
 		cloudStorage:       cloudStorage,
 		bucketName:         bucketName,
+		documentLibrary:    documentLibrary,
+		summarizer:         summarizer,
+		instructions:       instructions,
+		glossary:           glossary,
 		logger:             logger,
 	}
 }
 
-func (s *ResearchChatService) StartResearchSession(c *gin.Context, arxivIDs []string, userPDFs []string, priceTier string) (string, string, error) {
+// ResearchSessionRequest describes the corpus and settings of a new research session
+type ResearchSessionRequest struct {
+	ArxivIDs    []string
+	DocumentIDs []uint // documents from the user's library
+	PriceTier   string
+	ProjectID   *uint                   // project the session is filed under, if any
+	WorkspaceID *uint                   // workspace whose pool pays for the session; the caller must have checked membership
+	Instruction InstructionSelection    // answer style of the session
+	Generation  models.GenerationParams // sampling and safety settings, validated against the price tier
+	Budget      *CorpusBudget           // token budget the corpus is trimmed to, if any
+	// IncludeFigures caches images of the documents' figures and tables along with their text,
+	// on the pro tier only
+	IncludeFigures bool
+	// ExtractionMode is how the text of the documents is extracted, ExtractionModeText when
+	// empty. ExtractionModeMath recovers their equations as LaTeX.
+	ExtractionMode string
+}
+
+// extractionOptions checks what the request asks to extract from its documents: figure
+// images only on tiers that cache them, and a known extraction mode
+func extractionOptions(req ResearchSessionRequest) (ExtractionOptions, error) {
+	if req.IncludeFigures && req.PriceTier != "pro" {
+		return ExtractionOptions{}, fmt.Errorf("%w, not on the %s tier", ErrFiguresRequireProTier, req.PriceTier)
+	}
+	options := ExtractionOptions{Figures: req.IncludeFigures}
+	switch req.ExtractionMode {
+	case "", ExtractionModeText:
+	case ExtractionModeMath:
+		options.Math = true
+	default:
+		return ExtractionOptions{}, fmt.Errorf("%w, not %q", ErrInvalidExtractionMode, req.ExtractionMode)
+	}
+	return options, nil
+}
+
+// SessionEstimate is what keeping a corpus cached for a while would cost
+type SessionEstimate struct {
+	TokenCount      int32 // tokens of the corpus and system instruction, counted with the tier's tokenizer
+	Duration        time.Duration
+	TokenHours      float64 // million token-hours the cache would use over the duration
+	RemainingCredit float64
+	Affordable      bool
+	Corpus          *CorpusPlan // the corpus as it would be cached
+}
+
+// EstimateResearchSession aggregates the corpus of a research session without starting it,
+// trimmed to the request's token budget if it has one, and estimates what keeping it cached
+// for the duration would cost on the tier. A zero duration is the cache's initial lifetime.
+func (s *ResearchChatService) EstimateResearchSession(ctx context.Context, userID uuid.UUID, req ResearchSessionRequest, duration time.Duration) (*SessionEstimate, error) {
+	if duration == 0 {
+		duration = s.cacheExpiration
+	}
+	options, err := extractionOptions(req)
+	if err != nil {
+		return nil, err
+	}
+	remainingCredit, err := s.cacheManagement.RemainingCredit(ctx, userID, req.WorkspaceID, req.PriceTier)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user budget: %w", err)
+	}
+	instruction, err := s.instructions.ResolveInstruction(ctx, userID, req.Instruction)
+	if err != nil {
+		return nil, err
+	}
+	userDocuments, err := s.documentLibrary.GetUserDocuments(ctx, userID, req.DocumentIDs, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load library documents: %w", err)
+	}
+	plan, err := s.planCorpus(ctx, req, options, userDocuments, SystemInstruction(instruction))
+	if err != nil {
+		return nil, err
+	}
+	return estimateCorpus(plan, duration, remainingCredit), nil
+}
+
+// planCorpus collects the documents of a session and trims them to the request's budget
+func (s *ResearchChatService) planCorpus(ctx context.Context, req ResearchSessionRequest, options ExtractionOptions, userDocuments []UserDocument, systemInstruction string) (*CorpusPlan, error) {
+	corpus, err := s.contentAggregation.CollectDocuments(req.ArxivIDs, userDocuments, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate documents: %w", err)
+	}
+	return planCorpus(corpus, req.Budget, s.contentAggregation.RenderCorpus, func(content string, figures []FigureImage) (int32, error) {
+		return s.cacheManagement.CountTokens(ctx, req.PriceTier, content, figures, systemInstruction)
+	})
+}
+
+func estimateCorpus(plan *CorpusPlan, duration time.Duration, remainingCredit float64) *SessionEstimate {
+	tokenHours := float64(plan.TokenCount) * duration.Hours() / 1_000_000
+	return &SessionEstimate{
+		TokenCount:      plan.TokenCount,
+		Duration:        duration,
+		TokenHours:      tokenHours,
+		RemainingCredit: remainingCredit,
+		Affordable:      tokenHours <= remainingCredit,
+		Corpus:          plan,
+	}
+}
+
+// StartedSession is a research session that was started and the corpus its cache holds
+type StartedSession struct {
+	SessionID string
+	CacheName string
+	Corpus    *CorpusPlan
+}
+
+func (s *ResearchChatService) StartResearchSession(c *gin.Context, req ResearchSessionRequest) (*StartedSession, error) {
+	priceTier := req.PriceTier
 	s.logger.Info().Msg("Starting research session")
 	user, exists := c.Get("user")
 	if !exists {
 		s.logger.Error().Msg("User not found in context")
-		return "", "", fmt.Errorf("user not found in context")
+		return nil, fmt.Errorf("user not found in context")
 	}
 	userModel, ok := user.(*models.User)
 	if !ok {
 		s.logger.Error().Msg("Invalid user type in context")
-		return "", "", fmt.Errorf("invalid user type in context")
+		return nil, fmt.Errorf("invalid user type in context")
+	}
+
+	if err := ValidateGenerationParams(priceTier, req.Generation); err != nil {
+		return nil, err
+	}
+	options, err := extractionOptions(req)
+	if err != nil {
+		return nil, err
 	}
 
 	// Check if user has enough credits
-	budget, err := s.cacheServiceDB.GetTierTokenBudgetDB(userModel.ID, priceTier)
+	remainingCredit, err := s.cacheManagement.RemainingCredit(c.Request.Context(), userModel.ID, req.WorkspaceID, priceTier)
 	if err != nil {
 		s.logger.Error().Err(err).Msg("Failed to get user budget")
-		return "", "", fmt.Errorf("failed to get user budget: %w", err)
+		return nil, fmt.Errorf("failed to get user budget: %w", err)
 	}
 
-	remainingCredit := budget.TokenHoursBought - budget.TokenHoursUsed
 	if remainingCredit <= (999_999.0 / 1_000_000.0 * 11.0 / 60.0) {
 		s.logger.Error().Msg("Insufficient credits to start a new session")
-		return "", "", fmt.Errorf("insufficient credits to start a new session")
+		return nil, fmt.Errorf("%w to start a new session", ErrInsufficientCredit)
 	}
 
-	s.logger.Info().Msgf("Aggregating documents for arXiv IDs: %v and user PDFs: %v\n", arxivIDs, userPDFs)
-	// Aggregate content
-	aggregatedContent, err := s.contentAggregation.AggregateDocuments(arxivIDs, userPDFs)
+	instruction, err := s.instructions.ResolveInstruction(c.Request.Context(), userModel.ID, req.Instruction)
+	if err != nil {
+		return nil, err
+	}
+
+	userDocuments, err := s.documentLibrary.GetUserDocuments(c.Request.Context(), userModel.ID, req.DocumentIDs, options)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to load library documents")
+		return nil, fmt.Errorf("failed to load library documents: %w", err)
+	}
+
+	s.logger.Info().Msgf("Aggregating documents for arXiv IDs: %v and library documents: %v\n", req.ArxivIDs, req.DocumentIDs)
+	// Aggregate content, trimmed to the token budget if one was set
+	plan, err := s.planCorpus(c.Request.Context(), req, options, userDocuments, SystemInstruction(instruction))
 	if err != nil {
 		s.logger.Error().Err(err).Msg("Failed to aggregate documents")
-		return "", "", fmt.Errorf("failed to aggregate documents: %w", err)
+		return nil, err
+	}
+	aggregatedContent, documents := plan.Content, plan.Documents
+	if trimmed := plan.Trimmed(); len(trimmed) > 0 {
+		s.logger.Info().Msgf("Trimmed %d documents from %d to %d tokens to fit the token budget", len(trimmed), plan.OriginalTokenCount, plan.TokenCount)
+	}
+
+	// Refuse corpora the budget cannot keep cached for the cache's initial lifetime, before
+	// paying for the cache
+	estimate := estimateCorpus(plan, s.cacheExpiration, remainingCredit)
+	if !estimate.Affordable {
+		s.logger.Error().Msgf("Corpus of %d tokens needs %.6f token hours, %.6f left", estimate.TokenCount, estimate.TokenHours, remainingCredit)
+		return nil, fmt.Errorf("%w: the corpus of %d tokens needs %.6f million token-hours, %.6f are left", ErrInsufficientCredit, estimate.TokenCount, estimate.TokenHours, remainingCredit)
 	}
 
 	// Save raw text cache to Google Cloud Storage
@@ -90,22 +241,37 @@ func (s *ResearchChatService) StartResearchSession(c *gin.Context, arxivIDs []st
 	err = s.SaveRawTextCache(c.Request.Context(), sessionID, aggregatedContent)
 	if err != nil {
 		s.logger.Error().Err(err).Msg("Failed to save raw text cache")
-		return "", "", fmt.Errorf("failed to save raw text cache: %w", err)
+		return nil, fmt.Errorf("failed to save raw text cache: %w", err)
+	}
+	if err := s.SaveFigures(c.Request.Context(), sessionID, plan.Figures); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to save figures")
+		return nil, fmt.Errorf("failed to save figures: %w", err)
 	}
 
 	s.logger.Info().Msgf("Creating content cache for user ID: %s, session ID: %s, price tier: %s", userModel.ID, sessionID, priceTier)
 	// Create cache
-	cacheName, cacheExpiryTime, err := s.cacheManagement.CreateContentCache(c.Request.Context(), userModel.ID, sessionID, priceTier, aggregatedContent)
+	cacheName, cacheExpiryTime, err := s.cacheManagement.CreateContentCache(c.Request.Context(), userModel.ID, sessionID, priceTier, aggregatedContent, plan.Figures, SystemInstruction(instruction))
 	// Printout cacheCreateTime
 	s.logger.Info().Msgf("Cache expiry time from CreateContentCache: %v", cacheExpiryTime)
 	if err != nil {
 		s.logger.Error().Err(err).Msg("Failed to create content cache")
-		return "", "", fmt.Errorf("failed to create content cache: %w", err)
+		return nil, fmt.Errorf("failed to create content cache: %w", err)
+	}
+
+	if req.WorkspaceID != nil {
+		// Bill the cache to the workspace pool before the session starts metering it
+		if err := s.cacheServiceDB.SetCacheWorkspaceDB(sessionID, *req.WorkspaceID); err != nil {
+			s.logger.Error().Err(err).Msg("Failed to bill cache to workspace")
+			if err := s.cacheManagement.DeleteCache(c.Request.Context(), userModel.ID, sessionID, cacheName); err != nil {
+				s.logger.Error().Err(err).Msg("Failed to delete cache during cleanup")
+			}
+			return nil, fmt.Errorf("failed to bill cache to workspace: %w", err)
+		}
 	}
 
 	s.logger.Info().Msgf("Starting chat session for user ID: %s, cache name: %s, session ID: %s", userModel.ID, cacheName, sessionID)
 	// Start chat session
-	err = s.chatSession.StartChatSession(c.Request.Context(), userModel.ID, cacheName, sessionID, cacheExpiryTime)
+	err = s.chatSession.StartChatSession(c.Request.Context(), userModel.ID, cacheName, sessionID, cacheExpiryTime, req.Generation)
 	if err != nil {
 		s.logger.Error().Err(err).Msg("Failed to start chat session")
 		// Clean up cache if session start fails
@@ -120,12 +286,54 @@ func (s *ResearchChatService) StartResearchSession(c *gin.Context, arxivIDs []st
 		if err := s.cloudStorage.DeleteFile(c.Request.Context(), s.bucketName, fileName); err != nil {
 			s.logger.Error().Err(err).Msg("Failed to delete file from storage during cleanup")
 		}
+		s.deleteFigures(c.Request.Context(), sessionID, plan.Figures)
+
+		return nil, fmt.Errorf("failed to start chat session: %w", err)
+	}
 
-		return "", "", fmt.Errorf("failed to start chat session: %w", err)
+	if err := s.chatService.SaveChatDocumentsToDB(sessionID, chatDocumentsFromAggregation(documents)); err != nil {
+		// The session is usable without this record, it only feeds history and version tracking
+		s.logger.Error().Err(err).Msgf("Failed to save chat documents for session ID: %s", sessionID)
+	}
+	if err := s.chatService.SetChatGenerationDB(sessionID, req.Generation); err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to record the generation parameters of session ID: %s", sessionID)
+	}
+	if instruction != nil {
+		if err := s.chatService.SetChatInstructionDB(sessionID, instruction.PresetName, instruction.Instruction); err != nil {
+			s.logger.Error().Err(err).Msgf("Failed to record the answer style of session ID: %s", sessionID)
+		}
+	}
+	if req.ProjectID != nil {
+		if err := s.chatService.SetChatProjectDB(sessionID, req.ProjectID); err != nil {
+			s.logger.Error().Err(err).Msgf("Failed to add session ID %s to project %d", sessionID, *req.ProjectID)
+		}
+	}
+	if req.WorkspaceID != nil {
+		if err := s.chatService.SetChatWorkspaceDB(sessionID, *req.WorkspaceID); err != nil {
+			s.logger.Error().Err(err).Msgf("Failed to share session ID %s with workspace %d", sessionID, *req.WorkspaceID)
+		}
 	}
 
+	s.glossary.BuildGlossaryAsync(sessionID)
+
 	s.logger.Info().Msgf("Research session started successfully. Session ID: %s, Cache Name: %s", sessionID, cacheName)
-	return sessionID, cacheName, nil
+	return &StartedSession{SessionID: sessionID, CacheName: cacheName, Corpus: plan}, nil
+}
+
+func chatDocumentsFromAggregation(documents []AggregatedDocument) []models.ChatDocument {
+	chatDocuments := make([]models.ChatDocument, len(documents))
+	for i, doc := range documents {
+		chatDocuments[i] = models.ChatDocument{
+			Position:   doc.Index,
+			Source:     doc.Source,
+			ArxivID:    doc.ArxivID,
+			DocumentID: doc.DocumentID,
+			Title:      doc.Title,
+			Trims:      doc.Trims,
+			Figures:    doc.Figures,
+		}
+	}
+	return chatDocuments
 }
 
 func (s *ResearchChatService) SaveRawTextCache(ctx context.Context, sessionID string, content string) error {
@@ -138,20 +346,56 @@ func (s *ResearchChatService) SaveRawTextCache(ctx context.Context, sessionID st
 	return err
 }
 
+// figureObjectName returns where an image of a session's figures is stored
+func figureObjectName(sessionID, name string) string {
+	return fmt.Sprintf("figures_%s/%s", sessionID, name)
+}
+
+// SaveFigures stores the figure images cached with a session next to its raw text cache, under
+// the names its markup refers to them by
+func (s *ResearchChatService) SaveFigures(ctx context.Context, sessionID string, figures []FigureImage) error {
+	for i, figure := range figures {
+		objectName := figureObjectName(sessionID, figure.Name)
+		if err := s.cloudStorage.UploadFile(ctx, s.bucketName, objectName, bytes.NewReader(figure.PNG)); err != nil {
+			s.deleteFigures(ctx, sessionID, figures[:i])
+			return fmt.Errorf("failed to upload %s: %w", objectName, err)
+		}
+	}
+	return nil
+}
+
+func (s *ResearchChatService) deleteFigures(ctx context.Context, sessionID string, figures []FigureImage) {
+	for _, figure := range figures {
+		objectName := figureObjectName(sessionID, figure.Name)
+		if err := s.cloudStorage.DeleteFile(ctx, s.bucketName, objectName); err != nil && !errors.Is(err, ErrStorageObjectNotFound) {
+			s.logger.Error().Err(err).Msgf("Failed to delete stored figure %s", objectName)
+		}
+	}
+}
+
 func (s *ResearchChatService) GetRawTextCache(ctx context.Context, sessionID string) (string, error) {
 	objectName := fmt.Sprintf("raw_cache_%s.txt", sessionID)
 	s.logger.Info().Msgf("Downloading file from storage: %s", objectName)
-	content, err := s.cloudStorage.DownloadFile(ctx, s.bucketName, objectName)
+	reader, err := s.cloudStorage.DownloadFile(ctx, s.bucketName, objectName)
 	if err != nil {
 		s.logger.Error().Err(err).Msg("Failed to download file from storage")
 		return "", err
 	}
-	return string(content), nil
+	defer reader.Close()
+
+	var content strings.Builder
+	if _, err := io.Copy(&content, reader); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to read file from storage")
+		return "", fmt.Errorf("failed to read raw text cache: %w", err)
+	}
+	return content.String(), nil
 }
 
-func (s *ResearchChatService) SendMessage(ctx context.Context, sessionID, message string) (*genai.GenerateContentResponseIterator, error) {
+// SendMessage sends a prompt to the session. instruction overrides the session's answer style
+// for this prompt only and may be empty.
+func (s *ResearchChatService) SendMessage(ctx context.Context, sessionID, message, instruction string) (*genai.GenerateContentResponseIterator, error) {
 	// Send message and get response
-	responseIterator, err := s.chatSession.StreamChatMessage(ctx, sessionID, message)
+	responseIterator, err := s.chatSession.StreamChatMessage(ctx, sessionID, message, instruction)
 	if err != nil {
 		s.logger.Error().Err(err).Msg("Failed to send message")
 		return nil, fmt.Errorf("failed to send message: %w", err)
@@ -160,16 +404,114 @@ func (s *ResearchChatService) SendMessage(ctx context.Context, sessionID, messag
 	return responseIterator, nil
 }
 
-func (s *ResearchChatService) SaveAIResponse(sessionID, response string) error {
-	// Save AI response to history
-	if err := s.chatService.SaveMessageToDB(sessionID, "ai", response); err != nil {
-		s.logger.Error().Err(err).Msg("Failed to save AI response")
-		return fmt.Errorf("failed to save AI response: %w", err)
+// UpdateGenerationParams changes the sampling and safety settings of a running session
+func (s *ResearchChatService) UpdateGenerationParams(sessionID string, params models.GenerationParams) error {
+	return s.chatSession.SetGenerationParams(sessionID, params)
+}
+
+// ResolveInstruction returns the instruction text of a per-prompt answer style, empty when
+// none was selected
+func (s *ResearchChatService) ResolveInstruction(ctx context.Context, userID uuid.UUID, selection InstructionSelection) (string, error) {
+	resolved, err := s.instructions.ResolveInstruction(ctx, userID, selection)
+	if err != nil || resolved == nil {
+		return "", err
+	}
+	return resolved.Instruction, nil
+}
+
+// ErrInvalidBranchOperation is returned when a message cannot be edited or an answer cannot be regenerated
+var ErrInvalidBranchOperation = errors.New("invalid branch operation")
+
+// EditMessage forks the conversation at an earlier prompt and sends the edited prompt on a
+// new branch that continues from the messages before it. The caller saves the prompt and
+// the answer as for SendMessage.
+func (s *ResearchChatService) EditMessage(ctx context.Context, sessionID string, messageID uint, content string) (*genai.GenerateContentResponseIterator, *models.ChatBranch, error) {
+	original, err := s.chatService.GetMessageFromDB(sessionID, messageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if original.Type != "user" {
+		return nil, nil, fmt.Errorf("%w: only prompts can be edited", ErrInvalidBranchOperation)
+	}
+	return s.fork(ctx, sessionID, original.ParentID, original.ParentID, "edit", content)
+}
+
+// RegenerateAnswer asks the last prompt of the active branch again. The new answer goes on a
+// branch next to the previous one; the caller saves the answer but not the prompt.
+func (s *ResearchChatService) RegenerateAnswer(ctx context.Context, sessionID string) (*genai.GenerateContentResponseIterator, *models.ChatBranch, error) {
+	messages, err := s.chatService.GetActiveBranchMessagesFromDB(sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var prompt *models.Message
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Type == "user" {
+			prompt = &messages[i]
+			break
+		}
+	}
+	if prompt == nil {
+		return nil, nil, fmt.Errorf("%w: there is no answer to regenerate", ErrInvalidBranchOperation)
+	}
+	return s.fork(ctx, sessionID, &prompt.ID, prompt.ParentID, "regenerate", prompt.Content)
+}
+
+// fork starts a branch after forkedFromID, replays the conversation up to historyTip to the
+// model and sends prompt. The iterator only calls the model once it is read.
+func (s *ResearchChatService) fork(ctx context.Context, sessionID string, forkedFromID, historyTip *uint, reason, prompt string) (*genai.GenerateContentResponseIterator, *models.ChatBranch, error) {
+	history, err := s.chatService.GetMessagePathFromDB(historyTip)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get conversation: %w", err)
 	}
+	if err := s.chatSession.SetChatHistory(sessionID, history); err != nil {
+		return nil, nil, err
+	}
+	responseIterator, err := s.SendMessage(ctx, sessionID, prompt, "")
+	if err != nil {
+		s.restoreHistory(sessionID)
+		return nil, nil, err
+	}
+	branch, err := s.chatService.CreateBranchDB(sessionID, forkedFromID, reason)
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to create branch of session %s", sessionID)
+		s.restoreHistory(sessionID)
+		return nil, nil, fmt.Errorf("failed to create branch: %w", err)
+	}
+	return responseIterator, branch, nil
+}
 
+// SwitchBranch makes another branch of the session active. While the session is running the
+// model continues from that branch; a terminated session's branches can still be browsed.
+func (s *ResearchChatService) SwitchBranch(sessionID string, branchID uint) error {
+	if err := s.chatService.SetActiveBranchDB(sessionID, branchID); err != nil {
+		return err
+	}
+	messages, err := s.chatService.GetActiveBranchMessagesFromDB(sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to get conversation: %w", err)
+	}
+	if err := s.chatSession.SetChatHistory(sessionID, messages); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return err
+	}
 	return nil
 }
 
+// GetBranches lists the branches of a session, oldest first
+func (s *ResearchChatService) GetBranches(sessionID string) ([]models.ChatBranch, error) {
+	return s.chatService.GetBranchesFromDB(sessionID)
+}
+
+// restoreHistory puts the active branch back in front of the model after a failed fork
+func (s *ResearchChatService) restoreHistory(sessionID string) {
+	messages, err := s.chatService.GetActiveBranchMessagesFromDB(sessionID)
+	if err == nil {
+		err = s.chatSession.SetChatHistory(sessionID, messages)
+	}
+	if err != nil && !errors.Is(err, ErrSessionNotFound) {
+		s.logger.Error().Err(err).Msgf("Failed to restore conversation of session %s", sessionID)
+	}
+}
+
 func (s *ResearchChatService) EndResearchSession(ctx context.Context, sessionID string) error {
 	// Assumes the only caller of this method is an api endpoint whena user Terminates a chat
 	var reason TerminationReason = UserInitiated
@@ -180,16 +522,26 @@ func (s *ResearchChatService) EndResearchSession(ctx context.Context, sessionID
 	return nil
 }
 
-func (s *ResearchChatService) GetUserChatHistory(userID uuid.UUID) ([]models.Chat, error) {
-	return s.chatService.GetChatsByUserIDFromDB(userID)
-}
-
 func (s *ResearchChatService) UpdateSessionActivity(ctx context.Context, sessionID string) error {
 	return s.chatSession.UpdateSessionActivity(ctx, sessionID)
 }
 
-func (s *ResearchChatService) SaveMessageToDB(ctx context.Context, sessionID, msgType, content string) error {
-	return s.chatService.SaveMessageToDB(sessionID, msgType, content)
+// SaveMessageToDB persists a message of the session; authorID is the participant who wrote
+// a user message and nil for AI responses. The first AI response gets the session its title.
+func (s *ResearchChatService) SaveMessageToDB(ctx context.Context, sessionID, msgType, content string, authorID *uuid.UUID) (*models.Message, error) {
+	message, err := s.chatService.SaveMessageToDB(sessionID, msgType, content, authorID)
+	if err != nil {
+		return nil, err
+	}
+	if msgType == "ai" {
+		s.summarizer.SummarizeAsync(sessionID, SummaryAfterFirstExchange)
+	}
+	return message, nil
+}
+
+// AcquireTurn reserves the session for one prompt, see ChatSessionService.AcquireTurn
+func (s *ResearchChatService) AcquireTurn(sessionID string) (func(), error) {
+	return s.chatSession.AcquireTurn(sessionID)
 }
 
 func (s *ResearchChatService) CheckSessionStatus(sessionID string) (SessionStatus, time.Time, error) {
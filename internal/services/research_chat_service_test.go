@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCorpusCache counts every corpus as the same number of tokens. Methods the tests do not
+// need are left to the nil CacheManager and panic.
+type fakeCorpusCache struct {
+	CacheManager
+	remaining float64
+	tokens    int32
+	created   bool
+}
+
+func (f *fakeCorpusCache) RemainingCredit(ctx context.Context, userID uuid.UUID, workspaceID *uint, priceTier string) (float64, error) {
+	return f.remaining, nil
+}
+
+func (f *fakeCorpusCache) CountTokens(ctx context.Context, priceTier, aggregatedContent string, figures []FigureImage, systemInstruction string) (int32, error) {
+	return f.tokens, nil
+}
+
+func (f *fakeCorpusCache) CreateContentCache(ctx context.Context, userID uuid.UUID, sessionID string, priceTier, aggregatedContent string, figures []FigureImage, systemInstruction string) (string, time.Time, error) {
+	f.created = true
+	return "", time.Time{}, errors.New("unexpected cache")
+}
+
+type fakeAggregator struct {
+	ContentAggregator
+}
+
+func (fakeAggregator) CollectDocuments(arxivIDs []string, userDocuments []UserDocument, options ExtractionOptions) ([]CorpusDocument, error) {
+	return nil, nil
+}
+
+func (fakeAggregator) RenderCorpus(corpus []CorpusDocument) (string, []AggregatedDocument, error) {
+	return "corpus", nil, nil
+}
+
+type fakeLibrary struct{}
+
+func (fakeLibrary) GetUserDocuments(ctx context.Context, userID uuid.UUID, documentIDs []uint, options ExtractionOptions) ([]UserDocument, error) {
+	return nil, nil
+}
+
+func TestEstimateCorpus(t *testing.T) {
+	tests := []struct {
+		name       string
+		tokens     int32
+		duration   time.Duration
+		credit     float64
+		tokenHours float64
+		affordable bool
+	}{
+		{"a million tokens for an hour", 1_000_000, time.Hour, 2, 1, true},
+		{"half a million tokens for two hours", 500_000, 2 * time.Hour, 0.5, 1, false},
+		{"exactly the credit left", 200_000, 30 * time.Minute, 0.1, 0.1, true},
+		{"no credit left", 1000, time.Hour, 0, 0.001, false},
+		{"no tokens", 0, time.Hour, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := &CorpusPlan{TokenCount: tt.tokens}
+			estimate := estimateCorpus(plan, tt.duration, tt.credit)
+			assert.InDelta(t, tt.tokenHours, estimate.TokenHours, 1e-9)
+			assert.Equal(t, tt.affordable, estimate.Affordable)
+			assert.Equal(t, tt.tokens, estimate.TokenCount)
+			assert.Equal(t, tt.duration, estimate.Duration)
+			assert.Equal(t, tt.credit, estimate.RemainingCredit)
+			assert.Same(t, plan, estimate.Corpus)
+		})
+	}
+}
+
+func TestUnaffordableCorpusIsRefused(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	user := &models.User{ID: uuid.New()}
+
+	tests := []struct {
+		name      string
+		remaining float64
+		tokens    int32
+	}{
+		// An hour of cache lifetime costs the corpus size in million token-hours
+		{"corpus larger than the credit", 0.5, 600_000},
+		{"credit too low to start any session", 0.01, 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := &fakeCorpusCache{remaining: tt.remaining, tokens: tt.tokens}
+			research := NewResearchChatService(fakeAggregator{}, cache, nil, nil, nil, time.Hour, nil, "", fakeLibrary{}, nil,
+				NewInstructionPresetService(nil, zerolog.Nop()), nil, zerolog.Nop())
+			req := ResearchSessionRequest{ArxivIDs: []string{"1706.03762"}, PriceTier: "base"}
+
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("POST", "/research-sessions", nil)
+			c.Set("user", user)
+			_, err := research.StartResearchSession(c, req)
+			assert.True(t, errors.Is(err, ErrInsufficientCredit), "got %v", err)
+			assert.False(t, cache.created, "no cache is paid for")
+		})
+	}
+
+	// The estimate tells the same before a session is started
+	cache := &fakeCorpusCache{remaining: 0.5, tokens: 600_000}
+	research := NewResearchChatService(fakeAggregator{}, cache, nil, nil, nil, time.Hour, nil, "", fakeLibrary{}, nil,
+		NewInstructionPresetService(nil, zerolog.Nop()), nil, zerolog.Nop())
+	req := ResearchSessionRequest{ArxivIDs: []string{"1706.03762"}, PriceTier: "base"}
+	estimate, err := research.EstimateResearchSession(context.Background(), user.ID, req, 0)
+	require.NoError(t, err)
+	assert.False(t, estimate.Affordable)
+	assert.Equal(t, time.Hour, estimate.Duration)
+	estimate, err = research.EstimateResearchSession(context.Background(), user.ID, req, 30*time.Minute)
+	require.NoError(t, err)
+	assert.True(t, estimate.Affordable)
+}
+
+func TestExtractionOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     ResearchSessionRequest
+		options ExtractionOptions
+		err     error
+	}{
+		{"text by default", ResearchSessionRequest{PriceTier: "base"}, ExtractionOptions{}, nil},
+		{"text", ResearchSessionRequest{PriceTier: "base", ExtractionMode: ExtractionModeText}, ExtractionOptions{}, nil},
+		{"math on base", ResearchSessionRequest{PriceTier: "base", ExtractionMode: ExtractionModeMath}, ExtractionOptions{Math: true}, nil},
+		{"figures on pro", ResearchSessionRequest{PriceTier: "pro", IncludeFigures: true}, ExtractionOptions{Figures: true}, nil},
+		{"figures and math on pro", ResearchSessionRequest{PriceTier: "pro", IncludeFigures: true, ExtractionMode: ExtractionModeMath},
+			ExtractionOptions{Figures: true, Math: true}, nil},
+		{"figures on base", ResearchSessionRequest{PriceTier: "base", IncludeFigures: true}, ExtractionOptions{}, ErrFiguresRequireProTier},
+		{"figures on an unknown tier", ResearchSessionRequest{PriceTier: "", IncludeFigures: true}, ExtractionOptions{}, ErrFiguresRequireProTier},
+		{"unknown mode", ResearchSessionRequest{PriceTier: "pro", ExtractionMode: "ocr"}, ExtractionOptions{}, ErrInvalidExtractionMode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := extractionOptions(tt.req)
+			if tt.err != nil {
+				assert.True(t, errors.Is(err, tt.err), "got %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.options, options)
+		})
+	}
+}
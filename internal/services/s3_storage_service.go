@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/rs/zerolog"
+)
+
+// Uploads of unknown size are sent as multipart uploads; this bounds the memory
+// buffered per part (and caps objects at 10,000 parts, i.e. ~160 GiB).
+const s3UploadPartSize = 16 << 20
+
+// S3Storage stores objects in AWS S3 or any S3-compatible store such as MinIO
+type S3Storage struct {
+	client *minio.Client
+	region string
+	logger zerolog.Logger
+}
+
+func NewS3Storage(cfg StorageConfig, logger zerolog.Logger) (*S3Storage, error) {
+	client, err := minio.New(cfg.S3Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.S3AccessKeyID, cfg.S3SecretAccessKey, ""),
+		Secure: cfg.S3UseSSL,
+		Region: cfg.S3Region,
+	})
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to initialize S3 client")
+		return nil, fmt.Errorf("failed to initialize S3 client: %w", err)
+	}
+	logger.Info().Msgf("S3 client initialized for endpoint %s", cfg.S3Endpoint)
+	return &S3Storage{client: client, region: cfg.S3Region, logger: logger}, nil
+}
+
+func (s *S3Storage) EnsureBucket(ctx context.Context, bucketName string) error {
+	exists, err := s.client.BucketExists(ctx, bucketName)
+	if err != nil {
+		return fmt.Errorf("failed to check bucket: %w", err)
+	}
+	if exists {
+		return nil
+	}
+	s.logger.Info().Msgf("Bucket %s does not exist. Creating...", bucketName)
+	if err := s.client.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{Region: s.region}); err != nil {
+		return fmt.Errorf("failed to create bucket: %w", err)
+	}
+	s.logger.Info().Msgf("Bucket %s created successfully", bucketName)
+	return nil
+}
+
+func (s *S3Storage) UploadFile(ctx context.Context, bucketName, objectName string, content io.Reader) error {
+	s.logger.Info().Msgf("Uploading file to bucket: %s, object: %s", bucketName, objectName)
+	_, err := s.client.PutObject(ctx, bucketName, objectName, content, -1, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+		PartSize:    s3UploadPartSize,
+	})
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to upload S3 object: %s", objectName)
+		return fmt.Errorf("failed to upload S3 object: %w", err)
+	}
+	s.logger.Info().Msgf("File uploaded successfully to bucket: %s, object: %s", bucketName, objectName)
+	return nil
+}
+
+func (s *S3Storage) DownloadFile(ctx context.Context, bucketName, objectName string) (io.ReadCloser, error) {
+	s.logger.Info().Msgf("Downloading file from bucket: %s, object: %s", bucketName, objectName)
+	obj, err := s.client.GetObject(ctx, bucketName, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get S3 object: %w", err)
+	}
+	// GetObject is lazy; Stat surfaces missing objects before the caller starts reading
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if isS3NotFound(err) {
+			return nil, fmt.Errorf("S3 object %s: %w", objectName, ErrStorageObjectNotFound)
+		}
+		s.logger.Error().Err(err).Msgf("Failed to open S3 object: %s", objectName)
+		return nil, fmt.Errorf("failed to open S3 object: %w", err)
+	}
+	return obj, nil
+}
+
+func (s *S3Storage) DeleteFile(ctx context.Context, bucketName, objectName string) error {
+	s.logger.Info().Msgf("Deleting file from bucket: %s, object: %s", bucketName, objectName)
+	// S3 deletes are idempotent, so check for the object first to report missing ones
+	if _, err := s.client.StatObject(ctx, bucketName, objectName, minio.StatObjectOptions{}); err != nil {
+		if isS3NotFound(err) {
+			return fmt.Errorf("S3 object %s: %w", objectName, ErrStorageObjectNotFound)
+		}
+		return fmt.Errorf("failed to stat S3 object: %w", err)
+	}
+	if err := s.client.RemoveObject(ctx, bucketName, objectName, minio.RemoveObjectOptions{}); err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to delete S3 object: %s", objectName)
+		return fmt.Errorf("failed to delete S3 object: %w", err)
+	}
+	s.logger.Info().Msgf("File deleted successfully from bucket: %s, object: %s", bucketName, objectName)
+	return nil
+}
+
+func (s *S3Storage) ListFiles(ctx context.Context, bucketName string) ([]string, error) {
+	s.logger.Info().Msgf("Listing files in bucket: %s", bucketName)
+	var fileNames []string
+	for obj := range s.client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Recursive: true}) {
+		if obj.Err != nil {
+			s.logger.Error().Err(obj.Err).Msgf("Failed to list objects in bucket: %s", bucketName)
+			return nil, fmt.Errorf("failed to list objects in bucket: %w", obj.Err)
+		}
+		fileNames = append(fileNames, obj.Key)
+	}
+	s.logger.Info().Msgf("Listed %d files in bucket: %s", len(fileNames), bucketName)
+	return fileNames, nil
+}
+
+func isS3NotFound(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NotFound"
+}
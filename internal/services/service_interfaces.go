@@ -5,36 +5,121 @@ import (
 	"io"
 	"time"
 
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/utils/pdflayout"
+
 	"github.com/google/generative-ai-go/genai"
 	"github.com/google/uuid"
 )
 
 type ContentAggregator interface {
-	AggregateDocuments(arxivIDs []string, userPDFs []string) (string, error)
+	CollectDocuments(arxivIDs []string, userDocuments []UserDocument, options ExtractionOptions) ([]CorpusDocument, error)
+	RenderCorpus(corpus []CorpusDocument) (string, []AggregatedDocument, error)
+}
+
+type PDFTextExtractor interface {
+	ExtractTextFromPDF(pdfPath string) (string, error)
+}
+
+// PaperTextExtractor extracts the text of one arXiv version of a paper
+type PaperTextExtractor interface {
+	ExtractArXivVersionText(arxivID string, version int) (string, error)
+}
+
+// FigureExtractor crops the figures and tables of a PDF into images
+type FigureExtractor interface {
+	ExtractFiguresFromPDF(pdfPath string) ([]FigureImage, error)
+}
+
+// MathTextExtractor extracts the text of a PDF with its equations as LaTeX.
+// RecognizesEquations is false when it would only extract the plain text.
+type MathTextExtractor interface {
+	RecognizesEquations() bool
+	ExtractMathTextFromPDF(pdfPath string) (string, error)
+}
+
+// EquationRecognizer finds the equations on the pages of a PDF and reads them as LaTeX
+type EquationRecognizer interface {
+	RecognizeEquations(pdfPath string) ([]pdflayout.Equation, error)
+}
+
+// DocumentLibrary gives research sessions access to the documents in a user's library.
+// The options tell what to extract from the documents' PDFs besides the text stored on upload.
+type DocumentLibrary interface {
+	GetUserDocuments(ctx context.Context, userID uuid.UUID, documentIDs []uint, options ExtractionOptions) ([]UserDocument, error)
 }
 
 type CacheManager interface {
-	CreateContentCache(ctx context.Context, userID uuid.UUID, sessionID string, priceTier, aggregatedContent string) (string, time.Time, error)
+	CreateContentCache(ctx context.Context, userID uuid.UUID, sessionID string, priceTier, aggregatedContent string, figures []FigureImage, systemInstruction string) (string, time.Time, error)
+	CountTokens(ctx context.Context, priceTier, aggregatedContent string, figures []FigureImage, systemInstruction string) (int32, error)
 	ExtendCacheLifetime(ctx context.Context, cachedContentName string, newExpirationTime time.Time) error
 	DeleteCache(ctx context.Context, userID uuid.UUID, sessionID string, cachedContentName string) error
 	GetGenerativeModel(ctx context.Context, cachedContentName string) (*genai.GenerativeModel, error)
 	RecordCacheTokenUsage(ctx context.Context, userID uuid.UUID, sessionID string) error
+	RemainingCredit(ctx context.Context, userID uuid.UUID, workspaceID *uint, priceTier string) (float64, error)
+}
+
+// MemberSpendingLimiter reports how much a workspace member may still spend from the pool.
+// limited is false when the member has no limit on the tier.
+type MemberSpendingLimiter interface {
+	RemainingMemberLimit(ctx context.Context, workspaceID uint, userID uuid.UUID, priceTier string) (remaining float64, limited bool, err error)
+}
+
+// UsageMeter bills usage outside of cached sessions against a budget
+type UsageMeter interface {
+	RemainingCredit(ctx context.Context, userID uuid.UUID, workspaceID *uint, priceTier string) (float64, error)
+	LogCacheUsage(ctx context.Context, userID uuid.UUID, workspaceID *uint, priceTier string, tokenHoursUsed float64, chatDuration float64, tokenCountUsed int32) error
+}
+
+// SessionSummarizer titles and summarizes sessions in the background
+type SessionSummarizer interface {
+	SummarizeAsync(sessionID string, trigger SummaryTrigger)
+}
+
+// GlossaryBuilder builds the glossary of a session's corpus in the background
+type GlossaryBuilder interface {
+	BuildGlossaryAsync(sessionID string) bool
+}
+
+// InstructionResolver turns a user's choice of answer style into instruction text
+type InstructionResolver interface {
+	ResolveInstruction(ctx context.Context, userID uuid.UUID, selection InstructionSelection) (*ResolvedInstruction, error)
+}
+
+// SessionActivity records activity on a running session, extending its cache when needed
+type SessionActivity interface {
+	UpdateSessionActivity(ctx context.Context, sessionID string) error
+}
+
+// SessionAuthorizer resolves a session the user may access as its creator or a workspace member
+type SessionAuthorizer interface {
+	AuthorizeSession(ctx context.Context, userID uuid.UUID, sessionID string) (*models.Chat, error)
 }
 
 type ChatSessionManager interface {
-	StartChatSession(ctx context.Context, userID uuid.UUID, cachedContentName string, sessionID string, cacheCreateTime time.Time) error
+	StartChatSession(ctx context.Context, userID uuid.UUID, cachedContentName string, sessionID string, cacheCreateTime time.Time, params models.GenerationParams) error
 	CheckSessionStatus(sessionID string) (SessionStatus, time.Time, error)
 	UpdateSessionActivity(ctx context.Context, sessionID string) error
 	TerminateSession(ctx context.Context, sessionID string, reason TerminationReason) error
-	StreamChatMessage(ctx context.Context, sessionID string, message string) (*genai.GenerateContentResponseIterator, error)
+	AcquireTurn(sessionID string) (release func(), err error)
+	StreamChatMessage(ctx context.Context, sessionID string, message, instruction string) (*genai.GenerateContentResponseIterator, error)
+	SetChatHistory(sessionID string, messages []models.Message) error
+	SetGenerationParams(sessionID string, params models.GenerationParams) error
 	GetSessionStatus(sessionID string) (SessionStatusInfo, error)
 	ExtendSession(ctx context.Context, sessionID string) error
 	CheckCreditStatus(sessionID string) (bool, bool, float64, error)
 }
 
+// CloudStorageManager is implemented by every object storage backend (GCS, S3-compatible
+// stores and the local filesystem). Backends must pass the storagetest conformance suite.
 type CloudStorageManager interface {
+	// EnsureBucket creates the bucket if it does not exist yet
+	EnsureBucket(ctx context.Context, bucketName string) error
 	UploadFile(ctx context.Context, bucketName, objectName string, content io.Reader) error
-	DownloadFile(ctx context.Context, bucketName, objectName string) ([]byte, error)
+	// DownloadFile streams the object; the caller must close the returned reader.
+	// Missing objects are reported as ErrStorageObjectNotFound.
+	DownloadFile(ctx context.Context, bucketName, objectName string) (io.ReadCloser, error)
+	// DeleteFile reports ErrStorageObjectNotFound when the object does not exist
 	DeleteFile(ctx context.Context, bucketName, objectName string) error
 	ListFiles(ctx context.Context, bucketName string) ([]string, error)
 }
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"nexus_scholar_go_backend/internal/models"
+	"nexus_scholar_go_backend/internal/utils/broker"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/uuid"
+)
+
+// ErrInsufficientCredit is returned when the budget a job is billed to is used up
+var ErrInsufficientCredit = errors.New("insufficient credit")
+
+// generationTokenHourRate converts the tokens a one-off generation reads and writes, a cached
+// corpus included, into the million token-hours budgets are kept in
+const generationTokenHourRate = 0.05
+
+// Statuses of background jobs over a session's corpus
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// ErrCorpusUnavailable is returned when a job needs a session's cached corpus after the
+// session has ended and its cache is gone
+var ErrCorpusUnavailable = errors.New("the session has ended and its corpus is no longer cached")
+
+// JobEvent reports the progress of a background job over a session's corpus to the
+// session's WebSocket connections
+type JobEvent struct {
+	Kind      string `json:"kind"` // extraction, report or glossary
+	JobID     uint   `json:"jobId"`
+	Status    string `json:"status"`
+	Completed int    `json:"completed"`
+	Total     int    `json:"total"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SessionJobTopic is the message broker topic the background jobs of a session report on
+func SessionJobTopic(sessionID string) string {
+	return "session_jobs_" + sessionID
+}
+
+// corpusAccess lets background jobs generate against a session's cached corpus outside of
+// its chat, so neither the chat history nor the session's turn are affected
+type corpusAccess struct {
+	cacheManager   CacheManager
+	cacheServiceDB CacheServiceDB
+	activity       SessionActivity
+	messageBroker  *broker.Broker
+}
+
+// model returns a model bound to the session's cached corpus and the session's cache, which
+// tells the price tier and the budget the session is billed to
+func (a *corpusAccess) model(ctx context.Context, sessionID string) (*genai.GenerativeModel, *models.Cache, error) {
+	cache, err := a.cacheServiceDB.GetCacheDB(sessionID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get cache: %w", err)
+	}
+	if !cache.TerminationTime.IsZero() {
+		return nil, nil, ErrCorpusUnavailable
+	}
+	model, err := a.cacheManager.GetGenerativeModel(ctx, cache.CacheName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrCorpusUnavailable, err)
+	}
+	return model, cache, nil
+}
+
+// keepAlive counts a job's work as session activity, so the cache is extended while it runs
+func (a *corpusAccess) keepAlive(ctx context.Context, sessionID string) error {
+	return a.activity.UpdateSessionActivity(ctx, sessionID)
+}
+
+func (a *corpusAccess) publish(sessionID string, event JobEvent) {
+	a.messageBroker.Publish(SessionJobTopic(sessionID), event)
+}
+
+// checkJobCredit makes sure the budget a session bills to has credit left for the user
+func checkJobCredit(ctx context.Context, meter UsageMeter, userID uuid.UUID, cache *models.Cache) error {
+	remaining, err := meter.RemainingCredit(ctx, userID, cache.WorkspaceID, cache.PriceTier)
+	if err != nil {
+		return fmt.Errorf("failed to get remaining credit: %w", err)
+	}
+	if remaining <= 0 {
+		return fmt.Errorf("%w on the %s tier", ErrInsufficientCredit, cache.PriceTier)
+	}
+	return nil
+}
+
+// billJob debits a generation from the budget the session bills to, on behalf of the user,
+// and returns the tokens and token-hours billed
+func billJob(ctx context.Context, meter UsageMeter, userID uuid.UUID, cache *models.Cache, resp *genai.GenerateContentResponse) (int32, float64, error) {
+	return billGeneration(ctx, meter, userID, cache.WorkspaceID, cache.PriceTier, resp)
+}
+
+// billGeneration debits a generation from the user's budget on the tier, or from the
+// workspace's pool when workspaceID is set, and returns the tokens and token-hours billed
+func billGeneration(ctx context.Context, meter UsageMeter, userID uuid.UUID, workspaceID *uint, priceTier string, resp *genai.GenerateContentResponse) (int32, float64, error) {
+	if resp.UsageMetadata == nil {
+		return 0, 0, nil
+	}
+	tokens := resp.UsageMetadata.TotalTokenCount
+	tokenHours := float64(tokens) * generationTokenHourRate / 1_000_000
+	return tokens, tokenHours, meter.LogCacheUsage(ctx, userID, workspaceID, priceTier, tokenHours, 0, tokens)
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// ErrShareLinkNotFound is returned for unknown, tampered, revoked and expired share tokens alike,
+// so a public caller cannot tell them apart
+var ErrShareLinkNotFound = errors.New("share link not found")
+
+// SharedChat is the public, read-only view of a chat behind a share link
+type SharedChat struct {
+	Chat      *models.Chat
+	Documents []models.ChatDocument
+}
+
+type ShareLinkService struct {
+	db          *gorm.DB
+	chatService ChatServiceDB
+	secret      []byte
+	logger      zerolog.Logger
+}
+
+func NewShareLinkService(db *gorm.DB, chatService ChatServiceDB, secret []byte, logger zerolog.Logger) *ShareLinkService {
+	return &ShareLinkService{
+		db:          db,
+		chatService: chatService,
+		secret:      secret,
+		logger:      logger,
+	}
+}
+
+// CreateShareLink shares one of the user's chats. A nil expiresIn creates a link that
+// stays valid until revoked.
+func (s *ShareLinkService) CreateShareLink(ctx context.Context, userID uuid.UUID, sessionID string, expiresIn *time.Duration) (*models.ShareLink, error) {
+	// Only the chat's owner may share it, not other members of its workspace
+	var chat models.Chat
+	err := s.db.WithContext(ctx).Where("session_id = ? AND user_id = ?", sessionID, userID).First(&chat).Error
+	if err != nil {
+		return nil, err
+	}
+
+	tokenID := make([]byte, 16)
+	if _, err := rand.Read(tokenID); err != nil {
+		return nil, fmt.Errorf("failed to generate share token: %w", err)
+	}
+	link := &models.ShareLink{
+		ChatID:  chat.ID,
+		Chat:    chat,
+		UserID:  userID,
+		TokenID: hex.EncodeToString(tokenID),
+	}
+	if expiresIn != nil {
+		expiresAt := time.Now().Add(*expiresIn)
+		link.ExpiresAt = &expiresAt
+	}
+
+	if err := s.db.WithContext(ctx).Omit("Chat").Create(link).Error; err != nil {
+		s.logger.Error().Err(err).Msg("Failed to create share link")
+		return nil, fmt.Errorf("failed to create share link: %w", err)
+	}
+	s.logger.Info().Str("userID", userID.String()).Msgf("Created share link %d for session %s", link.ID, sessionID)
+	return link, nil
+}
+
+// ListShareLinks returns the user's share links, newest first, including revoked and expired ones
+func (s *ShareLinkService) ListShareLinks(ctx context.Context, userID uuid.UUID) ([]models.ShareLink, error) {
+	var links []models.ShareLink
+	err := s.db.WithContext(ctx).
+		Preload("Chat").
+		Where("user_id = ?", userID).
+		Order("created_at desc").
+		Find(&links).Error
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to list share links")
+		return nil, fmt.Errorf("failed to list share links: %w", err)
+	}
+	return links, nil
+}
+
+// RevokeShareLink makes a share link stop working immediately. Revoking twice is a no-op.
+func (s *ShareLinkService) RevokeShareLink(ctx context.Context, userID uuid.UUID, linkID uint) (*models.ShareLink, error) {
+	var link models.ShareLink
+	err := s.db.WithContext(ctx).Preload("Chat").Where("id = ? AND user_id = ?", linkID, userID).First(&link).Error
+	if err != nil {
+		return nil, err
+	}
+	if link.RevokedAt != nil {
+		return &link, nil
+	}
+
+	now := time.Now()
+	if err := s.db.WithContext(ctx).Model(&link).Update("revoked_at", now).Error; err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to revoke share link %d", linkID)
+		return nil, fmt.Errorf("failed to revoke share link: %w", err)
+	}
+	link.RevokedAt = &now
+	return &link, nil
+}
+
+// ViewSharedChat resolves a public share token and counts the view
+func (s *ShareLinkService) ViewSharedChat(ctx context.Context, token string) (*SharedChat, error) {
+	tokenID, ok := s.verifyToken(token)
+	if !ok {
+		return nil, ErrShareLinkNotFound
+	}
+
+	var link models.ShareLink
+	err := s.db.WithContext(ctx).Where("token_id = ?", tokenID).First(&link).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrShareLinkNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get share link: %w", err)
+	}
+	now := time.Now()
+	if link.RevokedAt != nil || (link.ExpiresAt != nil && now.After(*link.ExpiresAt)) {
+		return nil, ErrShareLinkNotFound
+	}
+
+	var chat models.Chat
+	err = s.db.WithContext(ctx).First(&chat, link.ChatID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrShareLinkNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shared chat: %w", err)
+	}
+	// Only the conversation along the active branch is shown, not abandoned edits
+	chat.Messages, err = s.chatService.GetActiveBranchMessagesFromDB(chat.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shared chat messages: %w", err)
+	}
+	documents, err := s.chatService.GetChatDocumentsFromDB(chat.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shared chat documents: %w", err)
+	}
+
+	err = s.db.WithContext(ctx).Model(&link).Updates(map[string]interface{}{
+		"view_count":     gorm.Expr("view_count + 1"),
+		"last_viewed_at": now,
+	}).Error
+	if err != nil {
+		// The view is still served, only the statistics are off
+		s.logger.Error().Err(err).Msgf("Failed to count view of share link %d", link.ID)
+	}
+
+	return &SharedChat{Chat: &chat, Documents: documents}, nil
+}
+
+// Token returns the public token of a share link
+func (s *ShareLinkService) Token(link *models.ShareLink) string {
+	return link.TokenID + "." + s.sign(link.TokenID)
+}
+
+func (s *ShareLinkService) verifyToken(token string) (string, bool) {
+	tokenID, signature, ok := strings.Cut(token, ".")
+	if !ok || tokenID == "" {
+		return "", false
+	}
+	return tokenID, hmac.Equal([]byte(signature), []byte(s.sign(tokenID)))
+}
+
+func (s *ShareLinkService) sign(tokenID string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(tokenID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
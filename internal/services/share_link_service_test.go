@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestVerifyToken(t *testing.T) {
+	links := NewShareLinkService(nil, nil, []byte("secret"), zerolog.Nop())
+	token := links.Token(&models.ShareLink{TokenID: "0123abcd"})
+	tokenID, signature, _ := strings.Cut(token, ".")
+	other := NewShareLinkService(nil, nil, []byte("other secret"), zerolog.Nop()).Token(&models.ShareLink{TokenID: "0123abcd"})
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"signed token", token, true},
+		{"tampered token ID", "0123abce." + signature, false},
+		{"tampered signature", tokenID + "." + strings.ToUpper(signature), false},
+		{"signed with another secret", other, false},
+		{"missing signature", tokenID, false},
+		{"empty signature", tokenID + ".", false},
+		{"empty token ID", "." + signature, false},
+		{"empty token", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := links.verifyToken(tt.token)
+			assert.Equal(t, tt.ok, ok)
+			if tt.ok {
+				assert.Equal(t, "0123abcd", got)
+			}
+		})
+	}
+}
+
+func TestViewSharedChat(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	chatDB := NewChatServiceDB(db)
+	links := NewShareLinkService(db, chatDB, []byte("secret"), zerolog.Nop())
+	owner, outsider := createTestUser(t, db), createTestUser(t, db)
+	sessionID, messages := createTestChat(t, chatDB, owner.ID, "p1", "a1", "p2", "a2")
+
+	_, err := links.CreateShareLink(ctx, outsider.ID, sessionID, nil)
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "got %v", err)
+
+	link, err := links.CreateShareLink(ctx, owner.ID, sessionID, nil)
+	require.NoError(t, err)
+	token := links.Token(link)
+
+	// Only the active branch is shown
+	_, err = chatDB.CreateBranchDB(sessionID, &messages[1].ID, "edit")
+	require.NoError(t, err)
+	shared, err := links.ViewSharedChat(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, sessionID, shared.Chat.SessionID)
+	assert.Equal(t, []string{"p1", "a1"}, messageContents(shared.Chat.Messages))
+	_, err = links.ViewSharedChat(ctx, token)
+	require.NoError(t, err)
+
+	var viewed models.ShareLink
+	require.NoError(t, db.First(&viewed, link.ID).Error)
+	assert.Equal(t, int64(2), viewed.ViewCount)
+	assert.NotNil(t, viewed.LastViewedAt)
+
+	expiresIn := -time.Minute
+	expired, err := links.CreateShareLink(ctx, owner.ID, sessionID, &expiresIn)
+	require.NoError(t, err)
+	revoked, err := links.CreateShareLink(ctx, owner.ID, sessionID, nil)
+	require.NoError(t, err)
+	_, err = links.RevokeShareLink(ctx, owner.ID, revoked.ID)
+	require.NoError(t, err)
+	tokenID, signature, _ := strings.Cut(token, ".")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"tampered", tokenID[:len(tokenID)-1] + "x." + signature},
+		{"unknown", links.Token(&models.ShareLink{TokenID: "0123abcd"})},
+		{"expired", links.Token(expired)},
+		{"revoked", links.Token(revoked)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := links.ViewSharedChat(ctx, tt.token)
+			assert.True(t, errors.Is(err, ErrShareLinkNotFound), "got %v", err)
+		})
+	}
+
+	// Refused views are not counted
+	require.NoError(t, db.First(&viewed, revoked.ID).Error)
+	assert.Zero(t, viewed.ViewCount)
+}
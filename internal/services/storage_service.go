@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog"
+)
+
+// Storage backends selectable through StorageConfig.Backend
+const (
+	StorageBackendGCS        = "gcs"
+	StorageBackendFileSystem = "fs"
+	StorageBackendS3         = "s3"
+)
+
+// ErrStorageObjectNotFound is returned (wrapped) by every CloudStorageManager when an object does not exist
+var ErrStorageObjectNotFound = errors.New("storage object not found")
+
+// StorageConfig selects and configures the object storage backend
+type StorageConfig struct {
+	Backend string // gcs (default), fs or s3
+
+	// gcs
+	GCSProjectID string
+
+	// fs
+	FileSystemRoot string
+
+	// s3 (AWS S3, MinIO or any other S3-compatible store)
+	S3Endpoint        string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+	S3Region          string
+	S3UseSSL          bool
+}
+
+// NewCloudStorageManager creates the storage backend selected by the config
+func NewCloudStorageManager(ctx context.Context, cfg StorageConfig, logger zerolog.Logger) (CloudStorageManager, error) {
+	switch cfg.Backend {
+	case "", StorageBackendGCS:
+		if cfg.GCSProjectID == "" {
+			return nil, fmt.Errorf("GCS storage requires a Google Cloud project ID")
+		}
+		gcsService, err := NewGCSService(ctx, cfg.GCSProjectID, logger)
+		if err != nil {
+			return nil, err
+		}
+		return gcsService, nil
+	case StorageBackendFileSystem:
+		if cfg.FileSystemRoot == "" {
+			return nil, fmt.Errorf("filesystem storage requires a root directory")
+		}
+		fsStorage, err := NewFileSystemStorage(cfg.FileSystemRoot, logger)
+		if err != nil {
+			return nil, err
+		}
+		return fsStorage, nil
+	case StorageBackendS3:
+		if cfg.S3Endpoint == "" {
+			return nil, fmt.Errorf("S3 storage requires an endpoint")
+		}
+		s3Storage, err := NewS3Storage(cfg, logger)
+		if err != nil {
+			return nil, err
+		}
+		return s3Storage, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", cfg.Backend)
+	}
+}
@@ -0,0 +1,185 @@
+// Package storagetest is the conformance suite every CloudStorageManager backend must pass.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"nexus_scholar_go_backend/internal/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Large enough that backends have to stream it through several reads and writes
+const largeObjectSize = 6 << 20
+
+// Factory returns the backend under test and the name of an existing bucket.
+// The bucket may be shared: the suite only touches objects under its own prefix.
+type Factory func(t *testing.T) (services.CloudStorageManager, string)
+
+// Run executes the conformance suite against the backend created by newStorage
+func Run(t *testing.T, newStorage Factory) {
+	t.Run("EnsureBucketIsIdempotent", func(t *testing.T) {
+		storage, bucket := newStorage(t)
+		require.NoError(t, storage.EnsureBucket(context.Background(), bucket))
+		require.NoError(t, storage.EnsureBucket(context.Background(), bucket))
+	})
+
+	t.Run("UploadAndDownload", func(t *testing.T) {
+		storage, bucket := newStorage(t)
+		name := objectName(t, storage, bucket, "raw_cache_session.txt")
+
+		upload(t, storage, bucket, name, "<Document 1>hello</Document 1>")
+		assert.Equal(t, "<Document 1>hello</Document 1>", download(t, storage, bucket, name))
+	})
+
+	t.Run("OverwriteReplacesContent", func(t *testing.T) {
+		storage, bucket := newStorage(t)
+		name := objectName(t, storage, bucket, "overwrite.txt")
+
+		upload(t, storage, bucket, name, "first version with some more bytes")
+		upload(t, storage, bucket, name, "second")
+		assert.Equal(t, "second", download(t, storage, bucket, name))
+	})
+
+	t.Run("EmptyObject", func(t *testing.T) {
+		storage, bucket := newStorage(t)
+		name := objectName(t, storage, bucket, "empty.txt")
+
+		upload(t, storage, bucket, name, "")
+		assert.Equal(t, "", download(t, storage, bucket, name))
+	})
+
+	t.Run("LargeObjectStreams", func(t *testing.T) {
+		storage, bucket := newStorage(t)
+		name := objectName(t, storage, bucket, "large.bin")
+		ctx := context.Background()
+
+		source := sha256.New()
+		content := io.TeeReader(io.LimitReader(rand.New(rand.NewSource(42)), largeObjectSize), source)
+		require.NoError(t, storage.UploadFile(ctx, bucket, name, content))
+
+		reader, err := storage.DownloadFile(ctx, bucket, name)
+		require.NoError(t, err)
+		defer reader.Close()
+		downloaded := sha256.New()
+		n, err := io.Copy(downloaded, reader)
+		require.NoError(t, err)
+		assert.Equal(t, int64(largeObjectSize), n)
+		assert.Equal(t, source.Sum(nil), downloaded.Sum(nil))
+	})
+
+	t.Run("NestedObjectNames", func(t *testing.T) {
+		storage, bucket := newStorage(t)
+		prefix := objectName(t, storage, bucket, "")
+		names := []string{prefix + "documents/user/a.pdf", prefix + "documents/user/b.pdf", prefix + "documents/c.pdf"}
+		for _, name := range names {
+			registerCleanup(t, storage, bucket, name)
+			upload(t, storage, bucket, name, name)
+		}
+
+		for _, name := range names {
+			assert.Equal(t, name, download(t, storage, bucket, name))
+		}
+		assert.ElementsMatch(t, names, list(t, storage, bucket, prefix))
+	})
+
+	t.Run("DownloadMissingObject", func(t *testing.T) {
+		storage, bucket := newStorage(t)
+		name := objectName(t, storage, bucket, "missing.txt")
+
+		_, err := storage.DownloadFile(context.Background(), bucket, name)
+		assert.True(t, errors.Is(err, services.ErrStorageObjectNotFound), "expected ErrStorageObjectNotFound, got %v", err)
+	})
+
+	t.Run("DeleteMissingObject", func(t *testing.T) {
+		storage, bucket := newStorage(t)
+		name := objectName(t, storage, bucket, "missing.txt")
+
+		err := storage.DeleteFile(context.Background(), bucket, name)
+		assert.True(t, errors.Is(err, services.ErrStorageObjectNotFound), "expected ErrStorageObjectNotFound, got %v", err)
+	})
+
+	t.Run("DeleteRemovesObject", func(t *testing.T) {
+		storage, bucket := newStorage(t)
+		prefix := objectName(t, storage, bucket, "")
+		kept, deleted := prefix+"dir/kept.txt", prefix+"dir/deleted.txt"
+		registerCleanup(t, storage, bucket, kept)
+		upload(t, storage, bucket, kept, "kept")
+		upload(t, storage, bucket, deleted, "deleted")
+
+		require.NoError(t, storage.DeleteFile(context.Background(), bucket, deleted))
+
+		_, err := storage.DownloadFile(context.Background(), bucket, deleted)
+		assert.True(t, errors.Is(err, services.ErrStorageObjectNotFound), "expected ErrStorageObjectNotFound, got %v", err)
+		assert.Equal(t, []string{kept}, list(t, storage, bucket, prefix))
+		assert.Equal(t, "kept", download(t, storage, bucket, kept))
+	})
+
+	t.Run("ListEmptyPrefix", func(t *testing.T) {
+		storage, bucket := newStorage(t)
+		prefix := objectName(t, storage, bucket, "")
+
+		assert.Empty(t, list(t, storage, bucket, prefix))
+	})
+}
+
+// objectName returns a name under a prefix unique to the test and deletes the
+// object when the test ends, so runs against shared buckets do not collide
+func objectName(t *testing.T, storage services.CloudStorageManager, bucket, name string) string {
+	prefix := fmt.Sprintf("storagetest-%d-%s/", time.Now().UnixNano(), strings.ReplaceAll(t.Name(), "/", "-"))
+	if name == "" {
+		return prefix
+	}
+	registerCleanup(t, storage, bucket, prefix+name)
+	return prefix + name
+}
+
+func registerCleanup(t *testing.T, storage services.CloudStorageManager, bucket, name string) {
+	t.Cleanup(func() {
+		err := storage.DeleteFile(context.Background(), bucket, name)
+		if err != nil && !errors.Is(err, services.ErrStorageObjectNotFound) {
+			t.Logf("failed to clean up %s: %v", name, err)
+		}
+	})
+}
+
+func upload(t *testing.T, storage services.CloudStorageManager, bucket, name, content string) {
+	t.Helper()
+	require.NoError(t, storage.UploadFile(context.Background(), bucket, name, strings.NewReader(content)))
+}
+
+func download(t *testing.T, storage services.CloudStorageManager, bucket, name string) string {
+	t.Helper()
+	reader, err := storage.DownloadFile(context.Background(), bucket, name)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, reader)
+	require.NoError(t, err)
+	return buf.String()
+}
+
+func list(t *testing.T, storage services.CloudStorageManager, bucket, prefix string) []string {
+	t.Helper()
+	names, err := storage.ListFiles(context.Background(), bucket)
+	require.NoError(t, err)
+
+	var matching []string
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			matching = append(matching, name)
+		}
+	}
+	return matching
+}
@@ -0,0 +1,87 @@
+package storagetest_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"nexus_scholar_go_backend/internal/services"
+	"nexus_scholar_go_backend/internal/services/storagetest"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSystemStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) (services.CloudStorageManager, string) {
+		storage, err := services.NewFileSystemStorage(t.TempDir(), zerolog.Nop())
+		require.NoError(t, err)
+		require.NoError(t, storage.EnsureBucket(context.Background(), "test-bucket"))
+		return storage, "test-bucket"
+	})
+}
+
+func TestFileSystemStorageRejectsEscapingNames(t *testing.T) {
+	storage, err := services.NewFileSystemStorage(t.TempDir(), zerolog.Nop())
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, storage.EnsureBucket(ctx, "test-bucket"))
+
+	for _, name := range []string{"../outside.txt", "a/../../outside.txt", "/absolute.txt", "a//b.txt", ""} {
+		err := storage.UploadFile(ctx, "test-bucket", name, strings.NewReader("x"))
+		assert.Error(t, err, "object name %q", name)
+	}
+	assert.Error(t, storage.EnsureBucket(ctx, "../outside"))
+}
+
+// Runs against a MinIO (or other S3-compatible) server when STORAGE_TEST_S3_ENDPOINT is set, e.g.
+// docker run -p 9000:9000 minio/minio server /data
+func TestS3Storage(t *testing.T) {
+	endpoint := os.Getenv("STORAGE_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("STORAGE_TEST_S3_ENDPOINT is not set")
+	}
+	cfg := services.StorageConfig{
+		Backend:           services.StorageBackendS3,
+		S3Endpoint:        endpoint,
+		S3AccessKeyID:     envOr("STORAGE_TEST_S3_ACCESS_KEY_ID", "minioadmin"),
+		S3SecretAccessKey: envOr("STORAGE_TEST_S3_SECRET_ACCESS_KEY", "minioadmin"),
+		S3Region:          os.Getenv("STORAGE_TEST_S3_REGION"),
+		S3UseSSL:          os.Getenv("STORAGE_TEST_S3_USE_SSL") == "true",
+	}
+	bucket := envOr("STORAGE_TEST_S3_BUCKET", "storagetest")
+
+	storagetest.Run(t, func(t *testing.T) (services.CloudStorageManager, string) {
+		storage, err := services.NewCloudStorageManager(context.Background(), cfg, zerolog.Nop())
+		require.NoError(t, err)
+		require.NoError(t, storage.EnsureBucket(context.Background(), bucket))
+		return storage, bucket
+	})
+}
+
+// Runs against a real GCS bucket when STORAGE_TEST_GCS_BUCKET is set (uses the usual Google credentials)
+func TestGCSStorage(t *testing.T) {
+	bucket := os.Getenv("STORAGE_TEST_GCS_BUCKET")
+	if bucket == "" {
+		t.Skip("STORAGE_TEST_GCS_BUCKET is not set")
+	}
+	cfg := services.StorageConfig{
+		Backend:      services.StorageBackendGCS,
+		GCSProjectID: os.Getenv("GOOGLE_CLOUD_PROJECT"),
+	}
+
+	storagetest.Run(t, func(t *testing.T) (services.CloudStorageManager, string) {
+		storage, err := services.NewCloudStorageManager(context.Background(), cfg, zerolog.Nop())
+		require.NoError(t, err)
+		return storage, bucket
+	})
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"fmt"
 	"os"
+	"strconv"
 
 	"github.com/rs/zerolog"
 	"github.com/stripe/stripe-go/v79"
@@ -25,7 +26,9 @@ func NewStripeService(publicKey, secretKey string, logger zerolog.Logger) *Strip
 	}
 }
 
-func (s *StripeService) CreateCheckoutSession(userID string, priceID string, tokenHours float64, priceTier string) (*stripe.CheckoutSession, error) {
+// CreateCheckoutSession starts a purchase of token hours. When workspaceID is set the
+// purchase tops up that workspace's pool.
+func (s *StripeService) CreateCheckoutSession(userID string, priceID string, tokenHours float64, priceTier string, workspaceID *uint) (*stripe.CheckoutSession, error) {
 	s.logger.Info().Msgf("Creating checkout session for user ID: %s, price ID: %s, token hours: %.2f, price tier: %s", userID, priceID, tokenHours, priceTier)
 
 	frontendURL := os.Getenv("FRONTEND_URL")
@@ -56,6 +59,9 @@ func (s *StripeService) CreateCheckoutSession(userID string, priceID string, tok
 			SetupFutureUsage: stripe.String(string(stripe.PaymentIntentSetupFutureUsageOffSession)),
 		},
 	}
+	if workspaceID != nil {
+		params.Metadata["workspace_id"] = strconv.FormatUint(uint64(*workspaceID), 10)
+	}
 	params.AddExpand("payment_intent")
 
 	session, err := session.New(params)
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const (
+	minSuggestions = 3
+	maxSuggestions = 5
+	// Only the latest messages of the conversation are sent along, cut to this many characters
+	suggestionContextMessages = 6
+	suggestionMessageLength   = 1500
+	maxSuggestionLength       = 300
+)
+
+type SuggestionService struct {
+	db          *gorm.DB
+	chatService ChatServiceDB
+	meter       UsageMeter
+	corpus      corpusAccess
+	logger      zerolog.Logger
+}
+
+func NewSuggestionService(db *gorm.DB, chatService ChatServiceDB, meter UsageMeter, cacheManager CacheManager, cacheServiceDB CacheServiceDB, logger zerolog.Logger) *SuggestionService {
+	return &SuggestionService{
+		db:          db,
+		chatService: chatService,
+		meter:       meter,
+		corpus: corpusAccess{
+			cacheManager:   cacheManager,
+			cacheServiceDB: cacheServiceDB,
+		},
+		logger: logger,
+	}
+}
+
+// Suggest generates follow-up questions to an answer, grounded in the session's corpus and
+// conversation, and stores them with the answer. The generation is billed like the session,
+// on behalf of the author of the prompt. It returns nil when the author turned suggestions
+// off, the session has ended or there is no credit left.
+func (s *SuggestionService) Suggest(ctx context.Context, sessionID string, authorID uuid.UUID, answer *models.Message) ([]string, error) {
+	var author models.User
+	if err := s.db.WithContext(ctx).Select("id", "suggestions_disabled").Where("id = ?", authorID).First(&author).Error; err != nil {
+		return nil, fmt.Errorf("failed to get author: %w", err)
+	}
+	if author.SuggestionsDisabled {
+		return nil, nil
+	}
+
+	model, cache, err := s.corpus.model(ctx, sessionID)
+	if errors.Is(err, ErrCorpusUnavailable) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkJobCredit(ctx, s.meter, authorID, cache); errors.Is(err, ErrInsufficientCredit) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	messages, err := s.chatService.GetActiveBranchMessagesFromDB(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat messages: %w", err)
+	}
+
+	model.SetTemperature(0.7)
+	model.SetMaxOutputTokens(512)
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"questions": {
+				Type:        genai.TypeArray,
+				Description: fmt.Sprintf("%d to %d follow-up questions", minSuggestions, maxSuggestions),
+				Items:       &genai.Schema{Type: genai.TypeString},
+			},
+		},
+		Required: []string{"questions"},
+	}
+
+	resp, err := model.GenerateContent(ctx, genai.Text(suggestionPrompt(messages)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate suggestions: %w", err)
+	}
+	if _, _, err := billJob(ctx, s.meter, authorID, cache, resp); err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to bill suggestions in session %s", sessionID)
+	}
+
+	var generated struct {
+		Questions []string `json:"questions"`
+	}
+	if err := json.Unmarshal([]byte(responseText(resp)), &generated); err != nil {
+		return nil, fmt.Errorf("failed to parse suggestions: %w", err)
+	}
+	var suggestions []string
+	for _, question := range generated.Questions {
+		if question = truncateRunes(strings.TrimSpace(question), maxSuggestionLength); question != "" {
+			suggestions = append(suggestions, question)
+		}
+		if len(suggestions) == maxSuggestions {
+			break
+		}
+	}
+	if len(suggestions) == 0 {
+		return nil, nil
+	}
+
+	answer.Suggestions = suggestions
+	if err := s.db.WithContext(ctx).Model(answer).Select("suggestions").Updates(answer).Error; err != nil {
+		return nil, fmt.Errorf("failed to save suggestions: %w", err)
+	}
+	return suggestions, nil
+}
+
+// suggestionPrompt asks for follow-up questions to the latest exchanges of a conversation
+func suggestionPrompt(messages []models.Message) string {
+	recent := messages[max(0, len(messages)-suggestionContextMessages):]
+	lines := make([]string, len(recent))
+	for i, msg := range recent {
+		speaker := "User"
+		if msg.Type == "ai" {
+			speaker = "Assistant"
+		}
+		lines[i] = speaker + ": " + truncateRunes(strings.TrimSpace(msg.Content), suggestionMessageLength)
+	}
+	return fmt.Sprintf("Below are the latest messages of a conversation between a researcher and you about the documents in the context. "+
+		"Suggest %d to %d short follow-up questions the researcher could ask next. Each must be answerable from the documents, "+
+		"must not have been answered in the conversation already and should be at most 20 words. "+
+		"Write them in the language of the conversation.\n\n%s",
+		minSuggestions, maxSuggestions, strings.Join(lines, "\n\n"))
+}
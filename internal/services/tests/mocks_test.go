@@ -174,14 +174,19 @@ type MockCloudStorageManager struct {
 	mock.Mock
 }
 
+func (m *MockCloudStorageManager) EnsureBucket(ctx context.Context, bucketName string) error {
+	args := m.Called(ctx, bucketName)
+	return args.Error(0)
+}
+
 func (m *MockCloudStorageManager) UploadFile(ctx context.Context, bucketName, objectName string, content io.Reader) error {
 	args := m.Called(ctx, bucketName, objectName, content)
 	return args.Error(0)
 }
 
-func (m *MockCloudStorageManager) DownloadFile(ctx context.Context, bucketName, objectName string) ([]byte, error) {
+func (m *MockCloudStorageManager) DownloadFile(ctx context.Context, bucketName, objectName string) (io.ReadCloser, error) {
 	args := m.Called(ctx, bucketName, objectName)
-	return args.Get(0).([]byte), args.Error(1)
+	return args.Get(0).(io.ReadCloser), args.Error(1)
 }
 
 func (m *MockCloudStorageManager) DeleteFile(ctx context.Context, bucketName, objectName string) error {
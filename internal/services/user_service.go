@@ -106,3 +106,25 @@ func (s *UserService) GetUserByAuth0ID(auth0ID string) (*models.User, error) {
 	s.logger.Info().Msgf("Successfully retrieved user with Auth0ID: %s", auth0ID)
 	return &user, nil
 }
+
+// UserSettingsInput holds the settings a user can change; nil fields are unchanged
+type UserSettingsInput struct {
+	FollowUpSuggestions *bool
+}
+
+// UpdateSettings saves changed settings of the user and applies them to user
+func (s *UserService) UpdateSettings(ctx context.Context, user *models.User, input UserSettingsInput) error {
+	updates := map[string]interface{}{}
+	if input.FollowUpSuggestions != nil {
+		user.SuggestionsDisabled = !*input.FollowUpSuggestions
+		updates["suggestions_disabled"] = user.SuggestionsDisabled
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	if err := s.db.WithContext(ctx).Model(user).Updates(updates).Error; err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to update settings of user %s", user.ID)
+		return fmt.Errorf("failed to update settings: %w", err)
+	}
+	return nil
+}
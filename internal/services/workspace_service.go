@@ -0,0 +1,551 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const maxWorkspaceNameLength = 200
+
+var (
+	// ErrInvalidWorkspace is returned when workspace or member fields fail validation
+	ErrInvalidWorkspace = errors.New("invalid workspace")
+	// ErrWorkspaceForbidden is returned when the member's role does not allow the operation
+	ErrWorkspaceForbidden = errors.New("not allowed in this workspace")
+	// ErrWorkspaceHasActiveSessions is returned when a workspace is deleted while sessions
+	// still bill to its pool
+	ErrWorkspaceHasActiveSessions = errors.New("the workspace has running sessions billed to its pool")
+)
+
+// WorkspacePoolBalance is the shared credit of a workspace on one price tier
+type WorkspacePoolBalance struct {
+	PriceTier        string  `json:"price_tier"`
+	TokenHoursBought float64 `json:"token_hours_bought"`
+	TokenHoursUsed   float64 `json:"token_hours_used"`
+}
+
+// WorkspaceMemberTierUsage is what a member has drawn from the pool on one price tier
+type WorkspaceMemberTierUsage struct {
+	PriceTier       string   `json:"price_tier"`
+	Sessions        int64    `json:"sessions"`
+	TokenHoursUsed  float64  `json:"token_hours_used"`
+	TokenHoursLimit *float64 `json:"token_hours_limit"` // nil when the member has no limit
+}
+
+type WorkspaceMemberUsage struct {
+	UserID uuid.UUID                  `json:"user_id"`
+	Email  string                     `json:"email"`
+	Role   string                     `json:"role"`
+	ByTier []WorkspaceMemberTierUsage `json:"by_tier"`
+}
+
+// WorkspaceUsage reports the pool balances and the spending of every member
+type WorkspaceUsage struct {
+	Pool    []WorkspacePoolBalance `json:"pool"`
+	Members []WorkspaceMemberUsage `json:"members"`
+}
+
+type WorkspaceService struct {
+	db          *gorm.DB
+	chatService ChatServiceDB
+	logger      zerolog.Logger
+}
+
+func NewWorkspaceService(db *gorm.DB, chatService ChatServiceDB, logger zerolog.Logger) *WorkspaceService {
+	return &WorkspaceService{
+		db:          db,
+		chatService: chatService,
+		logger:      logger,
+	}
+}
+
+// CreateWorkspace creates a workspace owned by the user
+func (s *WorkspaceService) CreateWorkspace(ctx context.Context, ownerID uuid.UUID, name string) (*models.Workspace, error) {
+	name, err := validateWorkspaceName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	workspace := &models.Workspace{
+		Name:    name,
+		OwnerID: ownerID,
+		Members: []models.WorkspaceMember{{UserID: ownerID, Role: models.WorkspaceRoleOwner}},
+	}
+	if err := s.db.WithContext(ctx).Create(workspace).Error; err != nil {
+		s.logger.Error().Err(err).Msg("Failed to create workspace")
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+	s.logger.Info().Str("userID", ownerID.String()).Msgf("Created workspace %d", workspace.ID)
+	return s.GetWorkspace(ctx, ownerID, workspace.ID)
+}
+
+// ListWorkspaces returns the workspaces the user is a member of
+func (s *WorkspaceService) ListWorkspaces(ctx context.Context, userID uuid.UUID) ([]models.Workspace, error) {
+	var workspaces []models.Workspace
+	err := s.db.WithContext(ctx).
+		Preload("Members.User").
+		Preload("Members.Limits").
+		Where("id IN (?)", s.db.Model(&models.WorkspaceMember{}).Select("workspace_id").Where("user_id = ?", userID)).
+		Order("name asc").
+		Find(&workspaces).Error
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to list workspaces")
+		return nil, fmt.Errorf("failed to list workspaces: %w", err)
+	}
+	return workspaces, nil
+}
+
+// GetWorkspace returns a workspace with its members. It returns gorm.ErrRecordNotFound
+// when the workspace does not exist or the user is not a member.
+func (s *WorkspaceService) GetWorkspace(ctx context.Context, userID uuid.UUID, workspaceID uint) (*models.Workspace, error) {
+	if _, err := s.GetMember(ctx, workspaceID, userID); err != nil {
+		return nil, err
+	}
+	var workspace models.Workspace
+	err := s.db.WithContext(ctx).
+		Preload("Members", func(db *gorm.DB) *gorm.DB { return db.Order("created_at asc") }).
+		Preload("Members.User").
+		Preload("Members.Limits").
+		First(&workspace, workspaceID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &workspace, nil
+}
+
+// UpdateWorkspace renames a workspace. Owners and admins only.
+func (s *WorkspaceService) UpdateWorkspace(ctx context.Context, userID uuid.UUID, workspaceID uint, name string) (*models.Workspace, error) {
+	if _, err := s.RequireRole(ctx, workspaceID, userID, models.WorkspaceRoleAdmin); err != nil {
+		return nil, err
+	}
+	name, err := validateWorkspaceName(name)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.WithContext(ctx).Model(&models.Workspace{}).Where("id = ?", workspaceID).Update("name", name).Error
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to update workspace %d", workspaceID)
+		return nil, fmt.Errorf("failed to update workspace: %w", err)
+	}
+	return s.GetWorkspace(ctx, userID, workspaceID)
+}
+
+// DeleteWorkspace deletes a workspace and its memberships. Its sessions go back to
+// being visible to their creators only, and the credit left in its pool goes to the owner.
+// Owner only, once no session bills to the pool anymore.
+func (s *WorkspaceService) DeleteWorkspace(ctx context.Context, userID uuid.UUID, workspaceID uint) error {
+	if _, err := s.RequireRole(ctx, workspaceID, userID, models.WorkspaceRoleOwner); err != nil {
+		return err
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Running sessions have no termination time yet, which is stored as the zero time
+		var running int64
+		err := tx.Model(&models.Cache{}).
+			Where("workspace_id = ? AND termination_time < creation_time", workspaceID).
+			Count(&running).Error
+		if err != nil {
+			return err
+		}
+		if running > 0 {
+			return ErrWorkspaceHasActiveSessions
+		}
+		if err := tx.Model(&models.Cache{}).Where("workspace_id = ?", workspaceID).Update("workspace_id", nil).Error; err != nil {
+			return err
+		}
+		if err := settleWorkspacePool(tx, workspaceID, userID); err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Chat{}).Where("workspace_id = ?", workspaceID).Update("workspace_id", nil).Error; err != nil {
+			return err
+		}
+		memberIDs := tx.Model(&models.WorkspaceMember{}).Select("id").Where("workspace_id = ?", workspaceID)
+		if err := tx.Unscoped().Where("workspace_member_id IN (?)", memberIDs).Delete(&models.WorkspaceMemberLimit{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("workspace_id = ?", workspaceID).Delete(&models.WorkspaceMemberUsage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("workspace_id = ?", workspaceID).Delete(&models.WorkspaceMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Workspace{}, workspaceID).Error
+	})
+	if errors.Is(err, ErrWorkspaceHasActiveSessions) {
+		return err
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to delete workspace %d", workspaceID)
+		return fmt.Errorf("failed to delete workspace: %w", err)
+	}
+	return nil
+}
+
+// settleWorkspacePool credits what is left of a workspace's pool to the owner's own budgets
+// and removes the pool
+func settleWorkspacePool(tx *gorm.DB, workspaceID uint, ownerID uuid.UUID) error {
+	var pool []models.TierTokenBudget
+	if err := tx.Where("workspace_id = ?", workspaceID).Find(&pool).Error; err != nil {
+		return err
+	}
+	for _, budget := range pool {
+		left := budget.TokenHoursBought - budget.TokenHoursUsed
+		if left <= 0 {
+			continue
+		}
+		var own models.TierTokenBudget
+		err := tx.Where("user_id = ? AND price_tier = ? AND workspace_id IS NULL", ownerID, budget.PriceTier).First(&own).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = tx.Create(&models.TierTokenBudget{UserID: ownerID, PriceTier: budget.PriceTier, TokenHoursBought: left}).Error
+		} else if err == nil {
+			err = tx.Model(&own).Update("token_hours_bought", gorm.Expr("token_hours_bought + ?", left)).Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Unscoped().Where("workspace_id = ?", workspaceID).Delete(&models.TierTokenBudget{}).Error
+}
+
+// AddMember adds a registered user to the workspace by email. Owners and admins can add
+// members; only the owner can add admins.
+func (s *WorkspaceService) AddMember(ctx context.Context, actorID uuid.UUID, workspaceID uint, email, role string) (*models.WorkspaceMember, error) {
+	actor, err := s.RequireRole(ctx, workspaceID, actorID, models.WorkspaceRoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAssignableRole(actor, role); err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	err = s.db.WithContext(ctx).Where("LOWER(email) = ?", strings.ToLower(strings.TrimSpace(email))).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: no user with email %s", ErrInvalidWorkspace, email)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+	if _, err := s.GetMember(ctx, workspaceID, user.ID); err == nil {
+		return nil, fmt.Errorf("%w: %s is already a member", ErrInvalidWorkspace, email)
+	}
+
+	member := &models.WorkspaceMember{WorkspaceID: workspaceID, UserID: user.ID, Role: role}
+	if err := s.db.WithContext(ctx).Create(member).Error; err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to add member to workspace %d", workspaceID)
+		return nil, fmt.Errorf("failed to add workspace member: %w", err)
+	}
+	member.User = user
+	s.logger.Info().Str("userID", user.ID.String()).Msgf("Added member to workspace %d", workspaceID)
+	return member, nil
+}
+
+// UpdateMemberRole changes the role of a member. The owner's role cannot be changed.
+func (s *WorkspaceService) UpdateMemberRole(ctx context.Context, actorID uuid.UUID, workspaceID uint, memberID uuid.UUID, role string) (*models.WorkspaceMember, error) {
+	actor, member, err := s.manageMember(ctx, actorID, workspaceID, memberID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAssignableRole(actor, role); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.WithContext(ctx).Model(member).Update("role", role).Error; err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to update member role in workspace %d", workspaceID)
+		return nil, fmt.Errorf("failed to update workspace member: %w", err)
+	}
+	return member, nil
+}
+
+// RemoveMember removes a member from the workspace. Members may remove themselves; the
+// owner cannot leave. Sessions the member shared stay in the workspace.
+func (s *WorkspaceService) RemoveMember(ctx context.Context, actorID uuid.UUID, workspaceID uint, memberID uuid.UUID) error {
+	var member *models.WorkspaceMember
+	var err error
+	if actorID == memberID {
+		member, err = s.GetMember(ctx, workspaceID, memberID)
+		if err == nil && member.Role == models.WorkspaceRoleOwner {
+			err = fmt.Errorf("%w: the owner cannot leave the workspace", ErrInvalidWorkspace)
+		}
+	} else {
+		_, member, err = s.manageMember(ctx, actorID, workspaceID, memberID)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("workspace_member_id = ?", member.ID).Delete(&models.WorkspaceMemberLimit{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(member).Error
+	})
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to remove member from workspace %d", workspaceID)
+		return fmt.Errorf("failed to remove workspace member: %w", err)
+	}
+	return nil
+}
+
+// SetMemberLimit caps the token-hours a member may draw from the pool on a tier.
+// A nil limit removes the cap. Owners and admins only.
+func (s *WorkspaceService) SetMemberLimit(ctx context.Context, actorID uuid.UUID, workspaceID uint, memberID uuid.UUID, priceTier string, limit *float64) error {
+	if _, err := s.RequireRole(ctx, workspaceID, actorID, models.WorkspaceRoleAdmin); err != nil {
+		return err
+	}
+	member, err := s.GetMember(ctx, workspaceID, memberID)
+	if err != nil {
+		return err
+	}
+	if priceTier != "base" && priceTier != "pro" {
+		return fmt.Errorf("%w: price_tier must be 'base' or 'pro'", ErrInvalidWorkspace)
+	}
+	if limit != nil && *limit < 0 {
+		return fmt.Errorf("%w: token_hours_limit cannot be negative", ErrInvalidWorkspace)
+	}
+
+	db := s.db.WithContext(ctx)
+	err = db.Unscoped().Where("workspace_member_id = ? AND price_tier = ?", member.ID, priceTier).Delete(&models.WorkspaceMemberLimit{}).Error
+	if err == nil && limit != nil {
+		err = db.Create(&models.WorkspaceMemberLimit{WorkspaceMemberID: member.ID, PriceTier: priceTier, TokenHoursLimit: *limit}).Error
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to set member limit in workspace %d", workspaceID)
+		return fmt.Errorf("failed to set member limit: %w", err)
+	}
+	return nil
+}
+
+// RemainingMemberLimit implements MemberSpendingLimiter
+func (s *WorkspaceService) RemainingMemberLimit(ctx context.Context, workspaceID uint, userID uuid.UUID, priceTier string) (float64, bool, error) {
+	member, err := s.GetMember(ctx, workspaceID, userID)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var limit models.WorkspaceMemberLimit
+	err = s.db.WithContext(ctx).Where("workspace_member_id = ? AND price_tier = ?", member.ID, priceTier).First(&limit).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get member limit: %w", err)
+	}
+
+	used, err := s.memberUsage(ctx, workspaceID, userID, priceTier)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to compute member usage: %w", err)
+	}
+	return limit.TokenHoursLimit - used, true, nil
+}
+
+// memberUsage is what a member has drawn from the pool on a tier: the debits of ended sessions
+// and jobs, and what the member's running sessions have used so far
+func (s *WorkspaceService) memberUsage(ctx context.Context, workspaceID uint, userID uuid.UUID, priceTier string) (float64, error) {
+	db := s.db.WithContext(ctx)
+	var usage models.WorkspaceMemberUsage
+	err := db.Where("workspace_id = ? AND user_id = ? AND price_tier = ?", workspaceID, userID, priceTier).First(&usage).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	var running []models.Cache
+	err = db.Where("workspace_id = ? AND user_id = ? AND price_tier = ? AND termination_time < creation_time", workspaceID, userID, priceTier).
+		Find(&running).Error
+	if err != nil {
+		return 0, err
+	}
+	used := usage.TokenHoursUsed
+	now := time.Now()
+	for i := range running {
+		used += cacheTokenHours(&running[i], now)
+	}
+	return used, nil
+}
+
+// GetWorkspaceSessions returns the sessions shared with the workspace, with their messages
+func (s *WorkspaceService) GetWorkspaceSessions(ctx context.Context, userID uuid.UUID, workspaceID uint) ([]models.Chat, error) {
+	if _, err := s.GetMember(ctx, workspaceID, userID); err != nil {
+		return nil, err
+	}
+	chats, err := s.chatService.GetChatsByWorkspaceIDFromDB(workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workspace sessions: %w", err)
+	}
+	return chats, nil
+}
+
+// AuthorizeSession checks that the user may take part in a session: its creator, or any
+// member of the workspace it is shared with. It returns gorm.ErrRecordNotFound otherwise.
+// The chat is returned without its messages.
+func (s *WorkspaceService) AuthorizeSession(ctx context.Context, userID uuid.UUID, sessionID string) (*models.Chat, error) {
+	chat := &models.Chat{}
+	if err := s.db.WithContext(ctx).Where("session_id = ?", sessionID).First(chat).Error; err != nil {
+		return nil, err
+	}
+	if chat.UserID == userID {
+		return chat, nil
+	}
+	if chat.WorkspaceID == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if _, err := s.GetMember(ctx, *chat.WorkspaceID, userID); err != nil {
+		return nil, err
+	}
+	return chat, nil
+}
+
+// GetWorkspaceUsage reports the pool balances and per-member spending of a workspace
+func (s *WorkspaceService) GetWorkspaceUsage(ctx context.Context, userID uuid.UUID, workspaceID uint) (*WorkspaceUsage, error) {
+	workspace, err := s.GetWorkspace(ctx, userID, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &WorkspaceUsage{Pool: []WorkspacePoolBalance{}, Members: []WorkspaceMemberUsage{}}
+	err = s.db.WithContext(ctx).Model(&models.TierTokenBudget{}).
+		Select("price_tier, SUM(token_hours_bought) AS token_hours_bought, SUM(token_hours_used) AS token_hours_used").
+		Where("workspace_id = ?", workspaceID).
+		Group("price_tier").
+		Order("price_tier").
+		Scan(&usage.Pool).Error
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to get pool of workspace %d", workspaceID)
+		return nil, fmt.Errorf("failed to get workspace pool: %w", err)
+	}
+
+	var rows []struct {
+		UserID uuid.UUID
+		WorkspaceMemberTierUsage
+	}
+	err = s.db.WithContext(ctx).Model(&models.Chat{}).
+		Select("user_id, price_tier, COUNT(*) AS sessions, COALESCE(SUM(token_hours_used), 0) AS token_hours_used").
+		Where("workspace_id = ?", workspaceID).
+		Group("user_id, price_tier").
+		Order("price_tier").
+		Scan(&rows).Error
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("Failed to compute member usage of workspace %d", workspaceID)
+		return nil, fmt.Errorf("failed to compute workspace usage: %w", err)
+	}
+
+	for _, member := range workspace.Members {
+		limits := make(map[string]float64, len(member.Limits))
+		for _, limit := range member.Limits {
+			limits[limit.PriceTier] = limit.TokenHoursLimit
+		}
+		memberUsage := WorkspaceMemberUsage{UserID: member.UserID, Email: member.User.Email, Role: member.Role, ByTier: []WorkspaceMemberTierUsage{}}
+		for _, row := range rows {
+			if row.UserID != member.UserID {
+				continue
+			}
+			tier := row.WorkspaceMemberTierUsage
+			if limit, ok := limits[tier.PriceTier]; ok {
+				tier.TokenHoursLimit = &limit
+				delete(limits, tier.PriceTier)
+			}
+			memberUsage.ByTier = append(memberUsage.ByTier, tier)
+		}
+		// Tiers the member has a limit on but has not used yet
+		for _, priceTier := range []string{"base", "pro"} {
+			if limit, ok := limits[priceTier]; ok {
+				memberUsage.ByTier = append(memberUsage.ByTier, WorkspaceMemberTierUsage{PriceTier: priceTier, TokenHoursLimit: &limit})
+			}
+		}
+		usage.Members = append(usage.Members, memberUsage)
+	}
+	return usage, nil
+}
+
+// MemberIDs returns the user IDs of all members, used to fan out pool balance updates
+func (s *WorkspaceService) MemberIDs(ctx context.Context, workspaceID uint) ([]uuid.UUID, error) {
+	var userIDs []uuid.UUID
+	err := s.db.WithContext(ctx).Model(&models.WorkspaceMember{}).
+		Where("workspace_id = ?", workspaceID).
+		Pluck("user_id", &userIDs).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workspace members: %w", err)
+	}
+	return userIDs, nil
+}
+
+// GetMember returns the user's membership. It returns gorm.ErrRecordNotFound when the
+// user is not a member of the workspace.
+func (s *WorkspaceService) GetMember(ctx context.Context, workspaceID uint, userID uuid.UUID) (*models.WorkspaceMember, error) {
+	var member models.WorkspaceMember
+	err := s.db.WithContext(ctx).Preload("User").
+		Where("workspace_id = ? AND user_id = ?", workspaceID, userID).
+		First(&member).Error
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// RequireRole returns the user's membership if their role is at least minRole
+func (s *WorkspaceService) RequireRole(ctx context.Context, workspaceID uint, userID uuid.UUID, minRole string) (*models.WorkspaceMember, error) {
+	member, err := s.GetMember(ctx, workspaceID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if workspaceRoleRank(member.Role) < workspaceRoleRank(minRole) {
+		return nil, ErrWorkspaceForbidden
+	}
+	return member, nil
+}
+
+// manageMember checks that the actor may manage the target member: admins manage
+// members, the owner manages everyone but themselves
+func (s *WorkspaceService) manageMember(ctx context.Context, actorID uuid.UUID, workspaceID uint, memberID uuid.UUID) (*models.WorkspaceMember, *models.WorkspaceMember, error) {
+	actor, err := s.RequireRole(ctx, workspaceID, actorID, models.WorkspaceRoleAdmin)
+	if err != nil {
+		return nil, nil, err
+	}
+	member, err := s.GetMember(ctx, workspaceID, memberID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if member.Role == models.WorkspaceRoleOwner || workspaceRoleRank(member.Role) >= workspaceRoleRank(actor.Role) {
+		return nil, nil, ErrWorkspaceForbidden
+	}
+	return actor, member, nil
+}
+
+func checkAssignableRole(actor *models.WorkspaceMember, role string) error {
+	if role != models.WorkspaceRoleAdmin && role != models.WorkspaceRoleMember {
+		return fmt.Errorf("%w: role must be 'admin' or 'member'", ErrInvalidWorkspace)
+	}
+	if role == models.WorkspaceRoleAdmin && actor.Role != models.WorkspaceRoleOwner {
+		return ErrWorkspaceForbidden
+	}
+	return nil
+}
+
+func workspaceRoleRank(role string) int {
+	switch role {
+	case models.WorkspaceRoleOwner:
+		return 3
+	case models.WorkspaceRoleAdmin:
+		return 2
+	case models.WorkspaceRoleMember:
+		return 1
+	}
+	return 0
+}
+
+func validateWorkspaceName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxWorkspaceNameLength {
+		return "", fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalidWorkspace, maxWorkspaceNameLength)
+	}
+	return name, nil
+}
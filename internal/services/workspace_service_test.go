@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// testWorkspace is a workspace with an owner, an admin and a member
+type testWorkspace struct {
+	id                   uint
+	owner, admin, member *models.User
+}
+
+func createTestWorkspace(t *testing.T, db *gorm.DB, workspaces *WorkspaceService) testWorkspace {
+	t.Helper()
+	ctx := context.Background()
+	ws := testWorkspace{owner: createTestUser(t, db), admin: createTestUser(t, db), member: createTestUser(t, db)}
+	workspace, err := workspaces.CreateWorkspace(ctx, ws.owner.ID, "Lab")
+	require.NoError(t, err)
+	ws.id = workspace.ID
+	_, err = workspaces.AddMember(ctx, ws.owner.ID, ws.id, ws.admin.Email, models.WorkspaceRoleAdmin)
+	require.NoError(t, err)
+	_, err = workspaces.AddMember(ctx, ws.owner.ID, ws.id, ws.member.Email, models.WorkspaceRoleMember)
+	require.NoError(t, err)
+	return ws
+}
+
+// startTestSession records a cache billed to the workspace that has been running for an hour
+func startTestSession(t *testing.T, cacheDB CacheServiceDB, userID uuid.UUID, workspaceID uint, priceTier string, tokens int32) string {
+	t.Helper()
+	sessionID := uuid.New().String()
+	require.NoError(t, cacheDB.CreateCacheDB(userID, sessionID, "cachedContents/"+sessionID, priceTier, tokens, time.Now().Add(-time.Hour)))
+	require.NoError(t, cacheDB.SetCacheWorkspaceDB(sessionID, workspaceID))
+	return sessionID
+}
+
+func TestWorkspaceRoles(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	workspaces := NewWorkspaceService(db, NewChatServiceDB(db), zerolog.Nop())
+	ws := createTestWorkspace(t, db, workspaces)
+	outsider := createTestUser(t, db)
+	limit := 1.0
+
+	tests := []struct {
+		name string
+		run  func() error
+		err  error
+	}{
+		{"members cannot add members", func() error {
+			_, err := workspaces.AddMember(ctx, ws.member.ID, ws.id, outsider.Email, models.WorkspaceRoleMember)
+			return err
+		}, ErrWorkspaceForbidden},
+		{"admins cannot add admins", func() error {
+			_, err := workspaces.AddMember(ctx, ws.admin.ID, ws.id, outsider.Email, models.WorkspaceRoleAdmin)
+			return err
+		}, ErrWorkspaceForbidden},
+		{"members cannot set limits", func() error {
+			return workspaces.SetMemberLimit(ctx, ws.member.ID, ws.id, ws.member.ID, "base", &limit)
+		}, ErrWorkspaceForbidden},
+		{"admins cannot change the owner's role", func() error {
+			_, err := workspaces.UpdateMemberRole(ctx, ws.admin.ID, ws.id, ws.owner.ID, models.WorkspaceRoleMember)
+			return err
+		}, ErrWorkspaceForbidden},
+		{"the owner cannot leave", func() error {
+			return workspaces.RemoveMember(ctx, ws.owner.ID, ws.id, ws.owner.ID)
+		}, ErrInvalidWorkspace},
+		{"admins cannot delete the workspace", func() error {
+			return workspaces.DeleteWorkspace(ctx, ws.admin.ID, ws.id)
+		}, ErrWorkspaceForbidden},
+		{"outsiders do not see the workspace", func() error {
+			_, err := workspaces.GetWorkspace(ctx, outsider.ID, ws.id)
+			return err
+		}, gorm.ErrRecordNotFound},
+		{"admins can set limits", func() error {
+			return workspaces.SetMemberLimit(ctx, ws.admin.ID, ws.id, ws.member.ID, "base", &limit)
+		}, nil},
+		{"members can leave", func() error {
+			return workspaces.RemoveMember(ctx, ws.member.ID, ws.id, ws.member.ID)
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.run()
+			if tt.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, tt.err), "got %v", err)
+			}
+		})
+	}
+}
+
+func TestWorkspacePoolDebit(t *testing.T) {
+	db := openTestDB(t)
+	cacheDB := NewCacheServiceDB(db)
+	workspaces := NewWorkspaceService(db, NewChatServiceDB(db), zerolog.Nop())
+	ws := createTestWorkspace(t, db, workspaces)
+
+	// Top-ups of a pool that does not exist yet add to one row
+	require.NoError(t, cacheDB.AddWorkspaceTokenHoursBoughtDB(ws.id, ws.owner.ID, "base", 2))
+	require.NoError(t, cacheDB.AddWorkspaceTokenHoursBoughtDB(ws.id, ws.owner.ID, "base", 1))
+	var pools []models.TierTokenBudget
+	require.NoError(t, db.Where("workspace_id = ?", ws.id).Find(&pools).Error)
+	require.Len(t, pools, 1)
+	assert.Equal(t, 3.0, pools[0].TokenHoursBought)
+
+	pool, err := cacheDB.GetWorkspaceTierTokenBudgetDB(ws.id, "base")
+	require.NoError(t, err)
+	require.NoError(t, cacheDB.DebitWorkspaceTokenHoursDB(pool, ws.member.ID, 1))
+	require.NoError(t, cacheDB.DebitWorkspaceTokenHoursDB(pool, ws.member.ID, 0.5))
+	require.NoError(t, cacheDB.DebitWorkspaceTokenHoursDB(pool, ws.admin.ID, 5))
+
+	// The pool is never debited beyond what was bought, members are charged all they used
+	pool, err = cacheDB.GetWorkspaceTierTokenBudgetDB(ws.id, "base")
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, pool.TokenHoursUsed)
+	var usage []models.WorkspaceMemberUsage
+	require.NoError(t, db.Where("workspace_id = ?", ws.id).Order("token_hours_used").Find(&usage).Error)
+	require.Len(t, usage, 2)
+	assert.Equal(t, ws.member.ID, usage[0].UserID)
+	assert.Equal(t, 1.5, usage[0].TokenHoursUsed)
+	assert.Equal(t, 5.0, usage[1].TokenHoursUsed)
+}
+
+func TestMemberLimitEnforcement(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	cacheDB := NewCacheServiceDB(db)
+	workspaces := NewWorkspaceService(db, NewChatServiceDB(db), zerolog.Nop())
+	credit := NewCacheManagementService(nil, nil, 0, cacheDB, nil, workspaces, zerolog.Nop())
+	ws := createTestWorkspace(t, db, workspaces)
+
+	require.NoError(t, cacheDB.AddWorkspaceTokenHoursBoughtDB(ws.id, ws.owner.ID, "base", 10))
+	limit := 2.0
+	require.NoError(t, workspaces.SetMemberLimit(ctx, ws.owner.ID, ws.id, ws.member.ID, "base", &limit))
+
+	remaining, err := credit.RemainingCredit(ctx, ws.member.ID, &ws.id, "base")
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, remaining)
+
+	// A job billed to the pool counts against the member
+	require.NoError(t, credit.LogCacheUsage(ctx, ws.member.ID, &ws.id, "base", 0.5, 0, 1000))
+	remaining, err = credit.RemainingCredit(ctx, ws.member.ID, &ws.id, "base")
+	require.NoError(t, err)
+	assert.InDelta(t, 1.5, remaining, 1e-9)
+
+	// So do running sessions: 500k tokens cached for an hour
+	startTestSession(t, cacheDB, ws.member.ID, ws.id, "base", 500_000)
+	startTestSession(t, cacheDB, ws.member.ID, ws.id, "base", 500_000)
+	remaining, err = credit.RemainingCredit(ctx, ws.member.ID, &ws.id, "base")
+	require.NoError(t, err)
+	assert.InDelta(t, 0.5, remaining, 0.01)
+
+	// Members without a limit draw on what is left of the pool
+	remaining, err = credit.RemainingCredit(ctx, ws.admin.ID, &ws.id, "base")
+	require.NoError(t, err)
+	assert.InDelta(t, 8.5, remaining, 0.01)
+
+	// A tier that was never topped up has no credit
+	remaining, err = credit.RemainingCredit(ctx, ws.admin.ID, &ws.id, "pro")
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, remaining)
+
+	// Former members may not draw on the pool
+	require.NoError(t, workspaces.RemoveMember(ctx, ws.owner.ID, ws.id, ws.member.ID))
+	_, err = credit.RemainingCredit(ctx, ws.member.ID, &ws.id, "base")
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "got %v", err)
+}
+
+func TestDeleteWorkspaceSettlesPool(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	cacheDB := NewCacheServiceDB(db)
+	workspaces := NewWorkspaceService(db, NewChatServiceDB(db), zerolog.Nop())
+	ws := createTestWorkspace(t, db, workspaces)
+
+	require.NoError(t, cacheDB.CreateTierTokenBudgetDB(&models.TierTokenBudget{UserID: ws.owner.ID, PriceTier: "base", TokenHoursBought: 1}))
+	require.NoError(t, cacheDB.AddWorkspaceTokenHoursBoughtDB(ws.id, ws.owner.ID, "base", 5))
+	require.NoError(t, cacheDB.AddWorkspaceTokenHoursBoughtDB(ws.id, ws.owner.ID, "pro", 3))
+	base, err := cacheDB.GetWorkspaceTierTokenBudgetDB(ws.id, "base")
+	require.NoError(t, err)
+	require.NoError(t, cacheDB.DebitWorkspaceTokenHoursDB(base, ws.member.ID, 2))
+	pro, err := cacheDB.GetWorkspaceTierTokenBudgetDB(ws.id, "pro")
+	require.NoError(t, err)
+	require.NoError(t, cacheDB.DebitWorkspaceTokenHoursDB(pro, ws.member.ID, 1))
+
+	sessionID := startTestSession(t, cacheDB, ws.member.ID, ws.id, "base", 1000)
+	err = workspaces.DeleteWorkspace(ctx, ws.owner.ID, ws.id)
+	assert.True(t, errors.Is(err, ErrWorkspaceHasActiveSessions), "got %v", err)
+
+	require.NoError(t, cacheDB.UpdateCacheTerminationTimeDB(sessionID, time.Now()))
+	require.NoError(t, workspaces.DeleteWorkspace(ctx, ws.owner.ID, ws.id))
+
+	// What is left of each tier goes to the owner's own budget, created if needed
+	own, err := cacheDB.GetTierTokenBudgetDB(ws.owner.ID, "base")
+	require.NoError(t, err)
+	assert.Equal(t, 4.0, own.TokenHoursBought)
+	own, err = cacheDB.GetTierTokenBudgetDB(ws.owner.ID, "pro")
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, own.TokenHoursBought)
+
+	var left int64
+	require.NoError(t, db.Unscoped().Model(&models.TierTokenBudget{}).Where("workspace_id = ?", ws.id).Count(&left).Error)
+	assert.Zero(t, left)
+	require.NoError(t, db.Unscoped().Model(&models.WorkspaceMemberUsage{}).Where("workspace_id = ?", ws.id).Count(&left).Error)
+	assert.Zero(t, left)
+	cache, err := cacheDB.GetCacheDB(sessionID)
+	require.NoError(t, err)
+	assert.Nil(t, cache.WorkspaceID)
+}
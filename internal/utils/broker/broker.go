@@ -5,6 +5,10 @@ import (
 	"sync"
 )
 
+// subscriberBuffer is how many messages a subscriber may fall behind before new ones are
+// dropped for it
+const subscriberBuffer = 16
+
 type Broker struct {
 	subscribers map[string][]chan interface{}
 	mu          sync.RWMutex
@@ -19,7 +23,7 @@ func NewBroker() *Broker {
 func (b *Broker) Subscribe(topic string) <-chan interface{} {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	ch := make(chan interface{}, 1)
+	ch := make(chan interface{}, subscriberBuffer)
 	b.subscribers[topic] = append(b.subscribers[topic], ch)
 	return ch
 }
@@ -38,12 +42,18 @@ func (b *Broker) Unsubscribe(topic string, ch <-chan interface{}) {
 	}
 }
 
+// Publish sends msg to the subscribers of topic without waiting on any of them. Subscribers
+// whose buffer is full miss the message, so one that stopped reading cannot hold up the
+// publisher or the broker.
 func (b *Broker) Publish(topic string, msg interface{}) {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 	if chans, ok := b.subscribers[topic]; ok {
 		for _, ch := range chans {
-			ch <- msg
+			select {
+			case ch <- msg:
+			default:
+			}
 		}
 	}
 }
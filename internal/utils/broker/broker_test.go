@@ -0,0 +1,37 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishDoesNotBlockOnStalledSubscriber(t *testing.T) {
+	b := NewBroker()
+	stalled := b.Subscribe("topic")
+	reading := b.Subscribe("topic")
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < subscriberBuffer*2; i++ {
+			b.Publish("topic", i)
+			<-reading
+		}
+		b.Unsubscribe("topic", stalled)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a subscriber that stopped reading")
+	}
+
+	// The stalled subscriber kept the first messages and missed the rest
+	var received []interface{}
+	for msg := range stalled {
+		received = append(received, msg)
+	}
+	assert.Len(t, received, subscriberBuffer)
+	assert.Equal(t, 0, received[0])
+}
@@ -0,0 +1,263 @@
+// Package docir is the structured form of the documents loaded into a session's corpus:
+// sections of paragraphs, display equations and figure or table captions, and the reference
+// list. Math is LaTeX: display equations are blocks of their own, inline math is delimited by
+// \( and \) within paragraphs. Extractors build it from a paper's text, corpus features edit
+// it, and it is written to the markup the session's cache holds and can be parsed back from it.
+package docir
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidDocument is returned for documents that cannot be written to or read from markup
+var ErrInvalidDocument = errors.New("invalid document")
+
+type SectionKind string
+
+const (
+	SectionAbstract SectionKind = "abstract"
+	SectionBody     SectionKind = "body"
+	SectionAppendix SectionKind = "appendix"
+)
+
+type BlockKind string
+
+const (
+	Paragraph BlockKind = "p"
+	Figure    BlockKind = "figure"
+	Table     BlockKind = "table"
+	Equation  BlockKind = "equation"
+)
+
+// Document is one document of a corpus
+type Document struct {
+	Index      int // the N in <Document N>
+	Title      string
+	Notes      []string // remarks on how the document was loaded, such as what was left out
+	Sections   []Section
+	References []Reference
+}
+
+// Section is the text under one heading. Text before the first heading is a body section
+// without a heading.
+type Section struct {
+	Kind    SectionKind
+	Heading string
+	Blocks  []Block
+}
+
+// Block is a paragraph, a display equation or the caption of a figure or table
+type Block struct {
+	Kind  BlockKind
+	Label string // "Figure 3" or "Table 1" for figures and tables, "Equation 2" for numbered equations
+	Text  string // LaTeX for equations
+	// Image names the picture of a figure or table that is cached along with the corpus, if any
+	Image string
+}
+
+// Reference is one entry of a document's reference list
+type Reference struct {
+	Label string // "[12]" or "12." as printed, if any
+	Text  string
+}
+
+// Validate checks that a document can be written to markup and read back as is
+func (d *Document) Validate() error {
+	if d.Index <= 0 {
+		return fmt.Errorf("%w: index %d must be positive", ErrInvalidDocument, d.Index)
+	}
+	for i, section := range d.Sections {
+		switch section.Kind {
+		case SectionAbstract, SectionBody, SectionAppendix:
+		default:
+			return fmt.Errorf("%w: section %d has unknown kind %q", ErrInvalidDocument, i+1, section.Kind)
+		}
+		for j, block := range section.Blocks {
+			switch block.Kind {
+			case Paragraph:
+				if block.Label != "" || block.Image != "" {
+					return fmt.Errorf("%w: paragraph %d of section %d has a label or image", ErrInvalidDocument, j+1, i+1)
+				}
+			case Figure, Table:
+				if block.Label == "" {
+					return fmt.Errorf("%w: %s %d of section %d has no label", ErrInvalidDocument, block.Kind, j+1, i+1)
+				}
+			case Equation:
+				if block.Image != "" {
+					return fmt.Errorf("%w: equation %d of section %d has an image", ErrInvalidDocument, j+1, i+1)
+				}
+			default:
+				return fmt.Errorf("%w: block %d of section %d has unknown kind %q", ErrInvalidDocument, j+1, i+1, block.Kind)
+			}
+		}
+	}
+	return nil
+}
+
+// Text returns the plain text of a document's sections and references, one block or
+// reference per paragraph and display equations between \[ and \]
+func (d *Document) Text() string {
+	var parts []string
+	for _, section := range d.Sections {
+		if section.Heading != "" {
+			parts = append(parts, section.Heading)
+		}
+		for _, block := range section.Blocks {
+			text := block.Text
+			if block.Kind == Equation {
+				text = `\[` + text + `\]`
+			}
+			if block.Label != "" {
+				parts = append(parts, block.Label+": "+text)
+			} else {
+				parts = append(parts, text)
+			}
+		}
+	}
+	for _, ref := range d.References {
+		parts = append(parts, strings.TrimSpace(ref.Label+" "+ref.Text))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// Clone returns a copy of a document that can be edited without affecting the original
+func (d *Document) Clone() Document {
+	clone := *d
+	clone.Notes = append([]string(nil), d.Notes...)
+	clone.References = append([]Reference(nil), d.References...)
+	clone.Sections = make([]Section, len(d.Sections))
+	for i, section := range d.Sections {
+		clone.Sections[i] = section
+		clone.Sections[i].Blocks = append([]Block(nil), section.Blocks...)
+	}
+	return clone
+}
+
+// DropReferences removes the reference list and reports whether there was one
+func (d *Document) DropReferences() bool {
+	dropped := len(d.References) > 0
+	d.References = nil
+	return dropped
+}
+
+// DropAppendices removes the appendix sections and reports whether there were any
+func (d *Document) DropAppendices() bool {
+	kept := d.Sections[:0]
+	for _, section := range d.Sections {
+		if section.Kind != SectionAppendix {
+			kept = append(kept, section)
+		}
+	}
+	dropped := len(kept) < len(d.Sections)
+	d.Sections = kept
+	return dropped
+}
+
+// Images returns the names of the figure and table images the document refers to
+func (d *Document) Images() []string {
+	var images []string
+	for _, section := range d.Sections {
+		for _, block := range section.Blocks {
+			if block.Image != "" {
+				images = append(images, block.Image)
+			}
+		}
+	}
+	return images
+}
+
+// DropImages unlinks the document's figures and tables from their images, keeping the
+// captions, and reports whether there were any
+func (d *Document) DropImages() bool {
+	dropped := false
+	for i := range d.Sections {
+		for j := range d.Sections[i].Blocks {
+			if d.Sections[i].Blocks[j].Image != "" {
+				d.Sections[i].Blocks[j].Image = ""
+				dropped = true
+			}
+		}
+	}
+	return dropped
+}
+
+// DropLines removes the lines of the document's blocks that drop matches, and the blocks left
+// empty, and reports whether anything was removed. Equations are LaTeX, not lines of text, and
+// are kept whole.
+func (d *Document) DropLines(drop func(line string) bool) bool {
+	removed := false
+	for i := range d.Sections {
+		blocks := d.Sections[i].Blocks[:0]
+		for _, block := range d.Sections[i].Blocks {
+			if block.Kind == Equation {
+				blocks = append(blocks, block)
+				continue
+			}
+			lines := strings.Split(block.Text, "\n")
+			kept := lines[:0]
+			for _, line := range lines {
+				if drop(strings.TrimSpace(line)) {
+					removed = true
+					continue
+				}
+				kept = append(kept, line)
+			}
+			block.Text = strings.Join(kept, "\n")
+			if strings.TrimSpace(block.Text) != "" || block.Label != "" {
+				blocks = append(blocks, block)
+			}
+		}
+		d.Sections[i].Blocks = blocks
+	}
+	return removed
+}
+
+// AbstractOnly cuts a document down to its abstract, or to its first blocks up to maxLength
+// characters when it has none, and notes why. It reports whether anything was cut.
+func (d *Document) AbstractOnly(maxLength int, note string) bool {
+	var abstract *Section
+	for i := range d.Sections {
+		if d.Sections[i].Kind == SectionAbstract {
+			abstract = &d.Sections[i]
+			break
+		}
+	}
+
+	var kept []Section
+	if abstract != nil {
+		kept = []Section{*abstract}
+	} else if len(d.Sections) > 0 {
+		first := Section{Kind: SectionBody, Heading: d.Sections[0].Heading}
+		length := 0
+	blocks:
+		for _, section := range d.Sections {
+			for _, block := range section.Blocks {
+				if length > 0 && length+len(block.Text) > maxLength {
+					break blocks
+				}
+				first.Blocks = append(first.Blocks, block)
+				length += len(block.Text)
+			}
+		}
+		kept = []Section{first}
+	}
+
+	cut := len(d.References) > 0 || countBlocks(kept) < countBlocks(d.Sections)
+	if !cut {
+		return false
+	}
+	d.Sections = kept
+	d.References = nil
+	d.Notes = append(d.Notes, note)
+	return true
+}
+
+func countBlocks(sections []Section) int {
+	count := 0
+	for _, section := range sections {
+		count += len(section.Blocks)
+	}
+	return count
+}
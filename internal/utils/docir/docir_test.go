@@ -0,0 +1,221 @@
+package docir
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const paper = `Attention Is Not All You Need
+Jane Doe, John Roe
+
+Abstract: We show that attention alone
+is not enough & argue for <more>.
+
+1 Introduction
+Transformers are everywhere. See Appendix A for proofs.
+
+Figure 1: The architecture
+of the model.
+
+2 Method
+We stack layers.
+
+Table 2. Results on the benchmark.
+
+References
+[1] A. Author. Some paper.
+2020.
+[2] B. Author. Another paper. 2019.
+
+Appendix A: Proofs
+Proof of theorem 1.
+`
+
+func TestFromText(t *testing.T) {
+	d := FromText(1, "Attention Is Not All You Need", paper)
+
+	require.Len(t, d.Sections, 5)
+	assert.Equal(t, Section{Kind: SectionBody, Blocks: []Block{
+		{Kind: Paragraph, Text: "Attention Is Not All You Need\nJane Doe, John Roe"},
+	}}, d.Sections[0])
+	assert.Equal(t, Section{Kind: SectionAbstract, Heading: "Abstract", Blocks: []Block{
+		{Kind: Paragraph, Text: "We show that attention alone\nis not enough & argue for <more>."},
+	}}, d.Sections[1])
+	assert.Equal(t, Section{Kind: SectionBody, Heading: "1 Introduction", Blocks: []Block{
+		{Kind: Paragraph, Text: "Transformers are everywhere. See Appendix A for proofs."},
+		{Kind: Figure, Label: "Figure 1", Text: "The architecture\nof the model."},
+	}}, d.Sections[2])
+	assert.Equal(t, Block{Kind: Table, Label: "Table 2", Text: "Results on the benchmark."}, d.Sections[3].Blocks[1])
+	assert.Equal(t, Section{Kind: SectionAppendix, Heading: "Appendix A: Proofs", Blocks: []Block{
+		{Kind: Paragraph, Text: "Proof of theorem 1."},
+	}}, d.Sections[4])
+	assert.Equal(t, []Reference{
+		{Label: "[1]", Text: "A. Author. Some paper.\n2020."},
+		{Label: "[2]", Text: "B. Author. Another paper. 2019."},
+	}, d.References)
+}
+
+func TestParseCaption(t *testing.T) {
+	kind, label, rest, ok := ParseCaption("Fig. 3: Loss curves.")
+	assert.True(t, ok)
+	assert.Equal(t, Figure, kind)
+	assert.Equal(t, "Figure 3", label)
+	assert.Equal(t, "Loss curves.", rest)
+
+	kind, label, _, ok = ParseCaption("Table A2. Hyperparameters")
+	assert.True(t, ok)
+	assert.Equal(t, Table, kind)
+	assert.Equal(t, "Table A2", label)
+
+	_, _, _, ok = ParseCaption("Figure 3 shows the loss curves.")
+	assert.False(t, ok)
+}
+
+func TestMarkupRoundTrip(t *testing.T) {
+	first := FromText(1, `Attention "Is" Not All You Need`, paper)
+	first.Notes = []string{"Only the abstract <was> loaded."}
+	first.Sections[2].Blocks[1].Image = "document-1-figure-1.png"
+	second := FromText(2, "Untitled", "Just one paragraph\nof text.")
+
+	var markup strings.Builder
+	markup.WriteString("Summary of Documents:\nDocument 1: ...\n\nAggregated Content:\n")
+	for _, d := range []Document{first, second} {
+		written, err := Marshal(&d)
+		require.NoError(t, err)
+		markup.WriteString(written)
+	}
+
+	parsed, err := Parse(markup.String())
+	require.NoError(t, err)
+	assert.Equal(t, []Document{first, second}, parsed)
+}
+
+func TestMarshal(t *testing.T) {
+	d := Document{Index: 3, Title: "A & B", Sections: []Section{
+		{Kind: SectionBody, Heading: `1 "Intro"`, Blocks: []Block{
+			{Kind: Paragraph, Text: "x < y, don't write &lt; or \"&#39;\""},
+			{Kind: Equation, Text: `a &= b \\ c &= d`},
+		}},
+	}}
+	written, err := Marshal(&d)
+	require.NoError(t, err)
+	assert.Equal(t, "<Document 3>\n<title>A & B</title>\n"+
+		"<section kind=\"body\" heading=\"1 &quot;Intro&quot;\">\n"+
+		"<p>x &lt; y, don't write &amp;lt; or \"&amp;#39;\"</p>\n<equation>a &= b \\\\ c &= d</equation>\n</section>\n"+
+		"</Document 3>\n", written)
+	parsed, err := Parse(written)
+	require.NoError(t, err)
+	assert.Equal(t, []Document{d}, parsed)
+
+	t.Run("invalid documents", func(t *testing.T) {
+		for _, d := range []Document{
+			{Index: 0},
+			{Index: 1, Sections: []Section{{Kind: "preface"}}},
+			{Index: 1, Sections: []Section{{Kind: SectionBody, Blocks: []Block{{Kind: Figure, Text: "no label"}}}}},
+			{Index: 1, Sections: []Section{{Kind: SectionBody, Blocks: []Block{{Kind: Paragraph, Label: "Figure 1"}}}}},
+			{Index: 1, Sections: []Section{{Kind: SectionBody, Blocks: []Block{{Kind: Paragraph, Image: "p.png"}}}}},
+		} {
+			_, err := Marshal(&d)
+			assert.ErrorIs(t, err, ErrInvalidDocument)
+		}
+	})
+}
+
+func TestParseMalformed(t *testing.T) {
+	for name, markup := range map[string]string{
+		"unclosed document":  "<Document 1>\n<title>T</title>\n",
+		"mismatched closer":  "<Document 1>\n<title>T</title>\n</Document 2>\n",
+		"closer without gap": "<Document 1>\n<title>T</title>\n</Document1>\n",
+		"missing title":      "<Document 1>\n</Document 1>\n",
+		"unknown element":    "<Document 1>\n<title>T</title>\n<aside>x</aside>\n</Document 1>\n",
+		"unclosed section":   "<Document 1>\n<title>T</title>\n<section kind=\"body\">\n<p>x</p>\n</Document 1>\n",
+		"unknown kind":       "<Document 1>\n<title>T</title>\n<section kind=\"preface\">\n</section>\n</Document 1>\n",
+		"nested markup":      "<Document 1>\n<title>T <b>bold</b></title>\n</Document 1>\n",
+		"bad attributes":     "<Document 1>\n<title>T</title>\n<section kind=body>\n</section>\n</Document 1>\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse(markup)
+			assert.ErrorIs(t, err, ErrInvalidDocument)
+		})
+	}
+}
+
+func TestEdits(t *testing.T) {
+	original := FromText(1, "T", paper)
+
+	t.Run("drop references and appendices", func(t *testing.T) {
+		d := original.Clone()
+		assert.True(t, d.DropReferences())
+		assert.False(t, d.DropReferences())
+		assert.True(t, d.DropAppendices())
+		assert.Empty(t, d.References)
+		assert.Len(t, d.Sections, 4)
+		// The original is untouched
+		assert.Len(t, original.References, 2)
+		assert.Len(t, original.Sections, 5)
+	})
+
+	t.Run("drop images", func(t *testing.T) {
+		d := original.Clone()
+		assert.False(t, d.DropImages())
+		d.Sections[2].Blocks[1].Image = "document-1-figure-1.png"
+		assert.Equal(t, []string{"document-1-figure-1.png"}, d.Images())
+		assert.True(t, d.DropImages())
+		assert.Empty(t, d.Images())
+		assert.Equal(t, "The architecture\nof the model.", d.Sections[2].Blocks[1].Text)
+		assert.Empty(t, original.Images())
+	})
+
+	t.Run("drop lines", func(t *testing.T) {
+		d := original.Clone()
+		assert.True(t, d.DropLines(func(line string) bool { return line == "Jane Doe, John Roe" || line == "We stack layers." }))
+		assert.Equal(t, "Attention Is Not All You Need", d.Sections[0].Blocks[0].Text)
+		assert.Equal(t, []Block{{Kind: Table, Label: "Table 2", Text: "Results on the benchmark."}}, d.Sections[3].Blocks)
+		assert.Equal(t, "Jane Doe, John Roe", strings.Split(original.Sections[0].Blocks[0].Text, "\n")[1])
+	})
+
+	t.Run("abstract only", func(t *testing.T) {
+		d := original.Clone()
+		assert.True(t, d.AbstractOnly(100, "Abstract only."))
+		assert.Equal(t, []Section{original.Sections[1]}, d.Sections)
+		assert.Empty(t, d.References)
+		assert.Equal(t, []string{"Abstract only."}, d.Notes)
+		assert.False(t, d.AbstractOnly(100, "Abstract only."))
+	})
+
+	t.Run("abstract only without an abstract", func(t *testing.T) {
+		d := FromText(1, "T", "First paragraph.\n\nSecond paragraph.\n\nThird paragraph.")
+		assert.True(t, d.AbstractOnly(35, "Beginning only."))
+		require.Len(t, d.Sections, 1)
+		assert.Equal(t, []Block{
+			{Kind: Paragraph, Text: "First paragraph."},
+			{Kind: Paragraph, Text: "Second paragraph."},
+		}, d.Sections[0].Blocks)
+	})
+}
+
+func TestEquations(t *testing.T) {
+	d := FromText(1, "T", "1 Method\nThe loss \\(L\\) is\n\n\\[ L = \\sum_i (y_i - \\hat{y}_i)^2 \\]\n\nwhere x < y.")
+	require.Len(t, d.Sections, 1)
+	assert.Equal(t, []Block{
+		{Kind: Paragraph, Text: "The loss \\(L\\) is"},
+		{Kind: Equation, Text: "L = \\sum_i (y_i - \\hat{y}_i)^2"},
+		{Kind: Paragraph, Text: "where x < y."},
+	}, d.Sections[0].Blocks)
+	assert.Equal(t, "1 Method\n\nThe loss \\(L\\) is\n\n\\[L = \\sum_i (y_i - \\hat{y}_i)^2\\]\n\nwhere x < y.", d.Text())
+
+	d.Sections[0].Blocks[1].Label = "Equation 1"
+	written, err := Marshal(&d)
+	require.NoError(t, err)
+	assert.Contains(t, written, "<equation label=\"Equation 1\">L = \\sum_i (y_i - \\hat{y}_i)^2</equation>\n")
+	parsed, err := Parse(written)
+	require.NoError(t, err)
+	assert.Equal(t, []Document{d}, parsed)
+
+	d.Sections[0].Blocks[1].Image = "eq.png"
+	_, err = Marshal(&d)
+	assert.ErrorIs(t, err, ErrInvalidDocument)
+}
@@ -0,0 +1,170 @@
+package docir
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Headings are short lines of their own; longer lines are prose
+const maxHeadingLength = 60
+
+var (
+	abstractHeading   = regexp.MustCompile(`(?i)^abstract\b[\s.:—–-]*`)
+	referencesHeading = regexp.MustCompile(`(?i)^(?:\d+\.?|[IVX]+\.)?\s*(?:references|bibliography|works cited|literature cited)$`)
+	appendixHeading   = regexp.MustCompile(`(?i)^(?:[A-Z]\.?\s+)?(?:appendix|appendices|supplementary materials?|supplemental materials?)\b`)
+	// Numbered headings such as "3 Method", "2.1. Data" or "IV. RESULTS", and the unnumbered
+	// ones most papers use
+	numberedHeading = regexp.MustCompile(`^(?:\d+(?:\.\d+)*\.?|[IVX]+\.|[A-H](?:\.\d+)*\.?)\s+[A-Z][^.!?,;:]*$`)
+	namedHeading    = regexp.MustCompile(`(?i)^(?:introduction|background|related work|conclusions?|discussion|limitations|acknowledge?ments)$`)
+	caption         = regexp.MustCompile(`^(Figure|Fig\.|Table)\s+([A-Z]?\d+(?:\.\d+)?)\s*[.:|]\s*`)
+	referenceLabel  = regexp.MustCompile(`^(\[\d+\]|\d+\.)\s+`)
+)
+
+// FromText structures the plain text extracted from a paper. Headings split it into sections,
+// blank lines into paragraphs; paragraphs starting with "Figure N:" or "Table N:" are captions,
+// paragraphs between \[ and \] are display equations and everything under a references
+// heading is the reference list.
+func FromText(index int, title, text string) Document {
+	d := Document{Index: index, Title: title}
+	b := &builder{doc: &d}
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			b.endParagraph()
+			continue
+		}
+		if len(trimmed) <= maxHeadingLength && b.heading(trimmed) {
+			continue
+		}
+		if rest := abstractHeading.ReplaceAllString(trimmed, ""); rest != trimmed && !b.inReferences && b.abstractStart(trimmed) {
+			// "Abstract—We show ..." runs the heading into the text
+			b.startSection(SectionAbstract, "Abstract")
+			b.addLine(rest)
+			continue
+		}
+		b.addLine(trimmed)
+	}
+	b.endParagraph()
+	return d
+}
+
+// ParseCaption tells whether text is the caption of a figure or table, "Figure 2: ..." or
+// "Table 1. ...", and returns its kind, its label and the text after the label
+func ParseCaption(text string) (kind BlockKind, label, rest string, ok bool) {
+	m := caption.FindStringSubmatch(text)
+	if m == nil {
+		return "", "", "", false
+	}
+	kind, name := Figure, "Figure"
+	if m[1] == "Table" {
+		kind, name = Table, "Table"
+	}
+	return kind, name + " " + m[2], text[len(m[0]):], true
+}
+
+type builder struct {
+	doc          *Document
+	section      *Section
+	paragraph    []string
+	inReferences bool
+	inAppendix   bool
+	sawAbstract  bool
+}
+
+// heading starts a section, or the reference list, if the line is a heading
+func (b *builder) heading(line string) bool {
+	switch {
+	case referencesHeading.MatchString(line):
+		b.endParagraph()
+		b.section = nil
+		b.inReferences = true
+		return true
+	case appendixHeading.MatchString(line) && (b.inReferences || b.hasBody()):
+		b.inAppendix = true
+		b.startSection(SectionAppendix, line)
+		return true
+	case abstractHeading.MatchString(line) && strings.EqualFold(strings.Trim(line, " .:—–-"), "abstract") && !b.sawAbstract:
+		b.startSection(SectionAbstract, "Abstract")
+		return true
+	case b.inReferences:
+		return false
+	case numberedHeading.MatchString(line) && len(strings.Fields(line)) <= 8, namedHeading.MatchString(line):
+		kind := SectionBody
+		if b.inAppendix {
+			kind = SectionAppendix
+		}
+		b.startSection(kind, line)
+		return true
+	}
+	return false
+}
+
+// abstractStart tells whether a line starting with "Abstract" begins the abstract rather than
+// using the word in a sentence
+func (b *builder) abstractStart(line string) bool {
+	return !b.sawAbstract && len(b.doc.Sections) <= 1 && !strings.HasPrefix(strings.ToLower(line), "abstract ")
+}
+
+// hasBody tells whether the document already has a headed section of its body. Appendices
+// follow the body; "Appendix" on a line of its own before that is a wrapped sentence.
+func (b *builder) hasBody() bool {
+	for _, section := range b.doc.Sections {
+		if section.Kind == SectionBody && section.Heading != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *builder) startSection(kind SectionKind, heading string) {
+	b.endParagraph()
+	b.inReferences = false
+	if kind == SectionAbstract {
+		b.sawAbstract = true
+	}
+	b.doc.Sections = append(b.doc.Sections, Section{Kind: kind, Heading: heading})
+	b.section = &b.doc.Sections[len(b.doc.Sections)-1]
+}
+
+func (b *builder) addLine(line string) {
+	if b.inReferences && len(b.paragraph) > 0 && referenceLabel.MatchString(line) {
+		// Numbered entries follow each other without blank lines
+		b.endParagraph()
+	}
+	b.paragraph = append(b.paragraph, line)
+}
+
+func (b *builder) endParagraph() {
+	if len(b.paragraph) == 0 {
+		return
+	}
+	text := strings.Join(b.paragraph, "\n")
+	b.paragraph = nil
+
+	if b.inReferences {
+		ref := Reference{Text: text}
+		if m := referenceLabel.FindStringSubmatch(text); m != nil {
+			ref.Label = m[1]
+			ref.Text = text[len(m[0]):]
+		}
+		b.doc.References = append(b.doc.References, ref)
+		return
+	}
+
+	block := Block{Kind: Paragraph, Text: text}
+	if kind, label, rest, ok := ParseCaption(text); ok {
+		block = Block{Kind: kind, Label: label, Text: rest}
+	} else if strings.HasPrefix(text, `\[`) && strings.HasSuffix(text, `\]`) && len(text) > 4 {
+		block = Block{Kind: Equation, Text: strings.TrimSpace(text[2 : len(text)-2])}
+	}
+	if b.section == nil {
+		b.doc.Sections = append(b.doc.Sections, Section{Kind: SectionBody})
+		b.section = &b.doc.Sections[len(b.doc.Sections)-1]
+	}
+	b.section.Blocks = append(b.section.Blocks, block)
+	if b.section.Kind == SectionAbstract {
+		// Abstracts are a single paragraph; what follows belongs to the body even when the
+		// introduction's heading was not recognized
+		b.section = nil
+	}
+}
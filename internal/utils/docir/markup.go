@@ -0,0 +1,289 @@
+package docir
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The markup of a document, one element per line. Text is escaped only as far as reading it
+// back needs: < always, & where it would start an entity, and " in attributes. Quotes and
+// LaTeX alignment such as a &= b stay as they are.
+//
+//	<Document 1>
+//	<title>Attention Is All You Need</title>
+//	<note>Only the abstract of this document was loaded.</note>
+//	<section kind="body" heading="1 Introduction">
+//	<p>Recurrent models ... the softmax \(\sigma(x)\) ...</p>
+//	<equation label="Equation 1">\mathrm{Attention}(Q, K, V) = \mathrm{softmax}(QK^T)V</equation>
+//	<figure label="Figure 1" image="document-1-figure-1.png">The Transformer architecture.</figure>
+//	</section>
+//	<references>
+//	<ref label="[1]">J. Ba, J. Kiros and G. Hinton. Layer normalization. 2016.</ref>
+//	</references>
+//	</Document 1>
+
+var (
+	documentOpen = regexp.MustCompile(`<Document (\d+)>`)
+	attribute    = regexp.MustCompile(`^\s*([a-z]+)="([^"]*)"`)
+	entityStart  = regexp.MustCompile(`&([A-Za-z#])`)
+)
+
+// Marshal writes a document as markup
+func Marshal(d *Document) (string, error) {
+	if err := d.Validate(); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "<Document %d>\n", d.Index)
+	writeElement(&b, "title", nil, d.Title)
+	for _, note := range d.Notes {
+		writeElement(&b, "note", nil, note)
+	}
+	for _, section := range d.Sections {
+		attrs := [][2]string{{"kind", string(section.Kind)}}
+		if section.Heading != "" {
+			attrs = append(attrs, [2]string{"heading", section.Heading})
+		}
+		b.WriteString("<section" + formatAttrs(attrs) + ">\n")
+		for _, block := range section.Blocks {
+			var blockAttrs [][2]string
+			if block.Label != "" {
+				blockAttrs = [][2]string{{"label", block.Label}}
+			}
+			if block.Image != "" {
+				blockAttrs = append(blockAttrs, [2]string{"image", block.Image})
+			}
+			writeElement(&b, string(block.Kind), blockAttrs, block.Text)
+		}
+		b.WriteString("</section>\n")
+	}
+	if len(d.References) > 0 {
+		b.WriteString("<references>\n")
+		for _, ref := range d.References {
+			var attrs [][2]string
+			if ref.Label != "" {
+				attrs = [][2]string{{"label", ref.Label}}
+			}
+			writeElement(&b, "ref", attrs, ref.Text)
+		}
+		b.WriteString("</references>\n")
+	}
+	fmt.Fprintf(&b, "</Document %d>\n", d.Index)
+	return b.String(), nil
+}
+
+func writeElement(b *strings.Builder, name string, attrs [][2]string, text string) {
+	b.WriteString("<" + name + formatAttrs(attrs) + ">" + escapeText(text) + "</" + name + ">\n")
+}
+
+func formatAttrs(attrs [][2]string) string {
+	var b strings.Builder
+	for _, attr := range attrs {
+		b.WriteString(" " + attr[0] + `="` + strings.ReplaceAll(escapeText(attr[1]), `"`, "&quot;") + `"`)
+	}
+	return b.String()
+}
+
+// escapeText escapes what Parse would otherwise read as markup or an entity
+func escapeText(text string) string {
+	return strings.ReplaceAll(entityStart.ReplaceAllString(text, "&amp;$1"), "<", "&lt;")
+}
+
+// Parse reads every document of a corpus's markup, in order. Text outside of documents, such
+// as the corpus summary, is skipped.
+func Parse(markup string) ([]Document, error) {
+	var documents []Document
+	for {
+		loc := documentOpen.FindStringSubmatchIndex(markup)
+		if loc == nil {
+			return documents, nil
+		}
+		index, _ := strconv.Atoi(markup[loc[2]:loc[3]])
+		p := &parser{rest: markup[loc[1]:]}
+		document, err := p.document(index)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", index, err)
+		}
+		documents = append(documents, document)
+		markup = p.rest
+	}
+}
+
+type parser struct {
+	rest string
+}
+
+// tag is an opening or closing tag
+type tag struct {
+	name    string
+	closing bool
+	attrs   map[string]string
+}
+
+func (p *parser) document(index int) (Document, error) {
+	d := Document{Index: index}
+	closing := fmt.Sprintf("</Document %d>", index)
+	var sawTitle bool
+	for {
+		p.skipSpace()
+		if strings.HasPrefix(p.rest, closing) {
+			p.rest = p.rest[len(closing):]
+			break
+		}
+		t, err := p.tag()
+		if err != nil {
+			return d, err
+		}
+		switch {
+		case t.closing:
+			return d, fmt.Errorf("%w: unexpected </%s>", ErrInvalidDocument, t.name)
+		case t.name == "title" && !sawTitle:
+			if d.Title, err = p.text("title"); err != nil {
+				return d, err
+			}
+			sawTitle = true
+		case t.name == "note":
+			note, err := p.text("note")
+			if err != nil {
+				return d, err
+			}
+			d.Notes = append(d.Notes, note)
+		case t.name == "section":
+			section, err := p.section(t)
+			if err != nil {
+				return d, err
+			}
+			d.Sections = append(d.Sections, section)
+		case t.name == "references":
+			if d.References, err = p.references(); err != nil {
+				return d, err
+			}
+		default:
+			return d, fmt.Errorf("%w: unexpected <%s>", ErrInvalidDocument, t.name)
+		}
+	}
+	if !sawTitle {
+		return d, fmt.Errorf("%w: missing <title>", ErrInvalidDocument)
+	}
+	return d, d.Validate()
+}
+
+func (p *parser) section(open tag) (Section, error) {
+	section := Section{Kind: SectionKind(open.attrs["kind"]), Heading: open.attrs["heading"]}
+	for {
+		t, err := p.nextTag()
+		if err != nil {
+			return section, err
+		}
+		if t.closing {
+			if t.name != "section" {
+				return section, fmt.Errorf("%w: unexpected </%s> in section", ErrInvalidDocument, t.name)
+			}
+			return section, nil
+		}
+		kind := BlockKind(t.name)
+		if kind != Paragraph && kind != Figure && kind != Table && kind != Equation {
+			return section, fmt.Errorf("%w: unexpected <%s> in section", ErrInvalidDocument, t.name)
+		}
+		text, err := p.text(t.name)
+		if err != nil {
+			return section, err
+		}
+		section.Blocks = append(section.Blocks, Block{Kind: kind, Label: t.attrs["label"], Text: text, Image: t.attrs["image"]})
+	}
+}
+
+func (p *parser) references() ([]Reference, error) {
+	var references []Reference
+	for {
+		t, err := p.nextTag()
+		if err != nil {
+			return nil, err
+		}
+		if t.closing {
+			if t.name != "references" {
+				return nil, fmt.Errorf("%w: unexpected </%s> in references", ErrInvalidDocument, t.name)
+			}
+			return references, nil
+		}
+		if t.name != "ref" {
+			return nil, fmt.Errorf("%w: unexpected <%s> in references", ErrInvalidDocument, t.name)
+		}
+		text, err := p.text("ref")
+		if err != nil {
+			return nil, err
+		}
+		references = append(references, Reference{Label: t.attrs["label"], Text: text})
+	}
+}
+
+func (p *parser) nextTag() (tag, error) {
+	p.skipSpace()
+	return p.tag()
+}
+
+// tag reads the tag at the start of the rest of the markup
+func (p *parser) tag() (tag, error) {
+	if !strings.HasPrefix(p.rest, "<") {
+		return tag{}, fmt.Errorf("%w: expected a tag at %q", ErrInvalidDocument, excerpt(p.rest))
+	}
+	end := strings.IndexByte(p.rest, '>')
+	if end < 0 {
+		return tag{}, fmt.Errorf("%w: unterminated tag at %q", ErrInvalidDocument, excerpt(p.rest))
+	}
+	raw := p.rest[1:end]
+	p.rest = p.rest[end+1:]
+
+	var t tag
+	if strings.HasPrefix(raw, "/") {
+		t.closing = true
+		raw = raw[1:]
+	}
+	name, attrs, _ := strings.Cut(raw, " ")
+	t.name = name
+	if t.closing {
+		if attrs != "" {
+			return tag{}, fmt.Errorf("%w: attributes on </%s>", ErrInvalidDocument, name)
+		}
+		return t, nil
+	}
+	t.attrs = make(map[string]string)
+	for strings.TrimSpace(attrs) != "" {
+		m := attribute.FindStringSubmatch(attrs)
+		if m == nil {
+			return tag{}, fmt.Errorf("%w: malformed attributes on <%s>", ErrInvalidDocument, name)
+		}
+		t.attrs[m[1]] = html.UnescapeString(m[2])
+		attrs = attrs[len(m[0]):]
+	}
+	return t, nil
+}
+
+// text reads the text of an element up to its closing tag
+func (p *parser) text(name string) (string, error) {
+	closing := "</" + name + ">"
+	end := strings.Index(p.rest, closing)
+	if end < 0 {
+		return "", fmt.Errorf("%w: missing %s", ErrInvalidDocument, closing)
+	}
+	raw := p.rest[:end]
+	if strings.ContainsRune(raw, '<') {
+		return "", fmt.Errorf("%w: markup inside <%s>", ErrInvalidDocument, name)
+	}
+	p.rest = p.rest[end+len(closing):]
+	return html.UnescapeString(raw), nil
+}
+
+func (p *parser) skipSpace() {
+	p.rest = strings.TrimLeft(p.rest, " \t\r\n")
+}
+
+func excerpt(s string) string {
+	if len(s) > 30 {
+		return s[:30] + "…"
+	}
+	return s
+}
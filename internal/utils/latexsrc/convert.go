@@ -0,0 +1,993 @@
+package latexsrc
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+
+	"nexus_scholar_go_backend/internal/utils/docir"
+)
+
+var (
+	labelCommand   = regexp.MustCompile(`\\label\s*\{([^}]*)\}`)
+	tagCommand     = regexp.MustCompile(`\\tag\*?\s*\{([^}]*)\}`)
+	noNumber       = regexp.MustCompile(`\\(?:nonumber|notag)\b`)
+	rowSpacing     = regexp.MustCompile(`^\s*\[-?[\d.]+\s*[a-z]*\]`)
+	tableRule      = regexp.MustCompile(`\\(?:hline|toprule|midrule|bottomrule|addlinespace|endhead|endfirsthead|endfoot|endlastfoot)\b|\\(?:cline|cmidrule)(?:\([^)]*\))?\s*\{[^}]*\}`)
+	tabularBegin   = regexp.MustCompile(`\\begin\{(tabular\*?|tabularx|tabulary)\}`)
+	captionOrLabel = regexp.MustCompile(`\\(caption|label)(\*?)\s*(?:\[[^\]]*\])?`)
+	newTheorem     = regexp.MustCompile(`\\newtheorem\*?\s*\{([^}]+)\}\s*(?:\[[^\]]*\])?\s*\{([^}]+)\}`)
+	citeCommand    = regexp.MustCompile(`^(?:[Cc]ite|parencite|textcite|autocite|footcite|citep|citet|citealp|citealt|citeauthor|citeyear|citenum)$`)
+	placeholder    = regexp.MustCompile("\x00([a-z]+)\\|([^\x00]*)\x00")
+	referencesName = regexp.MustCompile(`(?i)^(?:[\dA-Z.]+\s+)?(?:references|bibliography)$`)
+)
+
+// Display math environments. Those with several rows number each row; the others number the
+// whole equation.
+var mathEnvironments = map[string]bool{
+	"equation": false, "displaymath": false, "multline": false,
+	"align": true, "flalign": true, "alignat": true, "gather": true, "eqnarray": true,
+}
+
+// The environments \[ and \] cannot hold on their own, and what the rows of each are wrapped in
+var rowEnvironments = map[string]string{
+	"align": "aligned", "flalign": "aligned", "alignat": "alignedat", "gather": "gathered",
+	"multline": "gathered", "eqnarray": "array",
+}
+
+var theoremNames = map[string]string{
+	"theorem": "Theorem", "lemma": "Lemma", "proposition": "Proposition", "corollary": "Corollary",
+	"definition": "Definition", "remark": "Remark", "example": "Example", "assumption": "Assumption",
+	"claim": "Claim", "conjecture": "Conjecture", "hypothesis": "Hypothesis", "observation": "Observation",
+	"fact": "Fact", "proof": "Proof",
+}
+
+var (
+	verbatimEnvironments = map[string]bool{"verbatim": true, "Verbatim": true, "lstlisting": true, "minted": true}
+	droppedEnvironments  = map[string]bool{"tikzpicture": true, "pgfpicture": true, "picture": true, "comment": true, "algorithm": true}
+	// How many arguments environments take before their text, after an optional one
+	environmentArgs = map[string]int{
+		"minipage": 1, "multicols": 1, "adjustbox": 1, "spacing": 1, "list": 2,
+		"tabular": 1, "tabular*": 2, "tabularx": 2, "tabulary": 2,
+	}
+)
+
+// Commands whose arguments are not text, and how many of them are dropped. Arguments after
+// those, such as the text of \textcolor{red}{text}, are kept.
+var droppedArgs = map[string]int{
+	"begin": 1, "end": 1, "vspace": 1, "hspace": 1, "includegraphics": 1, "bibliographystyle": 1,
+	"bibliography": 1, "nocite": 1, "pageref": 1, "footnotetext": 1, "thanks": 1, "title": 1,
+	"author": 1, "date": 1, "affiliation": 1, "affil": 1, "address": 1, "email": 1, "institute": 1,
+	"setlength": 2, "addtolength": 2, "setcounter": 2, "addtocounter": 2, "addcontentsline": 3,
+	"pagestyle": 1, "thispagestyle": 1, "textcolor": 1, "color": 1, "colorbox": 1, "fcolorbox": 2,
+	"multicolumn": 2, "multirow": 2, "resizebox": 2, "scalebox": 1, "rotatebox": 1, "raisebox": 1,
+	"hypersetup": 1, "graphicspath": 1, "captionsetup": 1, "usepackage": 1, "definecolor": 3,
+	"phantom": 1, "hphantom": 1, "vphantom": 1, "enlargethispage": 1, "bibinfo": 1, "bibfield": 1,
+	"newtheorem": 2,
+}
+
+var symbols = map[string]string{
+	"ldots": "…", "dots": "…", "textellipsis": "…", "LaTeX": "LaTeX", "TeX": "TeX",
+	"textendash": "–", "textemdash": "—", "S": "§", "P": "¶", "ss": "ß", "ae": "æ", "AE": "Æ",
+	"oe": "œ", "OE": "Œ", "o": "ø", "O": "Ø", "aa": "å", "AA": "Å", "l": "ł", "L": "Ł", "i": "ı",
+	"copyright": "©", "textregistered": "®", "texttrademark": "™", "textbackslash": `\`,
+	"textasciitilde": "~", "textasciicircum": "^", "textbar": "|", "textless": "<", "textgreater": ">",
+	"textdegree": "°", "textbullet": "•", "textquoteleft": "‘", "textquoteright": "’",
+	"textquotedblleft": "“", "textquotedblright": "”", "quad": " ", "qquad": " ", "newline": " ",
+	"linebreak": " ", "newblock": " ", "par": " ", "item": "- ", "and": ", ",
+}
+
+// Accents, as the combining marks they put on the following letter
+var accents = map[string]string{
+	"'": "\u0301", "`": "\u0300", "^": "\u0302", `"`: "\u0308", "~": "\u0303", "=": "\u0304",
+	".": "\u0307", "c": "\u0327", "v": "\u030c", "u": "\u0306", "H": "\u030b", "r": "\u030a", "k": "\u0328",
+}
+
+// target is what a \label refers to: the number of the section, equation, figure or table it
+// is in
+type target struct {
+	kind   string // "Section", "Equation", "Figure" or "Table"
+	number string
+}
+
+type converter struct {
+	doc      *docir.Document
+	section  int // index of the current section, -1 before the first one or after the abstract
+	text     strings.Builder
+	appendix bool
+	// Numbers of the current section, subsection and subsubsection
+	numbers                    [3]int
+	equations, figures, tables int
+	target                     target
+	labels                     map[string]target
+	citations                  map[string]int
+	theorems                   map[string]string
+}
+
+// ToDocument converts the LaTeX of a paper, with its inputs expanded, into a document. Display
+// math becomes equation blocks numbered the way LaTeX numbers them, inline math is written
+// between \( and \), and references to labels and citations are resolved to the numbers
+// LaTeX would print. The title is read from \title when none is given.
+func ToDocument(index int, title, source string) docir.Document {
+	source = strings.ReplaceAll(source, "\x00", "")
+	preamble, body := source, source
+	if start := strings.Index(source, `\begin{document}`); start >= 0 {
+		preamble, body = source[:start], source[start+len(`\begin{document}`):]
+		if end := strings.Index(body, `\end{document}`); end >= 0 {
+			body = body[:end]
+		}
+	}
+
+	c := &converter{
+		doc:       &docir.Document{Index: index, Title: title},
+		section:   -1,
+		labels:    make(map[string]target),
+		citations: make(map[string]int),
+		theorems:  make(map[string]string),
+	}
+	for env, name := range theoremNames {
+		c.theorems[env] = name
+	}
+	for _, m := range newTheorem.FindAllStringSubmatch(source, -1) {
+		c.theorems[strings.TrimSpace(m[1])] = strings.TrimSpace(m[2])
+	}
+	if title == "" {
+		if start := strings.Index(preamble, `\title`); start >= 0 {
+			_, i, _ := optional(preamble, start+len(`\title`))
+			arg, _ := argument(preamble, i)
+			c.doc.Title = c.inline(arg)
+		}
+	}
+
+	c.blocks(body)
+	c.flush()
+
+	d := c.doc
+	d.Title = c.resolve(d.Title)
+	sections := d.Sections[:0]
+	for _, section := range d.Sections {
+		section.Heading = c.resolve(section.Heading)
+		// \section*{References} heads the reference list, which is not a section
+		if len(section.Blocks) == 0 && referencesName.MatchString(section.Heading) {
+			continue
+		}
+		for i := range section.Blocks {
+			section.Blocks[i].Text = c.resolve(section.Blocks[i].Text)
+		}
+		sections = append(sections, section)
+	}
+	d.Sections = sections
+	for i := range d.References {
+		d.References[i].Text = c.resolve(d.References[i].Text)
+	}
+	return *d
+}
+
+// blocks converts LaTeX into blocks of the current section. Text is collected into a paragraph
+// until a blank line, a heading or an environment of its own ends it.
+func (c *converter) blocks(s string) {
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == '\n':
+			j := i + 1
+			for j < len(s) && (s[j] == ' ' || s[j] == '\t' || s[j] == '\r') {
+				j++
+			}
+			if j < len(s) && s[j] == '\n' {
+				c.flush()
+				i = j + 1
+				continue
+			}
+			c.text.WriteByte('\n')
+			i++
+		case strings.HasPrefix(s[i:], "$$"):
+			end := strings.Index(s[i+2:], "$$")
+			if end < 0 {
+				end = len(s) - i - 2
+			}
+			c.flush()
+			c.display(s[i+2 : i+2+end])
+			i = min(len(s), i+4+end)
+		case s[i] == '$':
+			// Inline math is converted with its paragraph; it is skipped here so nothing in it is
+			// taken for a block
+			end := inlineMathEnd(s, i+1)
+			c.text.WriteString(s[i:end])
+			i = end
+		case s[i] == '\\':
+			i = c.command(s, i)
+		default:
+			c.text.WriteByte(s[i])
+			i++
+		}
+	}
+}
+
+// command handles the command at s[i] that structures the text, and copies any other command
+// into the paragraph. It returns where the text after the command starts.
+func (c *converter) command(s string, i int) int {
+	name, end := commandName(s, i)
+	starred := end < len(s) && s[end] == '*'
+	switch name {
+	case "[":
+		close := strings.Index(s[end:], `\]`)
+		if close < 0 {
+			close = len(s) - end
+		}
+		c.flush()
+		c.display(s[end : end+close])
+		return min(len(s), end+close+2)
+	case "(":
+		close := strings.Index(s[end:], `\)`)
+		if close < 0 {
+			close = len(s) - end
+		}
+		next := min(len(s), end+close+2)
+		c.text.WriteString(s[i:next])
+		return next
+	case "chapter", "section", "subsection", "subsubsection":
+		if starred {
+			end++
+		}
+		_, end, _ = optional(s, end)
+		title, next := argument(s, end)
+		c.heading(name, starred, title)
+		return next
+	case "paragraph", "subparagraph":
+		if starred {
+			end++
+		}
+		_, end, _ = optional(s, end)
+		title, next := argument(s, end)
+		// Run-in headings start their paragraph
+		c.flush()
+		c.text.WriteString(title)
+		if !strings.HasSuffix(strings.TrimSpace(title), ".") {
+			c.text.WriteString(".")
+		}
+		c.text.WriteString(" ")
+		return next
+	case "appendix":
+		c.flush()
+		c.appendix = true
+		c.numbers = [3]int{}
+		return end
+	case "par":
+		c.flush()
+		return end
+	case "begin":
+		env, after := argument(s, end)
+		body, next := environment(s, after, strings.TrimSpace(env))
+		c.environment(strings.TrimSpace(env), body)
+		return next
+	}
+	c.text.WriteString(s[i:end])
+	return end
+}
+
+// heading starts a section. Subsections are sections of their own, numbered like LaTeX does.
+func (c *converter) heading(command string, starred bool, title string) {
+	c.flush()
+	level := 0
+	switch command {
+	case "subsection":
+		level = 1
+	case "subsubsection":
+		level = 2
+	}
+	heading := c.inline(title)
+	kind := docir.SectionBody
+	if c.appendix {
+		kind = docir.SectionAppendix
+	}
+	if starred {
+		if strings.EqualFold(heading, "abstract") {
+			kind = docir.SectionAbstract
+		}
+	} else {
+		c.numbers[level]++
+		for l := level + 1; l < len(c.numbers); l++ {
+			c.numbers[l] = 0
+		}
+		parts := make([]string, level+1)
+		for l := range parts {
+			parts[l] = strconv.Itoa(c.numbers[l])
+		}
+		if c.appendix {
+			parts[0] = string(rune('A' + c.numbers[0] - 1))
+		}
+		number := strings.Join(parts, ".")
+		heading = strings.TrimSpace(number + " " + heading)
+		c.target = target{kind: "Section", number: number}
+	}
+	c.doc.Sections = append(c.doc.Sections, docir.Section{Kind: kind, Heading: heading})
+	c.section = len(c.doc.Sections) - 1
+}
+
+func (c *converter) environment(env, body string) {
+	c.flush()
+	base := strings.TrimSuffix(env, "*")
+	_, isMath := mathEnvironments[base]
+	switch {
+	case env == "abstract":
+		c.doc.Sections = append(c.doc.Sections, docir.Section{Kind: docir.SectionAbstract, Heading: "Abstract"})
+		c.section = len(c.doc.Sections) - 1
+		c.blocks(body)
+		c.flush()
+		c.section = -1
+	case isMath:
+		c.equation(env, body)
+	case base == "figure" || base == "wrapfigure" || base == "SCfigure":
+		c.float(docir.Figure, body)
+	case base == "table" || base == "wraptable":
+		c.float(docir.Table, body)
+	case base == "itemize" || base == "enumerate" || base == "description":
+		c.list(base, body)
+	case base == "thebibliography":
+		c.bibliography(body)
+	case base == "tabular" || base == "tabularx" || base == "tabulary":
+		if rows := c.tableRows(env, body); len(rows) > 0 {
+			c.add(docir.Block{Kind: docir.Paragraph, Text: strings.Join(rows, "\n")})
+		}
+	case verbatimEnvironments[base]:
+		c.verbatim(base, body)
+	case droppedEnvironments[base]:
+	case c.theorems[base] != "":
+		c.theorem(base, body)
+	default:
+		_, i, _ := optional(body, 0)
+		for n := 0; n < environmentArgs[env]; n++ {
+			_, i = argument(body, i)
+		}
+		c.blocks(body[i:])
+		c.flush()
+	}
+}
+
+// display adds unnumbered display math, from \[ \] or $$ $$
+func (c *converter) display(math string) {
+	if latex := c.math(math); latex != "" {
+		c.add(docir.Block{Kind: docir.Equation, Text: latex})
+	}
+}
+
+// equation adds the equation of a math environment. Its rows are numbered as LaTeX numbers
+// them, and labels in a row refer to the row's number.
+func (c *converter) equation(env, body string) {
+	base := strings.TrimSuffix(env, "*")
+	numbered := env == base && base != "displaymath"
+	columns := ""
+	if base == "alignat" {
+		columns, body = argumentRest(body)
+	}
+	rows := []string{body}
+	if mathEnvironments[base] {
+		rows = splitTop(body, `\\`)
+	}
+
+	var numbers, kept []string
+	for k, row := range rows {
+		if k > 0 {
+			row = rowSpacing.ReplaceAllString(row, "")
+		}
+		if strings.TrimSpace(labelCommand.ReplaceAllString(row, "")) == "" {
+			continue
+		}
+		number := ""
+		if m := tagCommand.FindStringSubmatch(row); m != nil {
+			number = strings.TrimSpace(m[1])
+		} else if numbered && !noNumber.MatchString(row) {
+			c.equations++
+			number = strconv.Itoa(c.equations)
+		}
+		if number != "" {
+			numbers = append(numbers, number)
+			for _, m := range labelCommand.FindAllStringSubmatch(row, -1) {
+				c.labels[strings.TrimSpace(m[1])] = target{kind: "Equation", number: number}
+			}
+		}
+		kept = append(kept, c.math(tagCommand.ReplaceAllString(noNumber.ReplaceAllString(row, ""), "")))
+	}
+	if len(kept) == 0 {
+		return
+	}
+
+	latex := strings.Join(kept, ` \\ `)
+	switch wrapper := rowEnvironments[base]; {
+	case wrapper == "alignedat":
+		latex = `\begin{alignedat}{` + columns + `} ` + latex + ` \end{alignedat}`
+	case wrapper == "array":
+		latex = `\begin{array}{rcl} ` + latex + ` \end{array}`
+	case base == "multline" && !strings.Contains(latex, `\\`):
+	case wrapper != "":
+		latex = `\begin{` + wrapper + `} ` + latex + ` \end{` + wrapper + `}`
+	}
+	block := docir.Block{Kind: docir.Equation, Text: latex}
+	switch len(numbers) {
+	case 0:
+	case 1:
+		block.Label = "Equation " + numbers[0]
+	default:
+		block.Label = "Equations " + numbers[0] + "–" + numbers[len(numbers)-1]
+	}
+	c.add(block)
+}
+
+// math returns the LaTeX of an equation without its labels, on one line
+func (c *converter) math(s string) string {
+	return strings.Join(strings.Fields(labelCommand.ReplaceAllString(s, "")), " ")
+}
+
+// float adds the captions of a figure or table environment, and the rows of a table. Each
+// caption is numbered, and labels refer to the caption before them, or to the first one.
+func (c *converter) float(kind docir.BlockKind, body string) {
+	saved := c.target
+	defer func() { c.target = saved }()
+	c.target = target{}
+
+	// Subfigures are part of their figure: their captions are not numbered like figures
+	var pending []string
+	body, removed := removeEnvironments(body, "subfigure", "subtable")
+	for _, m := range labelCommand.FindAllStringSubmatch(removed, -1) {
+		pending = append(pending, strings.TrimSpace(m[1]))
+	}
+
+	var blocks []docir.Block
+	for _, loc := range captionOrLabel.FindAllStringSubmatchIndex(body, -1) {
+		arg, _ := argument(body, loc[1])
+		if body[loc[2]:loc[3]] == "label" {
+			pending = append(pending, strings.TrimSpace(arg))
+		} else if loc[5] == loc[4] {
+			name := "Figure"
+			number := 0
+			if kind == docir.Table {
+				c.tables++
+				name, number = "Table", c.tables
+			} else {
+				c.figures++
+				number = c.figures
+			}
+			c.target = target{kind: name, number: strconv.Itoa(number)}
+			blocks = append(blocks, docir.Block{Kind: kind, Label: name + " " + c.target.number, Text: c.inline(arg)})
+		}
+		if c.target.kind != "" {
+			for _, key := range pending {
+				c.labels[key] = c.target
+			}
+			pending = nil
+		}
+	}
+
+	if kind == docir.Table {
+		var rows []string
+		for pos := 0; ; {
+			loc := tabularBegin.FindStringSubmatchIndex(body[pos:])
+			if loc == nil {
+				break
+			}
+			env := body[pos+loc[2] : pos+loc[3]]
+			inner, next := environment(body, pos+loc[1], env)
+			rows = append(rows, c.tableRows(env, inner)...)
+			pos = next
+		}
+		if len(rows) > 0 {
+			blocks = append(blocks, docir.Block{Kind: docir.Paragraph, Text: strings.Join(rows, "\n")})
+		}
+	}
+	for _, block := range blocks {
+		c.add(block)
+	}
+}
+
+// tableRows returns the rows of a tabular environment, one line per row with its cells
+// separated by " | "
+func (c *converter) tableRows(env, body string) []string {
+	_, i, _ := optional(body, 0)
+	for n := 0; n < environmentArgs[env]; n++ {
+		_, i = argument(body, i)
+	}
+	var rows []string
+	for _, row := range splitTop(tableRule.ReplaceAllString(body[i:], ""), `\\`) {
+		cells := splitTop(rowSpacing.ReplaceAllString(row, ""), "&")
+		for k, cell := range cells {
+			cells[k] = c.inline(cell)
+		}
+		line := strings.Join(cells, " | ")
+		if strings.Trim(line, " |") != "" {
+			rows = append(rows, line)
+		}
+	}
+	return rows
+}
+
+// list adds a paragraph per item, marked the way the list marks it
+func (c *converter) list(env, body string) {
+	items := splitTop(body, `\item`)
+	for n, item := range items[1:] {
+		prefix := "- "
+		if env == "enumerate" {
+			prefix = strconv.Itoa(n+1) + ". "
+		}
+		label, i, ok := optional(item, 0)
+		if ok {
+			prefix = label + " "
+			if env == "description" {
+				prefix = label + ": "
+			}
+		}
+		c.text.WriteString(prefix)
+		c.blocks(item[i:])
+		c.flush()
+	}
+}
+
+// theorem adds a theorem-like environment, its paragraphs led by its name: "Theorem (Name). ..."
+func (c *converter) theorem(env, body string) {
+	name := c.theorems[env]
+	note, i, ok := optional(body, 0)
+	switch {
+	case ok && env == "proof":
+		name = note
+	case ok:
+		name += " (" + note + ")"
+	}
+	c.text.WriteString(name + ". ")
+	c.blocks(body[i:])
+	c.flush()
+}
+
+// verbatim adds code as it is written
+func (c *converter) verbatim(env, body string) {
+	i := 0
+	switch env {
+	case "lstlisting", "Verbatim":
+		_, i, _ = optional(body, 0)
+	case "minted":
+		_, i, _ = optional(body, 0)
+		_, i = argument(body, i)
+	}
+	if text := strings.Trim(body[i:], "\r\n"); strings.TrimSpace(text) != "" {
+		c.add(docir.Block{Kind: docir.Paragraph, Text: text})
+	}
+}
+
+// bibliography adds the entries of a thebibliography environment, which BibTeX writes into the
+// .bbl, as references numbered in order
+func (c *converter) bibliography(body string) {
+	_, start := argument(body, 0)
+	items := splitTop(body[start:], `\bibitem`)
+	for _, item := range items[1:] {
+		_, i, _ := optional(item, 0)
+		keys, rest := argument(item, i)
+		number := len(c.doc.References) + 1
+		for _, key := range strings.Split(keys, ",") {
+			c.citations[strings.TrimSpace(key)] = number
+		}
+		c.doc.References = append(c.doc.References, docir.Reference{
+			Label: "[" + strconv.Itoa(number) + "]",
+			Text:  c.inline(item[rest:]),
+		})
+	}
+}
+
+func (c *converter) flush() {
+	text := c.inline(c.text.String())
+	c.text.Reset()
+	if text != "" {
+		c.add(docir.Block{Kind: docir.Paragraph, Text: text})
+	}
+}
+
+func (c *converter) add(block docir.Block) {
+	if c.section < 0 {
+		kind := docir.SectionBody
+		if c.appendix {
+			kind = docir.SectionAppendix
+		}
+		c.doc.Sections = append(c.doc.Sections, docir.Section{Kind: kind})
+		c.section = len(c.doc.Sections) - 1
+	}
+	c.doc.Sections[c.section].Blocks = append(c.doc.Sections[c.section].Blocks, block)
+}
+
+// inline converts running text: inline math is delimited by \( and \), formatting is dropped,
+// and references and citations are left as placeholders that resolve replaces once all labels
+// are known. Whitespace is collapsed.
+func (c *converter) inline(s string) string {
+	var b strings.Builder
+	c.inlineTo(&b, s)
+	return norm.NFC.String(strings.Join(strings.Fields(b.String()), " "))
+}
+
+func (c *converter) inlineTo(b *strings.Builder, s string) {
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], "$$"):
+			end := strings.Index(s[i+2:], "$$")
+			if end < 0 {
+				end = len(s) - i - 2
+			}
+			b.WriteString(`\(` + c.math(s[i+2:i+2+end]) + `\)`)
+			i = min(len(s), i+4+end)
+		case s[i] == '$':
+			end := inlineMathEnd(s, i+1)
+			inner := s[i+1 : end]
+			if strings.HasSuffix(inner, "$") {
+				inner = inner[:len(inner)-1]
+			}
+			b.WriteString(`\(` + c.math(inner) + `\)`)
+			i = end
+		case strings.HasPrefix(s[i:], `\(`), strings.HasPrefix(s[i:], `\[`):
+			closing := `\)`
+			if s[i+1] == '[' {
+				closing = `\]`
+			}
+			end := strings.Index(s[i+2:], closing)
+			if end < 0 {
+				end = len(s) - i - 2
+			}
+			b.WriteString(`\(` + c.math(s[i+2:i+2+end]) + `\)`)
+			i = min(len(s), i+4+end)
+		case s[i] == '\\':
+			i = c.inlineCommand(b, s, i)
+		case s[i] == '{' || s[i] == '}':
+			i++
+		case s[i] == '~':
+			b.WriteByte(' ')
+			i++
+		case strings.HasPrefix(s[i:], "---"):
+			b.WriteString("—")
+			i += 3
+		case strings.HasPrefix(s[i:], "--"):
+			b.WriteString("–")
+			i += 2
+		case strings.HasPrefix(s[i:], "``"):
+			b.WriteString("“")
+			i += 2
+		case strings.HasPrefix(s[i:], "''"):
+			b.WriteString("”")
+			i += 2
+		case s[i] == '`':
+			b.WriteString("‘")
+			i++
+		default:
+			b.WriteByte(s[i])
+			i++
+		}
+	}
+}
+
+// inlineCommand writes the text of the command at s[i] and returns where the text after it
+// starts. Unknown commands are dropped and their arguments kept as text.
+func (c *converter) inlineCommand(b *strings.Builder, s string, i int) int {
+	name, end := commandName(s, i)
+	if mark, ok := accents[name]; ok && (len(name) == 1 && !isLetter(name[0]) || end < len(s) && (s[end] == '{' || s[end] == ' ')) {
+		arg, next := argument(s, end)
+		base := c.inline(strings.NewReplacer(`\i`, "i", `\j`, "j").Replace(arg))
+		if base != "" {
+			_, size := utf8.DecodeRuneInString(base)
+			b.WriteString(base[:size] + mark + base[size:])
+		}
+		return next
+	}
+	if end < len(s) && s[end] == '*' && isLetter(name[0]) {
+		end++
+	}
+
+	switch {
+	case name == "":
+		return end
+	case name == `\`:
+		_, end, _ = optional(s, end)
+		b.WriteByte(' ')
+		return end
+	case name == " " || name == "," || name == ";" || name == ":":
+		b.WriteByte(' ')
+		return end
+	case name == "-" || name == "/" || name == "!" || name == "@":
+		return end
+	case !isLetter(name[0]):
+		// \& \% \$ \# \_ \{ \}
+		b.WriteString(name)
+		return end
+	case name == "ref" || name == "eqref" || name == "autoref" || name == "cref" || name == "Cref" || name == "nameref" || name == "vref":
+		keys, next := argument(s, end)
+		kind := "ref"
+		switch name {
+		case "eqref":
+			kind = "eqref"
+		case "autoref", "cref", "Cref":
+			kind = "auto"
+		}
+		b.WriteString("\x00" + kind + "|" + keys + "\x00")
+		return next
+	case citeCommand.MatchString(name):
+		_, end, _ = optional(s, end)
+		_, end, _ = optional(s, end)
+		keys, next := argument(s, end)
+		b.WriteString("\x00cite|" + keys + "\x00")
+		return next
+	case name == "label":
+		key, next := argument(s, end)
+		if c.target.kind != "" {
+			c.labels[strings.TrimSpace(key)] = c.target
+		}
+		return next
+	case name == "footnote":
+		_, end, _ = optional(s, end)
+		arg, next := argument(s, end)
+		b.WriteString(" (")
+		c.inlineTo(b, arg)
+		b.WriteString(")")
+		return next
+	case name == "url" || name == "nolinkurl":
+		arg, next := argument(s, end)
+		b.WriteString(arg)
+		return next
+	case name == "href":
+		url, next := argument(s, end)
+		text, next := argument(s, next)
+		c.inlineTo(b, text)
+		b.WriteString(" (" + url + ")")
+		return next
+	case name == "verb":
+		if end >= len(s) {
+			return end
+		}
+		close := strings.IndexByte(s[end+1:], s[end])
+		if close < 0 {
+			close = len(s) - end - 1
+		}
+		b.WriteString(s[end+1 : end+1+close])
+		return min(len(s), end+2+close)
+	case name == "newcommand" || name == "renewcommand" || name == "providecommand" || name == "DeclareMathOperator":
+		_, next := argument(s, end)
+		for ok := true; ok; {
+			_, next, ok = optional(s, next)
+		}
+		_, next = argument(s, next)
+		return next
+	case name == "def":
+		_, next := commandName(s, skipSpace(s, end))
+		if open := strings.IndexByte(s[next:], '{'); open >= 0 {
+			_, next, _ = group(s, next+open)
+		}
+		return next
+	}
+	if symbol, ok := symbols[name]; ok {
+		b.WriteString(symbol)
+		return end
+	}
+	for n := 0; n < droppedArgs[name]; n++ {
+		_, end, _ = optional(s, end)
+		_, end = argument(s, end)
+	}
+	return end
+}
+
+// resolve replaces the placeholders of references and citations: \ref by the number of its
+// label, \eqref by the number in parentheses, \autoref and \cref by the kind and number, and
+// citations by the numbers of the references in brackets
+func (c *converter) resolve(text string) string {
+	return placeholder.ReplaceAllStringFunc(text, func(m string) string {
+		parts := placeholder.FindStringSubmatch(m)
+		var resolved []string
+		for _, key := range strings.Split(parts[2], ",") {
+			key = strings.TrimSpace(key)
+			if key == "" {
+				continue
+			}
+			if parts[1] == "cite" {
+				if number, ok := c.citations[key]; ok {
+					resolved = append(resolved, strconv.Itoa(number))
+				} else {
+					resolved = append(resolved, "?")
+				}
+				continue
+			}
+			t, ok := c.labels[key]
+			switch {
+			case !ok:
+				resolved = append(resolved, "??")
+			case parts[1] == "eqref":
+				resolved = append(resolved, "("+t.number+")")
+			case parts[1] == "auto":
+				resolved = append(resolved, t.kind+" "+t.number)
+			default:
+				resolved = append(resolved, t.number)
+			}
+		}
+		if parts[1] == "cite" {
+			return "[" + strings.Join(resolved, ", ") + "]"
+		}
+		return strings.Join(resolved, ", ")
+	})
+}
+
+// commandName returns the name of the command at s[i], letters or a single other character,
+// and the index after it
+func commandName(s string, i int) (string, int) {
+	j := i + 1
+	if j >= len(s) {
+		return "", j
+	}
+	if !isLetter(s[j]) {
+		_, size := utf8.DecodeRuneInString(s[j:])
+		return s[j : j+size], j + size
+	}
+	for j < len(s) && isLetter(s[j]) {
+		j++
+	}
+	return s[i+1 : j], j
+}
+
+func isLetter(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z'
+}
+
+func skipSpace(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\r' || s[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// group returns the content of the braced group at s[i] and the index after it
+func group(s string, i int) (string, int, bool) {
+	if i >= len(s) || s[i] != '{' {
+		return "", i, false
+	}
+	depth := 0
+	for j := i; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return s[i+1 : j], j + 1, true
+			}
+		}
+	}
+	return s[i+1:], len(s), true
+}
+
+// argument returns the argument of a command starting at s[i]: a braced group, a command or a
+// single character
+func argument(s string, i int) (string, int) {
+	j := skipSpace(s, i)
+	if j >= len(s) {
+		return "", j
+	}
+	switch s[j] {
+	case '{':
+		content, end, _ := group(s, j)
+		return content, end
+	case '\\':
+		_, end := commandName(s, j)
+		return s[j:end], end
+	}
+	_, size := utf8.DecodeRuneInString(s[j:])
+	return s[j : j+size], j + size
+}
+
+// argumentRest returns the first argument of s and the text after it
+func argumentRest(s string) (string, string) {
+	arg, end := argument(s, 0)
+	return arg, s[end:]
+}
+
+// optional returns the optional argument in brackets starting at s[i], if there is one, and
+// the index after it
+func optional(s string, i int) (string, int, bool) {
+	j := skipSpace(s, i)
+	if j >= len(s) || s[j] != '[' {
+		return "", i, false
+	}
+	depth := 0
+	for k := j; k < len(s); k++ {
+		switch s[k] {
+		case '\\':
+			k++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ']':
+			if depth == 0 {
+				return s[j+1 : k], k + 1, true
+			}
+		}
+	}
+	return "", i, false
+}
+
+// inlineMathEnd returns the index after the $ that closes inline math starting at s[i]
+func inlineMathEnd(s string, i int) int {
+	for j := i; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '$':
+			return j + 1
+		}
+	}
+	return len(s)
+}
+
+// environment returns the body of the environment whose \begin{name} ends at s[i], and the
+// index after its \end{name}. Environments of the same name nest.
+func environment(s string, i int, name string) (string, int) {
+	begin, end := `\begin{`+name+`}`, `\end{`+name+`}`
+	depth := 1
+	for j := i; j < len(s); {
+		nextEnd := strings.Index(s[j:], end)
+		if nextEnd < 0 {
+			break
+		}
+		if nextBegin := strings.Index(s[j:], begin); nextBegin >= 0 && nextBegin < nextEnd {
+			depth++
+			j += nextBegin + len(begin)
+			continue
+		}
+		depth--
+		if depth == 0 {
+			return s[i : j+nextEnd], j + nextEnd + len(end)
+		}
+		j += nextEnd + len(end)
+	}
+	return s[i:], len(s)
+}
+
+// removeEnvironments cuts the environments of the given names out of s, returning what is left
+// and what was cut
+func removeEnvironments(s string, names ...string) (string, string) {
+	var removed strings.Builder
+	for _, name := range names {
+		begin := `\begin{` + name + `}`
+		for {
+			start := strings.Index(s, begin)
+			if start < 0 {
+				break
+			}
+			body, next := environment(s, start+len(begin), name)
+			removed.WriteString(body)
+			s = s[:start] + s[next:]
+		}
+	}
+	return s, removed.String()
+}
+
+// splitTop splits LaTeX at a separator outside of groups and nested environments, such as the
+// `\\` between rows, the & between cells or the \item between items
+func splitTop(s, sep string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case depth == 0 && strings.HasPrefix(s[i:], sep) &&
+			!(isLetter(sep[len(sep)-1]) && i+len(sep) < len(s) && isLetter(s[i+len(sep)])):
+			parts = append(parts, s[start:i])
+			i += len(sep) - 1
+			start = i + 1
+		case strings.HasPrefix(s[i:], `\begin{`):
+			depth++
+			i += len(`\begin`) - 1
+		case strings.HasPrefix(s[i:], `\end{`):
+			depth--
+			i += len(`\end`) - 1
+		case s[i] == '\\':
+			i++
+		case s[i] == '{':
+			depth++
+		case s[i] == '}':
+			depth--
+		}
+	}
+	return append(parts, s[start:])
+}
@@ -0,0 +1,188 @@
+// Package latexsrc reads the LaTeX sources arXiv keeps of most papers and converts them into
+// structured documents. Unlike the text extracted from a PDF, the source has the equations as
+// LaTeX, so they survive the conversion intact.
+package latexsrc
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"nexus_scholar_go_backend/internal/utils/docir"
+)
+
+// ErrNoSource is returned for downloads without a LaTeX paper, such as PDF-only submissions
+var ErrNoSource = errors.New("no LaTeX source")
+
+const (
+	// maxSourceSize bounds the uncompressed size of a download, figures included
+	maxSourceSize = 100 << 20
+	// maxInputDepth bounds how deeply \input files are followed
+	maxInputDepth = 10
+)
+
+var (
+	inputCommand        = regexp.MustCompile(`\\(?:input|include)\s*\{([^}]+)\}`)
+	bibliographyCommand = regexp.MustCompile(`\\bibliography\s*\{[^}]*\}`)
+)
+
+// Convert reads an arXiv source download and converts its main LaTeX file, with the files it
+// inputs, into a document. Sources without any text are reported as ErrNoSource.
+func Convert(index int, title string, download []byte) (docir.Document, error) {
+	files, err := Files(download)
+	if err != nil {
+		return docir.Document{}, err
+	}
+	main, err := Main(files)
+	if err != nil {
+		return docir.Document{}, err
+	}
+	d := ToDocument(index, title, Expand(files, main))
+	for _, section := range d.Sections {
+		if len(section.Blocks) > 0 {
+			return d, nil
+		}
+	}
+	return docir.Document{}, fmt.Errorf("%w: %s has no text", ErrNoSource, main)
+}
+
+// Files reads the .tex and .bbl files of an arXiv source download: a gzipped tar of the
+// submission's files, or a single gzipped .tex file
+func Files(download []byte) (map[string]string, error) {
+	data := download
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		defer gz.Close()
+		if data, err = io.ReadAll(io.LimitReader(gz, maxSourceSize+1)); err != nil {
+			return nil, fmt.Errorf("failed to decompress source: %w", err)
+		}
+		if len(data) > maxSourceSize {
+			return nil, fmt.Errorf("source is larger than %d bytes", maxSourceSize)
+		}
+	}
+
+	if len(data) > 262 && string(data[257:262]) == "ustar" {
+		files := make(map[string]string)
+		tr := tar.NewReader(bytes.NewReader(data))
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read source archive: %w", err)
+			}
+			name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+			if header.Typeflag != tar.TypeReg || (path.Ext(name) != ".tex" && path.Ext(name) != ".bbl") {
+				continue
+			}
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", name, err)
+			}
+			files[name] = string(content)
+		}
+		return files, nil
+	}
+
+	if bytes.Contains(data, []byte(`\documentclass`)) || bytes.Contains(data, []byte(`\begin{document}`)) {
+		return map[string]string{"main.tex": string(data)}, nil
+	}
+	return nil, ErrNoSource
+}
+
+// Main returns the name of the file that is compiled, the one with \documentclass and
+// \begin{document}. The first in name order wins when several files qualify.
+func Main(files map[string]string) (string, error) {
+	var candidates []string
+	for name, content := range files {
+		content = stripComments(content)
+		if path.Ext(name) == ".tex" && strings.Contains(content, `\documentclass`) && strings.Contains(content, `\begin{document}`) {
+			candidates = append(candidates, name)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("%w: no file has \\documentclass and \\begin{document}", ErrNoSource)
+	}
+	sort.Strings(candidates)
+	return candidates[0], nil
+}
+
+// Expand returns the main file with the files it \input or \include inlined, and the .bbl
+// BibTeX wrote in place of \bibliography. Comments are stripped.
+func Expand(files map[string]string, main string) string {
+	source := expand(files, path.Dir(main), main, 0)
+	// BibTeX is not run; arXiv keeps the reference list it formatted in the .bbl
+	bbl := files[strings.TrimSuffix(main, ".tex")+".bbl"]
+	if bbl == "" {
+		for name, content := range files {
+			if path.Ext(name) == ".bbl" {
+				bbl = content
+				break
+			}
+		}
+	}
+	return bibliographyCommand.ReplaceAllLiteralString(source, stripComments(bbl))
+}
+
+// expand inlines the inputs of a file. Paths are relative to the directory of the main file,
+// where LaTeX runs.
+func expand(files map[string]string, dir, name string, depth int) string {
+	content := stripComments(files[name])
+	if depth >= maxInputDepth {
+		return content
+	}
+	return inputCommand.ReplaceAllStringFunc(content, func(command string) string {
+		target := strings.TrimSpace(inputCommand.FindStringSubmatch(command)[1])
+		for _, candidate := range []string{target, target + ".tex"} {
+			candidate = path.Clean(path.Join(dir, candidate))
+			if _, ok := files[candidate]; ok {
+				return expand(files, dir, candidate, depth+1)
+			}
+		}
+		return ""
+	})
+}
+
+// stripComments removes % comments. Lines that are only a comment go entirely, so they do not
+// end a paragraph the way blank lines do.
+func stripComments(source string) string {
+	lines := strings.Split(source, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		cut := commentStart(line)
+		if cut < 0 {
+			kept = append(kept, line)
+			continue
+		}
+		if strings.TrimSpace(line[:cut]) == "" && strings.TrimSpace(line) != "" {
+			continue
+		}
+		kept = append(kept, line[:cut])
+	}
+	return strings.Join(kept, "\n")
+}
+
+// commentStart returns where the comment of a line starts, -1 if it has none. \% is a
+// percent sign.
+func commentStart(line string) int {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '%':
+			return i
+		}
+	}
+	return -1
+}
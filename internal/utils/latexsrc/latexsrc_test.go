@@ -0,0 +1,194 @@
+package latexsrc
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+
+	"nexus_scholar_go_backend/internal/utils/docir"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipped(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func tarball(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func TestFiles(t *testing.T) {
+	single := "\\documentclass{article}\n\\begin{document}\nHi.\n\\end{document}\n"
+	files, err := Files(gzipped(t, []byte(single)))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"main.tex": single}, files)
+
+	files, err = Files(gzipped(t, tarball(t, map[string]string{
+		"./paper.tex":    single,
+		"sections/a.tex": "A",
+		"paper.bbl":      "B",
+		"figure.png":     "\x89PNG",
+	})))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"paper.tex": single, "sections/a.tex": "A", "paper.bbl": "B"}, files)
+
+	_, err = Files(gzipped(t, []byte("%PDF-1.5")))
+	assert.True(t, errors.Is(err, ErrNoSource))
+}
+
+func TestMainAndExpand(t *testing.T) {
+	files := map[string]string{
+		"paper/main.tex": "\\documentclass{article}\n% \\input{unused}\n\\begin{document}\n\\input{sections/intro}\n" +
+			"\\include{sections/method.tex}\n\\bibliography{refs}\n\\end{document}\n",
+		"paper/sections/intro.tex":  "Intro, 50\\% done. % a comment\n",
+		"paper/sections/method.tex": "Method.\n",
+		"paper/main.bbl":            "\\begin{thebibliography}{1}\n\\bibitem{a} A.\n\\end{thebibliography}\n",
+		// Mentions \documentclass only in a comment
+		"paper/aaa.tex": "% \\documentclass{article}\n\\begin{document}\n",
+	}
+	main, err := Main(files)
+	require.NoError(t, err)
+	assert.Equal(t, "paper/main.tex", main)
+
+	assert.Equal(t, "\\documentclass{article}\n\\begin{document}\nIntro, 50\\% done. \n\nMethod.\n\n"+
+		"\\begin{thebibliography}{1}\n\\bibitem{a} A.\n\\end{thebibliography}\n\n\\end{document}\n", Expand(files, main))
+
+	_, err = Main(map[string]string{"notes.tex": "Just notes."})
+	assert.True(t, errors.Is(err, ErrNoSource))
+}
+
+const paper = `\documentclass{article}
+\newtheorem{thm}{Theorem}
+\title{Sparse \emph{Attention}}
+\begin{document}
+\maketitle
+\begin{abstract}
+We make attention sparse~\cite{vaswani}.
+\end{abstract}
+
+\section{Introduction}\label{sec:intro}
+Transformers use softmax $\sigma(x) = e^x / \sum_j e^{x_j}$ and the loss
+\begin{equation}\label{eq:loss}
+L = \sum_i (y_i - \hat{y}_i)^2
+\end{equation}
+which Eq.~\eqref{eq:loss} and \autoref{fig:arch} illustrate, as in Section~\ref{sec:intro}.
+
+\begin{figure}[t]
+\centering
+\includegraphics[width=\linewidth]{arch.pdf}
+\caption{The architecture of the model.}
+\label{fig:arch}
+\end{figure}
+
+\subsection{Layers}
+We stack ` + "``layers''" + ` --- see Table~\ref{tab:results} and Caf\'e~\cite{vaswani, devlin}:
+\begin{align}
+a &= b + c \\
+d &= e \nonumber \\
+f &= g \label{eq:last}
+\end{align}
+\[ E = mc^2 \]
+\begin{itemize}
+\item Fast\footnote{On GPUs.}.
+\item Small.
+\end{itemize}
+\begin{thm}[Sparsity]
+Attention is sparse, see (\ref{eq:last}).
+\end{thm}
+
+\begin{table}
+\caption{Results.}\label{tab:results}
+\begin{tabular}{lr}
+\toprule
+Model & Accuracy \\
+\midrule
+Ours & \textbf{91.2} \\
+\bottomrule
+\end{tabular}
+\end{table}
+
+\section*{References}
+\begin{thebibliography}{2}
+\bibitem{vaswani} A.~Vaswani et al. \newblock Attention is all you need. \newblock 2017.
+\bibitem[Devlin et~al.(2019)]{devlin} J.~Devlin. \newblock {BERT}.
+\end{thebibliography}
+
+\appendix
+\section{Proofs}
+\begin{proof}
+Trivial.
+\end{proof}
+\end{document}
+`
+
+func TestToDocument(t *testing.T) {
+	d := ToDocument(2, "", paper)
+	require.NoError(t, d.Validate())
+	assert.Equal(t, 2, d.Index)
+	assert.Equal(t, "Sparse Attention", d.Title)
+
+	require.Len(t, d.Sections, 4)
+	assert.Equal(t, docir.Section{Kind: docir.SectionAbstract, Heading: "Abstract", Blocks: []docir.Block{
+		{Kind: docir.Paragraph, Text: "We make attention sparse [1]."},
+	}}, d.Sections[0])
+
+	assert.Equal(t, docir.Section{Kind: docir.SectionBody, Heading: "1 Introduction", Blocks: []docir.Block{
+		{Kind: docir.Paragraph, Text: `Transformers use softmax \(\sigma(x) = e^x / \sum_j e^{x_j}\) and the loss`},
+		{Kind: docir.Equation, Label: "Equation 1", Text: `L = \sum_i (y_i - \hat{y}_i)^2`},
+		{Kind: docir.Paragraph, Text: "which Eq. (1) and Figure 1 illustrate, as in Section 1."},
+		{Kind: docir.Figure, Label: "Figure 1", Text: "The architecture of the model."},
+	}}, d.Sections[1])
+
+	assert.Equal(t, docir.Section{Kind: docir.SectionBody, Heading: "1.1 Layers", Blocks: []docir.Block{
+		{Kind: docir.Paragraph, Text: "We stack “layers” — see Table 1 and Café [1, 2]:"},
+		{Kind: docir.Equation, Label: "Equations 2–3", Text: `\begin{aligned} a &= b + c \\ d &= e \\ f &= g \end{aligned}`},
+		{Kind: docir.Equation, Text: "E = mc^2"},
+		{Kind: docir.Paragraph, Text: "- Fast (On GPUs.)."},
+		{Kind: docir.Paragraph, Text: "- Small."},
+		{Kind: docir.Paragraph, Text: "Theorem (Sparsity). Attention is sparse, see (3)."},
+		{Kind: docir.Table, Label: "Table 1", Text: "Results."},
+		{Kind: docir.Paragraph, Text: "Model | Accuracy\nOurs | 91.2"},
+	}}, d.Sections[2])
+
+	assert.Equal(t, docir.Section{Kind: docir.SectionAppendix, Heading: "A Proofs", Blocks: []docir.Block{
+		{Kind: docir.Paragraph, Text: "Proof. Trivial."},
+	}}, d.Sections[3])
+
+	assert.Equal(t, []docir.Reference{
+		{Label: "[1]", Text: "A. Vaswani et al. Attention is all you need. 2017."},
+		{Label: "[2]", Text: "J. Devlin. BERT."},
+	}, d.References)
+}
+
+func TestConvert(t *testing.T) {
+	download := gzipped(t, tarball(t, map[string]string{
+		"main.tex": "\\documentclass{article}\n\\begin{document}\n\\input{body}\n\\end{document}\n",
+		"body.tex": "Some text with $x^2$.\n",
+	}))
+	d, err := Convert(1, "Title", download)
+	require.NoError(t, err)
+	assert.Equal(t, "Title", d.Title)
+	assert.Equal(t, []docir.Section{{Kind: docir.SectionBody, Blocks: []docir.Block{
+		{Kind: docir.Paragraph, Text: `Some text with \(x^2\).`},
+	}}}, d.Sections)
+
+	_, err = Convert(1, "Title", gzipped(t, []byte("\\documentclass{article}\n\\begin{document}\n\\end{document}\n")))
+	assert.True(t, errors.Is(err, ErrNoSource))
+}
@@ -0,0 +1,337 @@
+// Package markdown parses the subset of Markdown that model answers use (headings,
+// paragraphs, lists, block quotes, fenced code, tables, rules and emphasis) into blocks
+// that the transcript exporters render to HTML and PDF.
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type BlockKind int
+
+const (
+	Paragraph BlockKind = iota
+	Heading
+	ListItem
+	Quote
+	CodeBlock
+	Table
+	Rule
+)
+
+// Span is a run of inline text with uniform styling
+type Span struct {
+	Text   string
+	Bold   bool
+	Italic bool
+	Code   bool
+}
+
+type Block struct {
+	Kind    BlockKind
+	Level   int        // heading level 1-6, or list nesting depth starting at 0
+	Ordered bool       // numbered list item
+	Number  int        // the item's number in an ordered list
+	Spans   []Span     // text of paragraphs, headings, list items and quotes
+	Code    string     // content of code blocks
+	Rows    [][][]Span // table cells, header row first
+}
+
+var (
+	headingPattern = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	bulletPattern  = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	orderedPattern = regexp.MustCompile(`^(\s*)(\d{1,9})[.)]\s+(.*)$`)
+	rulePattern    = regexp.MustCompile(`^\s{0,3}(-(\s*-){2,}|\*(\s*\*){2,}|_(\s*_){2,})\s*$`)
+	tableSeparator = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+	fencePattern   = regexp.MustCompile("^\\s*(```|~~~)")
+)
+
+// listIndentWidth is the number of spaces that nest a list item one level deeper
+const listIndentWidth = 2
+
+// Parse splits Markdown source into blocks. Anything it does not recognise is kept as
+// paragraph text, so no content is ever dropped.
+func Parse(src string) []Block {
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	var blocks []Block
+	var paragraph []string
+
+	flush := func() {
+		if len(paragraph) > 0 {
+			blocks = append(blocks, Block{Kind: Paragraph, Spans: ParseInline(strings.Join(paragraph, " "))})
+			paragraph = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			flush()
+
+		case fencePattern.MatchString(line):
+			flush()
+			fence := fencePattern.FindStringSubmatch(line)[1]
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				code = append(code, lines[i])
+			}
+			blocks = append(blocks, Block{Kind: CodeBlock, Code: strings.Join(code, "\n")})
+
+		case headingPattern.MatchString(trimmed):
+			flush()
+			m := headingPattern.FindStringSubmatch(trimmed)
+			blocks = append(blocks, Block{Kind: Heading, Level: len(m[1]), Spans: ParseInline(m[2])})
+
+		case rulePattern.MatchString(line):
+			flush()
+			blocks = append(blocks, Block{Kind: Rule})
+
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				quote = append(quote, strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")))
+			}
+			i--
+			blocks = append(blocks, Block{Kind: Quote, Spans: ParseInline(strings.Join(quote, " "))})
+
+		case bulletPattern.MatchString(line):
+			flush()
+			m := bulletPattern.FindStringSubmatch(line)
+			blocks = append(blocks, Block{Kind: ListItem, Level: indentLevel(m[1]), Spans: ParseInline(m[2])})
+
+		case orderedPattern.MatchString(line):
+			flush()
+			m := orderedPattern.FindStringSubmatch(line)
+			number, _ := strconv.Atoi(m[2])
+			blocks = append(blocks, Block{Kind: ListItem, Level: indentLevel(m[1]), Ordered: true, Number: number, Spans: ParseInline(m[3])})
+
+		case strings.Contains(trimmed, "|") && i+1 < len(lines) && tableSeparator.MatchString(lines[i+1]) && strings.Contains(lines[i+1], "|"):
+			flush()
+			rows := [][][]Span{tableCells(trimmed)}
+			for i += 2; i < len(lines) && strings.Contains(lines[i], "|") && strings.TrimSpace(lines[i]) != ""; i++ {
+				rows = append(rows, tableCells(strings.TrimSpace(lines[i])))
+			}
+			i--
+			blocks = append(blocks, Block{Kind: Table, Rows: rows})
+
+		case len(blocks) > 0 && len(paragraph) == 0 && blocks[len(blocks)-1].Kind == ListItem && strings.HasPrefix(line, " "):
+			// Continuation line of a list item
+			last := &blocks[len(blocks)-1]
+			last.Spans = append(last.Spans, Span{Text: " "})
+			last.Spans = append(last.Spans, ParseInline(trimmed)...)
+
+		default:
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	flush()
+	return blocks
+}
+
+func indentLevel(indent string) int {
+	width := len(strings.ReplaceAll(indent, "\t", "    "))
+	return width / listIndentWidth
+}
+
+func tableCells(row string) [][]Span {
+	row = strings.TrimSuffix(strings.TrimPrefix(row, "|"), "|")
+	cells := strings.Split(row, "|")
+	result := make([][]Span, len(cells))
+	for i, cell := range cells {
+		result[i] = ParseInline(strings.TrimSpace(cell))
+	}
+	return result
+}
+
+// ParseInline splits text into spans on **bold**, *italic*, _italic_ and `code` markers.
+// Links are reduced to their text followed by the URL in parentheses.
+func ParseInline(text string) []Span {
+	var spans []Span
+	var current strings.Builder
+	var bold, italic bool
+
+	emit := func() {
+		if current.Len() > 0 {
+			spans = append(spans, Span{Text: current.String(), Bold: bold, Italic: italic})
+			current.Reset()
+		}
+	}
+
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\\' && i+1 < len(text) && strings.ContainsRune("\\`*_[]()#+-.!|", rune(text[i+1])):
+			current.WriteByte(text[i+1])
+			i++
+
+		case text[i] == '`':
+			end := strings.IndexByte(text[i+1:], '`')
+			if end < 0 {
+				current.WriteByte('`')
+				continue
+			}
+			emit()
+			spans = append(spans, Span{Text: text[i+1 : i+1+end], Code: true, Bold: bold, Italic: italic})
+			i += end + 1
+
+		case strings.HasPrefix(text[i:], "**") || strings.HasPrefix(text[i:], "__"):
+			marker := text[i : i+2]
+			if !bold && !strings.Contains(text[i+2:], marker) {
+				current.WriteString(marker)
+				i++
+				continue
+			}
+			emit()
+			bold = !bold
+			i++
+
+		case (text[i] == '*' || text[i] == '_') && isEmphasisMarker(text, i, italic):
+			emit()
+			italic = !italic
+
+		case text[i] == '[':
+			label, url, width := parseLink(text[i:])
+			if width == 0 {
+				current.WriteByte('[')
+				continue
+			}
+			current.WriteString(label + " (" + url + ")")
+			i += width - 1
+
+		default:
+			current.WriteByte(text[i])
+		}
+	}
+	emit()
+	return spans
+}
+
+// isEmphasisMarker tells a single * or _ that opens or closes italics from a literal one,
+// such as in 2 * 3 or snake_case
+func isEmphasisMarker(text string, i int, open bool) bool {
+	marker := text[i]
+	if open {
+		return i > 0 && text[i-1] != ' '
+	}
+	if i+1 >= len(text) || text[i+1] == ' ' {
+		return false
+	}
+	if marker == '_' && i > 0 && isWordChar(text[i-1]) {
+		return false
+	}
+	return strings.IndexByte(text[i+1:], marker) >= 0
+}
+
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func parseLink(text string) (label, url string, width int) {
+	closeLabel := strings.Index(text, "](")
+	if closeLabel < 0 || strings.IndexByte(text[1:closeLabel], ']') >= 0 {
+		return "", "", 0
+	}
+	closeURL := strings.IndexByte(text[closeLabel:], ')')
+	if closeURL < 0 {
+		return "", "", 0
+	}
+	return text[1:closeLabel], text[closeLabel+2 : closeLabel+closeURL], closeLabel + closeURL + 1
+}
+
+// PlainText joins the text of spans without styling
+func PlainText(spans []Span) string {
+	var b strings.Builder
+	for _, span := range spans {
+		b.WriteString(span.Text)
+	}
+	return b.String()
+}
+
+// RenderHTML renders blocks as an HTML fragment. All text is escaped.
+func RenderHTML(blocks []Block) string {
+	var b strings.Builder
+	var openLists []bool // ordered flag of each open list, innermost last
+
+	closeLists := func(depth int) {
+		for len(openLists) > depth {
+			if openLists[len(openLists)-1] {
+				b.WriteString("</ol>\n")
+			} else {
+				b.WriteString("</ul>\n")
+			}
+			openLists = openLists[:len(openLists)-1]
+		}
+	}
+
+	for _, block := range blocks {
+		if block.Kind != ListItem {
+			closeLists(0)
+		}
+		switch block.Kind {
+		case Heading:
+			b.WriteString("<h" + strconv.Itoa(block.Level) + ">" + renderSpansHTML(block.Spans) + "</h" + strconv.Itoa(block.Level) + ">\n")
+		case Paragraph:
+			b.WriteString("<p>" + renderSpansHTML(block.Spans) + "</p>\n")
+		case Quote:
+			b.WriteString("<blockquote>" + renderSpansHTML(block.Spans) + "</blockquote>\n")
+		case CodeBlock:
+			b.WriteString("<pre><code>" + html.EscapeString(block.Code) + "</code></pre>\n")
+		case Rule:
+			b.WriteString("<hr>\n")
+		case ListItem:
+			closeLists(block.Level + 1)
+			if len(openLists) == block.Level+1 && openLists[block.Level] != block.Ordered {
+				closeLists(block.Level)
+			}
+			for len(openLists) < block.Level+1 {
+				if block.Ordered {
+					b.WriteString("<ol>\n")
+				} else {
+					b.WriteString("<ul>\n")
+				}
+				openLists = append(openLists, block.Ordered)
+			}
+			b.WriteString("<li>" + renderSpansHTML(block.Spans) + "</li>\n")
+		case Table:
+			b.WriteString("<table>\n")
+			for i, row := range block.Rows {
+				cellTag := "td"
+				if i == 0 {
+					cellTag = "th"
+				}
+				b.WriteString("<tr>")
+				for _, cell := range row {
+					b.WriteString("<" + cellTag + ">" + renderSpansHTML(cell) + "</" + cellTag + ">")
+				}
+				b.WriteString("</tr>\n")
+			}
+			b.WriteString("</table>\n")
+		}
+	}
+	closeLists(0)
+	return b.String()
+}
+
+func renderSpansHTML(spans []Span) string {
+	var b strings.Builder
+	for _, span := range spans {
+		text := html.EscapeString(span.Text)
+		if span.Code {
+			text = "<code>" + text + "</code>"
+		}
+		if span.Italic {
+			text = "<em>" + text + "</em>"
+		}
+		if span.Bold {
+			text = "<strong>" + text + "</strong>"
+		}
+		b.WriteString(text)
+	}
+	return b.String()
+}
@@ -0,0 +1,78 @@
+package markdown
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	src := "## Findings\n\n" +
+		"Both papers use **transformers** on\nthe same data.\n\n" +
+		"- first point\n" +
+		"  continued here\n" +
+		"  1. nested step\n" +
+		"> quoted *claim*\n\n" +
+		"```go\nfmt.Println(\"**not bold**\")\n```\n" +
+		"| Paper | Score |\n|---|---:|\n| A | 0.9 |\n| B | 0.8 |\n\n" +
+		"---\n"
+
+	blocks := Parse(src)
+	require.Len(t, blocks, 8)
+
+	assert.Equal(t, Heading, blocks[0].Kind)
+	assert.Equal(t, 2, blocks[0].Level)
+	assert.Equal(t, "Findings", PlainText(blocks[0].Spans))
+
+	assert.Equal(t, Paragraph, blocks[1].Kind)
+	assert.Equal(t, []Span{
+		{Text: "Both papers use "},
+		{Text: "transformers", Bold: true},
+		{Text: " on the same data."},
+	}, blocks[1].Spans)
+
+	assert.Equal(t, ListItem, blocks[2].Kind)
+	assert.Equal(t, "first point continued here", PlainText(blocks[2].Spans))
+	assert.Equal(t, Block{Kind: ListItem, Level: 1, Ordered: true, Number: 1, Spans: []Span{{Text: "nested step"}}}, blocks[3])
+
+	assert.Equal(t, Quote, blocks[4].Kind)
+	assert.Equal(t, []Span{{Text: "quoted "}, {Text: "claim", Italic: true}}, blocks[4].Spans)
+
+	assert.Equal(t, Block{Kind: CodeBlock, Code: "fmt.Println(\"**not bold**\")"}, blocks[5])
+
+	assert.Equal(t, Table, blocks[6].Kind)
+	require.Len(t, blocks[6].Rows, 3)
+	assert.Equal(t, "Score", PlainText(blocks[6].Rows[0][1]))
+	assert.Equal(t, "0.8", PlainText(blocks[6].Rows[2][1]))
+
+	assert.Equal(t, Rule, blocks[7].Kind)
+}
+
+func TestParseInline(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []Span
+	}{
+		{"code", "call `f(x)` now", []Span{{Text: "call "}, {Text: "f(x)", Code: true}, {Text: " now"}}},
+		{"literal asterisk", "2 * 3 = 6", []Span{{Text: "2 * 3 = 6"}}},
+		{"snake case", "use snake_case_names", []Span{{Text: "use snake_case_names"}}},
+		{"unclosed bold", "a ** b", []Span{{Text: "a ** b"}}},
+		{"escaped", `\*not italic\*`, []Span{{Text: "*not italic*"}}},
+		{"link", "see [the paper](https://arxiv.org/abs/1)", []Span{{Text: "see the paper (https://arxiv.org/abs/1)"}}},
+		{"bold italic", "***both***", []Span{{Text: "both", Bold: true, Italic: true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ParseInline(tt.text))
+		})
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	html := RenderHTML(Parse("# T <1>\n\n1. a\n2. **b**\n   - c\n\ntext"))
+	assert.Equal(t, "<h1>T &lt;1&gt;</h1>\n"+
+		"<ol>\n<li>a</li>\n<li><strong>b</strong></li>\n<ul>\n<li>c</li>\n</ul>\n</ol>\n"+
+		"<p>text</p>\n", html)
+}
@@ -0,0 +1,53 @@
+// Package papertext recognizes the page furniture in the plain text extracted from research
+// papers, so it can be dropped when a corpus has to fit a token budget.
+package papertext
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Lines of at least minRunningHeaderLength and at most maxRunningHeaderLength repeated this
+// often in a paper are running headers or footers; shorter ones may be bits of prose or
+// equations that recur
+const (
+	minRunningHeaderRepeats = 3
+	minRunningHeaderLength  = 10
+	maxRunningHeaderLength  = 60
+)
+
+var boilerplateLines = []*regexp.Regexp{
+	regexp.MustCompile(`^arXiv:\d{4}\.\d{4,5}(?:v\d+)?\s+\[[^\]]+\]`), // arXiv side stamp
+	regexp.MustCompile(`^(?:page\s+)?\d{1,4}(?:\s+of\s+\d{1,4})?$`),   // page numbers
+	regexp.MustCompile(`(?i)^preprint\b.{0,80}$`),
+	regexp.MustCompile(`(?i)(?:©|\(c\)\s*\d{4}|copyright\s+(?:©\s*)?\d{4})`),
+	regexp.MustCompile(`(?i)all rights reserved`),
+	regexp.MustCompile(`(?i)^permission to make digital or hard copies`),
+	regexp.MustCompile(`(?i)^licensed under (?:a |the )?creative commons`),
+}
+
+// Boilerplate returns whether a line of the text is page furniture: an arXiv stamp, a page
+// number, a copyright or licence notice or a running header or footer repeated across pages
+func Boilerplate(text string) func(line string) bool {
+	repeats := make(map[string]int)
+	for _, line := range strings.Split(text, "\n") {
+		if trimmed := strings.TrimSpace(line); len(trimmed) >= minRunningHeaderLength && len(trimmed) <= maxRunningHeaderLength {
+			repeats[trimmed]++
+		}
+	}
+	return func(line string) bool {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			return false
+		}
+		if repeats[trimmed] >= minRunningHeaderRepeats {
+			return true
+		}
+		for _, pattern := range boilerplateLines {
+			if pattern.MatchString(trimmed) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,46 @@
+package papertext
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const paper = `Attention Is Not All You Need
+arXiv:2101.00001v2 [cs.LG] 3 Feb 2021
+
+1 Introduction
+Transformers are everywhere.
+Short Title of the Paper
+1
+
+2 Method
+We stack layers.
+Short Title of the Paper
+2
+
+3 Results
+It works.
+Short Title of the Paper
+3
+© 2021 The Authors. All rights reserved.
+`
+
+func TestBoilerplate(t *testing.T) {
+	boilerplate := Boilerplate(paper)
+
+	var kept []string
+	for _, line := range strings.Split(paper, "\n") {
+		if !boilerplate(line) {
+			kept = append(kept, line)
+		}
+	}
+	assert.Equal(t, "Attention Is Not All You Need\n\n1 Introduction\nTransformers are everywhere.\n\n"+
+		"2 Method\nWe stack layers.\n\n3 Results\nIt works.\n", strings.Join(kept, "\n"))
+
+	t.Run("short repeated lines are kept", func(t *testing.T) {
+		text := "where\nx = 1\nwhere\ny = 2\nwhere\n"
+		assert.False(t, Boilerplate(text)("where"))
+	})
+}
@@ -0,0 +1,280 @@
+// Package pdflayout reads the page layout pdftotext reports with -bbox-layout. It locates the
+// figures and tables of a paper from their captions, so they can be cropped into images, and
+// writes the text of the pages with recognized equations in place of the glyphs they cover.
+package pdflayout
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"nexus_scholar_go_backend/internal/utils/docir"
+)
+
+// Box is a rectangle on a page, in points from the top left corner
+type Box struct {
+	XMin float64 `xml:"xMin,attr"`
+	YMin float64 `xml:"yMin,attr"`
+	XMax float64 `xml:"xMax,attr"`
+	YMax float64 `xml:"yMax,attr"`
+}
+
+func (b Box) Width() float64  { return b.XMax - b.XMin }
+func (b Box) Height() float64 { return b.YMax - b.YMin }
+
+// Page is a page of a PDF and its text blocks, in reading order
+type Page struct {
+	Number int // from 1
+	Width  float64
+	Height float64
+	Blocks []Block
+}
+
+// Block is a block of text, such as a paragraph or a caption
+type Block struct {
+	Box
+	Lines []Line
+}
+
+// Line is a line of text of a block
+type Line struct {
+	Box
+	Text string
+}
+
+// Text returns the lines of a block, one per line
+func (b Block) Text() string {
+	lines := make([]string, len(b.Lines))
+	for i, line := range b.Lines {
+		lines[i] = line.Text
+	}
+	return strings.Join(lines, "\n")
+}
+
+// caption returns the text of a block as one line, the way captions are matched
+func (b Block) caption() string {
+	return strings.ReplaceAll(b.Text(), "\n", " ")
+}
+
+func (b Block) words() int {
+	return len(strings.Fields(b.Text()))
+}
+
+type layout struct {
+	Pages []struct {
+		Width  float64 `xml:"width,attr"`
+		Height float64 `xml:"height,attr"`
+		Blocks []struct {
+			Box
+			Lines []struct {
+				Box
+				Words []string `xml:"word"`
+			} `xml:"line"`
+		} `xml:"flow>block"`
+	} `xml:"body>doc>page"`
+}
+
+// Parse reads the XHTML pdftotext -bbox-layout writes
+func Parse(r io.Reader) ([]Page, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	var doc layout
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse page layout: %w", err)
+	}
+	pages := make([]Page, len(doc.Pages))
+	for i, p := range doc.Pages {
+		pages[i] = Page{Number: i + 1, Width: p.Width, Height: p.Height}
+		for _, b := range p.Blocks {
+			block := Block{Box: b.Box}
+			for _, line := range b.Lines {
+				block.Lines = append(block.Lines, Line{Box: line.Box, Text: strings.Join(line.Words, " ")})
+			}
+			pages[i].Blocks = append(pages[i].Blocks, block)
+		}
+	}
+	return pages, nil
+}
+
+// Region is the area of a page a figure or table is drawn in
+type Region struct {
+	Page  int
+	Box   Box
+	Kind  docir.BlockKind
+	Label string // "Figure 2" or "Table 1", as in the document's structured text
+}
+
+const (
+	// Regions are padded a little so strokes on their edges are not cut off
+	regionPadding = 4.0
+	// Regions shorter than this are captions without a figure next to them, such as those
+	// continued from a previous page
+	minRegionHeight = 36.0
+	// Blocks of at least this many words, with long lines, are prose rather than the labels
+	// or cells of a figure or table
+	minProseWords        = 25
+	minProseWordsPerLine = 7.0
+)
+
+// Regions locates the figures and tables of a paper. Figures are drawn above their captions
+// and tables below them, as most papers lay them out: a region extends from the caption to
+// the nearest prose or caption of the same column, or to the edge of the page's text. A label
+// captioned twice, as a figure continued on the next page is, only gets its first region.
+func Regions(pages []Page) []Region {
+	var regions []Region
+	seen := make(map[string]bool)
+	for _, page := range pages {
+		if len(page.Blocks) == 0 {
+			continue
+		}
+		text := textArea(page)
+		for i, block := range page.Blocks {
+			kind, label, _, ok := docir.ParseCaption(block.caption())
+			if !ok || seen[label] {
+				continue
+			}
+			left, right := column(page, text, block.Box)
+			box := Box{XMin: left, XMax: right}
+			if kind == docir.Table {
+				box.YMin, box.YMax = block.YMax, text.YMax
+				for j, other := range page.Blocks {
+					if j != i && isBoundary(other) && overlaps(other.Box, left, right) && other.YMin >= block.YMax && other.YMin < box.YMax {
+						box.YMax = other.YMin
+					}
+				}
+			} else {
+				box.YMin, box.YMax = text.YMin, block.YMin
+				for j, other := range page.Blocks {
+					if j != i && isBoundary(other) && overlaps(other.Box, left, right) && other.YMax <= block.YMin && other.YMax > box.YMin {
+						box.YMin = other.YMax
+					}
+				}
+			}
+			if box.Height() < minRegionHeight {
+				continue
+			}
+			seen[label] = true
+			regions = append(regions, Region{Page: page.Number, Box: pad(box, page), Kind: kind, Label: label})
+		}
+	}
+	return regions
+}
+
+// textArea returns the box around all the text of a page, inside its margins
+func textArea(page Page) Box {
+	area := page.Blocks[0].Box
+	for _, block := range page.Blocks[1:] {
+		area.XMin = min(area.XMin, block.XMin)
+		area.YMin = min(area.YMin, block.YMin)
+		area.XMax = max(area.XMax, block.XMax)
+		area.YMax = max(area.YMax, block.YMax)
+	}
+	return area
+}
+
+// column returns the horizontal extent of the column a caption is in: one half of a two-column
+// page when the caption fits in it, the whole text width otherwise
+func column(page Page, text Box, caption Box) (float64, float64) {
+	middle := page.Width / 2
+	switch {
+	case caption.XMax <= middle+regionPadding:
+		return text.XMin, middle
+	case caption.XMin >= middle-regionPadding:
+		return middle, text.XMax
+	}
+	return text.XMin, text.XMax
+}
+
+// isBoundary tells whether a block ends the region of a figure or table next to it
+func isBoundary(block Block) bool {
+	if _, _, _, ok := docir.ParseCaption(block.caption()); ok {
+		return true
+	}
+	words := block.words()
+	return words >= minProseWords && float64(words)/float64(len(block.Lines)) >= minProseWordsPerLine
+}
+
+func overlaps(box Box, left, right float64) bool {
+	return box.XMin < right && box.XMax > left
+}
+
+func pad(box Box, page Page) Box {
+	return Box{
+		XMin: max(0, box.XMin-regionPadding),
+		YMin: max(0, box.YMin-regionPadding),
+		XMax: min(page.Width, box.XMax+regionPadding),
+		YMax: min(page.Height, box.YMax+regionPadding),
+	}
+}
+
+// Equation is an equation recognized on a page, as LaTeX
+type Equation struct {
+	Page  int
+	Box   Box
+	LaTeX string
+}
+
+// Text writes the text of the pages, a paragraph per block. The lines an equation covers are
+// replaced by the equation between \[ and \], as a paragraph of its own; equations that cover
+// no text are written after the page's text.
+func Text(pages []Page, equations []Equation) string {
+	var paragraphs []string
+	for _, page := range pages {
+		var onPage []Equation
+		for _, equation := range equations {
+			if equation.Page == page.Number {
+				onPage = append(onPage, equation)
+			}
+		}
+		written := make([]bool, len(onPage))
+		for _, block := range page.Blocks {
+			var lines []string
+			for _, line := range block.Lines {
+				covering := -1
+				for i, equation := range onPage {
+					if covers(equation.Box, line.Box) {
+						covering = i
+						break
+					}
+				}
+				if covering < 0 {
+					lines = append(lines, line.Text)
+					continue
+				}
+				if written[covering] {
+					continue
+				}
+				if len(lines) > 0 {
+					paragraphs = append(paragraphs, strings.Join(lines, "\n"))
+					lines = nil
+				}
+				paragraphs = append(paragraphs, displayMath(onPage[covering].LaTeX))
+				written[covering] = true
+			}
+			if len(lines) > 0 {
+				paragraphs = append(paragraphs, strings.Join(lines, "\n"))
+			}
+		}
+		for i, equation := range onPage {
+			if !written[i] {
+				paragraphs = append(paragraphs, displayMath(equation.LaTeX))
+			}
+		}
+	}
+	return strings.Join(paragraphs, "\n\n") + "\n"
+}
+
+// covers tells whether the middle of a line lies in an equation's box
+func covers(equation Box, line Box) bool {
+	x, y := (line.XMin+line.XMax)/2, (line.YMin+line.YMax)/2
+	return x >= equation.XMin && x <= equation.XMax && y >= equation.YMin && y <= equation.YMax
+}
+
+func displayMath(latex string) string {
+	// A blank line would split the equation into paragraphs
+	return `\[` + strings.Join(strings.Fields(latex), " ") + `\]`
+}
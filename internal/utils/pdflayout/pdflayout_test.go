@@ -0,0 +1,110 @@
+package pdflayout
+
+import (
+	"strings"
+	"testing"
+
+	"nexus_scholar_go_backend/internal/utils/docir"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const bboxLayout = `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+<title></title>
+<meta name="Producer" content="pdfTeX"/>
+</head>
+<body>
+<doc>
+  <page width="612.000000" height="792.000000">
+    <flow>
+      <block xMin="72.000000" yMin="300.000000" xMax="540.000000" yMax="312.000000">
+        <line xMin="72.000000" yMin="300.000000" xMax="540.000000" yMax="312.000000">
+          <word xMin="72.000000" yMin="300.000000" xMax="100.000000" yMax="312.000000">Figure</word>
+          <word xMin="102.000000" yMin="300.000000" xMax="110.000000" yMax="312.000000">1:</word>
+          <word xMin="112.000000" yMin="300.000000" xMax="140.000000" yMax="312.000000">R&amp;D</word>
+        </line>
+      </block>
+    </flow>
+  </page>
+  <page width="612.000000" height="792.000000">
+  </page>
+</doc>
+</body>
+</html>
+`
+
+func TestParse(t *testing.T) {
+	pages, err := Parse(strings.NewReader(bboxLayout))
+	require.NoError(t, err)
+	require.Len(t, pages, 2)
+	assert.Equal(t, Page{Number: 1, Width: 612, Height: 792, Blocks: []Block{
+		{Box: Box{XMin: 72, YMin: 300, XMax: 540, YMax: 312}, Lines: []Line{
+			{Box: Box{XMin: 72, YMin: 300, XMax: 540, YMax: 312}, Text: "Figure 1: R&D"},
+		}},
+	}}, pages[0])
+	assert.Empty(t, pages[1].Blocks)
+}
+
+// prose is a block of body text between top and bottom
+func prose(xMin, yMin, xMax, yMax float64) Block {
+	line := Line{Box: Box{xMin, yMin, xMax, yMax}, Text: "the quick brown fox jumps over the lazy dog again"}
+	return Block{Box: Box{xMin, yMin, xMax, yMax}, Lines: []Line{line, line, line}}
+}
+
+func caption(text string, xMin, yMin, xMax, yMax float64) Block {
+	return Block{Box: Box{xMin, yMin, xMax, yMax}, Lines: []Line{{Box: Box{xMin, yMin, xMax, yMax}, Text: text}}}
+}
+
+func TestRegions(t *testing.T) {
+	pages := []Page{
+		{Number: 1, Width: 612, Height: 792, Blocks: []Block{
+			prose(72, 72, 540, 200),
+			// Axis labels inside the figure do not end its region
+			caption("0.5 1.0", 100, 260, 140, 270),
+			caption("Figure 1: Loss curves.", 72, 400, 540, 412),
+			caption("Table 1. Results.", 72, 430, 540, 442),
+			{Box: Box{100, 450, 500, 560}, Lines: []Line{{Text: "Model Accuracy"}, {Text: "Ours 91.2"}}},
+			prose(72, 600, 540, 720),
+		}},
+		// Two columns: the figure of the right column stops at the right column's prose
+		{Number: 2, Width: 612, Height: 792, Blocks: []Block{
+			prose(72, 72, 300, 700),
+			prose(312, 72, 540, 150),
+			caption("Fig. 2: Attention maps.", 312, 400, 540, 412),
+			// Continued captions and captions without room for a figure are skipped
+			caption("Figure 1: Loss curves (continued).", 312, 160, 540, 172),
+		}},
+	}
+
+	regions := Regions(pages)
+	require.Len(t, regions, 3)
+	assert.Equal(t, Region{Page: 1, Kind: docir.Figure, Label: "Figure 1", Box: Box{68, 196, 544, 404}}, regions[0])
+	assert.Equal(t, Region{Page: 1, Kind: docir.Table, Label: "Table 1", Box: Box{68, 438, 544, 604}}, regions[1])
+	assert.Equal(t, Region{Page: 2, Kind: docir.Figure, Label: "Figure 2", Box: Box{302, 168, 544, 404}}, regions[2])
+}
+
+func TestText(t *testing.T) {
+	line := func(text string, yMin float64) Line {
+		return Line{Box: Box{72, yMin, 540, yMin + 10}, Text: text}
+	}
+	pages := []Page{
+		{Number: 1, Width: 612, Height: 792, Blocks: []Block{
+			{Lines: []Line{line("The loss is", 100), line("L = X (y − yˆ )2", 112), line("i i i", 118), line("summed over samples.", 130)}},
+			{Lines: []Line{line("2 Method", 160)}},
+		}},
+		{Number: 2, Width: 612, Height: 792, Blocks: []Block{
+			{Lines: []Line{line("We stack layers.", 100)}},
+		}},
+	}
+	equations := []Equation{
+		{Page: 1, Box: Box{100, 110, 500, 126}, LaTeX: "L = \\sum_i\n\n(y_i - \\hat{y}_i)^2"},
+		// Drawn as an image, so pdftotext found no text in it
+		{Page: 2, Box: Box{100, 300, 500, 320}, LaTeX: "E = mc^2"},
+	}
+
+	assert.Equal(t, "The loss is\n\n\\[L = \\sum_i (y_i - \\hat{y}_i)^2\\]\n\nsummed over samples.\n\n2 Method\n\n"+
+		"We stack layers.\n\n\\[E = mc^2\\]\n", Text(pages, equations))
+}
@@ -0,0 +1,218 @@
+// Package textdiff produces short, human readable word-level diffs between two
+// versions of a text (paper titles, abstracts, author lists, the full text of papers).
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// Texts whose changed middle is longer than this are compared on its first maxWords
+	// words only, which keeps the LCS table small for the abstract-sized inputs we care about
+	maxWords = 1500
+	// The same bound for the lines of full texts
+	maxLines = 3000
+	// maxSnippetWords shortens the change snippets of full texts, where whole paragraphs
+	// may be added or removed
+	maxSnippetWords = 30
+)
+
+type OpKind int
+
+const (
+	Equal OpKind = iota
+	Insert
+	Delete
+)
+
+// Op is a run of consecutive words that were kept, inserted or deleted
+type Op struct {
+	Kind  OpKind
+	Words []string
+}
+
+// Summary describes what changed between two texts
+type Summary struct {
+	WordsAdded   int
+	WordsRemoved int
+	Changes      []string // "- removed words" / "+ added words" snippets, in text order
+}
+
+// Diff returns the word-level edit script turning oldText into newText
+func Diff(oldText, newText string) []Op {
+	return diff(strings.Fields(oldText), strings.Fields(newText), maxWords)
+}
+
+// diff returns the edit script turning the tokens a into b. The common prefix and suffix are
+// kept as they are and only the middle, cut to limit tokens, is compared.
+func diff(a, b []string, limit int) []Op {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []Op
+	push := func(kind OpKind, word string) {
+		if len(ops) > 0 && ops[len(ops)-1].Kind == kind {
+			ops[len(ops)-1].Words = append(ops[len(ops)-1].Words, word)
+			return
+		}
+		ops = append(ops, Op{Kind: kind, Words: []string{word}})
+	}
+	for _, word := range a[:prefix] {
+		push(Equal, word)
+	}
+
+	middleA := truncate(a[prefix:len(a)-suffix], limit)
+	middleB := truncate(b[prefix:len(b)-suffix], limit)
+	// lcs[i][j] is the length of the longest common subsequence of middleA[i:] and middleB[j:]
+	lcs := make([][]int, len(middleA)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(middleB)+1)
+	}
+	for i := len(middleA) - 1; i >= 0; i-- {
+		for j := len(middleB) - 1; j >= 0; j-- {
+			if middleA[i] == middleB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(middleA) && j < len(middleB) {
+		switch {
+		case middleA[i] == middleB[j]:
+			push(Equal, middleA[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			push(Delete, middleA[i])
+			i++
+		default:
+			push(Insert, middleB[j])
+			j++
+		}
+	}
+	for ; i < len(middleA); i++ {
+		push(Delete, middleA[i])
+	}
+	for ; j < len(middleB); j++ {
+		push(Insert, middleB[j])
+	}
+
+	// A cut middle hides the rest of the changes, so the suffix is only known to be equal
+	// when nothing was cut
+	if len(middleA) == len(a)-prefix-suffix && len(middleB) == len(b)-prefix-suffix {
+		for _, word := range a[len(a)-suffix:] {
+			push(Equal, word)
+		}
+	}
+	return ops
+}
+
+// Summarize diffs the two texts and condenses the result into counts and a
+// list of change snippets.
+func Summarize(oldText, newText string) Summary {
+	var summary Summary
+	for _, op := range Diff(oldText, newText) {
+		switch op.Kind {
+		case Insert:
+			summary.WordsAdded += len(op.Words)
+			summary.Changes = append(summary.Changes, "+ "+strings.Join(op.Words, " "))
+		case Delete:
+			summary.WordsRemoved += len(op.Words)
+			summary.Changes = append(summary.Changes, "- "+strings.Join(op.Words, " "))
+		}
+	}
+	return summary
+}
+
+// Changed reports whether the summary contains any difference
+func (s Summary) Changed() bool {
+	return s.WordsAdded > 0 || s.WordsRemoved > 0
+}
+
+// String renders the summary, listing at most maxChanges snippets
+func (s Summary) String(maxChanges int) string {
+	if !s.Changed() {
+		return "no changes"
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d words added, %d words removed", s.WordsAdded, s.WordsRemoved)
+	for i, change := range s.Changes {
+		if i == maxChanges {
+			fmt.Fprintf(&sb, "\n… %d more changes", len(s.Changes)-maxChanges)
+			break
+		}
+		sb.WriteString("\n")
+		sb.WriteString(change)
+	}
+	return sb.String()
+}
+
+// SummarizeText diffs the full texts of two versions of a document, such as the text
+// extracted from their PDFs. The texts are first compared line by line, with whitespace
+// collapsed and blank lines ignored so layout changes do not count, and the changed lines are
+// then compared word by word. Snippets are shortened to a few words each.
+func SummarizeText(oldText, newText string) Summary {
+	var summary Summary
+	var removed, added []string
+	flush := func() {
+		hunk := Summarize(strings.Join(removed, " "), strings.Join(added, " "))
+		summary.WordsAdded += hunk.WordsAdded
+		summary.WordsRemoved += hunk.WordsRemoved
+		for _, change := range hunk.Changes {
+			summary.Changes = append(summary.Changes, shorten(change))
+		}
+		removed, added = nil, nil
+	}
+	for _, op := range diff(lines(oldText), lines(newText), maxLines) {
+		switch op.Kind {
+		case Delete:
+			removed = append(removed, op.Words...)
+		case Insert:
+			added = append(added, op.Words...)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return summary
+}
+
+// lines returns the non-blank lines of a text with their whitespace collapsed
+func lines(text string) []string {
+	var result []string
+	for _, line := range strings.Split(text, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			result = append(result, strings.Join(fields, " "))
+		}
+	}
+	return result
+}
+
+// shorten cuts a change snippet to maxSnippetWords words
+func shorten(change string) string {
+	words := strings.Fields(change)
+	// The first word is the + or - of the snippet
+	if len(words) <= maxSnippetWords+1 {
+		return change
+	}
+	return strings.Join(words[:maxSnippetWords+1], " ") + " …"
+}
+
+func truncate(tokens []string, limit int) []string {
+	if len(tokens) > limit {
+		return tokens[:limit]
+	}
+	return tokens
+}
@@ -0,0 +1,75 @@
+package textdiff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarize(t *testing.T) {
+	t.Run("identical texts", func(t *testing.T) {
+		summary := Summarize("We study   emergent abilities.", "We study emergent abilities.")
+		assert.False(t, summary.Changed())
+		assert.Equal(t, "no changes", summary.String(5))
+	})
+
+	t.Run("replaced and appended words", func(t *testing.T) {
+		summary := Summarize(
+			"We study emergent abilities of large models.",
+			"We study emergent abilities of large language models on 8 benchmarks.",
+		)
+		assert.Equal(t, 5, summary.WordsAdded)
+		assert.Equal(t, 1, summary.WordsRemoved)
+		assert.Equal(t, []string{"- models.", "+ language models on 8 benchmarks."}, summary.Changes)
+	})
+
+	t.Run("limits listed changes", func(t *testing.T) {
+		summary := Summarize("a b c d e", "a x c y e z")
+		assert.Equal(t, "3 words added, 2 words removed\n- b\n+ x\n… 3 more changes", summary.String(2))
+	})
+}
+
+func TestDiffRoundTrip(t *testing.T) {
+	oldText := "the quick brown fox jumps over the lazy dog"
+	newText := "the quick red fox leaps over the dog today"
+
+	var rebuiltOld, rebuiltNew []string
+	for _, op := range Diff(oldText, newText) {
+		if op.Kind != Insert {
+			rebuiltOld = append(rebuiltOld, op.Words...)
+		}
+		if op.Kind != Delete {
+			rebuiltNew = append(rebuiltNew, op.Words...)
+		}
+	}
+
+	assert.Equal(t, oldText, strings.Join(rebuiltOld, " "))
+	assert.Equal(t, newText, strings.Join(rebuiltNew, " "))
+}
+
+func TestSummarizeText(t *testing.T) {
+	oldText := "Attention Is All You Need\n\n1   Introduction\nRecurrent models are slow.\nWe propose the Transformer.\n\n" +
+		"2 Results\nWe reach 27.3 BLEU.\n"
+	t.Run("layout changes only", func(t *testing.T) {
+		relaidOut := "  Attention   Is All You Need\n1 Introduction\n\n\nRecurrent models are slow.\nWe propose the Transformer.\n" +
+			"2 Results\n   We reach 27.3 BLEU.\n\n"
+		assert.False(t, SummarizeText(oldText, relaidOut).Changed())
+	})
+
+	t.Run("changed lines are compared word by word", func(t *testing.T) {
+		newText := "Attention Is All You Need\n\n1   Introduction\nRecurrent models are slow.\nWe propose the Transformer.\n\n" +
+			"2 Results\nWe reach 28.4 BLEU\non WMT 2014.\n"
+		summary := SummarizeText(oldText, newText)
+		assert.Equal(t, 5, summary.WordsAdded)
+		assert.Equal(t, 2, summary.WordsRemoved)
+		assert.Equal(t, []string{"- 27.3 BLEU.", "+ 28.4 BLEU on WMT 2014."}, summary.Changes)
+	})
+
+	t.Run("long snippets are shortened", func(t *testing.T) {
+		paragraph := strings.Repeat("word ", 40)
+		summary := SummarizeText(oldText, oldText+paragraph)
+		assert.Equal(t, 40, summary.WordsAdded)
+		assert.Equal(t, []string{"+ " + strings.Repeat("word ", 30) + "…"}, summary.Changes)
+	})
+}
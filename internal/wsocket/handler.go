@@ -3,6 +3,7 @@ package wsocket
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -13,31 +14,65 @@ import (
 	"nexus_scholar_go_backend/internal/utils/broker"
 
 	"github.com/google/generative-ai-go/genai"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog"
 	"google.golang.org/api/iterator"
+	"gorm.io/gorm"
 )
 
+// suggestionTimeout bounds the generation of follow-up questions after an answer
+const suggestionTimeout = time.Minute
+
 type Handler struct {
 	researchChatService  *services.ResearchChatService
+	workspaceService     *services.WorkspaceService
+	suggestionService    *services.SuggestionService
 	upgrader             websocket.Upgrader
 	sessionCheckInterval time.Duration
+	hub                  *hub
 	log                  zerolog.Logger
 }
 
 type Message struct {
-	Type              string `json:"type"`
-	Content           string `json:"content"`
-	SessionID         string `json:"sessionId"`
-	CachedContentName string `json:"cachedContentName,omitempty"`
+	Type              string       `json:"type"`
+	Content           string       `json:"content"`
+	SessionID         string       `json:"sessionId"`
+	CachedContentName string       `json:"cachedContentName,omitempty"`
+	Author            *Participant `json:"author,omitempty"`    // who sent a prompt or caused an event
+	MessageID         uint         `json:"messageId,omitempty"` // the prompt to edit, or the answer suggestions are for
+	BranchID          uint         `json:"branchId,omitempty"`  // the branch to switch to
+	// Preset, PresetID or Instruction override the session's answer style for one prompt
+	Preset      string `json:"preset,omitempty"`
+	PresetID    uint   `json:"presetId,omitempty"`
+	Instruction string `json:"instruction,omitempty"`
+}
+
+// branchEvent is the content of branch messages, sent when an edit or regeneration starts a
+// branch or a participant switches branches; clients reload the conversation
+type branchEvent struct {
+	Event        string `json:"event"` // created or switched
+	BranchID     uint   `json:"branchId"`
+	ForkedFromID *uint  `json:"forkedFromId,omitempty"`
+	Reason       string `json:"reason,omitempty"` // edit or regenerate
+}
+
+// presenceEvent is the content of presence messages, sent when a participant joins or leaves
+type presenceEvent struct {
+	Event        string        `json:"event"` // join or leave
+	Participant  Participant   `json:"participant"`
+	Participants []Participant `json:"participants"`
 }
 
-func NewHandler(researchChatService *services.ResearchChatService, upgrader websocket.Upgrader, sessionCheckInterval time.Duration, sessionMemoryTimeout time.Duration, log zerolog.Logger) *Handler {
+func NewHandler(researchChatService *services.ResearchChatService, workspaceService *services.WorkspaceService, suggestionService *services.SuggestionService, upgrader websocket.Upgrader, sessionCheckInterval time.Duration, sessionMemoryTimeout time.Duration, log zerolog.Logger) *Handler {
 	log.Info().Msg("Creating new Handler")
 	return &Handler{
 		researchChatService:  researchChatService,
+		workspaceService:     workspaceService,
+		suggestionService:    suggestionService,
 		upgrader:             upgrader,
 		sessionCheckInterval: sessionCheckInterval,
+		hub:                  newHub(),
 		log:                  log,
 	}
 }
@@ -51,6 +86,19 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request, user i
 		return
 	}
 	h.log.Info().Str("sessionId", sessionID).Msg("Received sessionId")
+
+	// The session's creator and the members of the workspace it is shared with may join
+	userModel := user.(*models.User)
+	if _, err := h.workspaceService.AuthorizeSession(r.Context(), userModel.ID, sessionID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		h.log.Error().Err(err).Msg("Error authorizing session access")
+		http.Error(w, "Failed to authorize session access", http.StatusInternalServerError)
+		return
+	}
+
 	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		h.log.Error().Err(err).Msg("Error upgrading connection")
@@ -64,13 +112,31 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request, user i
 	ticker := time.NewTicker(h.sessionCheckInterval)
 	defer ticker.Stop()
 
-	userID := user.(*models.User).ID.String()
+	cl := &client{conn: conn, participant: participantFromUser(userModel)}
+	rm := h.hub.join(sessionID, cl)
+	h.announcePresence(rm, cl, "join")
+	defer func() {
+		h.hub.leave(rm, cl)
+		h.announcePresence(rm, cl, "leave")
+	}()
+
+	userID := userModel.ID.String()
 	creditUpdateChan := messageBroker.Subscribe("credit_update_" + userID)
-	defer messageBroker.Unsubscribe("credit_update_"+userID, creditUpdateChan)
+	notificationChan := messageBroker.Subscribe(services.NotificationTopic(userModel.ID))
+	jobChan := messageBroker.Subscribe(services.SessionJobTopic(sessionID))
+	// Unsubscribing twice is harmless, so this runs both when the goroutine below stops
+	// draining the channels and when the connection closes
+	unsubscribe := func() {
+		messageBroker.Unsubscribe("credit_update_"+userID, creditUpdateChan)
+		messageBroker.Unsubscribe(services.NotificationTopic(userModel.ID), notificationChan)
+		messageBroker.Unsubscribe(services.SessionJobTopic(sessionID), jobChan)
+	}
+	defer unsubscribe()
 
 	isTerminated := false
 
 	go func() {
+		defer unsubscribe()
 		for {
 			select {
 			case <-ctx.Done():
@@ -82,7 +148,7 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request, user i
 					return
 				}
 				if msgStr, ok := msg.(string); ok {
-					if err := conn.WriteJSON(Message{
+					if err := cl.send(Message{
 						Type:      "credit_update",
 						Content:   msgStr,
 						SessionID: sessionID,
@@ -92,6 +158,40 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request, user i
 				} else {
 					h.log.Warn().Interface("msg", msg).Msg("Received non-string message on credit update channel")
 				}
+			case msg, ok := <-notificationChan:
+				if !ok {
+					h.log.Info().Msg("Notification channel closed, exiting goroutine")
+					return
+				}
+				notificationJSON, err := json.Marshal(msg)
+				if err != nil {
+					h.log.Error().Err(err).Msg("Error marshaling notification")
+					continue
+				}
+				if err := cl.send(Message{
+					Type:      "notification",
+					Content:   string(notificationJSON),
+					SessionID: sessionID,
+				}); err != nil {
+					h.log.Error().Err(err).Msg("Error sending notification")
+				}
+			case msg, ok := <-jobChan:
+				if !ok {
+					h.log.Info().Msg("Job channel closed, exiting goroutine")
+					return
+				}
+				eventJSON, err := json.Marshal(msg)
+				if err != nil {
+					h.log.Error().Err(err).Msg("Error marshaling job progress")
+					continue
+				}
+				if err := cl.send(Message{
+					Type:      "job_progress",
+					Content:   string(eventJSON),
+					SessionID: sessionID,
+				}); err != nil {
+					h.log.Error().Err(err).Msg("Error sending job progress")
+				}
 			case <-ticker.C:
 				if isTerminated {
 					return
@@ -108,7 +208,7 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request, user i
 				}
 				if isLowCredit {
 					h.log.Info().Msg("Session low credit")
-					if err := conn.WriteJSON(Message{
+					if err := cl.send(Message{
 						Type:      "credit_warning",
 						Content:   fmt.Sprintf(`{"remainingCredit": %.6f}`, remainingCredit),
 						SessionID: sessionID,
@@ -122,7 +222,7 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request, user i
 					h.log.Error().Err(err).Msg("Error marshaling session status")
 					continue
 				}
-				if err := conn.WriteJSON(Message{
+				if err := cl.send(Message{
 					Type:      "session_status",
 					Content:   string(statusJSON),
 					SessionID: sessionID,
@@ -133,7 +233,7 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request, user i
 
 				if status.Status == "expired" {
 					h.log.Info().Msg("Session expired")
-					if err := conn.WriteJSON(Message{
+					if err := cl.send(Message{
 						Type:      "expired",
 						Content:   "Your session has expired.",
 						SessionID: sessionID,
@@ -158,34 +258,43 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request, user i
 			h.log.Error().Err(err).Msg("Error unmarshaling message")
 			continue
 		}
-		sessionID := msg.SessionID
+		// A connection only ever takes part in the session it was authorized for
+		msg.SessionID = sessionID
 		h.log.Info().Str("type", msg.Type).Str("sessionId", sessionID).Msg("Received message")
 
 		switch msg.Type {
 		case "message":
 			h.log.Info().Msg("Handling chat message")
-			h.handleChatMessage(conn, msg, ctx)
+			h.handleChatMessage(ctx, rm, cl, userModel, msg)
 			// Update session activity after processing any message
 			if err := h.researchChatService.UpdateSessionActivity(ctx, sessionID); err != nil {
 				h.log.Error().Err(err).Msg("Failed to update session activity")
-				conn.WriteJSON(Message{
+				cl.send(Message{
 					Type:      "error",
 					Content:   fmt.Sprintf("Failed to update session activity: %v", err),
 					SessionID: sessionID,
 				})
 			}
+		case "edit", "regenerate":
+			h.handleBranchMessage(ctx, rm, cl, userModel, msg)
+			if err := h.researchChatService.UpdateSessionActivity(ctx, sessionID); err != nil {
+				h.log.Error().Err(err).Msg("Failed to update session activity")
+			}
+		case "switch_branch":
+			h.handleSwitchBranch(rm, cl, msg)
+		case "update_generation":
+			h.handleUpdateGeneration(rm, cl, msg)
 		case "terminate":
 			h.log.Info().Msg("Terminating session")
 			if err := h.researchChatService.EndResearchSession(ctx, sessionID); err != nil {
 				h.log.Error().Err(err).Msg("Error ending research session")
 			} else {
-				if err := conn.WriteJSON(Message{
+				rm.broadcast(Message{
 					Type:      "info",
 					Content:   "Research session terminated successfully",
 					SessionID: sessionID,
-				}); err != nil {
-					h.log.Error().Err(err).Msg("Error sending termination confirmation")
-				}
+					Author:    &cl.participant,
+				}, nil)
 			}
 			isTerminated = true
 			time.Sleep(500 * time.Millisecond)
@@ -193,22 +302,23 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request, user i
 			return
 		case "get_session_status":
 			h.log.Info().Msg("Getting session status")
-			h.sendSessionStatus(conn, sessionID)
+			h.sendSessionStatus(cl, sessionID)
 		case "extend_session":
 			h.log.Info().Msg("Extending session")
 			if err := h.researchChatService.ExtendSession(ctx, sessionID); err != nil {
 				h.log.Error().Err(err).Msg("Failed to extend session")
-				conn.WriteJSON(Message{
+				cl.send(Message{
 					Type:      "error",
 					Content:   fmt.Sprintf("Failed to extend session: %v", err),
 					SessionID: sessionID,
 				})
 			} else {
-				conn.WriteJSON(Message{
+				rm.broadcast(Message{
 					Type:      "info",
 					Content:   "Session extended successfully",
 					SessionID: sessionID,
-				})
+					Author:    &cl.participant,
+				}, nil)
 			}
 		default:
 			h.log.Warn().Str("type", msg.Type).Msg("Unknown message type")
@@ -216,29 +326,202 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request, user i
 	}
 }
 
-func (h *Handler) handleChatMessage(conn *websocket.Conn, msg Message, ctx context.Context) {
+// handleChatMessage sends a participant's prompt to the session's chat and streams the
+// answer to everyone in the room. Only one prompt is answered at a time.
+func (h *Handler) handleChatMessage(ctx context.Context, rm *room, cl *client, author *models.User, msg Message) {
 	h.log.Info().Str("sessionId", msg.SessionID).Msg("Handling chat message")
-	responseIterator, err := h.researchChatService.SendMessage(ctx, msg.SessionID, msg.Content)
+	instruction, err := h.researchChatService.ResolveInstruction(ctx, author.ID, services.InstructionSelection{
+		Preset:      msg.Preset,
+		PresetID:    msg.PresetID,
+		Instruction: msg.Instruction,
+	})
 	if err != nil {
-		h.log.Error().Err(err).Msg("Failed to send message")
-		conn.WriteJSON(Message{
+		cl.send(Message{
 			Type:      "error",
 			Content:   fmt.Sprintf("Failed to send message: %v", err),
 			SessionID: msg.SessionID,
 		})
 		return
 	}
+	h.answer(ctx, rm, cl, author, msg, true, func() (*genai.GenerateContentResponseIterator, *models.ChatBranch, error) {
+		responseIterator, err := h.researchChatService.SendMessage(ctx, msg.SessionID, msg.Content, instruction)
+		return responseIterator, nil, err
+	})
+}
+
+// handleBranchMessage edits an earlier prompt or regenerates the last answer. Either starts a
+// new branch of the conversation, which is announced to the room before the answer streams.
+func (h *Handler) handleBranchMessage(ctx context.Context, rm *room, cl *client, author *models.User, msg Message) {
+	h.log.Info().Str("sessionId", msg.SessionID).Str("type", msg.Type).Msg("Handling branch message")
+	if msg.Type == "regenerate" {
+		h.answer(ctx, rm, cl, author, msg, false, func() (*genai.GenerateContentResponseIterator, *models.ChatBranch, error) {
+			return h.researchChatService.RegenerateAnswer(ctx, msg.SessionID)
+		})
+		return
+	}
+	if msg.MessageID == 0 || strings.TrimSpace(msg.Content) == "" {
+		cl.send(Message{
+			Type:      "error",
+			Content:   "An edit needs the messageId of the prompt and its new content",
+			SessionID: msg.SessionID,
+		})
+		return
+	}
+	h.answer(ctx, rm, cl, author, msg, true, func() (*genai.GenerateContentResponseIterator, *models.ChatBranch, error) {
+		return h.researchChatService.EditMessage(ctx, msg.SessionID, msg.MessageID, msg.Content)
+	})
+}
+
+// handleSwitchBranch makes another branch of the conversation active for everyone in the room
+func (h *Handler) handleSwitchBranch(rm *room, cl *client, msg Message) {
+	release, ok := h.acquireTurn(cl, msg.SessionID)
+	if !ok {
+		return
+	}
+	defer release()
+
+	if err := h.researchChatService.SwitchBranch(msg.SessionID, msg.BranchID); err != nil {
+		h.log.Error().Err(err).Msg("Failed to switch branch")
+		cl.send(Message{
+			Type:      "error",
+			Content:   fmt.Sprintf("Failed to switch branch: %v", err),
+			SessionID: msg.SessionID,
+		})
+		return
+	}
+	h.broadcastBranch(rm, cl, msg.SessionID, branchEvent{Event: "switched", BranchID: msg.BranchID})
+}
+
+// handleUpdateGeneration changes the session's sampling and safety settings from the next
+// prompt on. The content is the complete new settings as JSON; omitted fields go back to the
+// model defaults.
+func (h *Handler) handleUpdateGeneration(rm *room, cl *client, msg Message) {
+	var params models.GenerationParams
+	if err := json.Unmarshal([]byte(msg.Content), &params); err != nil {
+		cl.send(Message{
+			Type:      "error",
+			Content:   "Generation parameters must be a JSON object",
+			SessionID: msg.SessionID,
+		})
+		return
+	}
+
+	release, ok := h.acquireTurn(cl, msg.SessionID)
+	if !ok {
+		return
+	}
+	defer release()
+
+	if err := h.researchChatService.UpdateGenerationParams(msg.SessionID, params); err != nil {
+		h.log.Error().Err(err).Msg("Failed to update generation parameters")
+		cl.send(Message{
+			Type:      "error",
+			Content:   fmt.Sprintf("Failed to update generation parameters: %v", err),
+			SessionID: msg.SessionID,
+		})
+		return
+	}
+
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Error marshaling generation parameters")
+		return
+	}
+	rm.broadcast(Message{
+		Type:      "generation",
+		Content:   string(paramsJSON),
+		SessionID: msg.SessionID,
+		Author:    &cl.participant,
+	}, nil)
+}
 
-	// Save user message
-	if err := h.researchChatService.SaveMessageToDB(ctx, msg.SessionID, "user", msg.Content); err != nil {
-		h.log.Error().Err(err).Msg("Failed to save user message")
-		conn.WriteJSON(Message{
+// acquireTurn reserves the session for one prompt, telling the client when another
+// participant's prompt is still being answered
+func (h *Handler) acquireTurn(cl *client, sessionID string) (func(), bool) {
+	release, err := h.researchChatService.AcquireTurn(sessionID)
+	if errors.Is(err, services.ErrTurnInProgress) {
+		cl.send(Message{
+			Type:      "turn_busy",
+			Content:   "Another participant's prompt is being answered. Try again once it is complete.",
+			SessionID: sessionID,
+		})
+		return nil, false
+	}
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to acquire turn")
+		cl.send(Message{
 			Type:      "error",
-			Content:   fmt.Sprintf("Failed to save user message: %v", err),
+			Content:   fmt.Sprintf("Failed to send message: %v", err),
+			SessionID: sessionID,
+		})
+		return nil, false
+	}
+	return release, true
+}
+
+func (h *Handler) broadcastBranch(rm *room, cl *client, sessionID string, event branchEvent) {
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Error marshaling branch event")
+		return
+	}
+	rm.broadcast(Message{
+		Type:      "branch",
+		Content:   string(eventJSON),
+		SessionID: sessionID,
+		Author:    &cl.participant,
+		BranchID:  event.BranchID,
+	}, nil)
+}
+
+// answer holds the session's turn while start sends a prompt and the answer streams to the
+// room. savePrompt is false when the prompt is already in the conversation.
+func (h *Handler) answer(ctx context.Context, rm *room, cl *client, author *models.User, msg Message, savePrompt bool, start func() (*genai.GenerateContentResponseIterator, *models.ChatBranch, error)) {
+	release, ok := h.acquireTurn(cl, msg.SessionID)
+	if !ok {
+		return
+	}
+	defer release()
+
+	responseIterator, branch, err := start()
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to send message")
+		cl.send(Message{
+			Type:      "error",
+			Content:   fmt.Sprintf("Failed to send message: %v", err),
 			SessionID: msg.SessionID,
 		})
 		return
 	}
+	if branch != nil {
+		h.broadcastBranch(rm, cl, msg.SessionID, branchEvent{
+			Event:        "created",
+			BranchID:     branch.ID,
+			ForkedFromID: branch.ForkedFromID,
+			Reason:       branch.Reason,
+		})
+	}
+
+	if savePrompt {
+		// Save user message
+		if _, err := h.researchChatService.SaveMessageToDB(ctx, msg.SessionID, "user", msg.Content, &author.ID); err != nil {
+			h.log.Error().Err(err).Msg("Failed to save user message")
+			cl.send(Message{
+				Type:      "error",
+				Content:   fmt.Sprintf("Failed to save user message: %v", err),
+				SessionID: msg.SessionID,
+			})
+			return
+		}
+
+		// Show the prompt to the other participants before its answer starts streaming
+		rm.broadcast(Message{
+			Type:      "user",
+			Content:   msg.Content,
+			SessionID: msg.SessionID,
+			Author:    &cl.participant,
+		}, cl)
+	}
 
 	var aiResponse strings.Builder
 
@@ -247,36 +530,37 @@ func (h *Handler) handleChatMessage(conn *websocket.Conn, msg Message, ctx conte
 		if err == iterator.Done {
 			h.log.Info().Msg("AI response complete")
 			// Update the session's chat history with the AI response
-			if err := h.researchChatService.SaveMessageToDB(ctx, msg.SessionID, "ai", aiResponse.String()); err != nil {
+			answer, err := h.researchChatService.SaveMessageToDB(ctx, msg.SessionID, "ai", aiResponse.String(), nil)
+			if err != nil {
 				h.log.Error().Err(err).Msg("Failed to save AI response to chat history")
-				conn.WriteJSON(Message{
+				cl.send(Message{
 					Type:      "error",
 					Content:   fmt.Sprintf("Failed to save AI response to chat history: %v", err),
 					SessionID: msg.SessionID,
 				})
 			}
 			// Send end-of-message signal
-			endMsg := Message{
+			rm.broadcast(Message{
 				Type:      "ai",
 				Content:   "[END]",
 				SessionID: msg.SessionID,
-			}
-			if err := conn.WriteJSON(endMsg); err != nil {
-				h.log.Error().Err(err).Msg("Error sending end message")
+			}, nil)
+			if answer != nil {
+				go h.suggest(rm, msg.SessionID, author.ID, answer)
 			}
 			break
 		}
 		if err != nil {
 			h.log.Error().Err(err).Msg("Error getting response")
-			conn.WriteJSON(Message{
+			rm.broadcast(Message{
 				Type:      "error",
 				Content:   fmt.Sprintf("Error getting response: %v", err),
 				SessionID: msg.SessionID,
-			})
+			}, nil)
 			break
 		}
 
-		if len(response.Candidates) > 0 && len(response.Candidates[0].Content.Parts) > 0 {
+		if len(response.Candidates) > 0 && response.Candidates[0].Content != nil && len(response.Candidates[0].Content.Parts) > 0 {
 			var content string
 			switch part := response.Candidates[0].Content.Parts[0].(type) {
 			case genai.Text:
@@ -290,28 +574,75 @@ func (h *Handler) handleChatMessage(conn *websocket.Conn, msg Message, ctx conte
 			// Aggregating the ai response to later save in DB.
 			aiResponse.WriteString(content)
 
-			// Send the content as it is returned from the iterator
-			responseMsg := Message{
+			// Send the content to every participant as it is returned from the iterator. The
+			// stream is read to the end even if the sender has gone, so the answer is saved.
+			rm.broadcast(Message{
 				Type:      "ai",
 				Content:   content,
 				SessionID: msg.SessionID,
-			}
-			if err := conn.WriteJSON(responseMsg); err != nil {
-				h.log.Error().Err(err).Msg("Error sending AI response")
-				return
-			}
+			}, nil)
 		}
 	}
 }
 
-func (h *Handler) sendSessionStatus(conn *websocket.Conn, sessionID string) error {
+// suggest sends the room follow-up questions to an answer once they are generated. The turn
+// is released by then, so the next prompt does not wait for them.
+func (h *Handler) suggest(rm *room, sessionID string, authorID uuid.UUID, answer *models.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), suggestionTimeout)
+	defer cancel()
+	suggestions, err := h.suggestionService.Suggest(ctx, sessionID, authorID, answer)
+	if err != nil {
+		h.log.Error().Err(err).Msgf("Failed to suggest follow-up questions in session %s", sessionID)
+		return
+	}
+	if len(suggestions) == 0 {
+		return
+	}
+	suggestionsJSON, err := json.Marshal(suggestions)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Error marshaling suggestions")
+		return
+	}
+	rm.broadcast(Message{
+		Type:      "suggestions",
+		Content:   string(suggestionsJSON),
+		SessionID: sessionID,
+		MessageID: answer.ID,
+	}, nil)
+}
+
+// announcePresence tells the room that a participant joined or left. Extra connections of
+// a participant who is already present (another tab) are not announced.
+func (h *Handler) announcePresence(rm *room, cl *client, event string) {
+	connections := rm.connections(cl.participant.ID)
+	if (event == "join" && connections > 1) || (event == "leave" && connections > 0) {
+		return
+	}
+	presenceJSON, err := json.Marshal(presenceEvent{
+		Event:        event,
+		Participant:  cl.participant,
+		Participants: rm.participants(),
+	})
+	if err != nil {
+		h.log.Error().Err(err).Msg("Error marshaling presence event")
+		return
+	}
+	rm.broadcast(Message{
+		Type:      "presence",
+		Content:   string(presenceJSON),
+		SessionID: rm.sessionID,
+		Author:    &cl.participant,
+	}, nil)
+}
+
+func (h *Handler) sendSessionStatus(cl *client, sessionID string) error {
 	statusInfo, err := h.researchChatService.GetSessionStatus(sessionID)
 	if err != nil {
 		h.log.Error().Err(err).Msg("Error getting session status")
-		return conn.WriteJSON(Message{Type: "error", Content: "Failed to get session status"})
+		return cl.send(Message{Type: "error", Content: "Failed to get session status"})
 	}
 	statusInfoJSON, _ := json.Marshal(statusInfo)
-	return conn.WriteJSON(Message{
+	return cl.send(Message{
 		Type:      "session_status",
 		Content:   string(statusInfoJSON),
 		SessionID: sessionID,
@@ -0,0 +1,139 @@
+package wsocket
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"nexus_scholar_go_backend/internal/models"
+
+	"github.com/gorilla/websocket"
+)
+
+const writeTimeout = 10 * time.Second
+
+// Participant identifies the user behind a connection in presence events and message attribution
+type Participant struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func participantFromUser(user *models.User) Participant {
+	name := user.Name
+	if name == "" {
+		name = user.Nickname
+	}
+	if name == "" {
+		name = user.Email
+	}
+	return Participant{ID: user.ID.String(), Name: name}
+}
+
+// client is one WebSocket connection. Writes are serialized because the session status
+// loop, the message loop and broadcasts from other participants all write to it.
+type client struct {
+	conn        *websocket.Conn
+	participant Participant
+	writeMutex  sync.Mutex
+}
+
+func (cl *client) send(msg Message) error {
+	cl.writeMutex.Lock()
+	defer cl.writeMutex.Unlock()
+	// A stalled participant must not hold up broadcasts to everyone else
+	cl.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return cl.conn.WriteJSON(msg)
+}
+
+// room holds the connections of every participant of one research session
+type room struct {
+	sessionID string
+	mutex     sync.RWMutex
+	clients   map[*client]struct{}
+}
+
+// broadcast sends the message to every connection in the room except the given one (nil
+// for none). A connection that cannot be written to is skipped; its own read loop notices
+// the broken connection and leaves the room.
+func (rm *room) broadcast(msg Message, except *client) {
+	rm.mutex.RLock()
+	clients := make([]*client, 0, len(rm.clients))
+	for cl := range rm.clients {
+		if cl != except {
+			clients = append(clients, cl)
+		}
+	}
+	rm.mutex.RUnlock()
+
+	for _, cl := range clients {
+		cl.send(msg)
+	}
+}
+
+// participants lists the distinct users connected to the room; a user may have several tabs open
+func (rm *room) participants() []Participant {
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	seen := make(map[string]bool)
+	var participants []Participant
+	for cl := range rm.clients {
+		if !seen[cl.participant.ID] {
+			seen[cl.participant.ID] = true
+			participants = append(participants, cl.participant)
+		}
+	}
+	sort.Slice(participants, func(i, j int) bool { return participants[i].Name < participants[j].Name })
+	return participants
+}
+
+func (rm *room) connections(participantID string) int {
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	count := 0
+	for cl := range rm.clients {
+		if cl.participant.ID == participantID {
+			count++
+		}
+	}
+	return count
+}
+
+// hub tracks the rooms of all sessions with at least one open connection
+type hub struct {
+	mutex sync.Mutex
+	rooms map[string]*room
+}
+
+func newHub() *hub {
+	return &hub{rooms: make(map[string]*room)}
+}
+
+func (h *hub) join(sessionID string, cl *client) *room {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	rm, ok := h.rooms[sessionID]
+	if !ok {
+		rm = &room{sessionID: sessionID, clients: make(map[*client]struct{})}
+		h.rooms[sessionID] = rm
+	}
+	rm.mutex.Lock()
+	rm.clients[cl] = struct{}{}
+	rm.mutex.Unlock()
+	return rm
+}
+
+func (h *hub) leave(rm *room, cl *client) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	rm.mutex.Lock()
+	delete(rm.clients, cl)
+	empty := len(rm.clients) == 0
+	rm.mutex.Unlock()
+	if empty {
+		delete(h.rooms, rm.sessionID)
+	}
+}